@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"time"
 
 	"go.dedis.ch/cothority/v3"
@@ -13,6 +14,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -28,6 +30,10 @@ type Client struct {
 	ID           skipchain.SkipBlockID
 	Roster       onet.Roster
 	ServerNumber int // Which server in the Roster to contact, -1 means random.
+	// Genesis is the pinned genesis block of the chain. If it is set, the
+	// proofs are verified with Proof.VerifyFromGenesis. Use PinGenesis to
+	// set it.
+	Genesis *skipchain.SkipBlock
 }
 
 // NewClient instantiates a new ByzCoin client.
@@ -90,23 +96,85 @@ func (c *Client) AddTransaction(tx ClientTransaction) (*AddTxResponse, error) {
 }
 
 // AddTransactionAndWait adds a transaction and will wait for it to be included
-// in the ledger, up to a maximum of wait block intervals. It does not return
-// any feedback on the transaction. The Client's Roster and ID should be
-// initialized before calling this method (see NewClientFromConfig).
+// in the ledger, up to a maximum of wait block intervals. If wait is bigger
+// than 0, the response holds the results of the instructions returned by the
+// contracts implementing ContractWithResult. If the transaction got refused,
+// the error is a *TxRefusal, which is also in the response. The Client's
+// Roster and ID should be initialized before calling this method (see
+// NewClientFromConfig).
 func (c *Client) AddTransactionAndWait(tx ClientTransaction, wait int) (*AddTxResponse, error) {
-	reply := &AddTxResponse{}
-	err := c.SendProtobuf(c.getServer(), &AddTxRequest{
+	return c.addTransaction(&AddTxRequest{
 		Version:       CurrentVersion,
 		SkipchainID:   c.ID,
 		Transaction:   tx,
 		InclusionWait: wait,
-	}, reply)
+	})
+}
+
+// AddTransactionAndWaitFor is like AddTransactionAndWait, but the node waits
+// for the inclusion of the transaction for maxWait instead of twice the
+// duration of wait blocks. It still returns an error after wait blocks
+// without the transaction.
+func (c *Client) AddTransactionAndWaitFor(tx ClientTransaction, wait int, maxWait time.Duration) (*AddTxResponse, error) {
+	return c.addTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   c.ID,
+		Transaction:   tx,
+		InclusionWait: wait,
+		MaxWait:       maxWait,
+	})
+}
+
+// AddTransactionWithToken is like AddTransactionAndWait, but sends the
+// idempotency token with the transaction. If the reply is lost, the same call
+// can be done again: the node doesn't add the transaction a second time, but
+// returns its outcome, or waits for it if it is still pending. The node only
+// keeps the token for some minutes, and the other nodes don't know it, so the
+// ServerNumber of the Client must not be -1, so that it always sends the
+// request to the same node.
+func (c *Client) AddTransactionWithToken(tx ClientTransaction, wait int, token []byte) (*AddTxResponse, error) {
+	return c.addTransaction(&AddTxRequest{
+		Version:          CurrentVersion,
+		SkipchainID:      c.ID,
+		Transaction:      tx,
+		InclusionWait:    wait,
+		IdempotencyToken: token,
+	})
+}
+
+// addTransaction sends req asking for the refusal in the reply, and returns
+// it as error if the transaction got refused.
+func (c *Client) addTransaction(req *AddTxRequest) (*AddTxResponse, error) {
+	req.ReplyRefusal = true
+	reply := &AddTxResponse{}
+	err := c.SendProtobuf(c.getServer(), req, reply)
 	if err != nil {
 		return nil, err
 	}
+	if reply.Refused != nil {
+		return reply, reply.Refused
+	}
 	return reply, nil
 }
 
+// Error returns the same message as the error sent by the nodes to the
+// clients that don't ask for the refusal in the reply.
+func (r *TxRefusal) Error() string {
+	var reason error
+	switch {
+	case r.Instruction >= 0:
+		reason = InstructionError{Index: r.Instruction, Err: errors.New(r.Reason)}
+	case r.Reason != "":
+		reason = errors.New(r.Reason)
+	}
+	return refusedTxError(reason).Error()
+}
+
+// NewIdempotencyToken returns a random token for AddTransactionWithToken.
+func NewIdempotencyToken() []byte {
+	return random.Bits(128, true, random.New())
+}
+
 // GetProof returns a proof for the key stored in the skipchain by sending a
 // message to the node on index 0 of the roster. The proof can prove the existence
 // or the absence of the key. Note that the integrity of the proof is verified.
@@ -124,14 +192,164 @@ func (c *Client) GetProof(key []byte) (*GetProofResponse, error) {
 	}
 
 	// verify the integrity of the proof only
-	err = reply.Proof.Verify(c.ID)
+	if c.Genesis != nil {
+		err = reply.Proof.VerifyFromGenesis(c.Genesis)
+	} else {
+		err = reply.Proof.Verify(c.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// GetProofs returns the proofs of several keys in one request. The proofs
+// are computed against the same block, so they are consistent with each
+// other. The proofs of the keys listed in the errors of the reply are
+// missing, the other proofs are in the order of the keys.
+func (c *Client) GetProofs(keys [][]byte) (*GetProofsResponse, error) {
+	reply := &GetProofsResponse{}
+	err := c.SendProtobuf(c.getServer(), &GetProofs{
+		Version: CurrentVersion,
+		ID:      c.ID,
+		Keys:    keys,
+	}, reply)
 	if err != nil {
 		return nil, err
 	}
+	if len(reply.Proofs)+len(reply.Errors) != len(keys) {
+		return nil, errors.New("wrong number of proofs in the reply")
+	}
+
+	// verify the integrity of the proofs only
+	for i, p := range reply.Proofs {
+		if c.Genesis != nil {
+			err = p.VerifyFromGenesis(c.Genesis)
+		} else {
+			err = p.Verify(c.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !p.Latest.Hash.Equal(reply.Proofs[0].Latest.Hash) {
+			return nil, fmt.Errorf("proof %d doesn't end with the same block as the first one", i)
+		}
+	}
 
 	return reply, nil
 }
 
+// GetAuditProof returns a proof of the key, together with the genesis block
+// of the chain, so that it can be verified offline with VerifyAuditProof. The
+// key must be in the chain. If no genesis block is pinned, it is fetched from
+// the roster of the client.
+func (c *Client) GetAuditProof(key []byte) (*AuditProof, error) {
+	genesis := c.Genesis
+	if genesis == nil {
+		var err error
+		genesis, err = skipchain.NewClient().GetSingleBlock(&c.Roster, c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get the genesis block: %v", err)
+		}
+	}
+	reply, err := c.GetProof(key)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &AuditProof{
+		Key:     key,
+		Genesis: *genesis,
+		Proof:   reply.Proof,
+	}
+	if err := VerifyAuditProof(bundle, c.ID); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// PinGenesis makes the client verify all the proofs against the genesis block
+// with the given hash, which must be the ID of the client. The genesis block
+// is fetched from the roster of the client, but only accepted if it has the
+// given hash, so the nodes cannot replace it.
+func (c *Client) PinGenesis(hash skipchain.SkipBlockID) error {
+	if !hash.Equal(c.ID) {
+		return fmt.Errorf("pinned genesis %x is not the chain %x of the client", []byte(hash), []byte(c.ID))
+	}
+	genesis, err := skipchain.NewClient().GetSingleBlock(&c.Roster, hash)
+	if err != nil {
+		return fmt.Errorf("couldn't get the genesis block: %v", err)
+	}
+	if genesis.Index != 0 || !genesis.CalculateHash().Equal(hash) {
+		return fmt.Errorf("block %x is not the pinned genesis block", []byte(hash))
+	}
+	c.Genesis = genesis
+	return nil
+}
+
+// Instance holds the value of an instance together with its metadata, as
+// returned by GetInstance.
+type Instance struct {
+	ID         InstanceID
+	Value      []byte
+	ContractID string
+	DarcID     darc.ID
+	Version    uint64
+	// Deleted is true if the instance has been soft deleted and only its
+	// tombstone is left.
+	Deleted bool
+	// ContractVersion is the version of the format of the value, 0 if the
+	// contract doesn't give one.
+	ContractVersion uint32
+}
+
+// GetInstance returns the instance stored under the given ID, after
+// verifying the proof against the ID of the client. It returns an error if
+// the instance doesn't exist.
+func (c *Client) GetInstance(id InstanceID) (*Instance, error) {
+	p, err := c.GetProof(id.Slice())
+	if err != nil {
+		return nil, err
+	}
+	buf := p.Proof.InclusionProof.Get(id.Slice())
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("instance %x does not exist", id.Slice())
+	}
+	body, err := decodeStateChangeBody(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{
+		ID:              id,
+		Value:           body.Value,
+		ContractID:      body.ContractID,
+		DarcID:          body.DarcID,
+		Version:         body.Version,
+		Deleted:         body.Deleted,
+		ContractVersion: body.ContractVersion,
+	}, nil
+}
+
+// GetByIndex returns the instance that the contract contractID indexed under
+// key, after verifying the proofs of the index entry and of the instance.
+func (c *Client) GetByIndex(contractID string, key []byte) (*Instance, error) {
+	inst, err := c.GetInstance(NewIndexInstanceID(contractID, key))
+	if err != nil {
+		return nil, err
+	}
+	if inst.ContractID != ContractIndexID {
+		return nil, fmt.Errorf("instance %x is not an index entry", inst.ID.Slice())
+	}
+	var entry IndexEntry
+	if err = protobuf.Decode(inst.Value, &entry); err != nil {
+		return nil, err
+	}
+	if entry.ContractID != contractID || !bytes.Equal(entry.Key, key) {
+		return nil, errors.New("the index entry is for another key")
+	}
+	return c.GetInstance(entry.InstanceID)
+}
+
 // CheckAuthorization verifies which actions the given set of identities can
 // execute in the given darc.
 func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.Action, error) {
@@ -152,84 +370,100 @@ func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.A
 	return ret, nil
 }
 
-// GetGenDarc uses the GetProof method to fetch the latest version of the
+// GetGenDarc uses the GetInstance method to fetch the latest version of the
 // Genesis Darc from ByzCoin and parses it.
 func (c *Client) GetGenDarc() (*darc.Darc, error) {
-	// Get proof of the genesis darc.
-	p, err := c.GetProof(NewInstanceID(nil).Slice())
+	// Get the ID of the genesis darc from the config instance.
+	config, err := c.GetInstance(ConfigInstanceID)
 	if err != nil {
 		return nil, err
 	}
-	ok, err := p.Proof.InclusionProof.Exists(NewInstanceID(nil).Slice())
-	if err != nil {
-		return nil, err
-	}
-	if !ok {
-		return nil, errors.New("cannot find genesis Darc ID")
-	}
 
 	// Sanity check the values.
-	_, _, contract, darcID, err := p.Proof.KeyValue()
-	if contract != ContractConfigID {
-		return nil, errors.New("expected contract to be config but got: " + contract)
+	if config.ContractID != ContractConfigID {
+		return nil, errors.New("expected contract to be config but got: " + config.ContractID)
 	}
-	if len(darcID) != 32 {
+	if len(config.DarcID) != 32 {
 		return nil, errors.New("genesis darc ID is wrong length")
 	}
 
 	// Find the actual darc.
-	p, err = c.GetProof(darcID)
+	inst, err := c.GetInstance(NewInstanceID(config.DarcID))
 	if err != nil {
 		return nil, err
 	}
-	ok, err = p.Proof.InclusionProof.Exists(darcID)
+
+	// Check and parse the darc.
+	if inst.ContractID != ContractDarcID {
+		return nil, errors.New("expected contract to be darc but got: " + inst.ContractID)
+	}
+	d, err := darc.NewFromProtobuf(inst.Value)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return nil, errors.New("cannot find genesis Darc")
-	}
+	return d, nil
+}
 
-	// Check and parse the darc.
-	_, darcBuf, contract, _, err := p.Proof.KeyValue()
+// GetChainConfig returns the chain config of the latest block, as returned by
+// the GetChainConfig request.
+func (c *Client) GetChainConfig() (*ChainConfig, error) {
+	reply, err := c.GetChainConfigAndProof()
 	if err != nil {
 		return nil, err
 	}
-	if contract != ContractDarcID {
-		return nil, errors.New("expected contract to be darc but got: " + contract)
-	}
-	d, err := darc.NewFromProtobuf(darcBuf)
+	return &reply.Config, nil
+}
+
+// GetChainConfigAndProof returns the chain config of the latest block, with
+// the proof of the config instance. The proof is verified, and the config
+// must be the value of the config instance in the proof.
+func (c *Client) GetChainConfigAndProof() (*GetChainConfigResponse, error) {
+	reply := &GetChainConfigResponse{}
+	err := c.SendProtobuf(c.getServer(), &GetChainConfig{
+		Version:   CurrentVersion,
+		ByzCoinID: c.ID,
+	}, reply)
 	if err != nil {
 		return nil, err
 	}
-	return d, nil
-}
 
-// GetChainConfig uses the GetProof method to fetch the chain config
-// from ByzCoin.
-func (c *Client) GetChainConfig() (*ChainConfig, error) {
-	p, err := c.GetProof(NewInstanceID(nil).Slice())
+	if c.Genesis != nil {
+		err = reply.Proof.VerifyFromGenesis(c.Genesis)
+	} else {
+		err = reply.Proof.Verify(c.ID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	ok, err := p.Proof.InclusionProof.Exists(NewInstanceID(nil).Slice())
+	key, value, contractID, _, err := reply.Proof.KeyValue()
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return nil, errors.New("cannot find config")
+	if !bytes.Equal(key, ConfigInstanceID.Slice()) {
+		return nil, errors.New("the proof is not for the config instance")
 	}
-
-	_, configBuf, contract, _, err := p.Proof.KeyValue()
-	if contract != ContractConfigID {
-		return nil, errors.New("expected contract to be config but got: " + contract)
+	if contractID != ContractConfigID {
+		return nil, errors.New("expected contract to be config but got: " + contractID)
 	}
-	config := &ChainConfig{}
-	err = protobuf.DecodeWithConstructors(configBuf, config, network.DefaultConstructors(cothority.Suite))
+	config := ChainConfig{}
+	err = protobuf.DecodeWithConstructors(value, &config, network.DefaultConstructors(cothority.Suite))
 	if err != nil {
 		return nil, err
 	}
-	return config, nil
+	// Both configs are encoded again, so that an older encoding of the
+	// value doesn't make a difference.
+	want, err := protobuf.Encode(&config)
+	if err != nil {
+		return nil, err
+	}
+	got, err := protobuf.Encode(&reply.Config)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(want, got) {
+		return nil, errors.New("the config doesn't match the value of its proof")
+	}
+	return reply, nil
 }
 
 // WaitProof will poll ByzCoin until a given instanceID exists.
@@ -303,6 +537,43 @@ func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) erro
 	}
 }
 
+// StreamProof sends a request to the service to stream the proofs of the
+// key. The handler is called with the current proof, and then with a new
+// proof every time the key changes. This function blocks, the streaming
+// stops if the client or the service stops. The integrity of every proof is
+// verified, like in GetProof.
+func (c *Client) StreamProof(key []byte, handler func(GetProofResponse, error)) error {
+	req := StreamProofRequest{
+		ID:  c.ID,
+		Key: key,
+	}
+	conn, err := c.Stream(c.getServer(), &req)
+	if err != nil {
+		handler(GetProofResponse{}, err)
+		return err
+	}
+	for {
+		resp := GetProofResponse{}
+		if err := conn.ReadMessage(&resp); err != nil {
+			handler(GetProofResponse{}, err)
+			return nil
+		}
+
+		if c.Genesis != nil {
+			err = resp.Proof.VerifyFromGenesis(c.Genesis)
+		} else {
+			err = resp.Proof.Verify(c.ID)
+		}
+		if err != nil {
+			err = fmt.Errorf("got an invalid proof from %v: %v", c.Roster.List[0], err)
+			log.Warn(err.Error())
+			handler(GetProofResponse{}, err)
+			continue
+		}
+		handler(resp, nil)
+	}
+}
+
 // GetSignerCounters gets the signer counters from ByzCoin. The counter must be
 // set correctly in the instruction for it to be verified. Every counter maps
 // to a signer, if the most recent instruction is signed by the signer at count
@@ -321,6 +592,112 @@ func (c *Client) GetSignerCounters(ids ...string) (*GetSignerCountersResponse, e
 	return &reply, nil
 }
 
+// FillSignerCounters sets the SignerCounter of all the instructions of ctx,
+// which must already have their SignerIdentities. The counters of all the
+// signers are fetched in a single request. If a signer signs several
+// instructions, its counter is incremented for each of them, in the order of
+// the instructions. As the counters are part of the hash of the instructions,
+// the transaction must be signed afterwards.
+func (c *Client) FillSignerCounters(ctx *ClientTransaction) error {
+	var ids []string
+	index := make(map[string]int)
+	for _, instr := range ctx.Instructions {
+		for _, id := range instr.SignerIdentities {
+			if _, ok := index[id.String()]; !ok {
+				index[id.String()] = len(ids)
+				ids = append(ids, id.String())
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return errors.New("the instructions have no signer identities")
+	}
+	reply, err := c.GetSignerCounters(ids...)
+	if err != nil {
+		return err
+	}
+	if len(reply.Counters) != len(ids) {
+		return fmt.Errorf("got %d counters for %d signers", len(reply.Counters), len(ids))
+	}
+	next := reply.Counters
+	for i := range ctx.Instructions {
+		instr := &ctx.Instructions[i]
+		instr.SignerCounter = make([]uint64, len(instr.SignerIdentities))
+		for j, id := range instr.SignerIdentities {
+			k := index[id.String()]
+			next[k]++
+			instr.SignerCounter[j] = next[k]
+		}
+	}
+	return nil
+}
+
+// GetVersion returns the versions of the requests the node accepts, so that
+// the client can check that it is compatible.
+func (c *Client) GetVersion() (*GetVersionResponse, error) {
+	reply := &GetVersionResponse{}
+	err := c.SendProtobuf(c.getServer(), &GetVersion{}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Exists returns whether the key is in the state trie. The answer is not
+// verified, so GetProof must be used if the client cannot trust the node, or
+// needs the value of the key.
+func (c *Client) Exists(key []byte) (bool, error) {
+	reply := &ExistsResponse{}
+	err := c.SendProtobuf(c.getServer(), &Exists{
+		Version:     CurrentVersion,
+		SkipChainID: c.ID,
+		Key:         key,
+	}, reply)
+	if err != nil {
+		return false, err
+	}
+	return reply.Exists, nil
+}
+
+// GetInstancesByDarc returns the IDs of all the instances controlled by the
+// given darc. The instances are fetched in pages of 'length' IDs, each page
+// resuming after the last instance of the previous one.
+func (c *Client) GetInstancesByDarc(dID darc.ID, length int) ([]InstanceID, error) {
+	var ids []InstanceID
+	req := GetInstancesByDarc{
+		SkipChainID: c.ID,
+		DarcID:      dID,
+		Length:      length,
+	}
+	for {
+		var reply GetInstancesByDarcResponse
+		err := c.SendProtobuf(c.getServer(), &req, &reply)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, reply.InstanceIDs...)
+		if len(reply.InstanceIDs) < length {
+			return ids, nil
+		}
+		req.StartKey = ids[len(ids)-1].Slice()
+	}
+}
+
+// GetCoinSupply returns the sum of the coins of the given type held by all the
+// coin instances, with the index of the block it is from.
+func (c *Client) GetCoinSupply(coinType InstanceID) (*GetCoinSupplyResponse, error) {
+	reply := &GetCoinSupplyResponse{}
+	err := c.SendProtobuf(c.getServer(), &GetCoinSupply{
+		Version:     CurrentVersion,
+		SkipChainID: c.ID,
+		CoinType:    coinType,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
 // DownloadState is used by a new node to ask to download the global state.
 // The first call to DownloadState needs to have start = 0, so that the
 // service creates a snapshot of the current state which it will serve over
@@ -336,7 +713,28 @@ func (c *Client) GetSignerCounters(ids ...string) (*GetSignerCountersResponse, e
 // The first StateChange with start == 0 holds the metadata of the
 // trie which can be `protobuf.Decode`d into a struct{map[string][]byte}.
 func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, length int) (reply *DownloadStateResponse, err error) {
-	if length <= 0 {
+	return c.downloadState(&DownloadState{
+		ByzCoinID: byzcoinID,
+		Nonce:     nonce,
+		Length:    length,
+	})
+}
+
+// ResumeDownloadState starts a new download of the state, like DownloadState
+// with a nonce of 0, but only with the entries whose key is strictly bigger
+// than startKey, the last key received by an interrupted download. The
+// following calls to DownloadState use the nonce of the reply. As the state
+// can change in-between, the resulting trie must be verified.
+func (c *Client) ResumeDownloadState(byzcoinID skipchain.SkipBlockID, startKey []byte, length int) (reply *DownloadStateResponse, err error) {
+	return c.downloadState(&DownloadState{
+		ByzCoinID: byzcoinID,
+		Length:    length,
+		StartKey:  startKey,
+	})
+}
+
+func (c *Client) downloadState(req *DownloadState) (reply *DownloadStateResponse, err error) {
+	if req.Length <= 0 {
 		return nil, errors.New("invalid parameter")
 	}
 
@@ -352,11 +750,7 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	// Because the last elements of the roster might be a view-changed,
 	// defective old leader, we start from the first non-subleader.
 	for index < l {
-		err = c.SendProtobuf(c.Roster.List[index], &DownloadState{
-			ByzCoinID: byzcoinID,
-			Nonce:     nonce,
-			Length:    length,
-		}, reply)
+		err = c.SendProtobuf(c.Roster.List[index], req, reply)
 		if err == nil {
 			return reply, nil
 		}
@@ -379,6 +773,102 @@ func Debug(url string, byzcoinID *skipchain.SkipBlockID) (reply *DebugResponse,
 	return
 }
 
+// DebugDownloadStatus returns the downloads of the state the conode is serving
+// to other nodes. The conode only answers on loopback.
+func DebugDownloadStatus(url string) (*GetDownloadStatusResponse, error) {
+	reply := &GetDownloadStatusResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, &GetDownloadStatus{}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// DebugCancelDownload stops the downloads of the state the conode is serving and
+// returns their nonces. If nonce is not 0, only the download with this nonce
+// is stopped. The conode only answers on loopback.
+func DebugCancelDownload(url string, nonce uint64) ([]uint64, error) {
+	reply := &CancelDownloadResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, &CancelDownload{Nonce: nonce}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Nonces, nil
+}
+
+// DebugSetLog sets the debug level of a subsystem of the conode, and returns
+// the levels of all the subsystems. If subsystem is empty, it only returns
+// the levels. The conode only answers on loopback.
+func DebugSetLog(url string, subsystem string, level int) (*DebugSetLogResponse, error) {
+	reply := &DebugSetLogResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si,
+		&DebugSetLogRequest{Subsystem: subsystem, Level: level}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// DebugReplay asks the conode to execute again the transactions of the chain
+// up to the block at index to, or the latest block if to is 0, and to report
+// the first block whose trie root differs. The conode only answers on
+// loopback.
+func DebugReplay(url string, byzcoinID skipchain.SkipBlockID, to int) (*DebugReplayResponse, error) {
+	reply := &DebugReplayResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si,
+		&DebugReplayRequest{ByzCoinID: byzcoinID, To: to}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// DebugBisect asks the conode to look for the first block between the ones at
+// index from and to, or the latest block if to is 0, whose replayed trie root
+// differs, with a binary search. The conode only answers on loopback.
+func DebugBisect(url string, byzcoinID skipchain.SkipBlockID, from, to int) (*DebugReplayResponse, error) {
+	reply := &DebugReplayResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si,
+		&DebugBisectRequest{ByzCoinID: byzcoinID, From: from, To: to}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// DebugReconcile asks the conode to compare the latest state change of sample
+// instances of the chain, or all of them if sample is 0, with their value in
+// the trie. The conode only answers on loopback.
+func DebugReconcile(url string, byzcoinID skipchain.SkipBlockID, sample int) (*DebugReconcileResponse, error) {
+	reply := &DebugReconcileResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si,
+		&DebugReconcileRequest{ByzCoinID: byzcoinID, Sample: sample}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// DebugPruneStateChanges asks the conode to remove the state changes of the blocks
+// of the chain before blockIndex, except the latest one of every instance.
+// The conode only answers on loopback.
+func DebugPruneStateChanges(url string, byzcoinID skipchain.SkipBlockID, blockIndex int) (*PruneStateChangesResponse, error) {
+	reply := &PruneStateChangesResponse{}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si,
+		&PruneStateChanges{ByzCoinID: byzcoinID, BlockIndex: blockIndex}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
 // DebugRemove deletes an existing byzcoin-instance from the conode.
 func DebugRemove(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) error {
 	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(), byzcoinID)
@@ -450,6 +940,67 @@ func DefaultGenesisMsg(v Version, r *onet.Roster, rules []string, ids ...darc.Id
 	return &m, nil
 }
 
+// PingResult holds the round-trip time to one node of the roster, as
+// measured by Ping.
+type PingResult struct {
+	ServerIdentity *network.ServerIdentity
+	Latency        time.Duration
+	// Error is set if the node couldn't be reached.
+	Error error
+}
+
+// Ping sends a cheap request to all the nodes of the roster in parallel and
+// returns the round-trip times, sorted from the fastest to the slowest node.
+// The nodes that didn't answer within the timeout, or returned an error, are
+// at the end of the list with the Error field set. A zero timeout waits for
+// all the nodes.
+func (c *Client) Ping(timeout time.Duration) []PingResult {
+	results := make(chan PingResult, len(c.Roster.List))
+	for _, si := range c.Roster.List {
+		go func(si *network.ServerIdentity) {
+			// Use a new client, so that every measure includes
+			// opening the connection.
+			cl := onet.NewClient(cothority.Suite, ServiceName)
+			defer cl.Close()
+			start := time.Now()
+			err := cl.SendProtobuf(si, &GetVersion{}, &GetVersionResponse{})
+			results <- PingResult{si, time.Since(start), err}
+		}(si)
+	}
+
+	var res []PingResult
+	// A nil channel never fires, so a zero timeout waits for all the nodes.
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	answered := make(map[network.ServerIdentityID]bool)
+collect:
+	for range c.Roster.List {
+		select {
+		case r := <-results:
+			answered[r.ServerIdentity.ID] = true
+			res = append(res, r)
+		case <-deadline:
+			break collect
+		}
+	}
+	for _, si := range c.Roster.List {
+		if !answered[si.ID] {
+			res = append(res, PingResult{si, timeout,
+				fmt.Errorf("no answer within %v", timeout)})
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if (res[i].Error == nil) != (res[j].Error == nil) {
+			return res[i].Error == nil
+		}
+		return res[i].Latency < res[j].Latency
+	})
+	return res
+}
+
 // getServer returns a server from the roster, observing the ServerNumber selection.
 func (c *Client) getServer() *network.ServerIdentity {
 	n := c.ServerNumber
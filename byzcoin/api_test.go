@@ -150,6 +150,141 @@ func TestClient_GetProof(t *testing.T) {
 	require.Equal(t, value, v0)
 }
 
+// An audit proof can be stored and verified later, knowing only the ID of
+// the chain.
+func TestClient_GetAuditProof(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 2, 1, 1)
+
+	c := NewClient(s.genesis.SkipChainID(), *s.roster)
+	bundle, err := c.GetAuditProof(s.darc.GetBaseID())
+	require.NoError(t, err)
+	buf, err := protobuf.Encode(bundle)
+	require.NoError(t, err)
+
+	var stored AuditProof
+	require.NoError(t, protobuf.DecodeWithConstructors(buf, &stored,
+		network.DefaultConstructors(cothority.Suite)))
+	require.NoError(t, VerifyAuditProof(&stored, s.genesis.SkipChainID()))
+	_, value, _, _, err := stored.Proof.KeyValue()
+	require.NoError(t, err)
+	d, err := darc.NewFromProtobuf(value)
+	require.NoError(t, err)
+	require.True(t, d.GetBaseID().Equal(s.darc.GetBaseID()))
+
+	stored.Proof.Latest.Index++
+	require.Error(t, VerifyAuditProof(&stored, s.genesis.SkipChainID()))
+
+	_, err = c.GetAuditProof([]byte("unknown key"))
+	require.Equal(t, ErrorVerifyAuditKey, err)
+}
+
+func TestClient_GetInstance(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+
+	c, _, err := NewLedger(msg, false)
+	require.Nil(t, err)
+
+	inst, err := c.GetInstance(ConfigInstanceID)
+	require.Nil(t, err)
+	require.True(t, inst.ID.Equal(ConfigInstanceID))
+	require.Equal(t, ContractConfigID, inst.ContractID)
+	require.True(t, inst.DarcID.Equal(msg.GenesisDarc.GetBaseID()))
+	require.Equal(t, uint64(0), inst.Version)
+	config := ChainConfig{}
+	require.Nil(t, protobuf.DecodeWithConstructors(inst.Value, &config, network.DefaultConstructors(cothority.Suite)))
+	require.Equal(t, msg.BlockInterval, config.BlockInterval)
+
+	_, err = c.GetInstance(NewInstanceID([]byte{1, 2, 3}))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+}
+
+// The counters of several signers, some of which never signed anything, are
+// fetched in one request.
+func TestClient_FillSignerCounters(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	c, _, err := NewLedger(msg, false)
+	require.Nil(t, err)
+
+	tx, err := createOneClientTxWithCounter(msg.GenesisDarc.GetBaseID(), dummyContract, []byte{1}, signer, 1)
+	require.Nil(t, err)
+	_, err = c.AddTransactionAndWait(tx, 10)
+	require.Nil(t, err)
+
+	unknown := darc.NewSignerEd25519(nil, nil)
+	counters, err := c.GetSignerCounters(signer.Identity().String(),
+		unknown.Identity().String(), signer.Identity().String())
+	require.Nil(t, err)
+	require.Equal(t, []uint64{1, 0, 1}, counters.Counters)
+
+	ctx := ClientTransaction{
+		Instructions: Instructions{
+			{SignerIdentities: []darc.Identity{signer.Identity(), unknown.Identity()}},
+			{SignerIdentities: []darc.Identity{unknown.Identity()}},
+			{SignerIdentities: []darc.Identity{signer.Identity()}},
+		},
+	}
+	require.Nil(t, c.FillSignerCounters(&ctx))
+	require.Equal(t, []uint64{2, 1}, ctx.Instructions[0].SignerCounter)
+	require.Equal(t, []uint64{2}, ctx.Instructions[1].SignerCounter)
+	require.Equal(t, []uint64{3}, ctx.Instructions[2].SignerCounter)
+
+	require.Error(t, c.FillSignerCounters(&ClientTransaction{Instructions: Instructions{{}}}))
+}
+
+func TestClient_Ping(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(4, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	c, _, err := NewLedger(msg, false)
+	require.Nil(t, err)
+
+	res := c.Ping(0)
+	require.Equal(t, 4, len(res))
+	for i, r := range res {
+		require.Nil(t, r.Error)
+		if i > 0 {
+			require.True(t, res[i-1].Latency <= r.Latency)
+		}
+	}
+
+	// An unreachable node is reported last.
+	bad := network.NewServerIdentity(cothority.Suite.Point().Pick(cothority.Suite.RandomStream()),
+		network.NewAddress(network.PlainTCP, "127.0.0.1:1"))
+	list := append([]*network.ServerIdentity{bad}, roster.List...)
+	res = NewClient(c.ID, *onet.NewRoster(list)).Ping(10 * time.Second)
+	require.Equal(t, 5, len(res))
+	require.NotNil(t, res[4].Error)
+	require.True(t, res[4].ServerIdentity.Equal(bad))
+	for _, r := range res[:4] {
+		require.Nil(t, r.Error)
+	}
+}
+
 func TestClient_GetProofCorrupted(t *testing.T) {
 	l := onet.NewTCPTest(cothority.Suite)
 	servers, roster, _ := l.GenTree(3, true)
@@ -288,6 +423,8 @@ func newTestService(c *onet.Context) (onet.Service, error) {
 		darcToSc:               make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:       newStateChangeCache(),
 		stateChangeStorage:     newStateChangeStorage(c),
+		blockCosts:             newBlockCosts(),
+		darcVersions:           newDarcVersions(),
 		heartbeatsTimeout:      make(chan string, 1),
 		closeLeaderMonitorChan: make(chan bool, 1),
 		heartbeats:             newHeartbeats(),
@@ -0,0 +1,275 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// defaultBatchSize is the maximum number of instructions in one batch if
+// BatchClient.MaxBatchSize is not set.
+const defaultBatchSize = 100
+
+// signatureOverhead is a conservative estimation of the size of one
+// signature, used to estimate the size of a transaction before it is signed.
+const signatureOverhead = 128
+
+// BatchClient wraps a Client and accumulates instructions to send them as
+// multi-instruction transactions. This is useful for bulk loaders that need
+// to submit many independent instructions, as every flush only needs one
+// round trip instead of one per instruction.
+//
+// The signer counters are fetched once per signer and then incremented
+// locally, so the BatchClient must be the only one signing for the given
+// signers while it is in use.
+//
+// Because a ClientTransaction is atomic, a failing instruction will make
+// the whole batch it is part of fail. The instructions of a failed batch are
+// returned in a *BatchError and dropped from the BatchClient.
+type BatchClient struct {
+	*Client
+	// FlushInterval is the time after which the pending instructions are
+	// sent, even if the batch is not full. Zero means that the instructions
+	// are only sent when the batch is full or when Flush is called.
+	FlushInterval time.Duration
+	// MaxBatchSize is the maximum number of instructions per transaction.
+	MaxBatchSize int
+	// InclusionWait is passed to AddTransactionAndWait for every flush.
+	InclusionWait int
+
+	lock    sync.Mutex
+	pending []batchEntry
+	size    int
+	maxSize int
+	// chainBound is set if the chain has ChainBoundSignatures set, so that
+	// the transactions are signed with Instructions.HashWithChain.
+	chainBound bool
+	counters   map[string]uint64
+	stop       chan bool
+	done       chan bool
+}
+
+type batchEntry struct {
+	instr   Instruction
+	signers []darc.Signer
+}
+
+// BatchError is returned when a batch couldn't be sent. Its instructions are
+// not tried again, as they might have been applied anyway, e.g. if the
+// inclusion timed out, and a bad instruction would make every later batch
+// fail. Sending the same Transaction again is safe, as the signer counters
+// make sure that it is applied only once.
+type BatchError struct {
+	// Transaction holds the instructions of the batch. They are only
+	// signed if the transaction could be created.
+	Transaction ClientTransaction
+	// Signers are the signers of every instruction, to add it again to a
+	// BatchClient.
+	Signers [][]darc.Signer
+	// Err is why the batch failed.
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch of %d instructions failed: %s", len(e.Signers), e.Err)
+}
+
+// NewBatchClient returns a BatchClient that uses c to send the transactions.
+// If flush is non-zero, the pending instructions will be sent every flush
+// interval, until Close is called.
+func NewBatchClient(c *Client, maxBatch int, flush time.Duration) *BatchClient {
+	if maxBatch <= 0 {
+		maxBatch = defaultBatchSize
+	}
+	b := &BatchClient{
+		Client:        c,
+		FlushInterval: flush,
+		MaxBatchSize:  maxBatch,
+		counters:      make(map[string]uint64),
+	}
+	if flush > 0 {
+		b.stop = make(chan bool)
+		b.done = make(chan bool)
+		go b.flushLoop()
+	}
+	return b
+}
+
+// AddInstruction queues an instruction that will be signed by the given
+// signers. The SignerIdentities, SignerCounter and Signatures fields of the
+// instruction are overwritten when the batch is flushed. If the batch is full
+// or the instruction would make the transaction bigger than a block, the
+// pending instructions are sent first. If they fail, the *BatchError is
+// returned and instr is not queued.
+func (b *BatchClient) AddInstruction(instr Instruction, signers ...darc.Signer) error {
+	if len(signers) == 0 {
+		return errors.New("need at least one signer")
+	}
+	if instr.GetType() == InvalidInstrType {
+		return errors.New("invalid instruction type")
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.maxSize == 0 {
+		config, err := b.GetChainConfig()
+		if err != nil {
+			return errors.New("couldn't get chain config: " + err.Error())
+		}
+		b.maxSize = config.MaxBlockSize
+		b.chainBound = config.ChainBoundSignatures
+	}
+
+	sz, err := instructionSize(instr, len(signers))
+	if err != nil {
+		return err
+	}
+	if sz > b.maxSize {
+		return errors.New("instruction too large")
+	}
+	if len(b.pending) >= b.MaxBatchSize || b.size+sz > b.maxSize {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	b.pending = append(b.pending, batchEntry{instr: instr, signers: signers})
+	b.size += sz
+	if len(b.pending) >= b.MaxBatchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+// Flush sends all the pending instructions in one transaction. If it fails,
+// the error is a *BatchError holding the instructions.
+func (b *BatchClient) Flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.flush()
+}
+
+// Close stops the periodic flushing and sends the remaining instructions.
+func (b *BatchClient) Close() error {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+		b.stop = nil
+	}
+	return b.Flush()
+}
+
+func (b *BatchClient) flushLoop() {
+	defer close(b.done)
+	for {
+		select {
+		case <-time.After(b.FlushInterval):
+			if err := b.Flush(); err != nil {
+				log.Error("couldn't flush batch:", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// flush must be called with the lock held.
+func (b *BatchClient) flush() error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	pending := b.pending
+	b.pending = nil
+	b.size = 0
+
+	ctx, err := b.createTransaction(pending)
+	if err == nil {
+		_, err = b.AddTransactionAndWait(ctx, b.InclusionWait)
+	}
+	if err != nil {
+		// We cannot know whether the counters have been used or not,
+		// so fetch them again on the next flush.
+		b.counters = make(map[string]uint64)
+		berr := &BatchError{Transaction: ctx, Err: err}
+		if len(ctx.Instructions) == 0 {
+			berr.Transaction.Instructions = make(Instructions, len(pending))
+			for i, e := range pending {
+				berr.Transaction.Instructions[i] = e.instr
+			}
+		}
+		for _, e := range pending {
+			berr.Signers = append(berr.Signers, e.signers)
+		}
+		return berr
+	}
+	return nil
+}
+
+// createTransaction sets the counters of the instructions and signs them.
+// The counters of one signer are incremented for every instruction it signs,
+// in the order of the instructions.
+func (b *BatchClient) createTransaction(entries []batchEntry) (ClientTransaction, error) {
+	var missing []string
+	for _, e := range entries {
+		for _, s := range e.signers {
+			id := s.Identity().String()
+			if _, ok := b.counters[id]; !ok {
+				b.counters[id] = 0
+				missing = append(missing, id)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		reply, err := b.GetSignerCounters(missing...)
+		if err != nil {
+			return ClientTransaction{}, errors.New("couldn't get counters: " + err.Error())
+		}
+		if len(reply.Counters) != len(missing) {
+			return ClientTransaction{}, errors.New("got wrong number of counters")
+		}
+		for i, id := range missing {
+			b.counters[id] = reply.Counters[i]
+		}
+	}
+
+	ctx := ClientTransaction{Instructions: make(Instructions, len(entries))}
+	for i, e := range entries {
+		instr := e.instr
+		instr.SignerIdentities = make([]darc.Identity, len(e.signers))
+		instr.SignerCounter = make([]uint64, len(e.signers))
+		for j, s := range e.signers {
+			id := s.Identity()
+			b.counters[id.String()]++
+			instr.SignerIdentities[j] = id
+			instr.SignerCounter[j] = b.counters[id.String()]
+		}
+		ctx.Instructions[i] = instr
+	}
+
+	digest := ctx.Instructions.Hash()
+	if b.chainBound {
+		digest = ctx.Instructions.HashWithChain(b.ID)
+	}
+	for i, e := range entries {
+		if err := ctx.Instructions[i].SignWith(digest, e.signers...); err != nil {
+			return ClientTransaction{}, err
+		}
+	}
+	return ctx, nil
+}
+
+// instructionSize estimates the size of the instruction once it is signed by
+// nbrSigners.
+func instructionSize(instr Instruction, nbrSigners int) (int, error) {
+	buf, err := protobuf.Encode(&instr)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf) + nbrSigners*signatureOverhead, nil
+}
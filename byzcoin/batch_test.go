@@ -0,0 +1,111 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// Counters must be incremented per signer and in the order of the
+// instructions, also across batches.
+func TestBatchClient_Counters(t *testing.T) {
+	signer1 := darc.NewSignerEd25519(nil, nil)
+	signer2 := darc.NewSignerEd25519(nil, nil)
+	b := NewBatchClient(&Client{}, 10, 0)
+	b.counters[signer1.Identity().String()] = 5
+	b.counters[signer2.Identity().String()] = 0
+
+	entries := []batchEntry{
+		{createSpawnInstr(genID().Slice(), dummyContract, "data", nil), []darc.Signer{signer1}},
+		{createSpawnInstr(genID().Slice(), dummyContract, "data", nil), []darc.Signer{signer1, signer2}},
+		{createSpawnInstr(genID().Slice(), dummyContract, "data", nil), []darc.Signer{signer2}},
+	}
+	ctx, err := b.createTransaction(entries)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{6}, ctx.Instructions[0].SignerCounter)
+	require.Equal(t, []uint64{7, 1}, ctx.Instructions[1].SignerCounter)
+	require.Equal(t, []uint64{2}, ctx.Instructions[2].SignerCounter)
+
+	digest := ctx.Instructions.Hash()
+	for _, instr := range ctx.Instructions {
+		require.Equal(t, len(instr.SignerIdentities), len(instr.Signatures))
+		for i, id := range instr.SignerIdentities {
+			require.NoError(t, id.Verify(digest, instr.Signatures[i]))
+		}
+	}
+
+	ctx, err = b.createTransaction(entries[:1])
+	require.NoError(t, err)
+	require.Equal(t, []uint64{8}, ctx.Instructions[0].SignerCounter)
+
+	// The chains with ChainBoundSignatures need the signatures bound to
+	// the chain.
+	b.ID = genID().Slice()
+	b.chainBound = true
+	ctx, err = b.createTransaction(entries[:1])
+	require.NoError(t, err)
+	require.NoError(t, signer1.Identity().Verify(ctx.Instructions.HashWithChain(b.ID), ctx.Instructions[0].Signatures[0]))
+}
+
+// The instructions of a batch that cannot be sent are returned and dropped.
+func TestBatchClient_FlushFailure(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	si := network.NewServerIdentity(cothority.Suite.Point().Pick(cothority.Suite.RandomStream()),
+		network.NewAddress(network.PlainTCP, "127.0.0.1:2"))
+	b := NewBatchClient(NewClient(genID().Slice(), *onet.NewRoster([]*network.ServerIdentity{si})), 10, 0)
+	b.counters[signer.Identity().String()] = 0
+	instr := createSpawnInstr(genID().Slice(), dummyContract, "data", nil)
+	b.pending = []batchEntry{{instr, []darc.Signer{signer}}}
+	b.size = 1
+
+	err := b.Flush()
+	require.Error(t, err)
+	require.IsType(t, &BatchError{}, err)
+	berr := err.(*BatchError)
+	require.Equal(t, 1, len(berr.Transaction.Instructions))
+	require.Equal(t, instr.Spawn, berr.Transaction.Instructions[0].Spawn)
+	require.Equal(t, []uint64{1}, berr.Transaction.Instructions[0].SignerCounter)
+	require.Equal(t, [][]darc.Signer{{signer}}, berr.Signers)
+	require.Equal(t, 0, len(b.pending))
+	require.Equal(t, 0, b.size)
+	require.NoError(t, b.Flush())
+}
+
+func TestBatchClient_AddInstruction(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	c, _, err := NewLedger(msg, false)
+	require.NoError(t, err)
+
+	b := NewBatchClient(c, 3, 0)
+	b.InclusionWait = 10
+	var ids []InstanceID
+	for i := 0; i < 7; i++ {
+		id := genID()
+		ids = append(ids, id)
+		instr := createSpawnInstr(msg.GenesisDarc.GetBaseID(), dummyContract, "data", id.Slice())
+		require.NoError(t, b.AddInstruction(instr, signer))
+	}
+	require.NoError(t, b.Close())
+
+	for _, id := range ids {
+		pr, err := c.GetProof(id.Slice())
+		require.NoError(t, err)
+		require.True(t, pr.Proof.InclusionProof.Match(id.Slice()))
+	}
+	counters, err := c.GetSignerCounters(signer.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), counters.Counters[0])
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// auditRule is a rule of a darc in the report of darc audit. Flags tells why
+// the rule needs the attention of the reviewer.
+type auditRule struct {
+	Action     string
+	Expression string
+	Flags      []string `json:",omitempty"`
+}
+
+// auditDarc is a darc in the report of darc audit.
+type auditDarc struct {
+	ID          string
+	Description string
+	Version     uint64
+	Rules       []auditRule
+}
+
+func darcAudit(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	threshold := c.Int("threshold")
+	if threshold <= 0 {
+		return errors.New("--threshold must be positive")
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	ids, err := getDarcInstanceIDs(cl)
+	if err != nil {
+		return err
+	}
+
+	var report []auditDarc
+	for _, id := range ids {
+		// The dump of the node is not verified, so the darcs are read
+		// again with a proof.
+		inst, err := getInstance(cl, id)
+		if err != nil {
+			return err
+		}
+		if inst.Deleted {
+			continue
+		}
+		d, err := darc.NewFromProtobuf(inst.Value)
+		if err != nil {
+			return fmt.Errorf("couldn't decode darc %x: %v", id.Slice(), err)
+		}
+		report = append(report, auditDarcRules(d, threshold))
+	}
+
+	if c.Bool("json") {
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, string(buf))
+		return err
+	}
+	_, err = fmt.Fprint(c.App.Writer, fmtAuditReport(report))
+	return err
+}
+
+// getDarcInstanceIDs returns the IDs of all the darc instances of the ledger,
+// sorted, as given by the dump of a node of the roster. The nodes only answer
+// dump requests on the loopback interface.
+func getDarcInstanceIDs(cl *byzcoin.Client) ([]byzcoin.InstanceID, error) {
+	var resp byzcoin.DebugResponse
+	chooseServer(cl, false)
+	err := withTimeout("dumping the instances", func() error {
+		return cl.SendProtobuf(cl.Roster.List[cl.ServerNumber], &byzcoin.DebugRequest{ByzCoinID: cl.ID}, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []byzcoin.InstanceID
+	for _, inst := range resp.Dump {
+		if inst.State.ContractID == byzcoin.ContractDarcID {
+			ids = append(ids, byzcoin.NewInstanceID(inst.Key))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i].Slice(), ids[j].Slice()) < 0
+	})
+	return ids, nil
+}
+
+// auditDarcRules describes the rules of d. It flags the rules allowing
+// unrestricted evolutions, and the rules that can be used by threshold or more
+// identities signing alone.
+func auditDarcRules(d *darc.Darc, threshold int) auditDarc {
+	ad := auditDarc{
+		ID:          d.GetIdentityString(),
+		Description: string(d.Description),
+		Version:     d.Version,
+	}
+	for _, r := range d.Rules.List {
+		rule := auditRule{
+			Action:     string(r.Action),
+			Expression: string(r.Expr),
+		}
+		if rule.Action == "invoke:"+byzcoin.ContractDarcID+".evolve_unrestricted" {
+			rule.Flags = append(rule.Flags, "allows unrestricted evolutions of the darc")
+		}
+		if n := soleSigners(r.Expr); n >= threshold {
+			rule.Flags = append(rule.Flags, fmt.Sprintf("%d identities can use it alone", n))
+		}
+		ad.Rules = append(ad.Rules, rule)
+	}
+	return ad
+}
+
+// soleSigners returns how many of the identities in expr satisfy it without
+// any other signature.
+func soleSigners(expr expression.Expr) int {
+	var ids []string
+	_, err := expression.Evaluate(expression.InitParser(func(id string) bool {
+		ids = append(ids, id)
+		return false
+	}), expr)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if ok, err := expression.DefaultParser(expr, id); err == nil && ok {
+			n++
+		}
+	}
+	return n
+}
+
+// fmtAuditReport prints the darcs of the report with their rules, one per
+// line, followed by the flags of the rules.
+func fmtAuditReport(report []auditDarc) string {
+	var s strings.Builder
+	flagged := 0
+	for _, d := range report {
+		fmt.Fprintf(&s, "%s (Description: %q, Version: %d)\n", d.ID, d.Description, d.Version)
+		for _, r := range d.Rules {
+			fmt.Fprintf(&s, "\t%s - %q\n", r.Action, r.Expression)
+			for _, f := range r.Flags {
+				fmt.Fprintf(&s, "\t\t! %s\n", f)
+				flagged++
+			}
+		}
+	}
+	fmt.Fprintf(&s, "%d darcs, %d flags\n", len(report), flagged)
+	return s.String()
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/onet/v3/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// benchCleanupBatch is the number of instances deleted per transaction once
+// the benchmark is done.
+const benchCleanupBatch = 20
+
+// benchResult is the outcome of one transaction of the benchmark.
+type benchResult struct {
+	latency time.Duration
+	err     error
+}
+
+// benchWorker sends its transactions one after the other, so that the
+// counters of its signer are always used in order. Every worker has its own
+// signer, so that the workers can send their transactions in parallel.
+type benchWorker struct {
+	signer  darc.Signer
+	counter uint64
+	created []byzcoin.InstanceID
+	// chainBound is set if the chain has ChainBoundSignatures set.
+	chainBound bool
+}
+
+func bench(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	count := c.Int("count")
+	rate := c.Float64("rate")
+	parallel := c.Int("parallel")
+	if count <= 0 || rate <= 0 || parallel <= 0 {
+		return errors.New("--count, --rate and --parallel must be positive")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	var signer *darc.Signer
+	sstr := c.String("sign")
+	if sstr == "" {
+		signer, err = lib.LoadKey(cfg.AdminIdentity)
+	} else {
+		signer, err = lib.LoadKeyFromString(sstr)
+	}
+	if err != nil {
+		return err
+	}
+
+	cc, err := getChainConfig(cl)
+	if err != nil {
+		return err
+	}
+
+	workers := make([]*benchWorker, parallel)
+	var ids []string
+	for i := range workers {
+		workers[i] = &benchWorker{signer: darc.NewSignerEd25519(nil, nil), chainBound: cc.ChainBoundSignatures}
+		ids = append(ids, workers[i].signer.Identity().String())
+	}
+	d, err := spawnBenchDarc(cl, cfg, *signer, expression.Expr(strings.Join(ids, " | ")))
+	if err != nil {
+		return fmt.Errorf("couldn't spawn the darc of the benchmark: %v", err)
+	}
+	_, err = fmt.Fprintln(c.App.Writer, "Spawned the darc for the benchmark:", d.GetIdentityString())
+	if err != nil {
+		return err
+	}
+
+	// The server is chosen once, before the workers start.
+	chooseServer(cl, true)
+
+	jobs := make(chan struct{}, count)
+	results := make(chan benchResult, count)
+	for _, wk := range workers {
+		go func(wk *benchWorker) {
+			for range jobs {
+				results <- wk.spawn(cl, d.GetBaseID())
+			}
+		}(wk)
+	}
+	start := time.Now()
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			<-tick.C
+		}
+		jobs <- struct{}{}
+	}
+	tick.Stop()
+	close(jobs)
+
+	var latencies []time.Duration
+	failed := 0
+	for i := 0; i < count; i++ {
+		res := <-results
+		if res.err != nil {
+			log.Lvl2("Transaction failed:", res.err)
+			failed++
+			continue
+		}
+		latencies = append(latencies, res.latency)
+	}
+	elapsed := time.Since(start)
+
+	_, err = fmt.Fprint(c.App.Writer, benchSummary(count, rate, elapsed, latencies, failed))
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, wk := range workers {
+		n, err := wk.deleteAll(cl)
+		removed += n
+		if err != nil {
+			log.Warn("Couldn't delete all the instances of the benchmark:", err)
+		}
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Deleted %d instances\n", removed)
+	return err
+}
+
+// spawnBenchDarc spawns a darc owned by signer under the admin darc, which
+// allows expr to spawn and delete value instances.
+func spawnBenchDarc(cl *byzcoin.Client, cfg lib.Config, signer darc.Signer, expr expression.Expr) (*darc.Darc, error) {
+	id := signer.Identity()
+	rules := darc.InitRulesWith([]darc.Identity{id}, []darc.Identity{id}, "invoke:"+byzcoin.ContractDarcID+".evolve")
+	if err := rules.AddRule(darc.Action("spawn:"+contracts.ContractValueID), expr); err != nil {
+		return nil, err
+	}
+	if err := rules.AddRule(darc.Action("delete:"+contracts.ContractValueID), expr); err != nil {
+		return nil, err
+	}
+	d := darc.NewDarc(rules, []byte("bcadmin bench"))
+	dBuf, err := d.ToProto()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{
+			{
+				InstanceID: byzcoin.NewInstanceID(cfg.AdminDarc.GetBaseID()),
+				Spawn: &byzcoin.Spawn{
+					ContractID: byzcoin.ContractDarcID,
+					Args: []byzcoin.Argument{
+						{
+							Name:  "darc",
+							Value: dBuf,
+						},
+					},
+				},
+			},
+		},
+	}
+	if err = signWithCounters(cl, &ctx, signer); err != nil {
+		return nil, err
+	}
+	if err = addTransactionAndWait(cl, ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// sign signs the transaction with the signer of the worker, without asking
+// the chain config again for every transaction.
+func (wk *benchWorker) sign(cl *byzcoin.Client, ctx *byzcoin.ClientTransaction) error {
+	if wk.chainBound {
+		return ctx.FillSignersAndSignWithChain(cl.ID, wk.signer)
+	}
+	return ctx.FillSignersAndSignWith(wk.signer)
+}
+
+// spawn sends one transaction spawning a value instance and waits for it to
+// be included.
+func (wk *benchWorker) spawn(cl *byzcoin.Client, darcID darc.ID) benchResult {
+	wk.counter++
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{
+			{
+				InstanceID: byzcoin.NewInstanceID(darcID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: contracts.ContractValueID,
+					Args: []byzcoin.Argument{
+						{
+							Name:  "value",
+							Value: []byte("bench"),
+						},
+					},
+				},
+				SignerCounter: []uint64{wk.counter},
+			},
+		},
+	}
+	if err := wk.sign(cl, &ctx); err != nil {
+		wk.counter--
+		return benchResult{err: err}
+	}
+
+	start := time.Now()
+	err := withTimeout("sending the transaction", func() error {
+		_, err := cl.AddTransactionAndWait(ctx, 10)
+		return err
+	})
+	if err != nil {
+		// The transaction might still be included later, so the counter is
+		// fetched again instead of being reverted.
+		resp, cerr := cl.GetSignerCounters(wk.signer.Identity().String())
+		if cerr == nil {
+			wk.counter = resp.Counters[0]
+		}
+		return benchResult{err: err}
+	}
+	wk.created = append(wk.created, ctx.Instructions[0].DeriveID(""))
+	return benchResult{latency: time.Since(start)}
+}
+
+// deleteAll deletes the instances spawned by the worker and returns how many
+// of them have been deleted.
+func (wk *benchWorker) deleteAll(cl *byzcoin.Client) (int, error) {
+	removed := 0
+	for len(wk.created) > 0 {
+		n := len(wk.created)
+		if n > benchCleanupBatch {
+			n = benchCleanupBatch
+		}
+		var ctx byzcoin.ClientTransaction
+		for _, id := range wk.created[:n] {
+			wk.counter++
+			ctx.Instructions = append(ctx.Instructions, byzcoin.Instruction{
+				InstanceID:    id,
+				Delete:        &byzcoin.Delete{ContractID: contracts.ContractValueID},
+				SignerCounter: []uint64{wk.counter},
+			})
+		}
+		if err := wk.sign(cl, &ctx); err != nil {
+			return removed, err
+		}
+		if err := addTransactionAndWait(cl, ctx); err != nil {
+			return removed, err
+		}
+		removed += n
+		wk.created = wk.created[n:]
+	}
+	return removed, nil
+}
+
+// benchSummary describes the throughput and the inclusion latencies of a
+// benchmark.
+func benchSummary(count int, rate float64, elapsed time.Duration, latencies []time.Duration, failed int) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Sent %d transactions at %.1f tx/s in %v\n", count, rate, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&s, "Included: %d, failed: %d\n", len(latencies), failed)
+	fmt.Fprintf(&s, "Throughput: %.2f tx/s\n", float64(len(latencies))/elapsed.Seconds())
+	if len(latencies) == 0 {
+		return s.String()
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	last := len(latencies) - 1
+	fmt.Fprintf(&s, "Latency: min %v, avg %v, median %v, 95th percentile %v, max %v\n",
+		latencies[0].Round(time.Millisecond),
+		(sum / time.Duration(len(latencies))).Round(time.Millisecond),
+		latencies[last/2].Round(time.Millisecond),
+		latencies[last*95/100].Round(time.Millisecond),
+		latencies[last].Round(time.Millisecond))
+	return s.String()
+}
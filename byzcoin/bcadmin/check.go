@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func darcCheck(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("please give the expression as argument")
+	}
+	ids, err := checkExpression(expression.Expr(c.Args().First()))
+	if err != nil {
+		return err
+	}
+
+	// The darcs are only looked up if a chain is given.
+	found := make(map[string]bool)
+	if bcArg := c.String("bc"); bcArg != "" {
+		_, cl, err := lib.LoadConfig(bcArg)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id.Darc == nil {
+				continue
+			}
+			ok, err := exists(cl, id.Darc.ID)
+			if err != nil {
+				return err
+			}
+			found[id.String()] = ok
+		}
+	}
+
+	fmt.Fprintln(c.App.Writer, "The expression is valid, it references:")
+	var missing int
+	for _, id := range ids {
+		s := id.String()
+		status, ok := found[s]
+		switch {
+		case !ok:
+			fmt.Fprintf(c.App.Writer, "\t%s\n", s)
+		case status:
+			fmt.Fprintf(c.App.Writer, "\t%s (found on the chain)\n", s)
+		default:
+			fmt.Fprintf(c.App.Writer, "\t%s (NOT found on the chain)\n", s)
+			missing++
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d darcs are not on the chain", missing)
+	}
+	return nil
+}
+
+// checkExpression parses expr and returns the identities it references,
+// sorted, or an error telling which of them are invalid.
+func checkExpression(expr expression.Expr) ([]darc.Identity, error) {
+	var strs []string
+	_, err := expression.Evaluate(expression.InitParser(func(id string) bool {
+		strs = append(strs, id)
+		return false
+	}), expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %v", err)
+	}
+	sort.Strings(strs)
+
+	var ids []darc.Identity
+	var invalid []string
+	for i, s := range strs {
+		if i > 0 && s == strs[i-1] {
+			continue
+		}
+		id, err := darc.ParseIdentity(s)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", s, err))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(invalid) > 0 {
+		return nil, errors.New("invalid identities in the expression:\n\t" + strings.Join(invalid, "\n\t"))
+	}
+	return ids, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/protobuf"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// configHistory prints all the versions of the config instance, as kept in
+// the history of the instances of the node, with the block that created them.
+func configHistory(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	scs, err := getInstanceHistory(cfg, cl, byzcoin.ConfigInstanceID)
+	if err != nil {
+		return err
+	}
+	if len(scs) == 0 {
+		return errors.New("the node has no history of the config")
+	}
+
+	for _, v := range scs {
+		when, err := blockTime(cfg, v.BlockIndex)
+		if err != nil {
+			return err
+		}
+		value := fmtInstanceValue(byzcoin.ContractConfigID, v.StateChange.Value)
+		_, err = fmt.Fprintf(c.App.Writer, "Version %d at block %d (%s):\n\t%s\n", v.StateChange.Version,
+			v.BlockIndex, when.UTC().Format(time.RFC3339), strings.Replace(value, "\n", "\n\t", -1))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instanceHistory prints all the versions of an instance, as kept in the
+// history of the instances of the node.
+func instanceHistory(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	id, err := instanceIDArg(c)
+	if err != nil {
+		return err
+	}
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	scs, err := getInstanceHistory(cfg, cl, id)
+	if err != nil {
+		return err
+	}
+	if len(scs) == 0 {
+		return fmt.Errorf("the node has no history of instance %x", id.Slice())
+	}
+	_, err = fmt.Fprint(c.App.Writer, fmtInstanceHistory(scs, c.Bool("verbose")))
+	return err
+}
+
+// getInstanceHistory returns the state changes of the instance kept by the
+// node, ordered by version.
+func getInstanceHistory(cfg lib.Config, cl *byzcoin.Client, id byzcoin.InstanceID) ([]byzcoin.GetInstanceVersionResponse, error) {
+	var resp byzcoin.GetAllInstanceVersionResponse
+	chooseServer(cl, false)
+	err := withTimeout("getting the history of the instance", func() error {
+		return cl.SendProtobuf(cl.Roster.List[cl.ServerNumber], &byzcoin.GetAllInstanceVersion{
+			SkipChainID: cfg.ByzCoinID,
+			InstanceID:  id,
+		}, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.StateChanges, nil
+}
+
+// fmtInstanceHistory returns one line per version of an instance, followed
+// by a hex dump of the value if verbose is set.
+func fmtInstanceHistory(scs []byzcoin.GetInstanceVersionResponse, verbose bool) string {
+	var s strings.Builder
+	for _, v := range scs {
+		sc := v.StateChange
+		fmt.Fprintf(&s, "Version %d at block %d: %s %s, value of %d bytes\n",
+			sc.Version, v.BlockIndex, sc.StateAction, sc.ContractID, len(sc.Value))
+		if verbose && len(sc.Value) > 0 {
+			s.WriteString(hex.Dump(sc.Value))
+		}
+	}
+	return s.String()
+}
+
+// blockTime returns the timestamp of the block at the given index.
+func blockTime(cfg lib.Config, index int) (time.Time, error) {
+	var reply *skipchain.GetSingleBlockByIndexReply
+	err := withTimeout("getting the block", func() (err error) {
+		reply, err = skipchain.NewClient().GetSingleBlockByIndex(&cfg.Roster, cfg.ByzCoinID, index)
+		return
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	var header byzcoin.DataHeader
+	if err := protobuf.Decode(reply.SkipBlock.Data, &header); err != nil {
+		return time.Time{}, fmt.Errorf("couldn't decode the header of block %d: %v", index, err)
+	}
+	return time.Unix(0, header.Timestamp), nil
+}
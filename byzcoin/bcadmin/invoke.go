@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// invoke sends an instruction calling any command of the contract of an
+// instance, with the arguments given as name=hexvalue.
+func invoke(c *cli.Context) error {
+	if c.NArg() != 5 {
+		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg instanceID contract command")
+	}
+	idBuf, err := hex.DecodeString(c.Args().Get(2))
+	if err != nil {
+		return fmt.Errorf("invalid instance ID: %v", err)
+	}
+	if len(idBuf) != 32 {
+		return errors.New("the instance ID must be 32 bytes long")
+	}
+	id := byzcoin.NewInstanceID(idBuf)
+	contract := c.Args().Get(3)
+	command := c.Args().Get(4)
+	if contract == "" || command == "" {
+		return errors.New("the contract and the command must not be empty")
+	}
+	args, err := parseInvokeArgs(c.StringSlice("arg"))
+	if err != nil {
+		return err
+	}
+
+	_, cl, signer, _, _, err := getBcKey(c)
+	if err != nil {
+		return err
+	}
+
+	// The nodes would refuse the instruction with a less helpful message.
+	inst, err := getInstance(cl, id)
+	if err != nil {
+		return err
+	}
+	if inst.Deleted {
+		return fmt.Errorf("instance %x has been deleted", idBuf)
+	}
+	if inst.ContractID != contract {
+		return fmt.Errorf("instance %x is a %s instance, not a %s instance",
+			idBuf, inst.ContractID, contract)
+	}
+
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: contract,
+				Command:    command,
+				Args:       args,
+			},
+		}},
+	}
+	err = signWithCounters(cl, &ctx, *signer)
+	if err != nil {
+		return err
+	}
+	err = addTransactionAndWait(cl, ctx)
+	if err != nil {
+		return fmt.Errorf("invoke:%s.%s on instance %x failed: %v", contract, command, idBuf, err)
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "invoke:%s.%s on instance %x accepted\n", contract, command, idBuf)
+	return err
+}
+
+// spawn sends an instruction spawning an instance of any contract under the
+// given darc, with the arguments given as name=hexvalue, and prints the ID of
+// the new instance.
+func spawn(c *cli.Context) error {
+	if c.NArg() != 4 {
+		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg darcID contract")
+	}
+	contract := c.Args().Get(3)
+	if contract == "" {
+		return errors.New("the contract must not be empty")
+	}
+	args, err := parseInvokeArgs(c.StringSlice("arg"))
+	if err != nil {
+		return err
+	}
+
+	cfg, cl, signer, _, _, err := getBcKey(c)
+	if err != nil {
+		return err
+	}
+	dstr, err := resolveDarcAlias(cfg, c.Args().Get(2))
+	if err != nil {
+		return err
+	}
+	d, err := getDarcByString(cl, dstr)
+	if err != nil {
+		return err
+	}
+	if err := checkContractRegistered(cl, contract); err != nil {
+		return err
+	}
+
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{{
+			InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: contract,
+				Args:       args,
+			},
+		}},
+	}
+	err = signWithCounters(cl, &ctx, *signer)
+	if err != nil {
+		return err
+	}
+	// Contracts spawning a single instance use this ID, so it is known
+	// before the transaction is accepted.
+	id := ctx.Instructions[0].DeriveID("")
+
+	if c.Bool("wait") {
+		err = addTransactionAndWait(cl, ctx)
+	} else {
+		chooseServer(cl, true)
+		err = withTimeout("sending the transaction", func() error {
+			_, err := cl.AddTransaction(ctx)
+			return err
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("spawn:%s under darc %x failed: %v", contract, d.GetBaseID(), err)
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "%x\n", id.Slice())
+	return err
+}
+
+// checkContractRegistered returns an error if the node doesn't know the
+// contract. Nodes too old to list their contracts accept any contract.
+func checkContractRegistered(cl *byzcoin.Client, contract string) error {
+	var resp *byzcoin.GetVersionResponse
+	chooseServer(cl, false)
+	err := withTimeout("getting the contracts of the node", func() (err error) {
+		resp, err = cl.GetVersion()
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.ContractIDs) == 0 {
+		return nil
+	}
+	for _, id := range resp.ContractIDs {
+		if id == contract {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown contract '%s', the node knows %s", contract,
+		strings.Join(resp.ContractIDs, ", "))
+}
+
+// parseInvokeArgs parses the arguments given as name=hexvalue.
+func parseInvokeArgs(list []string) (byzcoin.Arguments, error) {
+	var args byzcoin.Arguments
+	names := make(map[string]bool)
+	for _, arg := range list {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("argument '%s' must be name=hexvalue", arg)
+		}
+		if names[kv[0]] {
+			return nil, fmt.Errorf("argument '%s' is given twice", kv[0])
+		}
+		names[kv[0]] = true
+		value, err := hex.DecodeString(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("value of argument '%s' is not hex: %v", kv[0], err)
+		}
+		args = append(args, byzcoin.Argument{Name: kv[0], Value: value})
+	}
+	return args, nil
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+)
+
+// DarcAliases maps human-friendly names to darc identity strings, in the
+// form "darc:%x". The aliases are only known locally and are stored per
+// ByzCoin ledger.
+type DarcAliases map[string]string
+
+func darcAliasesFile(bcID skipchain.SkipBlockID) string {
+	return filepath.Join(ConfigPath, fmt.Sprintf("alias-%x.json", bcID))
+}
+
+// LoadDarcAliases returns the darc aliases of the given ledger. If no alias
+// has been stored yet, an empty map is returned.
+func LoadDarcAliases(bcID skipchain.SkipBlockID) (DarcAliases, error) {
+	aliases := make(DarcAliases)
+	buf, err := ioutil.ReadFile(darcAliasesFile(bcID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return aliases, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &aliases); err != nil {
+		return nil, fmt.Errorf("could not read aliases: %v", err)
+	}
+	return aliases, nil
+}
+
+// SaveDarcAliases stores the darc aliases of the given ledger in the
+// ConfigPath directory.
+func SaveDarcAliases(bcID skipchain.SkipBlockID, aliases DarcAliases) error {
+	os.MkdirAll(ConfigPath, 0755)
+
+	buf, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(darcAliasesFile(bcID), buf, 0644)
+}
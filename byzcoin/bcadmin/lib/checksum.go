@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// checksumMagic starts every config and key file written by this package. It
+// is followed by the SHA-256 of the rest of the file, so that a truncated or
+// partially written file is detected when it is loaded.
+var checksumMagic = []byte("bcadmin-sha256-v1\n")
+
+// ReadCheckedFile returns the content of a file written by SaveConfig or
+// SaveKey, after verifying its checksum. Files written by older versions,
+// without checksum, are returned as they are.
+func ReadCheckedFile(fn string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%s is empty", fn)
+	}
+	if !bytes.HasPrefix(buf, checksumMagic) {
+		if len(buf) < len(checksumMagic) && bytes.HasPrefix(checksumMagic, buf) {
+			return nil, fmt.Errorf("%s is corrupted: the file is truncated", fn)
+		}
+		return buf, nil
+	}
+	buf = buf[len(checksumMagic):]
+	if len(buf) < sha256.Size {
+		return nil, fmt.Errorf("%s is corrupted: the file is truncated", fn)
+	}
+	sum, content := buf[:sha256.Size], buf[sha256.Size:]
+	if h := sha256.Sum256(content); !bytes.Equal(h[:], sum) {
+		return nil, fmt.Errorf("%s is corrupted: wrong checksum, the file "+
+			"might have been partially written", fn)
+	}
+	return content, nil
+}
+
+// writeCheckedFile replaces fn with buf, preceded by its checksum. The
+// content is written to a temporary file in the same directory, which is then
+// renamed to fn, so that fn is never partially written.
+func writeCheckedFile(fn string, buf []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not write %v: %v", fn, err)
+	}
+	tmp := f.Name()
+
+	sum := sha256.Sum256(buf)
+	out := append(append(append([]byte{}, checksumMagic...), sum[:]...), buf...)
+	_, err = f.Write(out)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write %v: %v", fn, err)
+	}
+	return nil
+}
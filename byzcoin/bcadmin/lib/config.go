@@ -3,9 +3,11 @@ package lib
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
@@ -13,6 +15,7 @@ import (
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 )
@@ -20,6 +23,10 @@ import (
 // ConfigPath points to where the files will be stored by default.
 var ConfigPath = "."
 
+// PinnedGenesis, if set, is the hash of the genesis block that the clients
+// returned by LoadConfig verify all the proofs against.
+var PinnedGenesis skipchain.SkipBlockID
+
 // Config is the structure used by ol to save its configuration. It holds everything
 // necessary to talk to a ByzCoin instance. The AdminDarc and AdminIdentity
 // can change over the lifetime of a ledger.
@@ -46,12 +53,23 @@ func LoadKeyFromString(id string) (*darc.Signer, error) {
 	return LoadSigner(fn)
 }
 
-// LoadSigner loads a signer from a file given by fn.
+// LoadSigner loads a signer from a file given by fn. If the file is
+// encrypted, the passphrase is asked for.
 func LoadSigner(fn string) (*darc.Signer, error) {
-	buf, err := ioutil.ReadFile(fn)
+	buf, err := ReadCheckedFile(fn)
 	if err != nil {
 		return nil, err
 	}
+	if IsEncryptedKey(buf) {
+		pass, err := Passphrase(fn, false)
+		if err != nil {
+			return nil, err
+		}
+		buf, err = decryptKey(buf, pass)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt %v: %v", fn, err)
+		}
+	}
 
 	var signer darc.Signer
 	err = protobuf.DecodeWithConstructors(buf, &signer,
@@ -63,32 +81,29 @@ func LoadSigner(fn string) (*darc.Signer, error) {
 	return &signer, err
 }
 
-// SaveKey stores a signer in a file.
+// SaveKey stores a signer in a file, together with its checksum. The file is
+// not encrypted, it can be encrypted afterwards with EncryptKeyFile.
 func SaveKey(signer darc.Signer) error {
 	os.MkdirAll(ConfigPath, 0755)
 
 	fn := fmt.Sprintf("key-%s.cfg", signer.Identity())
 	fn = filepath.Join(ConfigPath, fn)
 
-	// perms = 0400 because there is key material inside this file.
-	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0400)
-	if err != nil {
-		return fmt.Errorf("could not write %v: %v", fn, err)
-	}
-
 	buf, err := protobuf.Encode(&signer)
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(buf)
-	if err != nil {
-		return err
+	if _, err := os.Stat(fn); err == nil {
+		log.Warnf("Overwriting the key file %s, the private key is stored "+
+			"unencrypted in it, use 'bcadmin key --encrypt' to protect it "+
+			"with a passphrase", fn)
 	}
-	return f.Close()
+	// perms = 0400 because there is key material inside this file.
+	return writeCheckedFile(fn, buf, 0400)
 }
 
-// SaveConfig stores the config in the ConfigPath directory. It returns the
-// pathname of the stored file.
+// SaveConfig stores the config in the ConfigPath directory, together with its
+// checksum. It returns the pathname of the stored file.
 func SaveConfig(cfg Config) (string, error) {
 	os.MkdirAll(ConfigPath, 0755)
 
@@ -99,7 +114,7 @@ func SaveConfig(cfg Config) (string, error) {
 	if err != nil {
 		return fn, err
 	}
-	err = ioutil.WriteFile(fn, buf, 0644)
+	err = writeCheckedFile(fn, buf, 0644)
 	if err != nil {
 		return fn, err
 	}
@@ -111,7 +126,7 @@ func SaveConfig(cfg Config) (string, error) {
 // Client that can be used to communicate with ByzCoin.
 func LoadConfig(file string) (cfg Config, cl *byzcoin.Client, err error) {
 	var cfgBuf []byte
-	cfgBuf, err = ioutil.ReadFile(file)
+	cfgBuf, err = ReadCheckedFile(file)
 	if err != nil {
 		return
 	}
@@ -121,6 +136,9 @@ func LoadConfig(file string) (cfg Config, cl *byzcoin.Client, err error) {
 		return
 	}
 	cl = byzcoin.NewClient(cfg.ByzCoinID, cfg.Roster)
+	if PinnedGenesis != nil {
+		err = cl.PinGenesis(PinnedGenesis)
+	}
 	return
 }
 
@@ -142,3 +160,29 @@ func ReadRoster(file string) (r *onet.Roster, err error) {
 	}
 	return group.Roster, nil
 }
+
+// ReadRosterOrNode reads the roster file arg. If there is no such file, arg
+// is taken as the address of a single node, either as its URL or as the
+// host:port of the conode, whose websocket port is the next one. The returned
+// roster then only holds this node, without its public key: it can be used
+// to contact the node and ask it for the roster of a chain, but not to
+// verify anything.
+func ReadRosterOrNode(arg string) (*onet.Roster, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return ReadRoster(arg)
+	}
+	url := arg
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		host, port, err := net.SplitHostPort(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither a roster file nor the address of a node", arg)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %s: %v", arg, err)
+		}
+		url = "http://" + net.JoinHostPort(host, strconv.Itoa(p+1))
+	}
+	si := &network.ServerIdentity{Address: network.Address(arg), URL: url}
+	return &onet.Roster{List: []*network.ServerIdentity{si}}, nil
+}
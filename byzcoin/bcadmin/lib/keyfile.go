@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// encryptedKeyMagic starts every encrypted key file, so that LoadSigner can
+// tell them apart from the plaintext ones.
+var encryptedKeyMagic = []byte("bcadmin-encrypted-key-v1\n")
+
+// Parameters of scrypt, as recommended for interactive logins in 2017.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	keySaltLen   = 32
+	keyNonceLen  = 24
+	secretKeyLen = 32
+)
+
+// Passphrase returns the passphrase for the key file fn. If confirm is true,
+// a new passphrase is being chosen and must be asked twice. It prompts on the
+// terminal by default and can be replaced, e.g. for tests.
+var Passphrase = readPassphrase
+
+func readPassphrase(fn string, confirm bool) ([]byte, error) {
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", fn)
+	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase: %v", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase: %v", err)
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return pass, nil
+}
+
+// IsEncryptedKey returns true if buf is the content of an encrypted key file.
+func IsEncryptedKey(buf []byte) bool {
+	return bytes.HasPrefix(buf, encryptedKeyMagic)
+}
+
+// encryptKey seals the content of a key file with a key derived from the
+// passphrase.
+func encryptKey(plain, pass []byte) ([]byte, error) {
+	salt := make([]byte, keySaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	var nonce [keyNonceLen]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedKeyMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, plain, &nonce, key), nil
+}
+
+// decryptKey opens the content of a key file encrypted by encryptKey.
+func decryptKey(buf, pass []byte) ([]byte, error) {
+	if !IsEncryptedKey(buf) {
+		return nil, errors.New("key is not encrypted")
+	}
+	buf = buf[len(encryptedKeyMagic):]
+	if len(buf) < keySaltLen+keyNonceLen+secretbox.Overhead {
+		return nil, errors.New("encrypted key is too short")
+	}
+	salt := buf[:keySaltLen]
+	var nonce [keyNonceLen]byte
+	copy(nonce[:], buf[keySaltLen:])
+	key, err := deriveKey(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, ok := secretbox.Open(nil, buf[keySaltLen+keyNonceLen:], &nonce, key)
+	if !ok {
+		return nil, errors.New("wrong passphrase or corrupted key")
+	}
+	return plain, nil
+}
+
+func deriveKey(pass, salt []byte) (*[secretKeyLen]byte, error) {
+	buf, err := scrypt.Key(pass, salt, scryptN, scryptR, scryptP, secretKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var key [secretKeyLen]byte
+	copy(key[:], buf)
+	return &key, nil
+}
+
+// EncryptKeyFile encrypts the key file fn with a new passphrase.
+func EncryptKeyFile(fn string) error {
+	buf, err := ReadCheckedFile(fn)
+	if err != nil {
+		return err
+	}
+	if IsEncryptedKey(buf) {
+		return errors.New("key is already encrypted")
+	}
+	pass, err := Passphrase(fn, true)
+	if err != nil {
+		return err
+	}
+	enc, err := encryptKey(buf, pass)
+	if err != nil {
+		return err
+	}
+	// perms = 0400 because there is key material inside this file.
+	return writeCheckedFile(fn, enc, 0400)
+}
+
+// DecryptKeyFile stores the key file fn in plaintext again.
+func DecryptKeyFile(fn string) error {
+	buf, err := ReadCheckedFile(fn)
+	if err != nil {
+		return err
+	}
+	pass, err := Passphrase(fn, false)
+	if err != nil {
+		return err
+	}
+	plain, err := decryptKey(buf, pass)
+	if err != nil {
+		return err
+	}
+	return writeCheckedFile(fn, plain, 0400)
+}
@@ -10,16 +10,22 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/big"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/qantik/qrgo"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
@@ -56,6 +62,10 @@ var cmds = cli.Commands{
 				Usage: "the block interval for this ledger",
 				Value: 5 * time.Second,
 			},
+			cli.StringFlag{
+				Name:  "owner",
+				Usage: "the identity of an existing key to use as the owner of the genesis darc, instead of creating a new key",
+			},
 		},
 		Action: create,
 	},
@@ -64,7 +74,7 @@ var cmds = cli.Commands{
 		Name:      "link",
 		Usage:     "link to existing ledger",
 		Aliases:   []string{"ln"},
-		ArgsUsage: "roster.toml [bcid]",
+		ArgsUsage: "(roster.toml | node) [bcid]",
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "admindarc, ad",
@@ -74,6 +84,10 @@ var cmds = cli.Commands{
 				Name:  "adminpub, ap",
 				Usage: "the public key of the admin to use",
 			},
+			cli.StringFlag{
+				Name:  "save-key",
+				Usage: "the hex private key of the admin, to store in a key file",
+			},
 		},
 		Action: link,
 	},
@@ -91,13 +105,20 @@ var cmds = cli.Commands{
 			},
 			cli.IntFlag{
 				Name:  "server",
-				Usage: "which server number from the roster to contact (default: 0)",
-				Value: 0,
+				Usage: "which server number from the roster to contact (default: the fastest one)",
 			},
 			cli.BoolFlag{
 				Name:  "update",
 				Usage: "update the ByzCoin config file with the fetched roster",
 			},
+			cli.BoolFlag{
+				Name:  "watch",
+				Usage: "keep printing the latest block once per block interval until interrupted",
+			},
+			cli.BoolFlag{
+				Name:  "export-json",
+				Usage: "print the chain and its latest block as JSON",
+			},
 		},
 		Action: latest,
 	},
@@ -137,6 +158,120 @@ var cmds = cli.Commands{
 				Action:    debugRemove,
 				ArgsUsage: "private.toml byzcoin-id",
 			},
+			{
+				Name:      "replay",
+				Usage:     "executes again the blocks of a chain on the node, and shows the first block whose trie root differs",
+				Action:    debugReplay,
+				ArgsUsage: "ip:port byzcoin-id",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "to",
+						Usage: "index of the last block to replay (default: the latest block)",
+					},
+				},
+			},
+			{
+				Name:      "bisect",
+				Usage:     "looks for the first block of a range whose replayed trie root differs, with a binary search",
+				Action:    debugBisect,
+				ArgsUsage: "ip:port byzcoin-id",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "from",
+						Usage: "index of the first block of the range",
+					},
+					cli.IntFlag{
+						Name:  "to",
+						Usage: "index of the last block of the range (default: the latest block)",
+					},
+				},
+			},
+			{
+				Name:      "reconcile",
+				Usage:     "compares the history of instances of a chain with their value in the trie of the node",
+				Action:    debugReconcile,
+				ArgsUsage: "ip:port byzcoin-id",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "sample",
+						Value: 100,
+						Usage: "number of instances picked at random, 0 to check all of them",
+					},
+				},
+			},
+			{
+				Name:      "prune",
+				Usage:     "removes from the history of the instances of a chain the state changes before a block, except the latest one of every instance",
+				Action:    debugPrune,
+				ArgsUsage: "ip:port byzcoin-id block-index",
+			},
+			{
+				Name:      "set-log",
+				Usage:     "sets the debug level of a subsystem of byzcoin: block, catchup, viewchange or streaming",
+				Action:    debugSetLog,
+				ArgsUsage: "ip:port [subsystem level]",
+			},
+			{
+				Name:  "download",
+				Usage: "manage the download of the state a node serves to another node",
+				Subcommands: cli.Commands{
+					{
+						Name:      "status",
+						Usage:     "shows the downloads served by the node",
+						Action:    debugDownloadStatus,
+						ArgsUsage: "ip:port",
+					},
+					{
+						Name:      "cancel",
+						Usage:     "stops the downloads served by the node, or only the one with the given nonce",
+						Action:    debugDownloadCancel,
+						ArgsUsage: "ip:port [nonce]",
+					},
+				},
+			},
+			{
+				Name:   "ping",
+				Usage:  "measures the latency to all the nodes of the roster",
+				Action: debugPing,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
+			{
+				Name:      "cosi",
+				Usage:     "shows which nodes co-signed the forward link of a block",
+				Action:    debugCosi,
+				ArgsUsage: "block-index",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.IntFlag{
+						Name:  "level",
+						Usage: "the level of the forward link",
+						Value: 0,
+					},
+				},
+			},
+			{
+				Name:      "counters",
+				Usage:     "shows the signer counters of identities, the next instructions they sign must use the counter + 1",
+				Action:    debugCounters,
+				ArgsUsage: "[identity ...]",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
 		},
 	},
 
@@ -145,6 +280,55 @@ var cmds = cli.Commands{
 		Usage:     "mint coins on account",
 		ArgsUsage: "bc-xxx.cfg key-xxx.cfg public-key #coins",
 		Action:    mint,
+		Flags: []cli.Flag{
+			cli.UintFlag{
+				Name:  "decimals",
+				Usage: "#coins is in units of 10^decimals coins, and can have up to that many decimals",
+			},
+		},
+	},
+
+	{
+		Name:  "coin",
+		Usage: "read the coin accounts",
+		Subcommands: cli.Commands{
+			{
+				Name:      "show",
+				Usage:     "print the balance of an account created by mint",
+				ArgsUsage: "bc-xxx.cfg public-key",
+				Action:    coinShow,
+			},
+		},
+	},
+
+	{
+		Name:      "invoke",
+		Usage:     "invoke any command of the contract of an instance",
+		ArgsUsage: "bc-xxx.cfg key-xxx.cfg instanceID contract command",
+		Action:    invoke,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "arg",
+				Usage: "name=hexvalue: an argument of the command, can be repeated",
+			},
+		},
+	},
+
+	{
+		Name:      "spawn",
+		Usage:     "spawn an instance of any contract and print its ID",
+		ArgsUsage: "bc-xxx.cfg key-xxx.cfg darcID contract",
+		Action:    spawn,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "arg",
+				Usage: "name=hexvalue: an argument of the spawn, can be repeated",
+			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait for the instance to be in a block",
+			},
+		},
 	},
 
 	{
@@ -155,29 +339,62 @@ var cmds = cli.Commands{
 			{
 				Name:      "add",
 				ArgsUsage: "bc-xxx.cfg key-xxx.cfg public.toml",
-				Usage:     "Add a new node to the roster",
+				Usage:     "Add the nodes of the TOML file to the roster",
 				Action:    rosterAdd,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "force",
+						Usage: "add the node even if it doesn't answer",
+					},
+				},
 			},
 			{
 				Name:      "del",
 				ArgsUsage: "bc-xxx.cfg key-xxx.cfg public.toml",
-				Usage:     "Remove a node from the roster",
+				Usage:     "Remove the nodes of the TOML file from the roster",
 				Action:    rosterDel,
 			},
 			{
 				Name:      "leader",
 				ArgsUsage: "bc-xxx.cfg key-xxx.cfg public.toml",
-				Usage:     "Set a specific node to be the leader",
+				Usage:     "Set a specific node to be the leader and wait for its first block",
 				Action:    rosterLeader,
+				Flags: []cli.Flag{
+					cli.DurationFlag{
+						Name:  "wait",
+						Usage: "how long to wait for a block of the new leader (default: 10 block intervals)",
+					},
+				},
+			},
+			{
+				Name:      "list",
+				ArgsUsage: "[bc-xxx.cfg]",
+				Usage:     "List the nodes of the current roster",
+				Action:    rosterList,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the roster as JSON",
+					},
+				},
 			},
 		},
 	},
 
 	{
 		Name:      "config",
-		Usage:     "update the config",
+		Usage:     "update or show the config",
 		ArgsUsage: "bc-xxx.cfg key-xxx.cfg",
 		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "show",
+				Usage: "only print the current config, the key file is not needed",
+			},
 			cli.StringFlag{
 				Name:  "interval",
 				Usage: "change the interval",
@@ -186,8 +403,100 @@ var cmds = cli.Commands{
 				Name:  "blockSize",
 				Usage: "adjust the maximum block size",
 			},
+			cli.IntFlag{
+				Name:  "max-instructions",
+				Usage: "set the maximum number of instructions per transaction, 0 for no limit",
+			},
 		},
 		Action: config,
+		Subcommands: cli.Commands{
+			{
+				Name:   "history",
+				Usage:  "List all the versions of the config, with the block that created them",
+				Action: configHistory,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
+			{
+				Name:      "rebuild",
+				Usage:     "Create the config file of a ledger from the chain, without the admin identity",
+				ArgsUsage: "(roster.toml | node) bcid",
+				Action:    configRebuild,
+			},
+			{
+				Name:      "set",
+				Usage:     "Change several parts of the config in one transaction",
+				ArgsUsage: "bc-xxx.cfg key-xxx.cfg",
+				Action:    configSet,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "interval",
+						Usage: "change the interval",
+					},
+					cli.IntFlag{
+						Name:  "blockSize",
+						Usage: "adjust the maximum block size",
+					},
+					cli.IntFlag{
+						Name:  "max-instructions",
+						Usage: "set the maximum number of instructions per transaction, 0 for no limit",
+					},
+					cli.IntFlag{
+						Name:  "rotation-window",
+						Usage: "set the number of block intervals without heartbeat after which a new leader is chosen, 0 for the default of the nodes",
+					},
+					cli.IntFlag{
+						Name:  "byzcoin-version",
+						Usage: "raise the version of the rules of the chain, once all the nodes support it",
+					},
+					cli.StringFlag{
+						Name:  "add",
+						Usage: "TOML file of a node to add to the roster",
+					},
+					cli.StringFlag{
+						Name:  "del",
+						Usage: "TOML file of a node to remove from the roster",
+					},
+					cli.StringFlag{
+						Name:  "leader",
+						Usage: "TOML file of the node to set as the leader",
+					},
+					cli.StringFlag{
+						Name:  "add-observer",
+						Usage: "TOML file of a node to add to the observers, which follow the chain without being in the roster",
+					},
+					cli.StringFlag{
+						Name:  "del-observer",
+						Usage: "TOML file of a node to remove from the observers",
+					},
+					cli.StringSliceFlag{
+						Name:  "allow-spawn",
+						Usage: "add a contract to the spawn allowlist, can be repeated - once the list is not empty, only its contracts can be spawned",
+					},
+					cli.StringSliceFlag{
+						Name:  "disallow-spawn",
+						Usage: "remove a contract from the spawn allowlist, can be repeated",
+					},
+					cli.BoolFlag{
+						Name:  "allow-all-spawns",
+						Usage: "empty the spawn allowlist, so that all the contracts can be spawned",
+					},
+					cli.StringSliceFlag{
+						Name:  "pin-contract",
+						Usage: "contract=version: the nodes must run this version of the contract to accept blocks, can be repeated",
+					},
+					cli.StringSliceFlag{
+						Name:  "unpin-contract",
+						Usage: "remove the version of a contract the nodes must run, can be repeated",
+					},
+				},
+			},
+		},
 	},
 
 	{
@@ -203,8 +512,29 @@ var cmds = cli.Commands{
 				Name:  "print",
 				Usage: "print the private and public key",
 			},
+			cli.StringFlag{
+				Name:  "encrypt",
+				Usage: "encrypt the given key file with a passphrase",
+			},
+			cli.StringFlag{
+				Name:  "decrypt",
+				Usage: "store the given encrypted key file in plaintext",
+			},
+			cli.StringFlag{
+				Name:  "type",
+				Value: "ed25519",
+				Usage: "type of the new keypair: ed25519 or bls",
+			},
 		},
 		Action: key,
+		Subcommands: cli.Commands{
+			{
+				Name:      "import",
+				Usage:     "stores an existing ed25519 private key in a key file and prints its public identity",
+				Action:    keyImport,
+				ArgsUsage: "[ed25519:]hex-private-key",
+			},
+		},
 	},
 
 	{
@@ -224,7 +554,23 @@ var cmds = cli.Commands{
 					},
 					cli.StringFlag{
 						Name:  "darc",
-						Usage: "the darc to show (no default)",
+						Usage: "the darc or darc alias to show (no default)",
+					},
+				},
+			},
+			{
+				Name:   "list",
+				Usage:  "List all the DARCs of the ledger, sorted by description",
+				Action: darcList,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.BoolFlag{
+						Name:  "verbose, v",
+						Usage: "also print the rules of the DARCs",
 					},
 				},
 			},
@@ -250,6 +596,11 @@ var cmds = cli.Commands{
 						Name:  "owner",
 						Usage: "the identity who is allowed to sign and evolve it (default is a new key pair)",
 					},
+					cli.StringFlag{
+						Name:  "owner_type",
+						Value: "ed25519",
+						Usage: "type of the new key pair if no owner is given: ed25519 or bls",
+					},
 					cli.BoolFlag{
 						Name:  "unrestricted",
 						Usage: "add the invoke:evolve_unrestricted rule",
@@ -266,8 +617,21 @@ var cmds = cli.Commands{
 						Name:  "desc",
 						Usage: "the description for the new DARC (default: random)",
 					},
+					cli.StringFlag{
+						Name:  "template",
+						Usage: "add the rules of a template for the owner, see darc templates (optional)",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "print the new DARC without sending it",
+					},
 				},
 			},
+			{
+				Name:   "templates",
+				Usage:  "List the templates of rules for darc add.",
+				Action: darcTemplatesList,
+			},
 			{
 				Name:   "rule",
 				Usage:  "Edit DARC rules.",
@@ -280,11 +644,15 @@ var cmds = cli.Commands{
 					},
 					cli.StringFlag{
 						Name:  "darc",
-						Usage: "the DARC to update (no default)",
+						Usage: "the DARC or DARC alias to update (no default)",
 					},
 					cli.StringFlag{
 						Name:  "rule",
-						Usage: "the rule to be added, updated or deleted",
+						Usage: "the rule to be added, updated or deleted, or the prefix of the rules to list",
+					},
+					cli.BoolFlag{
+						Name:  "list",
+						Usage: "only list the rules of the DARC",
 					},
 					cli.StringFlag{
 						Name:  "sign",
@@ -302,58 +670,283 @@ var cmds = cli.Commands{
 						Name:  "delete",
 						Usage: "delete the rule",
 					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "print the changes of the rules and the new darc without sending them",
+					},
+				},
+			},
+			{
+				Name:      "instances",
+				Usage:     "List the instances controlled by a DARC",
+				ArgsUsage: "darc:xxx (or a darc alias)",
+				Action:    darcInstances,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
+			{
+				Name:   "audit",
+				Usage:  "Report the rules of all the DARCs of the ledger",
+				Action: darcAudit,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the report as JSON",
+					},
+					cli.IntFlag{
+						Name:  "threshold",
+						Value: 10,
+						Usage: "flag the rules that this many identities can use alone",
+					},
+				},
+			},
+			{
+				Name:      "check",
+				Usage:     "Check an expression before using it in a rule",
+				ArgsUsage: "expression",
+				Action:    darcCheck,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "bc",
+						Usage: "the ByzCoin config to use to look up the darcs (optional)",
+					},
+				},
+			},
+			{
+				Name:  "alias",
+				Usage: "Manage local names for DARCs, usable with --darc",
+				Subcommands: cli.Commands{
+					{
+						Name:      "set",
+						Usage:     "Give a name to a DARC",
+						ArgsUsage: "name darc:xxx",
+						Action:    darcAliasSet,
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "bc",
+								EnvVar: "BC",
+								Usage:  "the ByzCoin config to use (required)",
+							},
+						},
+					},
+					{
+						Name:   "list",
+						Usage:  "List the DARC names",
+						Action: darcAliasList,
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "bc",
+								EnvVar: "BC",
+								Usage:  "the ByzCoin config to use (required)",
+							},
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Remove a DARC name",
+						ArgsUsage: "name",
+						Action:    darcAliasRm,
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "bc",
+								EnvVar: "BC",
+								Usage:  "the ByzCoin config to use (required)",
+							},
+						},
+					},
 				},
 			},
 		},
 	},
 
 	{
-		Name:    "qr",
-		Usage:   "generates a QRCode containing the description of the BC Config",
-		Aliases: []string{"qrcode"},
-		Flags: []cli.Flag{
-			cli.StringFlag{
-				Name:   "bc",
-				EnvVar: "BC",
-				Usage:  "the ByzCoin config to use (required)",
-			},
-			cli.BoolFlag{
-				Name:  "admin",
-				Usage: "If specified, the QR Code will contain the admin keypair",
+		Name:  "tx",
+		Usage: "tool used to inspect transactions",
+		Subcommands: cli.Commands{
+			{
+				Name:      "decode",
+				Usage:     "Print a transaction encoded with protobuf, in binary or hex",
+				ArgsUsage: "file or hex string, or - to read from stdin (default)",
+				Action:    txDecode,
 			},
 		},
-		Action: qrcode,
 	},
-}
-
-var cliApp = cli.NewApp()
-
-// getDataPath is a function pointer so that tests can hook and modify this.
-var getDataPath = cfgpath.GetDataPath
 
-var gitTag = "dev"
-
-func init() {
-	cliApp.Name = "bcadmin"
-	cliApp.Usage = "Create ByzCoin ledgers and grant access to them."
-	cliApp.Version = gitTag
-	cliApp.Commands = cmds
-	cliApp.Flags = []cli.Flag{
-		cli.IntFlag{
-			Name:  "debug, d",
-			Value: 0,
-			Usage: "debug-level: 1 for terse, 5 for maximal",
-		},
-		cli.StringFlag{
-			Name:   "config, c",
-			EnvVar: "BC_CONFIG",
+	{
+		Name:  "instance",
+		Usage: "tool used to manage instances",
+		Subcommands: cli.Commands{
+			{
+				Name:      "get",
+				Usage:     "Print an instance",
+				ArgsUsage: "instance ID in hex",
+				Action:    instanceGet,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the instance as JSON",
+					},
+				},
+			},
+			{
+				Name:      "history",
+				Usage:     "Print all the versions of an instance kept by the node",
+				ArgsUsage: "instance ID in hex",
+				Action:    instanceHistory,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "print a hex dump of the value of every version",
+					},
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete an instance",
+				ArgsUsage: "instance ID in hex",
+				Action:    instanceDelete,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.StringSliceFlag{
+						Name:  "sign",
+						Usage: "public key of a signing entity, can be repeated for instances that need several signatures (default is the admin public key)",
+					},
+					cli.BoolFlag{
+						Name:  "soft",
+						Usage: "keep a tombstone of the instance, if the contract supports it",
+					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:   "bench",
+		Usage:  "measure the transaction throughput of the ledger",
+		Action: bench,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "bc",
+				EnvVar: "BC",
+				Usage:  "the ByzCoin config to use (required)",
+			},
+			cli.StringFlag{
+				Name:  "sign",
+				Usage: "public key of the entity spawning the darc of the benchmark (default is the admin public key)",
+			},
+			cli.IntFlag{
+				Name:  "count",
+				Value: 100,
+				Usage: "number of transactions to send",
+			},
+			cli.Float64Flag{
+				Name:  "rate",
+				Value: 10,
+				Usage: "target number of transactions sent per second",
+			},
+			cli.IntFlag{
+				Name:  "parallel",
+				Value: 10,
+				Usage: "maximum number of transactions waiting for their inclusion at the same time",
+			},
+		},
+	},
+
+	{
+		Name:    "qr",
+		Usage:   "generates a QRCode containing the description of the BC Config",
+		Aliases: []string{"qrcode"},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "bc",
+				EnvVar: "BC",
+				Usage:  "the ByzCoin config to use (required)",
+			},
+			cli.BoolFlag{
+				Name:  "admin",
+				Usage: "If specified, the QR Code will contain the admin keypair",
+			},
+		},
+		Action: qrcode,
+	},
+}
+
+var cliApp = cli.NewApp()
+
+// getDataPath is a function pointer so that tests can hook and modify this.
+var getDataPath = cfgpath.GetDataPath
+
+var gitTag = "dev"
+
+func init() {
+	cliApp.Name = "bcadmin"
+	cliApp.Usage = "Create ByzCoin ledgers and grant access to them."
+	cliApp.Version = gitTag
+	addOverallTimeout(cmds)
+	cliApp.Commands = cmds
+	cliApp.Flags = []cli.Flag{
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug-level: 1 for terse, 5 for maximal",
+		},
+		cli.StringFlag{
+			Name:   "config, c",
+			EnvVar: "BC_CONFIG",
 			Value:  getDataPath(cliApp.Name),
 			Usage:  "path to configuration-directory",
 		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			EnvVar: "BC_TIMEOUT",
+			Usage:  "maximum time for every network operation, the whole command is aborted after 10 times this duration (default: no timeout)",
+		},
+		cli.StringFlag{
+			Name:   "pin-genesis",
+			EnvVar: "BC_PIN_GENESIS",
+			Usage:  "hex hash of the genesis block all the proofs must start from",
+		},
 	}
 	cliApp.Before = func(c *cli.Context) error {
 		log.SetDebugVisible(c.Int("debug"))
 		lib.ConfigPath = c.String("config")
+		timeout = c.Duration("timeout")
+		lib.PinnedGenesis = nil
+		if c.IsSet("pin-genesis") {
+			pin, err := hex.DecodeString(c.String("pin-genesis"))
+			if err != nil || len(pin) != 32 {
+				return errors.New("--pin-genesis needs the hex hash of a genesis block")
+			}
+			lib.PinnedGenesis = pin
+		}
+		return nil
+	}
+	cliApp.After = func(c *cli.Context) error {
+		chosenServers.forgetAll()
 		return nil
 	}
 }
@@ -382,16 +975,33 @@ func create(c *cli.Context) error {
 
 	interval := c.Duration("interval")
 
-	owner := darc.NewSignerEd25519(nil, nil)
+	// The key of an existing owner is managed elsewhere, only its
+	// identity is known.
+	var owner *darc.Signer
+	var identity darc.Identity
+	if o := c.String("owner"); o != "" {
+		identity, err = darc.ParseIdentity(o)
+		if err != nil {
+			return fmt.Errorf("invalid owner: %v", err)
+		}
+	} else {
+		signer := darc.NewSignerEd25519(nil, nil)
+		owner = &signer
+		identity = signer.Identity()
+	}
 
-	req, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, r, []string{"spawn:longTermSecret"}, owner.Identity())
+	req, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, r, []string{"spawn:longTermSecret"}, identity)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 	req.BlockInterval = interval
 
-	_, resp, err := byzcoin.NewLedger(req, false)
+	var resp *byzcoin.CreateGenesisBlockResponse
+	err = withTimeout("creating the ledger", func() (err error) {
+		_, resp, err = byzcoin.NewLedger(req, false)
+		return
+	})
 	if err != nil {
 		return err
 	}
@@ -400,16 +1010,24 @@ func create(c *cli.Context) error {
 		ByzCoinID:     resp.Skipblock.SkipChainID(),
 		Roster:        *r,
 		AdminDarc:     req.GenesisDarc,
-		AdminIdentity: owner.Identity(),
+		AdminIdentity: identity,
 	}
 	fn, err = lib.SaveConfig(cfg)
 	if err != nil {
 		return err
 	}
 
-	err = lib.SaveKey(owner)
-	if err != nil {
-		return err
+	if owner != nil {
+		err = lib.SaveKey(*owner)
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = fmt.Fprintf(c.App.Writer, "The private key of the owner %s is managed externally, "+
+			"it has not been saved.\n", identity)
+		if err != nil {
+			return err
+		}
 	}
 
 	_, err = fmt.Fprintf(c.App.Writer, "Created ByzCoin with ID %x.\n", cfg.ByzCoinID)
@@ -429,9 +1047,9 @@ func create(c *cli.Context) error {
 
 func link(c *cli.Context) error {
 	if c.NArg() < 1 {
-		return errors.New("please give the following args: roster.toml [bcid]")
+		return errors.New("please give the following args: (roster.toml | node) [bcid]")
 	}
-	r, err := lib.ReadRoster(c.Args().First())
+	r, err := lib.ReadRosterOrNode(c.Args().First())
 	if err != nil {
 		return err
 	}
@@ -441,7 +1059,7 @@ func link(c *cli.Context) error {
 		log.Info("Fetching all byzcoin-ids from the roster")
 		var scIDs []skipchain.SkipBlockID
 		for _, si := range r.List {
-			reply, err := scl.GetAllSkipChainIDs(si)
+			reply, err := getAllSkipChainIDs(scl, si)
 			if err != nil {
 				log.Warn("Couldn't contact", si.Address, err)
 			} else {
@@ -469,9 +1087,10 @@ func link(c *cli.Context) error {
 		var cl *byzcoin.Client
 		var cc *byzcoin.ChainConfig
 		for _, si := range r.List {
-			reply, err := scl.GetAllSkipChainIDs(si)
+			reply, err := getAllSkipChainIDs(scl, si)
 			if err != nil {
-				log.Warn("Got error while asking", si.Address, "for skipchains")
+				log.Warn("Got error while asking", si.Address, "for skipchains:", err)
+				continue
 			}
 			found := false
 			for _, idc := range reply.IDs {
@@ -481,9 +1100,18 @@ func link(c *cli.Context) error {
 				}
 			}
 			if found {
-				cl = byzcoin.NewClient(id, *onet.NewRoster([]*network.ServerIdentity{si}))
-				cc, err = cl.GetChainConfig()
+				// The node might be given by its address only, so it cannot
+				// go through onet.NewRoster. The roster of the chain config
+				// replaces it.
+				cl = byzcoin.NewClient(id, onet.Roster{List: []*network.ServerIdentity{si}})
+				if lib.PinnedGenesis != nil {
+					if err = cl.PinGenesis(lib.PinnedGenesis); err != nil {
+						return err
+					}
+				}
+				cc, err = getChainConfig(cl)
 				if err != nil {
+					log.Warn("Couldn't get the chain config from", si.Address, err)
 					cl = nil
 					continue
 				}
@@ -496,6 +1124,7 @@ func link(c *cli.Context) error {
 		}
 		ad := &darc.Darc{}
 		adPub := cothority.Suite.Point()
+		var adSigner *darc.Signer
 		// Accept both plain-darcs, as well as "darc:...." darcs
 		adID, err := stringToDarcID(c.String("admindarc"))
 		if err == nil {
@@ -507,7 +1136,17 @@ func link(c *cli.Context) error {
 			if err = adPub.UnmarshalBinary(adPubBuf); err != nil {
 				return errors.New("got an invalid admin public key: " + err.Error())
 			}
-			p, err := cl.GetProof(adID)
+			if key := c.String("save-key"); key != "" {
+				signer, err := parseEd25519Signer(key)
+				if err != nil {
+					return err
+				}
+				if !signer.Ed25519.Point.Equal(adPub) {
+					return errors.New("the private key of --save-key doesn't match the admin public key")
+				}
+				adSigner = &signer
+			}
+			p, err := getProof(cl, adID)
 			if err != nil {
 				return errors.New("couldn't get proof for admin-darc: " + err.Error())
 			}
@@ -525,6 +1164,8 @@ func link(c *cli.Context) error {
 			if err != nil {
 				return errors.New("invalid darc stored in byzcoin: " + err.Error())
 			}
+		} else if c.String("save-key") != "" {
+			return errors.New("--save-key needs the admin darc and public key")
 		}
 		log.Infof("ByzCoin-config for %+x:\n"+
 			"\tRoster: %s\n"+
@@ -542,6 +1183,12 @@ func link(c *cli.Context) error {
 			return errors.New("while writing config-file: " + err.Error())
 		}
 		log.Info("Wrote config to", path.Join(lib.ConfigPath, fn))
+		if adSigner != nil {
+			if err := lib.SaveKey(*adSigner); err != nil {
+				return errors.New("while writing the key file: " + err.Error())
+			}
+			log.Info("Wrote the key of", adSigner.Identity())
+		}
 	}
 	return nil
 }
@@ -555,36 +1202,49 @@ func latest(c *cli.Context) error {
 		}
 	}
 
+	exportJSON := c.Bool("export-json")
+	if exportJSON && c.Bool("watch") {
+		return errors.New("--export-json can't be used with --watch")
+	}
+
 	cfg, cl, err := lib.LoadConfig(bcArg)
 	if err != nil {
 		return err
 	}
 
-	// Allow the user to set the server number; useful when testing leader rotation.
-	cl.ServerNumber = c.Int("server")
-	if cl.ServerNumber > len(cl.Roster.List)-1 {
-		return errors.New("server index out of range")
+	// Allow the user to set the server number; useful when testing leader
+	// rotation. Otherwise the fastest node is used.
+	if c.IsSet("server") {
+		cl.ServerNumber = c.Int("server")
+		if cl.ServerNumber < 0 || cl.ServerNumber > len(cl.Roster.List)-1 {
+			return errors.New("server index out of range")
+		}
+		pinServer(cl)
+	} else {
+		chooseServer(cl, false)
 	}
 
-	_, err = fmt.Fprintf(c.App.Writer, "ByzCoinID: %x\n", cfg.ByzCoinID)
-	if err != nil {
-		return err
-	}
-	_, err = fmt.Fprintf(c.App.Writer, "Admin DARC: %x\n", cfg.AdminDarc.GetBaseID())
-	if err != nil {
-		return err
-	}
-	_, err = fmt.Fprintln(c.App.Writer, "local roster:", fmtRoster(&cfg.Roster))
-	if err != nil {
-		return err
-	}
-	_, err = fmt.Fprintln(c.App.Writer, "contacting server:", cl.Roster.List[cl.ServerNumber])
-	if err != nil {
-		return err
+	if !exportJSON {
+		_, err = fmt.Fprintf(c.App.Writer, "ByzCoinID: %x\n", cfg.ByzCoinID)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.App.Writer, "Admin DARC: %x\n", cfg.AdminDarc.GetBaseID())
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, "local roster:", fmtRoster(&cfg.Roster))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, "contacting server:", cl.Roster.List[cl.ServerNumber])
+		if err != nil {
+			return err
+		}
 	}
 
 	// Find the latest block by asking for the Proof of the config instance.
-	p, err := cl.GetProof(byzcoin.ConfigInstanceID.Slice())
+	p, err := getProof(cl, byzcoin.ConfigInstanceID.Slice())
 	if err != nil {
 		return err
 	}
@@ -595,17 +1255,29 @@ func latest(c *cli.Context) error {
 	}
 
 	sb := p.Proof.Latest
-	_, err = fmt.Fprintf(c.App.Writer, "Last block:\n\tIndex: %d\n\tBlockMaxHeight: %d\n\tBackLinks: %d\n\tRoster: %s\n\n",
-		sb.Index, sb.Height, len(sb.BackLinkIDs), fmtRoster(sb.Roster))
-	if err != nil {
-		return err
+	if exportJSON {
+		buf, err := json.MarshalIndent(newLatestInfo(cfg, &sb), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, string(buf))
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = fmt.Fprintf(c.App.Writer, "Last block:\n\tIndex: %d\n\tBlockMaxHeight: %d\n\tBackLinks: %d\n\tRoster: %s\n\n",
+			sb.Index, sb.Height, len(sb.BackLinkIDs), fmtRoster(sb.Roster))
+		if err != nil {
+			return err
+		}
 	}
 
 	if c.Bool("update") {
 		cfg.Roster = *sb.Roster
 		var fn string
 		fn, err = lib.SaveConfig(cfg)
-		if err == nil {
+		// The JSON output must stay parsable.
+		if err == nil && !exportJSON {
 			_, err = fmt.Fprintln(c.App.Writer, "updated config file:", fn)
 			if err != nil {
 				return err
@@ -613,9 +1285,46 @@ func latest(c *cli.Context) error {
 		}
 	}
 
+	if err == nil && c.Bool("watch") {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		defer signal.Stop(sig)
+		stop := make(chan struct{})
+		go func() {
+			<-sig
+			close(stop)
+		}()
+		err = watchLatest(c.App.Writer, cl, stop)
+	}
 	return err
 }
 
+// latestInfo is the JSON description of a chain and its latest block
+// printed by latest.
+type latestInfo struct {
+	ByzCoinID string
+	AdminDarc string
+	Roster    []string
+	Index     int
+	Height    int
+	BackLinks int
+}
+
+func newLatestInfo(cfg lib.Config, sb *skipchain.SkipBlock) latestInfo {
+	info := latestInfo{
+		ByzCoinID: hex.EncodeToString(cfg.ByzCoinID),
+		AdminDarc: hex.EncodeToString(cfg.AdminDarc.GetBaseID()),
+		Roster:    []string{},
+		Index:     sb.Index,
+		Height:    sb.Height,
+		BackLinks: len(sb.BackLinkIDs),
+	}
+	for _, si := range sb.Roster.List {
+		info.Roster = append(info.Roster, string(si.Address))
+	}
+	return info
+}
+
 func fmtRoster(r *onet.Roster) string {
 	var roster []string
 	for _, s := range r.List {
@@ -646,63 +1355,84 @@ func getBcKey(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.
 	}
 
 	log.Lvl2("Getting latest chainConfig")
-	pr, err := cl.GetProof(byzcoin.ConfigInstanceID.Slice())
+	var reply *byzcoin.GetChainConfigResponse
+	chooseServer(cl, false)
+	err = withTimeout("getting the chain config", func() (err error) {
+		reply, err = cl.GetChainConfigAndProof()
+		return
+	})
 	if err != nil {
-		err = errors.New("couldn't get proof for chainConfig: " + err.Error())
+		err = errors.New("couldn't get chainConfig: " + err.Error())
 		return
 	}
-	proof = pr.Proof
+	proof = reply.Proof
+	chainCfg = reply.Config
+	return
+}
 
-	_, value, _, _, err := proof.KeyValue()
+func getBcKeyPub(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.Signer,
+	proof byzcoin.Proof, chainCfg byzcoin.ChainConfig, pub *network.ServerIdentity, err error) {
+	cfg, cl, signer, proof, chainCfg, err = getBcKey(c)
 	if err != nil {
-		err = errors.New("couldn't get value out of proof: " + err.Error())
 		return
 	}
-	err = protobuf.DecodeWithConstructors(value, &chainCfg, network.DefaultConstructors(cothority.Suite))
+
+	pub, err = readServerIdentity(c.Args().Get(2))
+	return
+}
+
+func getBcKeyPubs(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.Signer,
+	proof byzcoin.Proof, chainCfg byzcoin.ChainConfig, pubs []*network.ServerIdentity, err error) {
+	cfg, cl, signer, proof, chainCfg, err = getBcKey(c)
 	if err != nil {
-		err = errors.New("couldn't decode chainConfig: " + err.Error())
 		return
 	}
+
+	pubs, err = readServerIdentities(c.Args().Get(2))
 	return
 }
 
-func getBcKeyPub(c *cli.Context) (cfg lib.Config, cl *byzcoin.Client, signer *darc.Signer,
-	proof byzcoin.Proof, chainCfg byzcoin.ChainConfig, pub *network.ServerIdentity, err error) {
-	cfg, cl, signer, proof, chainCfg, err = getBcKey(c)
+// readServerIdentity returns the node described in the TOML file fn.
+func readServerIdentity(fn string) (*network.ServerIdentity, error) {
+	list, err := readServerIdentities(fn)
 	if err != nil {
-		return
+		return nil, err
+	}
+	if len(list) != 1 {
+		return nil, errors.New("the TOML file should have exactly one entry")
 	}
+	return list[0], nil
+}
 
-	fn := c.Args().Get(2)
+// readServerIdentities returns the nodes described in the TOML file fn. A node
+// given twice is an error.
+func readServerIdentities(fn string) ([]*network.ServerIdentity, error) {
 	if fn == "" {
-		err = errors.New("no TOML file provided")
-		return
+		return nil, errors.New("no TOML file provided")
 	}
 	f, err := os.Open(fn)
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer f.Close()
 	group, err := app.ReadGroupDescToml(f)
 	if err != nil {
-		err = fmt.Errorf("couldn't open %v: %v", fn, err.Error())
-		return
+		return nil, fmt.Errorf("couldn't open %v: %v", fn, err.Error())
 	}
-	if len(group.Roster.List) != 1 {
-		err = errors.New("the TOML file should have exactly one entry")
-		return
+	if group.Roster == nil || len(group.Roster.List) == 0 {
+		return nil, errors.New("the TOML file has no entry")
 	}
-	pub = group.Roster.List[0]
-
-	return
+	seen := make(map[network.ServerIdentityID]bool)
+	for _, si := range group.Roster.List {
+		if seen[si.ID] {
+			return nil, fmt.Errorf("node %s is given twice in %v", si.Address, fn)
+		}
+		seen[si.ID] = true
+	}
+	return group.Roster.List, nil
 }
 
 func updateConfig(cl *byzcoin.Client, signer *darc.Signer, chainConfig byzcoin.ChainConfig) error {
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return errors.New("couldn't get counters: " + err.Error())
-	}
-	counters.Counters[0]++
 	ccBuf, err := protobuf.Encode(&chainConfig)
 	if err != nil {
 		return errors.New("couldn't encode chainConfig: " + err.Error())
@@ -715,17 +1445,16 @@ func updateConfig(cl *byzcoin.Client, signer *darc.Signer, chainConfig byzcoin.C
 				Command:    "update_config",
 				Args:       byzcoin.Arguments{{Name: "config", Value: ccBuf}},
 			},
-			SignerCounter: counters.Counters,
 		}},
 	}
 
-	err = ctx.FillSignersAndSignWith(*signer)
+	err = signWithCounters(cl, &ctx, *signer)
 	if err != nil {
 		return errors.New("couldn't sign the clientTransaction: " + err.Error())
 	}
 
 	log.Lvl1("Sending new roster to byzcoin")
-	_, err = cl.AddTransactionAndWait(ctx, 10)
+	err = addTransactionAndWait(cl, ctx)
 	if err != nil {
 		return errors.New("client transaction wasn't accepted: " + err.Error())
 	}
@@ -733,6 +1462,9 @@ func updateConfig(cl *byzcoin.Client, signer *darc.Signer, chainConfig byzcoin.C
 }
 
 func config(c *cli.Context) error {
+	if c.Bool("show") {
+		return configShow(c)
+	}
 	_, cl, signer, _, chainConfig, err := getBcKey(c)
 	if err != nil {
 		return err
@@ -751,6 +1483,9 @@ func config(c *cli.Context) error {
 		}
 		chainConfig.MaxBlockSize = blockSize
 	}
+	if c.IsSet("max-instructions") {
+		chainConfig.MaxInstructionsPerTx = c.Int("max-instructions")
+	}
 
 	err = updateConfig(cl, signer, chainConfig)
 	if err != nil {
@@ -762,56 +1497,405 @@ func config(c *cli.Context) error {
 	return nil
 }
 
-func mint(c *cli.Context) error {
-	if c.NArg() < 4 {
-		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg pubkey coins")
+// configShow prints the current chain config, which only needs the config
+// file of the ledger.
+func configShow(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give the following arguments: bc-xxx.cfg")
 	}
-	cfg, cl, signer, _, _, err := getBcKey(c)
+	_, cl, err := lib.LoadConfig(c.Args().First())
 	if err != nil {
-		return err
+		return errors.New("couldn't load config file: " + err.Error())
 	}
-
-	pubBuf, err := hex.DecodeString(c.Args().Get(2))
+	cc, err := getChainConfig(cl)
 	if err != nil {
 		return err
 	}
+	_, err = fmt.Fprintln(c.App.Writer, fmtChainConfig(cc))
+	return err
+}
 
-	h := sha256.New()
-	h.Write([]byte(contracts.ContractCoinID))
-	h.Write(pubBuf)
-	account := byzcoin.NewInstanceID(h.Sum(nil))
-
-	coins, err := strconv.ParseUint(c.Args().Get(3), 10, 64)
+// configSet applies all the requested changes in a single update_config
+// transaction, so that there is no intermediate config on the chain.
+func configSet(c *cli.Context) error {
+	_, cl, signer, _, chainConfig, err := getBcKey(c)
 	if err != nil {
 		return err
 	}
-	coinsBuf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(coinsBuf, coins)
+	oldConfig := chainConfig
 
-	cReply, err := cl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return err
+	if interval := c.String("interval"); interval != "" {
+		dur, err := time.ParseDuration(interval)
+		if err != nil {
+			return errors.New("couldn't parse interval: " + err.Error())
+		}
+		chainConfig.BlockInterval = dur
+	}
+	if blockSize := c.Int("blockSize"); blockSize > 0 {
+		chainConfig.MaxBlockSize = blockSize
+	}
+	if c.IsSet("max-instructions") {
+		chainConfig.MaxInstructionsPerTx = c.Int("max-instructions")
+	}
+	if c.IsSet("rotation-window") {
+		chainConfig.RotationWindow = c.Int("rotation-window")
+	}
+	if c.IsSet("byzcoin-version") {
+		chainConfig.ByzCoinVersion = c.Int("byzcoin-version")
 	}
-	counters := cReply.Counters
 
-	p, err := cl.GetProof(account.Slice())
-	if err != nil {
-		return err
+	// Work on a copy, so that oldConfig keeps the current roster.
+	list := append([]*network.ServerIdentity{}, chainConfig.Roster.List...)
+	search := func(fn string) (int, *network.ServerIdentity, error) {
+		si, err := readServerIdentity(fn)
+		if err != nil {
+			return 0, nil, err
+		}
+		i, _ := onet.NewRoster(list).Search(si.ID)
+		return i, si, nil
 	}
-	if !p.Proof.InclusionProof.Match(account.Slice()) {
-		log.Info("Creating darc and coin")
-		pub := cothority.Suite.Point()
-		err = pub.UnmarshalBinary(pubBuf)
+	if fn := c.String("add"); fn != "" {
+		i, si, err := search(fn)
 		if err != nil {
 			return err
 		}
-		pubI := darc.NewIdentityEd25519(pub)
-		rules := darc.NewRules()
-		err = rules.AddRule(darc.Action("spawn:coin"), expression.Expr(signer.Identity().String()))
+		if i >= 0 {
+			return errors.New("new node is already in roster")
+		}
+		list = append(list, si)
+	}
+	if fn := c.String("del"); fn != "" {
+		i, _, err := search(fn)
 		if err != nil {
 			return err
 		}
-		err = rules.AddRule(darc.Action("invoke:coin.transfer"), expression.Expr(pubI.String()))
+		switch {
+		case i < 0:
+			return errors.New("node to delete is not in roster")
+		case i == 0:
+			return errors.New("cannot delete leader from roster")
+		}
+		list = append(list[0:i], list[i+1:]...)
+	}
+	if fn := c.String("leader"); fn != "" {
+		i, _, err := search(fn)
+		if err != nil {
+			return err
+		}
+		switch {
+		case i < 0:
+			return errors.New("new leader is not in roster")
+		case i == 0:
+			return errors.New("new node is already leader")
+		}
+		list[0], list[i] = list[i], list[0]
+	}
+	chainConfig.Roster = *onet.NewRoster(list)
+
+	// onet.NewRoster doesn't accept an empty list of observers.
+	observers := onet.Roster{List: append([]*network.ServerIdentity{}, chainConfig.Observers...)}
+	if fn := c.String("add-observer"); fn != "" {
+		si, err := readServerIdentity(fn)
+		if err != nil {
+			return err
+		}
+		if i, _ := observers.Search(si.ID); i >= 0 {
+			return errors.New("new observer is already an observer")
+		}
+		observers.List = append(observers.List, si)
+	}
+	if fn := c.String("del-observer"); fn != "" {
+		si, err := readServerIdentity(fn)
+		if err != nil {
+			return err
+		}
+		i, _ := observers.Search(si.ID)
+		if i < 0 {
+			return errors.New("node to delete is not an observer")
+		}
+		observers.List = append(observers.List[0:i], observers.List[i+1:]...)
+	}
+	chainConfig.Observers = observers.List
+
+	spawnIDs, err := updateSpawnContractIDs(oldConfig.SpawnContractIDs,
+		c.StringSlice("allow-spawn"), c.StringSlice("disallow-spawn"), c.Bool("allow-all-spawns"))
+	if err != nil {
+		return err
+	}
+	chainConfig.SpawnContractIDs = spawnIDs
+
+	versions, err := updateContractVersions(oldConfig.ContractVersions,
+		c.StringSlice("pin-contract"), c.StringSlice("unpin-contract"))
+	if err != nil {
+		return err
+	}
+	chainConfig.ContractVersions = versions
+
+	// Refuse the changes the nodes would refuse, before sending them.
+	if err = oldConfig.CheckNewConfig(chainConfig); err != nil {
+		return errors.New("invalid config: " + err.Error())
+	}
+
+	err = updateConfig(cl, signer, chainConfig)
+	if err != nil {
+		return err
+	}
+	log.Lvl1("Updated configuration")
+	return nil
+}
+
+// updateSpawnContractIDs returns the spawn allowlist ids after removing the
+// contracts of del, or all of them if clear is set, and adding the ones of
+// add.
+func updateSpawnContractIDs(ids, add, del []string, clear bool) ([]string, error) {
+	var out []string
+	if !clear {
+	idLoop:
+		for _, id := range ids {
+			for _, d := range del {
+				if id == d {
+					continue idLoop
+				}
+			}
+			out = append(out, id)
+		}
+	}
+	for _, a := range add {
+		if a == "" {
+			return nil, errors.New("empty contract ID in --allow-spawn")
+		}
+		found := false
+		for _, id := range out {
+			found = found || id == a
+		}
+		if !found {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// updateContractVersions returns the versions after removing the contracts of
+// unpin and setting the ones of pin, given as contract=version.
+func updateContractVersions(versions []byzcoin.ContractVersion, pin, unpin []string) ([]byzcoin.ContractVersion, error) {
+	var out []byzcoin.ContractVersion
+versionLoop:
+	for _, cv := range versions {
+		for _, u := range unpin {
+			if cv.ContractID == u {
+				continue versionLoop
+			}
+		}
+		out = append(out, cv)
+	}
+pinLoop:
+	for _, p := range pin {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("--pin-contract must be contract=version, got '%s'", p)
+		}
+		for i := range out {
+			if out[i].ContractID == kv[0] {
+				out[i].Version = kv[1]
+				continue pinLoop
+			}
+		}
+		out = append(out, byzcoin.ContractVersion{ContractID: kv[0], Version: kv[1]})
+	}
+	return out, nil
+}
+
+// configRebuild creates the config file of a ledger from the chain alone. As
+// the admin identity cannot be found on the chain, it is left empty.
+func configRebuild(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("please give the following args: (roster.toml | node) bcid")
+	}
+	r, err := lib.ReadRosterOrNode(c.Args().First())
+	if err != nil {
+		return err
+	}
+	id, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil || len(id) != 32 {
+		return errors.New("second argument is not a valid ID")
+	}
+
+	cl := byzcoin.NewClient(id, *r)
+	if lib.PinnedGenesis != nil {
+		if err = cl.PinGenesis(lib.PinnedGenesis); err != nil {
+			return err
+		}
+	}
+	cc, err := getChainConfig(cl)
+	if err != nil {
+		return fmt.Errorf("couldn't get the chain config: %v", err)
+	}
+	cl.Roster = cc.Roster
+	chosenServers.forget(cl)
+
+	// The config instance is controlled by the genesis darc.
+	inst, err := getInstance(cl, byzcoin.ConfigInstanceID)
+	if err != nil {
+		return err
+	}
+	d, err := getDarcByID(cl, inst.DarcID)
+	if err != nil {
+		return err
+	}
+
+	fn, err := lib.SaveConfig(lib.Config{
+		Roster:    cc.Roster,
+		ByzCoinID: id,
+		AdminDarc: *d,
+	})
+	if err != nil {
+		return errors.New("while writing config-file: " + err.Error())
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Wrote config to %s\n"+
+		"The admin identity is not set: give the signing key with --sign, or\n"+
+		"use link with --admindarc and --adminpub to set it.\n", fn)
+	return err
+}
+
+// coinsSeparators are the thousands separators accepted in an amount.
+var coinsSeparators = strings.NewReplacer(",", "", "_", "", "'", "", " ", "")
+
+// parseCoins returns the number of coins of an amount given in units of
+// 10^decimals coins, like 1,000,000.5. Digits after the decimal point are
+// accepted beyond the decimals only if they are zeros.
+func parseCoins(amount string, decimals uint) (uint64, error) {
+	s := coinsSeparators.Replace(amount)
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 || parts[0] == "" {
+		return 0, fmt.Errorf("invalid amount '%s'", amount)
+	}
+	frac := ""
+	if len(parts) == 2 {
+		frac = strings.TrimRight(parts[1], "0")
+		if parts[1] == "" {
+			return 0, fmt.Errorf("invalid amount '%s'", amount)
+		}
+	}
+	if uint(len(frac)) > decimals {
+		return 0, fmt.Errorf("amount '%s' has more than %d decimals", amount, decimals)
+	}
+	digits := parts[0] + frac + strings.Repeat("0", int(decimals)-len(frac))
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return 0, fmt.Errorf("invalid amount '%s'", amount)
+		}
+	}
+	coins, ok := new(big.Int).SetString(digits, 10)
+	if !ok || !coins.IsUint64() {
+		return 0, fmt.Errorf("amount '%s' is larger than the maximum of %d coins",
+			amount, uint64(math.MaxUint64))
+	}
+	return coins.Uint64(), nil
+}
+
+// coinAccountID returns the ID of the coin instance mint creates for the
+// public key.
+func coinAccountID(pubBuf []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(contracts.ContractCoinID))
+	h.Write(pubBuf)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+func coinShow(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("please give the following arguments: bc-xxx.cfg public-key")
+	}
+	_, cl, err := lib.LoadConfig(c.Args().First())
+	if err != nil {
+		return err
+	}
+	pubBuf, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	account := coinAccountID(pubBuf)
+
+	p, err := getProof(cl, account.Slice())
+	if err != nil {
+		return err
+	}
+	if !p.Proof.InclusionProof.Match(account.Slice()) {
+		return fmt.Errorf("account %x does not exist yet", account.Slice())
+	}
+	_, buf, cid, _, err := p.Proof.KeyValue()
+	if err != nil {
+		return err
+	}
+	if cid != contracts.ContractCoinID {
+		return fmt.Errorf("instance %x is a %s instance, not a coin account", account.Slice(), cid)
+	}
+	var coin byzcoin.Coin
+	if err := protobuf.Decode(buf, &coin); err != nil {
+		return fmt.Errorf("couldn't decode the coin account: %v", err)
+	}
+	_, err = fmt.Fprint(c.App.Writer, fmtCoin(account, coin))
+	return err
+}
+
+// fmtCoin returns the balance and the type of a coin account.
+func fmtCoin(account byzcoin.InstanceID, coin byzcoin.Coin) string {
+	coinType := fmt.Sprintf("%x", coin.Name.Slice())
+	if coin.Name.Equal(contracts.CoinName) {
+		coinType += " (byzCoin)"
+	}
+	return fmt.Sprintf("Account: %x\nBalance: %d\nType: %s\n", account.Slice(), coin.Value, coinType)
+}
+
+func mint(c *cli.Context) error {
+	if c.NArg() < 4 {
+		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg pubkey coins")
+	}
+	cfg, cl, signer, _, _, err := getBcKey(c)
+	if err != nil {
+		return err
+	}
+
+	pubBuf, err := hex.DecodeString(c.Args().Get(2))
+	if err != nil {
+		return err
+	}
+
+	account := coinAccountID(pubBuf)
+
+	coins, err := parseCoins(c.Args().Get(3), c.Uint("decimals"))
+	if err != nil {
+		return err
+	}
+	coinsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coinsBuf, coins)
+
+	cReply, err := getSignerCounters(cl, signer.Identity().String())
+	if err != nil {
+		return err
+	}
+	counters := cReply.Counters
+
+	// Only the existence of the account is needed, so a full proof is not
+	// worth its size.
+	found, err := exists(cl, account.Slice())
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Info("Creating darc and coin")
+		pub := cothority.Suite.Point()
+		err = pub.UnmarshalBinary(pubBuf)
+		if err != nil {
+			return err
+		}
+		pubI := darc.NewIdentityEd25519(pub)
+		rules := darc.NewRules()
+		err = rules.AddRule(darc.Action("spawn:coin"), expression.Expr(signer.Identity().String()))
+		if err != nil {
+			return err
+		}
+		err = rules.AddRule(darc.Action("invoke:coin.transfer"), expression.Expr(pubI.String()))
 		if err != nil {
 			return err
 		}
@@ -826,61 +1910,64 @@ func mint(c *cli.Context) error {
 		}
 
 		log.Info("Creating darc for coin")
+		darcArgs, err := byzcoin.NewArguments("spawn:"+byzcoin.ContractDarcID,
+			map[string][]byte{"darc": dBuf})
+		if err != nil {
+			return err
+		}
 		counters[0]++
 		ctx := byzcoin.ClientTransaction{
 			Instructions: byzcoin.Instructions{{
 				InstanceID: byzcoin.NewInstanceID(cfg.AdminDarc.GetBaseID()),
 				Spawn: &byzcoin.Spawn{
 					ContractID: byzcoin.ContractDarcID,
-					Args: byzcoin.Arguments{{
-						Name:  "darc",
-						Value: dBuf,
-					}},
+					Args:       darcArgs,
 				},
 				SignerCounter: counters,
 			}},
 		}
-		err = ctx.FillSignersAndSignWith(*signer)
+		err = signTx(cl, &ctx, *signer)
 		if err != nil {
 			return err
 		}
-		_, err = cl.AddTransactionAndWait(ctx, 10)
+		err = addTransactionAndWait(cl, ctx)
 		if err != nil {
 			return err
 		}
 
 		log.Info("Creating coin")
+		coinArgs, err := byzcoin.NewArguments("spawn:"+contracts.ContractCoinID,
+			map[string][]byte{"type": contracts.CoinName.Slice(), "coinID": pubBuf})
+		if err != nil {
+			return err
+		}
 		counters[0]++
 		ctx = byzcoin.ClientTransaction{
 			Instructions: byzcoin.Instructions{{
 				InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
 				Spawn: &byzcoin.Spawn{
 					ContractID: contracts.ContractCoinID,
-					Args: byzcoin.Arguments{
-						{
-							Name:  "type",
-							Value: contracts.CoinName.Slice(),
-						},
-						{
-							Name:  "coinID",
-							Value: pubBuf,
-						},
-					},
+					Args:       coinArgs,
 				},
 				SignerCounter: counters,
 			}},
 		}
-		err = ctx.FillSignersAndSignWith(*signer)
+		err = signTx(cl, &ctx, *signer)
 		if err != nil {
 			return err
 		}
-		_, err = cl.AddTransactionAndWait(ctx, 10)
+		err = addTransactionAndWait(cl, ctx)
 		if err != nil {
 			return err
 		}
 	}
 
 	log.Info("Minting coin")
+	mintArgs, err := byzcoin.NewArguments("invoke:"+contracts.ContractCoinID+".mint",
+		map[string][]byte{"coins": coinsBuf})
+	if err != nil {
+		return err
+	}
 	counters[0]++
 	ctx := byzcoin.ClientTransaction{
 		Instructions: byzcoin.Instructions{{
@@ -888,19 +1975,16 @@ func mint(c *cli.Context) error {
 			Invoke: &byzcoin.Invoke{
 				ContractID: contracts.ContractCoinID,
 				Command:    "mint",
-				Args: byzcoin.Arguments{{
-					Name:  "coins",
-					Value: coinsBuf,
-				}},
+				Args:       mintArgs,
 			},
 			SignerCounter: counters,
 		}},
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
+	err = signTx(cl, &ctx, *signer)
 	if err != nil {
 		return err
 	}
-	_, err = cl.AddTransactionAndWait(ctx, 10)
+	err = addTransactionAndWait(cl, ctx)
 	if err != nil {
 		return err
 	}
@@ -913,22 +1997,41 @@ func rosterAdd(c *cli.Context) error {
 	if c.NArg() < 3 {
 		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg newServer.toml")
 	}
-	_, cl, signer, _, chainConfig, pub, err := getBcKeyPub(c)
+	_, cl, signer, _, chainConfig, pubs, err := getBcKeyPubs(c)
 	if err != nil {
 		return err
 	}
 
-	old := chainConfig.Roster
-	if i, _ := old.Search(pub.ID); i >= 0 {
-		return errors.New("new node is already in roster")
+	// All the nodes are checked before the first change, so that an error
+	// doesn't leave the roster half updated.
+	for _, pub := range pubs {
+		if i, _ := chainConfig.Roster.Search(pub.ID); i >= 0 {
+			return rosterNodeError(pubs, pub, "new node is already in roster")
+		}
+		// A node that doesn't answer would stall the chain once it
+		// becomes the leader.
+		if !c.Bool("force") {
+			if _, err := getAllSkipChainIDs(skipchain.NewClient(), pub); err != nil {
+				return fmt.Errorf("the new node %s is unreachable, use --force to add it anyway: %v",
+					pub.Address, err)
+			}
+		}
 	}
-	log.Lvl2("Old roster is:", old.List)
-	chainConfig.Roster = *old.Concat(pub)
-	log.Lvl2("New roster is:", chainConfig.Roster.List)
 
-	err = updateConfig(cl, signer, chainConfig)
-	if err != nil {
-		return err
+	// The roster can only change by one node per block.
+	for i, pub := range pubs {
+		old := chainConfig.Roster
+		log.Lvl2("Old roster is:", old.List)
+		chainConfig.Roster = *old.Concat(pub)
+		log.Lvl2("New roster is:", chainConfig.Roster.List)
+
+		if len(pubs) > 1 {
+			log.Infof("Adding node %d/%d: %s", i+1, len(pubs), pub.Address)
+		}
+		err = updateConfig(cl, signer, chainConfig)
+		if err != nil {
+			return rosterNodeError(pubs, pub, err.Error())
+		}
 	}
 	log.Lvl1("New roster is now active")
 	return nil
@@ -938,32 +2041,52 @@ func rosterDel(c *cli.Context) error {
 	if c.NArg() < 3 {
 		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg serverToDelete.toml")
 	}
-	_, cl, signer, _, chainConfig, pub, err := getBcKeyPub(c)
+	_, cl, signer, _, chainConfig, pubs, err := getBcKeyPubs(c)
 	if err != nil {
 		return err
 	}
 
-	old := chainConfig.Roster
-	i, _ := old.Search(pub.ID)
-	switch {
-	case i < 0:
-		return errors.New("node to delete is not in roster")
-	case i == 0:
-		return errors.New("cannot delete leader from roster")
+	for _, pub := range pubs {
+		i, _ := chainConfig.Roster.Search(pub.ID)
+		switch {
+		case i < 0:
+			return rosterNodeError(pubs, pub, "node to delete is not in roster")
+		case i == 0:
+			return rosterNodeError(pubs, pub, "cannot delete leader from roster")
+		}
 	}
-	log.Lvl2("Old roster is:", old.List)
-	list := append(old.List[0:i], old.List[i+1:]...)
-	chainConfig.Roster = *onet.NewRoster(list)
-	log.Lvl2("New roster is:", chainConfig.Roster.List)
 
-	err = updateConfig(cl, signer, chainConfig)
-	if err != nil {
-		return err
+	for i, pub := range pubs {
+		old := chainConfig.Roster
+		j, _ := old.Search(pub.ID)
+		log.Lvl2("Old roster is:", old.List)
+		list := append([]*network.ServerIdentity{}, old.List[0:j]...)
+		list = append(list, old.List[j+1:]...)
+		chainConfig.Roster = *onet.NewRoster(list)
+		log.Lvl2("New roster is:", chainConfig.Roster.List)
+
+		if len(pubs) > 1 {
+			log.Infof("Removing node %d/%d: %s", i+1, len(pubs), pub.Address)
+		}
+		err = updateConfig(cl, signer, chainConfig)
+		if err != nil {
+			return rosterNodeError(pubs, pub, err.Error())
+		}
 	}
 	log.Lvl1("New roster is now active")
 	return nil
 }
 
+// rosterNodeError returns the error msg about the node pub. The node is only
+// named when the TOML file has several of them, so that the errors for a
+// single node stay the same.
+func rosterNodeError(pubs []*network.ServerIdentity, pub *network.ServerIdentity, msg string) error {
+	if len(pubs) == 1 {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %s", pub.Address, msg)
+}
+
 func rosterLeader(c *cli.Context) error {
 	if c.NArg() < 3 {
 		return errors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg newLeader.toml")
@@ -996,52 +2119,57 @@ func rosterLeader(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	log.Lvl1("New roster is now active")
-	return nil
-}
 
-func key(c *cli.Context) error {
-	if f := c.String("print"); f != "" {
-		sig, err := lib.LoadSigner(f)
-		if err != nil {
-			return errors.New("couldn't load signer: " + err.Error())
-		}
-		log.Infof("Private: %s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
-		//log.Infof("Private: 65642e706f696e74%s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
-		return nil
+	wait := c.Duration("wait")
+	if wait <= 0 {
+		wait = 10 * chainConfig.BlockInterval
 	}
-	newSigner := darc.NewSignerEd25519(nil, nil)
-	err := lib.SaveKey(newSigner)
+	sb, err := waitForLeader(cl, pub, chainConfig.BlockInterval, wait)
 	if err != nil {
 		return err
 	}
+	log.Infof("New leader %s created block %d, the new roster is now active", pub.Address, sb.Index)
+	return nil
+}
 
-	var fo io.Writer
-
-	save := c.String("save")
-	if save == "" {
-		fo = os.Stdout
-	} else {
-		file, err := os.Create(save)
+// waitForLeader polls the latest block until it is created by the leader, and
+// returns it. It returns an error if this doesn't happen within the timeout.
+func waitForLeader(cl *byzcoin.Client, leader *network.ServerIdentity, interval,
+	timeout time.Duration) (*skipchain.SkipBlock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		p, err := getProof(cl, byzcoin.ConfigInstanceID.Slice())
 		if err != nil {
-			return err
+			return nil, err
 		}
-		fo = file
-		defer func() {
-			err := file.Close()
-			if err != nil {
-				log.Error(err)
-			}
-		}()
+		latest := p.Proof.Latest
+		if latest.Roster == nil || len(latest.Roster.List) == 0 {
+			return nil, errors.New("the latest block has no roster")
+		}
+		if latest.Roster.List[0].Equal(leader) {
+			return &latest, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the handoff didn't complete: no block of %s after %v, the latest "+
+				"block %d was created by %s", leader.Address, timeout, latest.Index, latest.Roster.List[0].Address)
+		}
+		time.Sleep(interval)
 	}
-	_, err = fmt.Fprintln(fo, newSigner.Identity().String())
-	return err
 }
 
-func darcShow(c *cli.Context) error {
+type rosterNode struct {
+	Address string
+	URL     string `json:",omitempty"`
+	Leader  bool
+}
+
+func rosterList(c *cli.Context) error {
 	bcArg := c.String("bc")
 	if bcArg == "" {
-		return errors.New("--bc flag is required")
+		bcArg = c.Args().First()
+		if bcArg == "" {
+			return errors.New("--bc flag is required")
+		}
 	}
 
 	cfg, cl, err := lib.LoadConfig(bcArg)
@@ -1049,17 +2177,280 @@ func darcShow(c *cli.Context) error {
 		return err
 	}
 
-	dstr := c.String("darc")
-	if dstr == "" {
-		dstr = cfg.AdminDarc.GetIdentityString()
-	}
-
-	d, err := getDarcByString(cl, dstr)
+	// The roster of the latest block is the current one, the roster in the
+	// config file might be outdated.
+	p, err := getProof(cl, byzcoin.ConfigInstanceID.Slice())
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintln(c.App.Writer, d.String())
-	return err
+	if err = p.Proof.Verify(cfg.ByzCoinID); err != nil {
+		return err
+	}
+
+	var nodes []rosterNode
+	for i, si := range p.Proof.Latest.Roster.List {
+		nodes = append(nodes, rosterNode{
+			Address: string(si.Address),
+			URL:     si.URL,
+			Leader:  i == 0,
+		})
+	}
+
+	if c.Bool("json") {
+		buf, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, string(buf))
+		return err
+	}
+
+	for _, n := range nodes {
+		line := n.Address
+		if n.URL != "" {
+			line += " (url: " + n.URL + ")"
+		}
+		if n.Leader {
+			line += " leader"
+		}
+		if _, err = fmt.Fprintln(c.App.Writer, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func key(c *cli.Context) error {
+	if f := c.String("encrypt"); f != "" {
+		return lib.EncryptKeyFile(f)
+	}
+	if f := c.String("decrypt"); f != "" {
+		return lib.DecryptKeyFile(f)
+	}
+	if f := c.String("print"); f != "" {
+		sig, err := lib.LoadSigner(f)
+		if err != nil {
+			return errors.New("couldn't load signer: " + err.Error())
+		}
+		switch {
+		case sig.Ed25519 != nil:
+			log.Infof("Private: %s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
+		case sig.BLS != nil:
+			log.Infof("Private: %x\nPublic: %x", sig.BLS.Secret, sig.BLS.Public)
+		default:
+			return errors.New("cannot print this type of key")
+		}
+		//log.Infof("Private: 65642e706f696e74%s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
+		return nil
+	}
+	newSigner, err := generateSigner(c.String("type"))
+	if err != nil {
+		return err
+	}
+	err = lib.SaveKey(newSigner)
+	if err != nil {
+		return err
+	}
+
+	var fo io.Writer
+
+	save := c.String("save")
+	if save == "" {
+		fo = os.Stdout
+	} else {
+		file, err := os.Create(save)
+		if err != nil {
+			return err
+		}
+		fo = file
+		defer func() {
+			err := file.Close()
+			if err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+	_, err = fmt.Fprintln(fo, newSigner.Identity().String())
+	return err
+}
+
+// keyImport stores an ed25519 private key given in hex, as printed by key
+// --print, in a key file.
+func keyImport(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("please give the following argument: [ed25519:]hex-private-key")
+	}
+	signer, err := parseEd25519Signer(c.Args().First())
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(lib.ConfigPath, fmt.Sprintf("key-%s.cfg", signer.Identity()))
+	if _, err := os.Stat(fn); err == nil {
+		return fmt.Errorf("the key file %s already exists", fn)
+	}
+	if err := lib.SaveKey(signer); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.App.Writer, signer.Identity().String())
+	return err
+}
+
+// parseEd25519Signer returns the signer of an ed25519 private key given in
+// hex, optionally prefixed with "ed25519:".
+func parseEd25519Signer(s string) (darc.Signer, error) {
+	buf, err := hex.DecodeString(strings.TrimPrefix(s, "ed25519:"))
+	if err != nil {
+		return darc.Signer{}, fmt.Errorf("the private key is not hex: %v", err)
+	}
+	secret := cothority.Suite.Scalar()
+	if len(buf) != secret.MarshalSize() {
+		return darc.Signer{}, fmt.Errorf("the private key must be %d bytes long, not %d",
+			secret.MarshalSize(), len(buf))
+	}
+	if err := secret.UnmarshalBinary(buf); err != nil {
+		return darc.Signer{}, fmt.Errorf("invalid private key: %v", err)
+	}
+	if secret.Equal(cothority.Suite.Scalar().Zero()) {
+		return darc.Signer{}, errors.New("the private key must not be zero")
+	}
+	return darc.NewSignerEd25519(cothority.Suite.Point().Mul(secret, nil), secret), nil
+}
+
+// generateSigner returns a new signer with a random key pair of the given type.
+func generateSigner(keyType string) (darc.Signer, error) {
+	switch keyType {
+	case "", "ed25519":
+		return darc.NewSignerEd25519(nil, nil), nil
+	case "bls":
+		return darc.NewSignerBLS(nil, nil), nil
+	default:
+		return darc.Signer{}, fmt.Errorf("unknown key type %q, must be ed25519 or bls", keyType)
+	}
+}
+
+func darcShow(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	dstr := c.String("darc")
+	if dstr == "" {
+		dstr = cfg.AdminDarc.GetIdentityString()
+	}
+	dstr, err = resolveDarcAlias(cfg, dstr)
+	if err != nil {
+		return err
+	}
+
+	d, err := getDarcByString(cl, dstr)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.App.Writer, d.String())
+	return err
+}
+
+func darcList(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	ids, err := getDarcInstanceIDs(cl)
+	if err != nil {
+		return err
+	}
+
+	var darcs []*darc.Darc
+	for _, id := range ids {
+		// The dump of the node is not verified, so the darcs are read
+		// again with a proof.
+		inst, err := getInstance(cl, id)
+		if err != nil {
+			return err
+		}
+		if inst.Deleted {
+			continue
+		}
+		d, err := darc.NewFromProtobuf(inst.Value)
+		if err != nil {
+			return fmt.Errorf("couldn't decode darc %x: %v", id.Slice(), err)
+		}
+		darcs = append(darcs, d)
+	}
+	_, err = fmt.Fprint(c.App.Writer, fmtDarcList(darcs, c.Bool("verbose")))
+	return err
+}
+
+// fmtDarcList returns one line per darc, sorted by description and then by
+// ID, followed by its rules if verbose is set.
+func fmtDarcList(darcs []*darc.Darc, verbose bool) string {
+	sort.SliceStable(darcs, func(i, j int) bool {
+		if !bytes.Equal(darcs[i].Description, darcs[j].Description) {
+			return bytes.Compare(darcs[i].Description, darcs[j].Description) < 0
+		}
+		return bytes.Compare(darcs[i].GetBaseID(), darcs[j].GetBaseID()) < 0
+	})
+	var out strings.Builder
+	for _, d := range darcs {
+		fmt.Fprintf(&out, "%s - version %d - %s\n", d.GetIdentityString(), d.Version, d.Description)
+		if verbose {
+			for _, l := range darcRuleLines(d, "") {
+				fmt.Fprintf(&out, "\t%s\n", l)
+			}
+		}
+	}
+	return out.String()
+}
+
+// instancesPageSize is the number of instance IDs fetched in one request.
+const instancesPageSize = 100
+
+func darcInstances(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	if c.NArg() < 1 {
+		return errors.New("please give the darc as argument")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	dstr, err := resolveDarcAlias(cfg, c.Args().First())
+	if err != nil {
+		return err
+	}
+	dID, err := stringToDarcID(dstr)
+	if err != nil {
+		return err
+	}
+
+	var ids []byzcoin.InstanceID
+	chooseServer(cl, false)
+	err = withTimeout("getting the instances", func() (err error) {
+		ids, err = cl.GetInstancesByDarc(dID, instancesPageSize)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		fmt.Fprintf(c.App.Writer, "%x\n", id.Slice())
+	}
+	return nil
 }
 
 func debugList(c *cli.Context) error {
@@ -1091,7 +2482,12 @@ func debugList(c *cli.Context) error {
 
 	for _, url := range urls {
 		log.Info("Contacting ", url)
-		resp, err := byzcoin.Debug(url, nil)
+		url := url
+		var resp *byzcoin.DebugResponse
+		err := withTimeout("contacting "+url, func() (err error) {
+			resp, err = byzcoin.Debug(url, nil)
+			return
+		})
 		if err != nil {
 			log.Error(err)
 			continue
@@ -1150,7 +2546,11 @@ func debugDump(c *cli.Context) error {
 		return err
 	}
 	bcid := skipchain.SkipBlockID(bcidBuf)
-	resp, err := byzcoin.Debug(c.Args().First(), &bcid)
+	var resp *byzcoin.DebugResponse
+	err = withTimeout("dumping the instances", func() (err error) {
+		resp, err = byzcoin.Debug(c.Args().First(), &bcid)
+		return
+	})
 	if err != nil {
 		log.Error(err)
 		return err
@@ -1160,17 +2560,11 @@ func debugDump(c *cli.Context) error {
 	})
 	for _, inst := range resp.Dump {
 		log.Infof("%x / %d: %s", inst.Key, inst.State.Version, string(inst.State.ContractID))
-		if c.Bool("verbose") {
-			switch inst.State.ContractID {
-			case byzcoin.ContractDarcID:
-				d, err := darc.NewFromProtobuf(inst.State.Value)
-				if err != nil {
-					log.Warn("Didn't recognize as a darc instance")
-				}
-				log.Infof("\tDesc: %s, Rules:", string(d.Description))
-				for _, r := range d.Rules.List {
-					log.Infof("\tAction: %s - Expression: %s", r.Action, r.Expr)
-				}
+		// The description is given by the contract of the instance, if
+		// it implements byzcoin.ContractWithDescription.
+		if c.Bool("verbose") && inst.Description != "" {
+			for _, l := range strings.Split(inst.Description, "\n") {
+				log.Infof("\t%s", l)
 			}
 		}
 	}
@@ -1178,6 +2572,49 @@ func debugDump(c *cli.Context) error {
 	return nil
 }
 
+// darcRuleLines returns a description of the rules of d whose action starts
+// with prefix, one rule per line.
+func darcRuleLines(d *darc.Darc, prefix string) []string {
+	var lines []string
+	for _, r := range d.Rules.List {
+		if strings.HasPrefix(string(r.Action), prefix) {
+			lines = append(lines, fmt.Sprintf("Action: %s - Expression: %s", r.Action, r.Expr))
+		}
+	}
+	return lines
+}
+
+// fmtDarcDiff returns the rules that differ between d and its evolution d2,
+// one per line, prefixed by + if added, - if removed and ~ if changed.
+func fmtDarcDiff(d, d2 *darc.Darc) string {
+	added, removed, changed := d.Diff(d2)
+	var out strings.Builder
+	for _, r := range added {
+		fmt.Fprintf(&out, "+ %s: %s\n", r.Action, r.Expr)
+	}
+	for _, r := range removed {
+		fmt.Fprintf(&out, "- %s: %s\n", r.Action, r.Expr)
+	}
+	for _, r := range changed {
+		fmt.Fprintf(&out, "~ %s: %s -> %s\n", r.Action, d.Rules.Get(r.Action), r.Expr)
+	}
+	return out.String()
+}
+
+// fmtDarcRules returns the action and the expression of the rules of d whose
+// action starts with prefix, one rule per line, with the expressions aligned.
+func fmtDarcRules(d *darc.Darc, prefix string) string {
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 8, 2, ' ', 0)
+	for _, r := range d.Rules.List {
+		if strings.HasPrefix(string(r.Action), prefix) {
+			fmt.Fprintf(w, "%s\t%s\n", r.Action, r.Expr)
+		}
+	}
+	w.Flush()
+	return out.String()
+}
+
 func debugRemove(c *cli.Context) error {
 	if c.NArg() < 2 {
 		return errors.New("please give the following arguments: private.toml byzcoin-id")
@@ -1197,11 +2634,348 @@ func debugRemove(c *cli.Context) error {
 		return err
 	}
 	bcid := skipchain.SkipBlockID(bcidBuf)
-	err = byzcoin.DebugRemove(si, bcid)
+	err = withTimeout("removing the ledger", func() error {
+		return byzcoin.DebugRemove(si, bcid)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Successfully removed ByzCoinID %x from %s", bcid, si.Address)
+	return nil
+}
+
+func debugDownloadStatus(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give the following argument: ip:port")
+	}
+	var resp *byzcoin.GetDownloadStatusResponse
+	err := withTimeout("getting the download status", func() (err error) {
+		resp, err = byzcoin.DebugDownloadStatus(c.Args().First())
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Downloads) == 0 {
+		_, err = fmt.Fprintln(c.App.Writer, "No download")
+		return err
+	}
+	for _, d := range resp.Downloads {
+		state := "finished"
+		if d.Active {
+			state = "in progress"
+		}
+		_, err = fmt.Fprintf(c.App.Writer, "ByzCoinID: %x\nNonce: %x\nServed: %d/%d entries (%s)\n",
+			d.ByzCoinID, d.Nonce, d.Served, d.Total, state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func debugReplay(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("please give the following arguments: ip:port byzcoin-id")
+	}
+	bcidBuf, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil {
+		return errors.New("couldn't parse byzcoin-id: " + err.Error())
+	}
+	var resp *byzcoin.DebugReplayResponse
+	err = withTimeout("replaying the chain", func() (err error) {
+		resp, err = byzcoin.DebugReplay(c.Args().First(), skipchain.SkipBlockID(bcidBuf), c.Int("to"))
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Diverged {
+		_, err = fmt.Fprintf(c.App.Writer, "Replayed %d blocks, block %d diverges: %s\n",
+			resp.Replayed, resp.Index, resp.Reason)
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Replayed %d blocks up to block %d, all the trie roots match\n",
+		resp.Replayed, resp.Index)
+	return err
+}
+
+func debugBisect(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("please give the following arguments: ip:port byzcoin-id")
+	}
+	bcidBuf, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil {
+		return errors.New("couldn't parse byzcoin-id: " + err.Error())
+	}
+	var resp *byzcoin.DebugReplayResponse
+	err = withTimeout("bisecting the chain", func() (err error) {
+		resp, err = byzcoin.DebugBisect(c.Args().First(), skipchain.SkipBlockID(bcidBuf),
+			c.Int("from"), c.Int("to"))
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Diverged {
+		_, err = fmt.Fprintf(c.App.Writer, "Replayed %d blocks, block %d is the first to diverge: %s\n",
+			resp.Replayed, resp.Index, resp.Reason)
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Replayed %d blocks, the trie root of block %d matches\n",
+		resp.Replayed, resp.Index)
+	return err
+}
+
+func debugReconcile(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("please give the following arguments: ip:port byzcoin-id")
+	}
+	bcidBuf, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil {
+		return errors.New("couldn't parse byzcoin-id: " + err.Error())
+	}
+	if c.Int("sample") < 0 {
+		return errors.New("the sample must not be negative")
+	}
+	var resp *byzcoin.DebugReconcileResponse
+	err = withTimeout("reconciling the history", func() (err error) {
+		resp, err = byzcoin.DebugReconcile(c.Args().First(), skipchain.SkipBlockID(bcidBuf), c.Int("sample"))
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range resp.Mismatches {
+		_, err = fmt.Fprintf(c.App.Writer, "%x: %s\n", m.InstanceID.Slice(), m.Reason)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Checked %d instances at index %d: %d without history, %d mismatches\n",
+		resp.Checked, resp.Index, resp.Missing, len(resp.Mismatches))
+	if err != nil {
+		return err
+	}
+	if len(resp.Mismatches) > 0 {
+		return errors.New("the history differs from the trie")
+	}
+	return nil
+}
+
+func debugPrune(c *cli.Context) error {
+	if c.NArg() != 3 {
+		return errors.New("please give the following arguments: ip:port byzcoin-id block-index")
+	}
+	bcidBuf, err := hex.DecodeString(c.Args().Get(1))
+	if err != nil {
+		return errors.New("couldn't parse byzcoin-id: " + err.Error())
+	}
+	index, err := strconv.Atoi(c.Args().Get(2))
+	if err != nil {
+		return errors.New("couldn't parse block-index: " + err.Error())
+	}
+	if index <= 0 {
+		return errors.New("the block index must be bigger than 0")
+	}
+	var resp *byzcoin.PruneStateChangesResponse
+	err = withTimeout("pruning the history", func() (err error) {
+		resp, err = byzcoin.DebugPruneStateChanges(c.Args().First(), skipchain.SkipBlockID(bcidBuf), index)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Removed %d state changes before block %d\n", resp.Removed, index)
+	return err
+}
+
+func debugSetLog(c *cli.Context) error {
+	if c.NArg() != 1 && c.NArg() != 3 {
+		return errors.New("please give the following arguments: ip:port [subsystem level]")
+	}
+	var subsystem string
+	var level int
+	if c.NArg() == 3 {
+		subsystem = c.Args().Get(1)
+		var err error
+		level, err = strconv.Atoi(c.Args().Get(2))
+		if err != nil {
+			return errors.New("couldn't parse level: " + err.Error())
+		}
+	}
+	var resp *byzcoin.DebugSetLogResponse
+	err := withTimeout("setting the log level", func() (err error) {
+		resp, err = byzcoin.DebugSetLog(c.Args().First(), subsystem, level)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range resp.Levels {
+		level := "global"
+		if l.Level > 0 {
+			level = strconv.Itoa(l.Level)
+		}
+		_, err = fmt.Fprintf(c.App.Writer, "%s: %s\n", l.Subsystem, level)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func debugDownloadCancel(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give the following arguments: ip:port [nonce]")
+	}
+	var nonce uint64
+	if c.NArg() > 1 {
+		var err error
+		nonce, err = strconv.ParseUint(c.Args().Get(1), 16, 64)
+		if err != nil {
+			return errors.New("couldn't parse nonce: " + err.Error())
+		}
+	}
+	var nonces []uint64
+	err := withTimeout("cancelling the download", func() (err error) {
+		nonces, err = byzcoin.DebugCancelDownload(c.Args().First(), nonce)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Cancelled %d download(s): %x\n", len(nonces), nonces)
+	return err
+}
+
+// defaultPingTimeout is used by debug ping if no --timeout is given, so that
+// nodes that never answer are reported.
+const defaultPingTimeout = 10 * time.Second
+
+func debugPing(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	d := timeout
+	if d <= 0 {
+		d = defaultPingTimeout
+	}
+	for i, r := range cl.Ping(d) {
+		if r.Error != nil {
+			fmt.Fprintf(c.App.Writer, "%d: %s unreachable: %v\n", i+1, r.ServerIdentity.Address, r.Error)
+		} else {
+			fmt.Fprintf(c.App.Writer, "%d: %s %v\n", i+1, r.ServerIdentity.Address, r.Latency)
+		}
+	}
+	return nil
+}
+
+// debugCounters prints the signer counters of the given identities, or of
+// the admin identity of the config.
+func debugCounters(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, arg := range c.Args() {
+		id, err := darc.ParseIdentity(arg)
+		if err != nil {
+			return fmt.Errorf("couldn't parse identity '%s': %v", arg, err)
+		}
+		ids = append(ids, id.String())
+	}
+	if len(ids) == 0 {
+		ids = []string{cfg.AdminIdentity.String()}
+	}
+
+	resp, err := getSignerCounters(cl, ids...)
 	if err != nil {
 		return err
 	}
-	log.Infof("Successfully removed ByzCoinID %x from %s", bcid, si.Address)
+	if len(resp.Counters) != len(ids) {
+		return fmt.Errorf("got %d counters for %d identities", len(resp.Counters), len(ids))
+	}
+	_, err = fmt.Fprint(c.App.Writer, fmtCounters(ids, resp.Counters))
+	return err
+}
+
+// fmtCounters returns one line per identity with its counter, aligned.
+func fmtCounters(ids []string, counters []uint64) string {
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 8, 2, ' ', 0)
+	for i, id := range ids {
+		fmt.Fprintf(w, "%s\t%d\n", id, counters[i])
+	}
+	w.Flush()
+	return out.String()
+}
+
+func debugCosi(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	if c.NArg() < 1 {
+		return errors.New("please give the block index as argument")
+	}
+	index, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return errors.New("couldn't parse block index: " + err.Error())
+	}
+
+	cfg, _, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	var reply *skipchain.GetSingleBlockByIndexReply
+	err = withTimeout("getting the block", func() (err error) {
+		reply, err = skipchain.NewClient().GetSingleBlockByIndex(&cfg.Roster, cfg.ByzCoinID, index)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	sb := reply.SkipBlock
+	level := c.Int("level")
+	signers, err := sb.ForwardLinkSigners(level)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.App.Writer, "Block %d: forward link %d to %x signed by %d of %d nodes (threshold: %d)\n",
+		sb.Index, level, sb.ForwardLink[level].To, len(signers), len(sb.Roster.List),
+		protocol.DefaultThreshold(len(sb.Roster.List)))
+	if err != nil {
+		return err
+	}
+	signed := make(map[network.ServerIdentityID]bool)
+	for _, si := range signers {
+		signed[si.ID] = true
+	}
+	for _, si := range sb.Roster.List {
+		status := "missing"
+		if signed[si.ID] {
+			status = "signed"
+		}
+		_, err = fmt.Fprintf(c.App.Writer, "\t%s: %s\n", si.Address, status)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1247,11 +3021,16 @@ func darcAdd(c *cli.Context) error {
 			return err
 		}
 	} else {
-		s := darc.NewSignerEd25519(nil, nil)
-		err = lib.SaveKey(s)
+		s, err := generateSigner(c.String("owner_type"))
 		if err != nil {
 			return err
 		}
+		if !c.Bool("dry-run") {
+			err = lib.SaveKey(s)
+			if err != nil {
+				return err
+			}
+		}
 		identity = s.Identity()
 		newSigner = &s
 	}
@@ -1273,8 +3052,23 @@ func darcAdd(c *cli.Context) error {
 			return err
 		}
 	}
+	if t := c.String("template"); t != "" {
+		tmpl, err := getDarcTemplate(t)
+		if err != nil {
+			return err
+		}
+		err = tmpl.apply(&rules, identity)
+		if err != nil {
+			return err
+		}
+	}
 	d := darc.NewDarc(rules, desc)
 
+	if c.Bool("dry-run") {
+		_, err = fmt.Fprintln(c.App.Writer, d.String())
+		return err
+	}
+
 	dBuf, err := d.ToProto()
 	if err != nil {
 		return err
@@ -1282,8 +3076,6 @@ func darcAdd(c *cli.Context) error {
 
 	instID := byzcoin.NewInstanceID(dSpawn.GetBaseID())
 
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
-
 	spawn := byzcoin.Spawn{
 		ContractID: byzcoin.ContractDarcID,
 		Args: []byzcoin.Argument{
@@ -1297,18 +3089,17 @@ func darcAdd(c *cli.Context) error {
 	ctx := byzcoin.ClientTransaction{
 		Instructions: []byzcoin.Instruction{
 			{
-				InstanceID:    instID,
-				Spawn:         &spawn,
-				SignerCounter: []uint64{counters.Counters[0] + 1},
+				InstanceID: instID,
+				Spawn:      &spawn,
 			},
 		},
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
+	err = signWithCounters(cl, &ctx, *signer)
 	if err != nil {
 		return err
 	}
 
-	_, err = cl.AddTransactionAndWait(ctx, 10)
+	err = addTransactionAndWait(cl, ctx)
 	if err != nil {
 		return err
 	}
@@ -1354,20 +3145,17 @@ func darcRule(c *cli.Context) error {
 	if dstr == "" {
 		dstr = cfg.AdminDarc.GetIdentityString()
 	}
+	dstr, err = resolveDarcAlias(cfg, dstr)
+	if err != nil {
+		return err
+	}
 	d, err := getDarcByString(cl, dstr)
 	if err != nil {
 		return err
 	}
 
-	var signer *darc.Signer
-
-	sstr := c.String("sign")
-	if sstr == "" {
-		signer, err = lib.LoadKey(cfg.AdminIdentity)
-	} else {
-		signer, err = lib.LoadKeyFromString(sstr)
-	}
-	if err != nil {
+	if c.Bool("list") {
+		_, err = fmt.Fprint(c.App.Writer, fmtDarcRules(d, c.String("rule")))
 		return err
 	}
 
@@ -1402,12 +3190,31 @@ func darcRule(c *cli.Context) error {
 		return err
 	}
 
-	d2Buf, err := d2.ToProto()
+	_, err = fmt.Fprint(c.App.Writer, fmtDarcDiff(d, d2))
+	if err != nil {
+		return err
+	}
+	// The key is not needed to review the new darc.
+	if c.Bool("dry-run") {
+		_, err = fmt.Fprintln(c.App.Writer, d2.String())
+		return err
+	}
+
+	var signer *darc.Signer
+	sstr := c.String("sign")
+	if sstr == "" {
+		signer, err = lib.LoadKey(cfg.AdminIdentity)
+	} else {
+		signer, err = lib.LoadKeyFromString(sstr)
+	}
 	if err != nil {
 		return err
 	}
 
-	counters, err := cl.GetSignerCounters(signer.Identity().String())
+	d2Buf, err := d2.ToProto()
+	if err != nil {
+		return err
+	}
 
 	invoke := byzcoin.Invoke{
 		ContractID: byzcoin.ContractDarcID,
@@ -1423,25 +3230,313 @@ func darcRule(c *cli.Context) error {
 	ctx := byzcoin.ClientTransaction{
 		Instructions: []byzcoin.Instruction{
 			{
-				InstanceID:    byzcoin.NewInstanceID(d2.GetBaseID()),
-				Invoke:        &invoke,
-				SignerCounter: []uint64{counters.Counters[0] + 1},
+				InstanceID: byzcoin.NewInstanceID(d2.GetBaseID()),
+				Invoke:     &invoke,
 			},
 		},
 	}
-	err = ctx.FillSignersAndSignWith(*signer)
+	err = signWithCounters(cl, &ctx, *signer)
+	if err != nil {
+		return err
+	}
+
+	err = addTransactionAndWait(cl, ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func darcAliasSet(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	if c.NArg() != 2 {
+		return errors.New("please give the name and the darc as arguments")
+	}
+	name := c.Args().Get(0)
+	if strings.HasPrefix(name, "darc:") {
+		return errors.New("the name cannot start with 'darc:'")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	// Only accept darcs that exist, to catch typos early.
+	d, err := getDarcByString(cl, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	aliases, err := lib.LoadDarcAliases(cfg.ByzCoinID)
 	if err != nil {
 		return err
 	}
+	aliases[name] = d.GetIdentityString()
+	return lib.SaveDarcAliases(cfg.ByzCoinID, aliases)
+}
+
+func darcAliasList(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
 
-	_, err = cl.AddTransactionAndWait(ctx, 10)
+	cfg, _, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	aliases, err := lib.LoadDarcAliases(cfg.ByzCoinID)
 	if err != nil {
 		return err
 	}
 
+	var names []string
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err = fmt.Fprintf(c.App.Writer, "%s: %s\n", name, aliases[name])
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func darcAliasRm(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	if c.NArg() != 1 {
+		return errors.New("please give the name as argument")
+	}
+	name := c.Args().First()
+
+	cfg, _, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	aliases, err := lib.LoadDarcAliases(cfg.ByzCoinID)
+	if err != nil {
+		return err
+	}
+	if _, ok := aliases[name]; !ok {
+		return fmt.Errorf("no darc with the name %s", name)
+	}
+	delete(aliases, name)
+	return lib.SaveDarcAliases(cfg.ByzCoinID, aliases)
+}
+
+// instanceInfo is the JSON description of an instance printed by instance
+// get.
+type instanceInfo struct {
+	ID         string
+	ContractID string
+	Version    uint64
+	DarcID     string
+	Deleted    bool `json:",omitempty"`
+	Value      string
+}
+
+func instanceGet(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	id, err := instanceIDArg(c)
+	if err != nil {
+		return err
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+	inst, err := getInstance(cl, id)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		buf, err := json.MarshalIndent(instanceInfo{
+			ID:         hex.EncodeToString(inst.ID.Slice()),
+			ContractID: inst.ContractID,
+			Version:    inst.Version,
+			DarcID:     hex.EncodeToString(inst.DarcID),
+			Deleted:    inst.Deleted,
+			Value:      hex.EncodeToString(inst.Value),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.App.Writer, string(buf))
+		return err
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "ID: %x\n", inst.ID.Slice())
+	fmt.Fprintf(&s, "Contract: %s\n", inst.ContractID)
+	fmt.Fprintf(&s, "Version: %d\n", inst.Version)
+	fmt.Fprintf(&s, "Darc: darc:%x\n", inst.DarcID)
+	if inst.Deleted {
+		fmt.Fprintln(&s, "Deleted: true")
+	}
+	fmt.Fprintf(&s, "Value: %x\n", inst.Value)
+	if pretty := fmtInstanceValue(inst.ContractID, inst.Value); pretty != "" {
+		fmt.Fprintln(&s, pretty)
+	}
+	_, err = fmt.Fprint(c.App.Writer, s.String())
+	return err
+}
+
+// fmtChainConfig returns the fields of the chain config, one per line.
+func fmtChainConfig(cc *byzcoin.ChainConfig) string {
+	s := fmt.Sprintf("BlockInterval: %v\nMaxBlockSize: %d\nRoster: %s\nDarcContracts: %s",
+		cc.BlockInterval, cc.MaxBlockSize, fmtRoster(&cc.Roster),
+		strings.Join(cc.DarcContractIDs, ", "))
+	if cc.ChainBoundSignatures {
+		s += "\nChainBoundSignatures: true"
+	}
+	if cc.MaxInstructionsPerTx > 0 {
+		s += fmt.Sprintf("\nMaxInstructionsPerTx: %d", cc.MaxInstructionsPerTx)
+	}
+	if cc.RotationWindow > 0 {
+		s += fmt.Sprintf("\nRotationWindow: %d", cc.RotationWindow)
+	}
+	if cc.ByzCoinVersion > 0 {
+		s += fmt.Sprintf("\nByzCoinVersion: %d", cc.ByzCoinVersion)
+	}
+	if len(cc.Observers) > 0 {
+		s += "\nObservers: " + fmtRoster(&onet.Roster{List: cc.Observers})
+	}
+	if len(cc.SpawnContractIDs) > 0 {
+		s += "\nSpawnContracts: " + strings.Join(cc.SpawnContractIDs, ", ")
+	}
+	if len(cc.ContractVersions) > 0 {
+		var versions []string
+		for _, cv := range cc.ContractVersions {
+			versions = append(versions, cv.ContractID+"="+cv.Version)
+		}
+		s += "\nContractVersions: " + strings.Join(versions, ", ")
+	}
+	return s
+}
+
+// fmtInstanceValue decodes the value of the instances of the contracts known
+// to bcadmin, and of the index entries. It returns an empty string for the other contracts, or if the
+// value cannot be decoded.
+func fmtInstanceValue(contractID string, value []byte) string {
+	switch contractID {
+	case byzcoin.ContractDarcID:
+		d, err := darc.NewFromProtobuf(value)
+		if err != nil {
+			return ""
+		}
+		return d.String()
+	case byzcoin.ContractConfigID:
+		var cc byzcoin.ChainConfig
+		err := protobuf.DecodeWithConstructors(value, &cc, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return ""
+		}
+		return fmtChainConfig(&cc)
+	case byzcoin.ContractIndexID:
+		var entry byzcoin.IndexEntry
+		if err := protobuf.Decode(value, &entry); err != nil {
+			return ""
+		}
+		return fmt.Sprintf("Index of %s: %q -> %x", entry.ContractID, entry.Key, entry.InstanceID.Slice())
+	case contracts.ContractCoinID:
+		var coin byzcoin.Coin
+		if err := protobuf.Decode(value, &coin); err != nil {
+			return ""
+		}
+		return fmt.Sprintf("Coin: %x, Value: %d", coin.Name.Slice(), coin.Value)
+	}
+	return ""
+}
+
+// instanceIDArg returns the instance ID given as the only argument of c.
+func instanceIDArg(c *cli.Context) (byzcoin.InstanceID, error) {
+	if c.NArg() != 1 {
+		return byzcoin.InstanceID{}, errors.New("please give the instance ID as argument")
+	}
+	idBuf, err := hex.DecodeString(c.Args().First())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(idBuf) != 32 {
+		return byzcoin.InstanceID{}, errors.New("the instance ID must be 32 bytes long")
+	}
+	return byzcoin.NewInstanceID(idBuf), nil
+}
+
+func instanceDelete(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return errors.New("--bc flag is required")
+	}
+	id, err := instanceIDArg(c)
+	if err != nil {
+		return err
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	var signers []darc.Signer
+	for _, sstr := range c.StringSlice("sign") {
+		signer, err := lib.LoadKeyFromString(sstr)
+		if err != nil {
+			return err
+		}
+		signers = append(signers, *signer)
+	}
+	if len(signers) == 0 {
+		signer, err := lib.LoadKey(cfg.AdminIdentity)
+		if err != nil {
+			return err
+		}
+		signers = append(signers, *signer)
+	}
+
+	// The contract of the instance must be given in the instruction.
+	inst, err := getInstance(cl, id)
+	if err != nil {
+		return err
+	}
+	if inst.Deleted {
+		return errors.New("the instance has already been deleted")
+	}
+
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{
+			{
+				InstanceID: id,
+				Delete: &byzcoin.Delete{
+					ContractID: inst.ContractID,
+					Soft:       c.Bool("soft"),
+				},
+			},
+		},
+	}
+	err = signWithCounters(cl, &ctx, signers...)
+	if err != nil {
+		return err
+	}
+
+	return addTransactionAndWait(cl, ctx)
+}
+
 func qrcode(c *cli.Context) error {
 	type pair struct {
 		Priv string
@@ -1532,6 +3627,19 @@ func stringToEd25519Buf(pub string) ([]byte, error) {
 	return hex.DecodeString(pub)
 }
 
+// resolveDarcAlias returns the darc identity string stored under the name
+// given in dstr. If there is no such alias, dstr is returned unchanged.
+func resolveDarcAlias(cfg lib.Config, dstr string) (string, error) {
+	aliases, err := lib.LoadDarcAliases(cfg.ByzCoinID)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := aliases[dstr]; ok {
+		return id, nil
+	}
+	return dstr, nil
+}
+
 func getDarcByString(cl *byzcoin.Client, id string) (*darc.Darc, error) {
 	xrep, err := stringToDarcID(id)
 	if err != nil {
@@ -1541,29 +3649,136 @@ func getDarcByString(cl *byzcoin.Client, id string) (*darc.Darc, error) {
 }
 
 func getDarcByID(cl *byzcoin.Client, id []byte) (*darc.Darc, error) {
-	pr, err := cl.GetProof(id)
+	inst, err := getInstance(cl, byzcoin.NewInstanceID(id))
+	if err != nil {
+		return nil, fmt.Errorf("could not find darc for %x: %v", id, err)
+	}
+	if inst.ContractID != byzcoin.ContractDarcID {
+		return nil, fmt.Errorf("unexpected contract %v, expected a darc", inst.ContractID)
+	}
+
+	d, err := darc.NewFromProtobuf(inst.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func getProof(cl *byzcoin.Client, key []byte) (*byzcoin.GetProofResponse, error) {
+	var resp *byzcoin.GetProofResponse
+	chooseServer(cl, false)
+	err := withTimeout("getting the proof", func() (err error) {
+		resp, err = cl.GetProof(key)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// exists returns whether the key is in the state trie, as told by the node
+// without a proof.
+func exists(cl *byzcoin.Client, key []byte) (bool, error) {
+	var found bool
+	chooseServer(cl, false)
+	err := withTimeout("checking the key", func() (err error) {
+		found, err = cl.Exists(key)
+		return
+	})
+	return found, err
+}
+
+func getInstance(cl *byzcoin.Client, id byzcoin.InstanceID) (*byzcoin.Instance, error) {
+	var inst *byzcoin.Instance
+	chooseServer(cl, false)
+	err := withTimeout("getting the instance", func() (err error) {
+		inst, err = cl.GetInstance(id)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
+	return inst, nil
+}
 
-	p := &pr.Proof
-	err = p.Verify(cl.ID)
+func getSignerCounters(cl *byzcoin.Client, ids ...string) (*byzcoin.GetSignerCountersResponse, error) {
+	var resp *byzcoin.GetSignerCountersResponse
+	chooseServer(cl, false)
+	err := withTimeout("getting the signer counters", func() (err error) {
+		resp, err = cl.GetSignerCounters(ids...)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
+	return resp, nil
+}
+
+// signWithCounters makes all the signers sign all the instructions of ctx,
+// after setting their counters. The counters of all the signers are fetched
+// in a single request.
+func signWithCounters(cl *byzcoin.Client, ctx *byzcoin.ClientTransaction, signers ...darc.Signer) error {
+	var ids []darc.Identity
+	for _, signer := range signers {
+		ids = append(ids, signer.Identity())
+	}
+	for i := range ctx.Instructions {
+		ctx.Instructions[i].SignerIdentities = ids
+	}
+	chooseServer(cl, false)
+	err := withTimeout("getting the signer counters", func() error {
+		return cl.FillSignerCounters(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	return signTx(cl, ctx, signers...)
+}
 
-	vs, cid, _, err := p.Get(id)
+// signTx fills the signer identities of all the instructions and signs them.
+// The signatures are bound to the chain if its config asks for it.
+func signTx(cl *byzcoin.Client, ctx *byzcoin.ClientTransaction, signers ...darc.Signer) error {
+	cc, err := getChainConfig(cl)
 	if err != nil {
-		return nil, fmt.Errorf("could not find darc for %x", id)
+		return err
 	}
-	if cid != byzcoin.ContractDarcID {
-		return nil, fmt.Errorf("unexpected contract %v, expected a darc", cid)
+	if cc.ChainBoundSignatures {
+		return ctx.FillSignersAndSignWithChain(cl.ID, signers...)
 	}
+	return ctx.FillSignersAndSignWith(signers...)
+}
+
+func addTransactionAndWait(cl *byzcoin.Client, ctx byzcoin.ClientTransaction) error {
+	chooseServer(cl, true)
+	return withTimeout("sending the transaction", func() error {
+		_, err := cl.AddTransactionAndWait(ctx, 10)
+		return err
+	})
+}
 
-	d, err := darc.NewFromProtobuf(vs)
+func getAllSkipChainIDs(scl *skipchain.Client, si *network.ServerIdentity) (*skipchain.GetAllSkipChainIDsReply, error) {
+	var reply *skipchain.GetAllSkipChainIDsReply
+	err := withTimeout("contacting "+si.Address.String(), func() (err error) {
+		reply, err = scl.GetAllSkipChainIDs(si)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
+	return reply, nil
+}
 
-	return d, nil
+func getChainConfig(cl *byzcoin.Client) (*byzcoin.ChainConfig, error) {
+	var cc *byzcoin.ChainConfig
+	chooseServer(cl, false)
+	err := withTimeout("getting the chain config", func() (err error) {
+		cc, err = cl.GetChainConfig()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cc, nil
 }
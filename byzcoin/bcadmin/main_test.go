@@ -2,17 +2,30 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/app"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 // This is required; without it onet/log/testuitl.go:interestingGoroutines will
@@ -21,6 +34,424 @@ func TestMain(m *testing.M) {
 	log.MainTest(m)
 }
 
+func TestTimeout(t *testing.T) {
+	// The worker is told to stop when it times out.
+	stopped := make(chan bool)
+	err := runWithin(nil, 10*time.Millisecond, "test", func(stop <-chan struct{}) error {
+		<-stop
+		close(stopped)
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "test timed out")
+	<-stopped
+
+	err = runWithin(nil, 0, "test", func(<-chan struct{}) error {
+		return errors.New("done")
+	})
+	require.EqualError(t, err, "done")
+
+	// Once the command timed out, its next operations are aborted.
+	nested := make(chan error, 1)
+	err = runWithin(nil, 10*time.Millisecond, "command", func(stop <-chan struct{}) error {
+		<-stop
+		nested <- runWithin(stop, 0, "test", func(<-chan struct{}) error {
+			return nil
+		})
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, (<-nested).Error(), "test aborted")
+	parent := make(chan struct{})
+	close(parent)
+	err = runWithin(parent, time.Second, "test", func(<-chan struct{}) error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "test aborted")
+}
+
+func TestKeyEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bc-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oldPath := lib.ConfigPath
+	lib.ConfigPath = dir
+	defer func() { lib.ConfigPath = oldPath }()
+
+	pass := "correct horse"
+	asked := 0
+	lib.Passphrase = func(fn string, confirm bool) ([]byte, error) {
+		asked++
+		return []byte(pass), nil
+	}
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	require.NoError(t, lib.SaveKey(signer))
+	fn := path.Join(dir, "key-"+signer.Identity().String()+".cfg")
+
+	_, err = lib.LoadSigner(fn)
+	require.NoError(t, err)
+	require.Equal(t, 0, asked)
+
+	b := &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args := []string{"bcadmin", "-c", dir, "key", "--encrypt", fn}
+	require.NoError(t, cliApp.Run(args))
+	require.Error(t, cliApp.Run(args))
+	buf, err := lib.ReadCheckedFile(fn)
+	require.NoError(t, err)
+	require.True(t, lib.IsEncryptedKey(buf))
+
+	loaded, err := lib.LoadKey(signer.Identity())
+	require.NoError(t, err)
+	require.Equal(t, signer.Identity().String(), loaded.Identity().String())
+
+	pass = "wrong"
+	_, err = lib.LoadSigner(fn)
+	require.Error(t, err)
+	require.Error(t, cliApp.Run([]string{"bcadmin", "-c", dir, "key", "--decrypt", fn}))
+
+	pass = "correct horse"
+	require.NoError(t, cliApp.Run([]string{"bcadmin", "-c", dir, "key", "--decrypt", fn}))
+	asked = 0
+	_, err = lib.LoadSigner(fn)
+	require.NoError(t, err)
+	require.Equal(t, 0, asked)
+}
+
+func TestConfigChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bc-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oldPath := lib.ConfigPath
+	lib.ConfigPath = dir
+	defer func() { lib.ConfigPath = oldPath }()
+
+	si := network.NewServerIdentity(cothority.Suite.Point().Pick(cothority.Suite.RandomStream()), "tls://127.0.0.1:7770")
+	cfg := lib.Config{
+		Roster:    *onet.NewRoster([]*network.ServerIdentity{si}),
+		ByzCoinID: bytes.Repeat([]byte{1}, 32),
+	}
+	fn, err := lib.SaveConfig(cfg)
+	require.NoError(t, err)
+	_, _, err = lib.LoadConfig(fn)
+	require.NoError(t, err)
+	// Saving again replaces the file.
+	_, err = lib.SaveConfig(cfg)
+	require.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	// A truncated file is reported as corrupted.
+	buf, err := ioutil.ReadFile(fn)
+	require.NoError(t, err)
+	for _, l := range []int{len(buf) - 1, 40, 5} {
+		require.NoError(t, ioutil.WriteFile(fn, buf[:l], 0644))
+		_, _, err = lib.LoadConfig(fn)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is corrupted")
+	}
+
+	// Files written without checksum can still be loaded.
+	raw, err := protobuf.Encode(&cfg)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(fn, raw, 0644))
+	loaded, _, err := lib.LoadConfig(fn)
+	require.NoError(t, err)
+	require.True(t, loaded.ByzCoinID.Equal(cfg.ByzCoinID))
+}
+
+func TestUpdateSpawnContractIDs(t *testing.T) {
+	ids, err := updateSpawnContractIDs(nil, []string{"darc", "value"}, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"darc", "value"}, ids)
+	ids, err = updateSpawnContractIDs(ids, []string{"value", "coin"}, []string{"darc"}, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"value", "coin"}, ids)
+	ids, err = updateSpawnContractIDs(ids, nil, nil, true)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	_, err = updateSpawnContractIDs(nil, []string{""}, nil, false)
+	require.Error(t, err)
+}
+
+func TestUpdateContractVersions(t *testing.T) {
+	versions, err := updateContractVersions(nil, []string{"value=1", "coin=2"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.ContractVersion{{ContractID: "value", Version: "1"},
+		{ContractID: "coin", Version: "2"}}, versions)
+	versions, err = updateContractVersions(versions, []string{"coin=3"}, []string{"value"})
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.ContractVersion{{ContractID: "coin", Version: "3"}}, versions)
+	_, err = updateContractVersions(nil, []string{"coin"}, nil)
+	require.Error(t, err)
+	_, err = updateContractVersions(nil, []string{"coin="}, nil)
+	require.Error(t, err)
+}
+
+func TestParseInvokeArgs(t *testing.T) {
+	args, err := parseInvokeArgs([]string{"value=0102", "empty="})
+	require.NoError(t, err)
+	require.Equal(t, byzcoin.Arguments{{Name: "value", Value: []byte{1, 2}},
+		{Name: "empty", Value: []byte{}}}, args)
+	args, err = parseInvokeArgs(nil)
+	require.NoError(t, err)
+	require.Empty(t, args)
+
+	_, err = parseInvokeArgs([]string{"value"})
+	require.Error(t, err)
+	_, err = parseInvokeArgs([]string{"=01"})
+	require.Error(t, err)
+	_, err = parseInvokeArgs([]string{"value=xyz"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not hex")
+	_, err = parseInvokeArgs([]string{"value=01", "value=02"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "twice")
+}
+
+func TestParseCoins(t *testing.T) {
+	for _, tc := range []struct {
+		amount   string
+		decimals uint
+		coins    uint64
+	}{
+		{"1000000", 0, 1000000},
+		{"1,000,000", 0, 1000000},
+		{"1_000_000", 0, 1000000},
+		{"1000000.0", 0, 1000000},
+		{"1,000.25", 2, 100025},
+		{"1.5", 3, 1500},
+		{"0", 0, 0},
+		{"18446744073709551615", 0, math.MaxUint64},
+		{"18,446,744,073,709,551.615", 3, math.MaxUint64},
+	} {
+		coins, err := parseCoins(tc.amount, tc.decimals)
+		require.NoError(t, err, tc.amount)
+		require.Equal(t, tc.coins, coins, tc.amount)
+	}
+
+	for _, amount := range []string{"", "-1", "1.2.3", "1.", ".5", "1e6", "0x10", "1.5"} {
+		_, err := parseCoins(amount, 0)
+		require.Error(t, err, amount)
+	}
+	_, err := parseCoins("18446744073709551616", 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "larger than the maximum")
+	_, err = parseCoins("18446744073709551.616", 3)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "larger than the maximum")
+}
+
+func TestFmtChainConfig(t *testing.T) {
+	si := network.NewServerIdentity(cothority.Suite.Point().Base(), "tls://127.0.0.1:7770")
+	cc := &byzcoin.ChainConfig{
+		BlockInterval:   5 * time.Second,
+		MaxBlockSize:    4000000,
+		Roster:          *onet.NewRoster([]*network.ServerIdentity{si}),
+		DarcContractIDs: []string{"darc", "secure_darc"},
+	}
+	require.Equal(t, "BlockInterval: 5s\nMaxBlockSize: 4000000\n"+
+		"Roster: tls://127.0.0.1:7770\nDarcContracts: darc, secure_darc", fmtChainConfig(cc))
+
+	cc.SpawnContractIDs = []string{"darc", "value"}
+	out := fmtChainConfig(cc)
+	require.Contains(t, out, "\nSpawnContracts: darc, value")
+	require.NotContains(t, out, "ChainBoundSignatures")
+	require.NotContains(t, out, "Observers")
+	require.NotContains(t, out, "MaxInstructionsPerTx")
+	cc.MaxInstructionsPerTx = 10
+	require.Contains(t, fmtChainConfig(cc), "\nMaxInstructionsPerTx: 10")
+	require.NotContains(t, out, "ByzCoinVersion")
+	cc.ByzCoinVersion = 1
+	require.Contains(t, fmtChainConfig(cc), "\nByzCoinVersion: 1")
+
+	cc.Observers = []*network.ServerIdentity{
+		network.NewServerIdentity(cothority.Suite.Point().Base(), "tls://127.0.0.1:7780")}
+	require.Contains(t, fmtChainConfig(cc), "\nObservers: tls://127.0.0.1:7780\n")
+}
+
+func TestFmtInstanceHistory(t *testing.T) {
+	scs := []byzcoin.GetInstanceVersionResponse{
+		{StateChange: byzcoin.StateChange{StateAction: byzcoin.Create, ContractID: "value",
+			Value: []byte("abc"), Version: 0}, BlockIndex: 2},
+		{StateChange: byzcoin.StateChange{StateAction: byzcoin.Remove, ContractID: "value",
+			Version: 1}, BlockIndex: 5},
+	}
+	require.Equal(t, "Version 0 at block 2: Create value, value of 3 bytes\n"+
+		"Version 1 at block 5: Remove value, value of 0 bytes\n", fmtInstanceHistory(scs, false))
+	require.Equal(t, "Version 0 at block 2: Create value, value of 3 bytes\n"+
+		hex.Dump([]byte("abc"))+
+		"Version 1 at block 5: Remove value, value of 0 bytes\n", fmtInstanceHistory(scs, true))
+}
+
+func TestFmtCoin(t *testing.T) {
+	account := byzcoin.NewInstanceID([]byte("account"))
+	out := fmtCoin(account, byzcoin.Coin{Name: contracts.CoinName, Value: 1234})
+	require.Equal(t, fmt.Sprintf("Account: %x\nBalance: 1234\nType: %x (byzCoin)\n",
+		account.Slice(), contracts.CoinName.Slice()), out)
+
+	other := byzcoin.NewInstanceID([]byte("other"))
+	out = fmtCoin(account, byzcoin.Coin{Name: other})
+	require.Contains(t, out, "Balance: 0\n")
+	require.Contains(t, out, fmt.Sprintf("Type: %x\n", other.Slice()))
+}
+
+func TestFmtCounters(t *testing.T) {
+	require.Equal(t, "ed25519:aa   3\ned25519:bbb  0\n",
+		fmtCounters([]string{"ed25519:aa", "ed25519:bbb"}, []uint64{3, 0}))
+}
+
+func TestParseEd25519Signer(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	buf, err := signer.Ed25519.Secret.MarshalBinary()
+	require.NoError(t, err)
+	id := signer.Identity()
+
+	for _, s := range []string{hex.EncodeToString(buf), "ed25519:" + hex.EncodeToString(buf)} {
+		imported, err := parseEd25519Signer(s)
+		require.NoError(t, err)
+		require.True(t, imported.Identity().Equal(&id))
+	}
+
+	_, err = parseEd25519Signer(hex.EncodeToString(buf[1:]))
+	require.Error(t, err)
+	_, err = parseEd25519Signer("xyz")
+	require.Error(t, err)
+	_, err = parseEd25519Signer(hex.EncodeToString(make([]byte, 32)))
+	require.Error(t, err)
+}
+
+func TestSoleSigners(t *testing.T) {
+	require.Equal(t, 1, soleSigners(expression.Expr("ed25519:aa")))
+	require.Equal(t, 3, soleSigners(expression.Expr("ed25519:aa | ed25519:bb | ed25519:cc")))
+	require.Equal(t, 0, soleSigners(expression.Expr("ed25519:aa & ed25519:bb")))
+	require.Equal(t, 1, soleSigners(expression.Expr("ed25519:aa | (ed25519:bb & ed25519:cc)")))
+}
+
+func TestDecodeTx(t *testing.T) {
+	ctx := byzcoin.ClientTransaction{Instructions: byzcoin.Instructions{{
+		InstanceID: byzcoin.NewInstanceID([]byte("instance")),
+		Invoke: &byzcoin.Invoke{
+			ContractID: "value",
+			Command:    "update",
+		},
+		SignerCounter: []uint64{1},
+	}}}
+	buf, err := protobuf.Encode(&ctx)
+	require.NoError(t, err)
+
+	dec, err := decodeTx(buf)
+	require.NoError(t, err)
+	require.Equal(t, ctx.Instructions.Hash(), dec.Instructions.Hash())
+	dec, err = decodeTx([]byte(hex.EncodeToString(buf) + "\n"))
+	require.NoError(t, err)
+	require.Equal(t, ctx.Instructions.Hash(), dec.Instructions.Hash())
+
+	_, err = decodeTx([]byte("not a transaction"))
+	require.Error(t, err)
+	_, err = decodeTx(nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no instructions")
+}
+
+func TestCheckExpression(t *testing.T) {
+	ids, err := checkExpression(expression.Expr("ed25519:5866666666666666666666666666666666666666666666666666666666666666 | (darc:aa & darc:bb) | darc:aa"))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(ids))
+	require.Equal(t, "darc:aa", ids[0].String())
+	require.NotNil(t, ids[2].Ed25519)
+
+	_, err = checkExpression(expression.Expr("darc:aa &"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid expression")
+	_, err = checkExpression(expression.Expr("darc:aa | foo:bar"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid expression")
+	// Well formed, but not a valid point.
+	_, err = checkExpression(expression.Expr("darc:aa | ed25519:aa"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ed25519:aa")
+}
+
+func TestDarcTemplates(t *testing.T) {
+	id := darc.NewSignerEd25519(nil, nil).Identity()
+	rules := darc.InitRulesWith([]darc.Identity{id}, []darc.Identity{id}, "invoke:darc.evolve")
+	tmpl, err := getDarcTemplate("coin")
+	require.NoError(t, err)
+	require.NoError(t, tmpl.apply(&rules, id))
+	require.Equal(t, id.String(), string(rules.Get("invoke:coin.mint")))
+	require.True(t, rules.Contains("spawn:coin"))
+	require.True(t, rules.Contains("invoke:coin.transfer"))
+	// Applying it twice fails, as the rules already exist.
+	require.Error(t, tmpl.apply(&rules, id))
+
+	tmpl, err = getDarcTemplate("read-only")
+	require.NoError(t, err)
+	rules = darc.InitRulesWith([]darc.Identity{id}, []darc.Identity{id}, "invoke:darc.evolve")
+	require.NoError(t, tmpl.apply(&rules, id))
+	require.Equal(t, 2, len(rules.List))
+
+	_, err = getDarcTemplate("gold")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "coin, content, read-only")
+}
+
+func TestFmtDarcRules(t *testing.T) {
+	d := darc.NewDarc(darc.NewRules(), []byte("rules"))
+	require.NoError(t, d.Rules.AddRule("spawn:coin", []byte("ed25519:aa")))
+	require.NoError(t, d.Rules.AddRule("invoke:coin.transfer", []byte("ed25519:aa | ed25519:bb")))
+	require.NoError(t, d.Rules.AddRule("spawn:darc", []byte("ed25519:bb")))
+
+	require.Equal(t, "spawn:coin            ed25519:aa\n"+
+		"invoke:coin.transfer  ed25519:aa | ed25519:bb\n"+
+		"spawn:darc            ed25519:bb\n", fmtDarcRules(d, ""))
+	require.Equal(t, "spawn:coin  ed25519:aa\nspawn:darc  ed25519:bb\n",
+		fmtDarcRules(d, "spawn:"))
+	require.Equal(t, "", fmtDarcRules(d, "delete:"))
+}
+
+func TestFmtDarcDiff(t *testing.T) {
+	d := darc.NewDarc(darc.NewRules(), []byte("diff"))
+	require.NoError(t, d.Rules.AddRule("spawn:coin", []byte("ed25519:aa")))
+	require.NoError(t, d.Rules.AddRule("spawn:darc", []byte("ed25519:aa")))
+	d2 := d.Copy()
+	require.NoError(t, d2.EvolveFrom(d))
+	require.Equal(t, "", fmtDarcDiff(d, d2))
+
+	require.NoError(t, d2.Rules.UpdateRule("spawn:coin", []byte("ed25519:bb")))
+	require.NoError(t, d2.Rules.DeleteRules("spawn:darc"))
+	require.NoError(t, d2.Rules.AddRule("invoke:coin.mint", []byte("ed25519:aa")))
+	require.Equal(t, "+ invoke:coin.mint: ed25519:aa\n"+
+		"- spawn:darc: ed25519:aa\n"+
+		"~ spawn:coin: ed25519:aa -> ed25519:bb\n", fmtDarcDiff(d, d2))
+}
+
+func TestFmtDarcList(t *testing.T) {
+	id := darc.NewSignerEd25519(nil, nil).Identity()
+	rules := darc.InitRules([]darc.Identity{id}, []darc.Identity{id})
+	users := darc.NewDarc(rules, []byte("users"))
+	admin := darc.NewDarc(rules, []byte("admin"))
+	other := darc.NewSignerEd25519(nil, nil).Identity()
+	admin2 := darc.NewDarc(darc.InitRules([]darc.Identity{other}, []darc.Identity{other}),
+		[]byte("admin"))
+
+	out := fmtDarcList([]*darc.Darc{users, admin, admin2}, false)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Equal(t, 3, len(lines))
+	require.True(t, strings.HasSuffix(lines[0], "- admin"))
+	require.True(t, strings.HasSuffix(lines[1], "- admin"))
+	require.Equal(t, users.GetIdentityString()+" - version 0 - users", lines[2])
+	// The order doesn't depend on the order of the darcs.
+	require.Equal(t, out, fmtDarcList([]*darc.Darc{admin2, users, admin}, false))
+
+	out = fmtDarcList([]*darc.Darc{users}, true)
+	require.Contains(t, out, "\tAction: _sign - Expression: "+id.String())
+}
+
 func TestCli(t *testing.T) {
 	dir, err := ioutil.TempDir("", "bc-test")
 	if err != nil {
@@ -60,16 +491,85 @@ func TestCli(t *testing.T) {
 	require.IsType(t, "", bc)
 	os.Setenv("BC", bc.(string))
 
+	// A ledger can be owned by a key bcadmin doesn't know.
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "create", "-roster", rf, "--owner", "ed25519:xyz"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid owner")
+	external := darc.NewSignerEd25519(nil, nil).Identity()
+	args = []string{"bcadmin", "create", "-roster", rf, "--interval", interval.String(),
+		"--owner", external.String()}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), "managed externally")
+	ownedCfg, _, err := lib.LoadConfig(cliApp.Metadata["BC"].(string))
+	require.NoError(t, err)
+	require.True(t, external.Equal(&ownedCfg.AdminIdentity))
+	_, err = os.Stat(path.Join(dir, "key-"+external.String()+".cfg"))
+	require.True(t, os.IsNotExist(err))
+
 	log.Lvl1("latest: ")
 	b = &bytes.Buffer{}
 	cliApp.Writer = b
 	cliApp.ErrWriter = b
-	args = []string{"bcadmin", "latest"}
+	args = []string{"bcadmin", "--timeout", "10s", "latest"}
 	err = cliApp.Run(args)
 	require.NoError(t, err)
 	require.Contains(t, string(b.Bytes()), "Index: 0")
 	require.Contains(t, string(b.Bytes()), "Roster: tcp://127.0.0.1")
 
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "--timeout", "10s", "latest", "--export-json"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	var latest latestInfo
+	require.NoError(t, json.Unmarshal(b.Bytes(), &latest))
+	require.Equal(t, 0, latest.Index)
+	require.Equal(t, len(roster.List), len(latest.Roster))
+	require.Equal(t, string(roster.List[0].Address), latest.Roster[0])
+	require.Equal(t, 64, len(latest.ByzCoinID))
+
+	log.Lvl1("debug counters: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "debug", "counters"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	adminCfg, _, err := lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(b.String(), adminCfg.AdminIdentity.String()))
+	args = []string{"bcadmin", "debug", "counters", "nothing"}
+	require.Error(t, cliApp.Run(args))
+
+	log.Lvl1("roster list: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "roster", "list"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b.Bytes())), "\n")
+	require.Equal(t, 3, len(lines))
+	require.Equal(t, string(roster.List[0].Address)+" leader", lines[0])
+	require.Equal(t, string(roster.List[1].Address), lines[1])
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "roster", "list", "--json"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	var nodes []rosterNode
+	require.NoError(t, json.Unmarshal(b.Bytes(), &nodes))
+	require.Equal(t, 3, len(nodes))
+	require.True(t, nodes[0].Leader)
+	require.False(t, nodes[2].Leader)
+
 	log.Lvl1("darc show: ")
 	b = &bytes.Buffer{}
 	cliApp.Writer = b
@@ -80,13 +580,26 @@ func TestCli(t *testing.T) {
 	require.Contains(t, string(b.Bytes()), "Ver:\t0")
 
 	log.Lvl1("darc rule: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "rule", "--dry-run", "-identity", "foo", "-rule", "spawn:xxx"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), "+ spawn:xxx: foo\n")
+	require.Contains(t, string(b.Bytes()), "Ver:\t1")
+	args = []string{"bcadmin", "darc", "rule", "--dry-run", "-delete", "-rule", "spawn:xxx"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+
 	b = &bytes.Buffer{}
 	cliApp.Writer = b
 	cliApp.ErrWriter = b
 	args = []string{"bcadmin", "darc", "rule", "-identity", "foo", "-rule", "spawn:xxx"}
 	err = cliApp.Run(args)
 	require.NoError(t, err)
-	require.Equal(t, string(b.Bytes()), "")
+	require.Equal(t, "+ spawn:xxx: foo\n", string(b.Bytes()))
 
 	log.Lvl1("darc show: ")
 	b = &bytes.Buffer{}
@@ -98,4 +611,302 @@ func TestCli(t *testing.T) {
 	require.Contains(t, string(b.Bytes()), "Ver:\t1")
 	require.Contains(t, string(b.Bytes()), "spawn:xxx")
 
+	log.Lvl1("debug ping: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "debug", "ping"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(b.Bytes())), "\n")
+	require.Equal(t, 3, len(lines))
+	require.NotContains(t, string(b.Bytes()), "unreachable")
+
+	log.Lvl1("debug cosi: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "debug", "cosi", "0"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), "Block 0: forward link 0")
+	require.Contains(t, string(b.Bytes()), "of 3 nodes")
+	args = []string{"bcadmin", "debug", "cosi", "--level", "5", "0"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+
+	log.Lvl1("darc alias: ")
+	cfg, _, err := lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	adminDarc := cfg.AdminDarc.GetIdentityString()
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "alias", "set", "admin", adminDarc}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	args = []string{"bcadmin", "darc", "alias", "set", "unknown", "darc:" + strings.Repeat("00", 32)}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	args = []string{"bcadmin", "darc", "alias", "list"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Equal(t, "admin: "+adminDarc+"\n", string(b.Bytes()))
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "instances", "admin"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), strings.Repeat("00", 32)+"\n")
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "show", "--darc", "admin"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), "spawn:xxx")
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "rule", "--list", "--darc", "admin", "--rule", "spawn:"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), "spawn:xxx  ")
+	require.NotContains(t, string(b.Bytes()), "Action:")
+	require.NotContains(t, string(b.Bytes()), "invoke:")
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "audit", "--threshold", "1"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, string(b.Bytes()), adminDarc+" (Description: ")
+	require.Contains(t, string(b.Bytes()), "\tspawn:xxx - ")
+	require.Contains(t, string(b.Bytes()), "\t\t! 1 identities can use it alone\n")
+
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	cliApp.ErrWriter = b
+	args = []string{"bcadmin", "darc", "audit", "--json"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	var report []auditDarc
+	require.NoError(t, json.Unmarshal(b.Bytes(), &report))
+	found := false
+	for _, d := range report {
+		found = found || d.ID == adminDarc
+	}
+	require.True(t, found)
+
+	args = []string{"bcadmin", "darc", "alias", "rm", "admin"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	args = []string{"bcadmin", "darc", "show", "--darc", "admin"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+
+	log.Lvl1("config set: ")
+	keyFile := path.Join(lib.ConfigPath, "key-"+cfg.AdminIdentity.String()+".cfg")
+	args = []string{"bcadmin", "config", "set", "--interval", "200ms", "--blockSize", "20000", bc.(string), keyFile}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	_, cl, err := lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	cc, err := cl.GetChainConfig()
+	require.NoError(t, err)
+	require.Equal(t, 200*time.Millisecond, cc.BlockInterval)
+	require.Equal(t, 20000, cc.MaxBlockSize)
+
+	// The changes are validated before being sent.
+	args = []string{"bcadmin", "config", "set", "--blockSize", "1000", bc.(string), keyFile}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid config")
+	leader := &app.Group{Roster: onet.NewRoster(roster.List[:1])}
+	lf := path.Join(dir, "leader.toml")
+	require.NoError(t, leader.Save(cothority.Suite, lf))
+	args = []string{"bcadmin", "config", "set", "--del", lf, bc.(string), keyFile}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+
+	log.Lvl1("roster add: ")
+	down := network.NewServerIdentity(cothority.Suite.Point().Pick(cothority.Suite.RandomStream()),
+		network.NewAddress(network.TLS, "127.0.0.1:2"))
+	df := path.Join(dir, "down.toml")
+	require.NoError(t, (&app.Group{Roster: onet.NewRoster([]*network.ServerIdentity{down})}).Save(cothority.Suite, df))
+	args = []string{"bcadmin", "--timeout", "2s", "roster", "add", bc.(string), keyFile, df}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is unreachable")
+	// A TOML file can have several nodes, but not twice the same one.
+	twice := &app.Group{Roster: &onet.Roster{List: []*network.ServerIdentity{down, down}}}
+	tf := path.Join(dir, "twice.toml")
+	require.NoError(t, twice.Save(cothority.Suite, tf))
+	args = []string{"bcadmin", "roster", "add", bc.(string), keyFile, tf}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is given twice")
+	// Nothing is changed if one of the nodes can't be added.
+	several := &app.Group{Roster: &onet.Roster{List: []*network.ServerIdentity{down, roster.List[0]}}}
+	sf := path.Join(dir, "several.toml")
+	require.NoError(t, several.Save(cothority.Suite, sf))
+	args = []string{"bcadmin", "roster", "add", "--force", bc.(string), keyFile, sf}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), string(roster.List[0].Address)+": new node is already in roster")
+	args = []string{"bcadmin", "roster", "del", bc.(string), keyFile, sf}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), string(down.Address)+": node to delete is not in roster")
+
+	log.Lvl1("choose server: ")
+	_, cl, err = lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	cl.ServerNumber = 2
+	chooseServer(cl, true)
+	require.Equal(t, 0, cl.ServerNumber)
+	// The roster is only probed once per client.
+	cl.ServerNumber = 2
+	chooseServer(cl, false)
+	require.Equal(t, 2, cl.ServerNumber)
+	// The choices are forgotten at the end of every command.
+	require.NoError(t, cliApp.Run([]string{"bcadmin", "latest"}))
+	chooseServer(cl, true)
+	require.Equal(t, 0, cl.ServerNumber)
+
+	log.Lvl1("watch latest: ")
+	b = &bytes.Buffer{}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watchLatest(b, cl, stop)
+	}()
+	time.Sleep(time.Second)
+	close(stop)
+	require.NoError(t, <-done)
+	require.Contains(t, b.String(), "Index: ")
+	require.True(t, strings.HasSuffix(b.String(), "\n"))
+
+	log.Lvl1("instance get: ")
+	adminDarcHex := hex.EncodeToString(cfg.AdminDarc.GetBaseID())
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "instance", "get", adminDarcHex}
+	require.NoError(t, cliApp.Run(args))
+	require.Contains(t, b.String(), "Contract: darc\n")
+	require.Contains(t, b.String(), cfg.AdminDarc.GetIdentityString())
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "instance", "get", "--json", adminDarcHex}
+	require.NoError(t, cliApp.Run(args))
+	var info instanceInfo
+	require.NoError(t, json.Unmarshal(b.Bytes(), &info))
+	require.Equal(t, adminDarcHex, info.ID)
+	require.Equal(t, "darc", info.ContractID)
+
+	log.Lvl1("instance history: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "instance", "history", "--verbose", adminDarcHex}
+	require.NoError(t, cliApp.Run(args))
+	require.True(t, strings.HasPrefix(b.String(), "Version 0 at block 0: Create darc, value of "))
+	require.Contains(t, b.String(), "\nVersion 1 at block ")
+	require.Contains(t, b.String(), ": Update darc, value of ")
+
+	log.Lvl1("instance delete: ")
+	args = []string{"bcadmin", "instance", "delete", "--soft", strings.Repeat("11", 32)}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+
+	log.Lvl1("pin genesis: ")
+	args = []string{"bcadmin", "--pin-genesis", hex.EncodeToString(cfg.ByzCoinID), "darc", "show"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	args = []string{"bcadmin", "--pin-genesis", strings.Repeat("11", 32), "darc", "show"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not the chain")
+	lib.PinnedGenesis = nil
+
+	log.Lvl1("bench: ")
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "bench", "--count", "5", "--rate", "20", "--parallel", "2"}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "Included: 5, failed: 0")
+	require.Contains(t, b.String(), "Deleted 5 instances")
+
+	log.Lvl1("link from a node: ")
+	require.NoError(t, os.Remove(bc.(string)))
+	args = []string{"bcadmin", "link", roster.List[1].Address.NetworkAddress(), hex.EncodeToString(cfg.ByzCoinID)}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	linked, _, err := lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(linked.Roster.List))
+	require.True(t, linked.Roster.List[0].Equal(roster.List[0]))
+	// The key of the admin can be stored while linking, if it matches.
+	adminSigner, err := lib.LoadKey(cfg.AdminIdentity)
+	require.NoError(t, err)
+	adminKey, err := adminSigner.Ed25519.Secret.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(keyFile))
+	linkWithKey := func(key string) error {
+		return cliApp.Run([]string{"bcadmin", "link", "--admindarc", cfg.AdminDarc.GetIdentityString(),
+			"--adminpub", cfg.AdminIdentity.String(), "--save-key", key,
+			roster.List[1].Address.NetworkAddress(), hex.EncodeToString(cfg.ByzCoinID)})
+	}
+	require.Error(t, linkWithKey(hex.EncodeToString(adminKey[1:])))
+	otherKey, err := darc.NewSignerEd25519(nil, nil).Ed25519.Secret.MarshalBinary()
+	require.NoError(t, err)
+	err = linkWithKey(hex.EncodeToString(otherKey))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't match the admin public key")
+	_, err = os.Stat(keyFile)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, linkWithKey(hex.EncodeToString(adminKey)))
+	_, err = os.Stat(keyFile)
+	require.NoError(t, err)
+	args = []string{"bcadmin", "link", "nothing-here"}
+	err = cliApp.Run(args)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "neither a roster file nor")
+
+	log.Lvl1("roster leader: ")
+	newLeader := &app.Group{Roster: onet.NewRoster(roster.List[1:2])}
+	nlf := path.Join(dir, "new-leader.toml")
+	require.NoError(t, newLeader.Save(cothority.Suite, nlf))
+	args = []string{"bcadmin", "roster", "leader", bc.(string), keyFile, nlf}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	_, cl, err = lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	sb, err := waitForLeader(cl, roster.List[1], interval, 0)
+	require.NoError(t, err)
+	require.True(t, sb.Roster.List[0].Equal(roster.List[1]))
+	// No block of the old leader comes anymore.
+	_, err = waitForLeader(cl, roster.List[0], interval, 2*interval)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the handoff didn't complete")
+
+	log.Lvl1("config rebuild: ")
+	require.NoError(t, os.Remove(bc.(string)))
+	b = &bytes.Buffer{}
+	cliApp.Writer = b
+	args = []string{"bcadmin", "config", "rebuild", rf, hex.EncodeToString(cfg.ByzCoinID)}
+	err = cliApp.Run(args)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "The admin identity is not set")
+	rebuilt, _, err := lib.LoadConfig(bc.(string))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(rebuilt.Roster.List))
+	require.Equal(t, cfg.AdminDarc.GetBaseID(), rebuilt.AdminDarc.GetBaseID())
+	require.Equal(t, darc.Identity{}, rebuilt.AdminIdentity)
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// serverChoice tells whether the server of a client has been chosen for
+// transactions, or only for reads.
+type serverChoice struct {
+	forWrite bool
+}
+
+// serverChoices remembers the clients whose server has already been chosen,
+// so that the roster is only probed once per command. The choices are
+// forgotten at the end of every command.
+type serverChoices struct {
+	sync.Mutex
+	chosen map[*byzcoin.Client]serverChoice
+}
+
+var chosenServers = serverChoices{chosen: make(map[*byzcoin.Client]serverChoice)}
+
+// choose records the choice for cl and returns true, unless the server of cl
+// has already been chosen for the same use.
+func (sc *serverChoices) choose(cl *byzcoin.Client, forWrite bool) bool {
+	sc.Lock()
+	defer sc.Unlock()
+	if choice, ok := sc.chosen[cl]; ok && (choice.forWrite || !forWrite) {
+		return false
+	}
+	sc.chosen[cl] = serverChoice{forWrite: forWrite}
+	return true
+}
+
+// forget makes the next call to chooseServer probe the roster of cl again.
+func (sc *serverChoices) forget(cl *byzcoin.Client) {
+	sc.Lock()
+	defer sc.Unlock()
+	delete(sc.chosen, cl)
+}
+
+// forgetAll drops the choices of all the clients.
+func (sc *serverChoices) forgetAll() {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.chosen = make(map[*byzcoin.Client]serverChoice)
+}
+
+// pinServer keeps the server of cl as it is, e.g. when it has been given
+// with --server.
+func pinServer(cl *byzcoin.Client) {
+	chosenServers.choose(cl, true)
+}
+
+// chooseServer probes the roster of cl and makes it contact the fastest
+// reachable node. If forWrite is true, the current leader, as given by the
+// latest chain config, is preferred if it is reachable. If no node is
+// reachable, the server is left unchanged.
+func chooseServer(cl *byzcoin.Client, forWrite bool) {
+	if len(cl.Roster.List) < 2 {
+		return
+	}
+	if !chosenServers.choose(cl, forWrite) {
+		return
+	}
+
+	d := timeout
+	if d <= 0 {
+		d = defaultPingTimeout
+	}
+	results := cl.Ping(d)
+	if len(results) == 0 || results[0].Error != nil {
+		log.Lvl2("No node is reachable, keeping server", cl.ServerNumber)
+		return
+	}
+	reachable := make(map[int]bool)
+	for _, r := range results {
+		if r.Error == nil {
+			i, _ := cl.Roster.Search(r.ServerIdentity.ID)
+			reachable[i] = true
+		}
+	}
+	cl.ServerNumber, _ = cl.Roster.Search(results[0].ServerIdentity.ID)
+
+	if forWrite {
+		var cc *byzcoin.ChainConfig
+		err := withTimeout("getting the chain config", func() (err error) {
+			cc, err = cl.GetChainConfig()
+			return
+		})
+		if err != nil {
+			log.Lvl2("Couldn't get the leader:", err)
+		} else if i, _ := cl.Roster.Search(cc.Roster.List[0].ID); reachable[i] {
+			cl.ServerNumber = i
+		} else {
+			log.Lvlf2("Leader %s is not reachable", cc.Roster.List[0].Address)
+		}
+	}
+	log.Lvlf2("Using server %s", cl.Roster.List[cl.ServerNumber].Address)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// darcTemplate is a set of rules that darc add gives to the owner of the new
+// darc, on top of _sign and evolve.
+type darcTemplate struct {
+	name        string
+	description string
+	actions     []darc.Action
+}
+
+var darcTemplates = []darcTemplate{
+	{
+		name:        "coin",
+		description: "spawn coins, mint them and transfer them",
+		actions: []darc.Action{
+			darc.Action("spawn:" + contracts.ContractCoinID),
+			darc.Action("invoke:" + contracts.ContractCoinID + ".mint"),
+			darc.Action("invoke:" + contracts.ContractCoinID + ".transfer"),
+		},
+	},
+	{
+		name:        "content",
+		description: "spawn values, update them and delete them",
+		actions: []darc.Action{
+			darc.Action("spawn:" + contracts.ContractValueID),
+			darc.Action("invoke:" + contracts.ContractValueID + ".update"),
+			darc.Action("delete:" + contracts.ContractValueID),
+		},
+	},
+	{
+		name:        "read-only",
+		description: "only sign and evolve, without any rule to change the instances",
+	},
+}
+
+// getDarcTemplate returns the template with the given name.
+func getDarcTemplate(name string) (darcTemplate, error) {
+	var names []string
+	for _, t := range darcTemplates {
+		if t.name == name {
+			return t, nil
+		}
+		names = append(names, t.name)
+	}
+	return darcTemplate{}, fmt.Errorf("unknown template '%s', must be one of %s",
+		name, strings.Join(names, ", "))
+}
+
+// apply adds the rules of the template to the rules, all of them given to the
+// identity.
+func (t darcTemplate) apply(rules *darc.Rules, id darc.Identity) error {
+	for _, a := range t.actions {
+		if err := rules.AddRule(a, expression.Expr(id.String())); err != nil {
+			return fmt.Errorf("couldn't add rule '%s' of template '%s': %v", a, t.name, err)
+		}
+	}
+	return nil
+}
+
+// darcTemplatesList prints the templates that can be given to darc add.
+func darcTemplatesList(c *cli.Context) error {
+	for _, t := range darcTemplates {
+		actions := []string{"_sign", "invoke:" + byzcoin.ContractDarcID + ".evolve"}
+		for _, a := range t.actions {
+			actions = append(actions, string(a))
+		}
+		_, err := fmt.Fprintf(c.App.Writer, "%s: %s\n\t%s\n", t.name, t.description,
+			strings.Join(actions, "\n\t"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -25,9 +25,11 @@ main(){
     run testLink
     run testCoin
     run testRoster
+    run testConfigSet
     run testCreateStoreRead
     run testAddDarc
     run testRuleDarc
+    run testAliasDarc
     run testAddDarcFromOtherOne
     run testAddDarcWithOwner
     run testExpression
@@ -103,6 +105,20 @@ testRoster(){
 }
 
 
+testConfigSet(){
+  rm -f config/*
+  runCoBG 1 2 3 4
+  testOK runBA create public.toml --interval .5s
+  bc=config/bc*cfg
+  key=config/key*cfg
+  # Only one node can be added or removed at a time
+  testFail runBA config set --add co4/public.toml --del co2/public.toml $bc $key
+  testOK runBA config set --interval 1s --blockSize 1000000 --add co4/public.toml $bc $key
+  testOK runBA config --blockSize 1000000 $bc $key
+  testGrep 2008 runBA latest $bc
+}
+
+
 # When a conode is linked to a client (`scmgr link add ...`), it removes the
 # possibility for 3rd parties to create a new skipchain on that conode. In the
 # case a Bizcoin service hosted on a linked conode wants to adds a new
@@ -175,6 +191,26 @@ testRuleDarc(){
   testNGrep "spawn:xxx" runBA darc show -darc "$ID"
 }
 
+testAliasDarc(){
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testOK runBA darc add -out_id ./darc_id.txt -out_key ./darc_key.txt -desc aliased -unrestricted
+  ID=`cat ./darc_id.txt`
+  KEY=`cat ./darc_key.txt`
+  testFail runBA darc alias set foo
+  testOK runBA darc alias set foo "$ID"
+  testGrep "foo: $ID" runBA darc alias list
+  testGrep "Description: \"aliased\"" runBA darc show -darc foo
+  testOK runBA darc rule -rule spawn:xxx -identity ed25519:foo -darc foo -sign "$KEY"
+  testGrep "spawn:xxx" runBA darc show -darc "$ID"
+  testOK runBA darc alias rm foo
+  testFail runBA darc alias rm foo
+  testFail runBA darc show -darc foo
+}
+
 testAddDarcFromOtherOne(){
   runCoBG 1 2 3
   runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// timeout bounds every network operation of bcadmin. It is set by the global
+// --timeout flag, and zero means no limit.
+var timeout time.Duration
+
+// overallTimeoutFactor is the number of network timeouts a whole command may
+// take. This leaves room for commands that go through all the nodes of a
+// roster and skip the unresponsive ones.
+const overallTimeoutFactor = 10
+
+// commandStop is closed when the running command times out, so that its
+// next network operation fails instead of being sent in the background.
+var commandStop struct {
+	sync.Mutex
+	c <-chan struct{}
+}
+
+// withTimeout runs the network operation f and returns an error if it does
+// not finish within the timeout, or if the command timed out. A single call
+// of a client cannot be interrupted, so f still returns once the client gives
+// up reading, and its results must be ignored by the caller.
+func withTimeout(what string, f func() error) error {
+	commandStop.Lock()
+	parent := commandStop.c
+	commandStop.Unlock()
+	return runWithin(parent, timeout, what, func(<-chan struct{}) error {
+		return f()
+	})
+}
+
+// runWithin runs f and returns an error if it does not finish within d, or
+// once parent is closed. In both cases the stop channel given to f is closed,
+// and f must return as soon as it sees it, without doing anything else.
+func runWithin(parent <-chan struct{}, d time.Duration, what string, f func(stop <-chan struct{}) error) error {
+	select {
+	case <-parent:
+		return fmt.Errorf("%s aborted: the command timed out", what)
+	default:
+	}
+	if d <= 0 && parent == nil {
+		return f(nil)
+	}
+	var expired <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	res := make(chan error, 1)
+	go func() {
+		res <- f(stop)
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-expired:
+		return fmt.Errorf("%s timed out after %v", what, d)
+	case <-parent:
+		return fmt.Errorf("%s aborted: the command timed out", what)
+	}
+}
+
+// addOverallTimeout makes sure that all the commands return after
+// overallTimeoutFactor times the timeout. A command that timed out stops at
+// its next network operation.
+func addOverallTimeout(cmds cli.Commands) {
+	for i := range cmds {
+		if action, ok := cmds[i].Action.(func(*cli.Context) error); ok {
+			name := cmds[i].Name
+			cmds[i].Action = func(c *cli.Context) error {
+				return runWithin(nil, overallTimeoutFactor*timeout, "command "+name, func(stop <-chan struct{}) error {
+					commandStop.Lock()
+					commandStop.c = stop
+					commandStop.Unlock()
+					defer func() {
+						commandStop.Lock()
+						if commandStop.c == stop {
+							commandStop.c = nil
+						}
+						commandStop.Unlock()
+					}()
+					return action(c)
+				})
+			}
+		}
+		addOverallTimeout(cmds[i].Subcommands)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/protobuf"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func txDecode(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errors.New("please give at most one file or hex string as argument")
+	}
+	var buf []byte
+	var err error
+	switch arg := c.Args().First(); {
+	case arg == "" || arg == "-":
+		buf, err = ioutil.ReadAll(os.Stdin)
+	case fileExists(arg):
+		buf, err = ioutil.ReadFile(arg)
+	default:
+		buf = []byte(arg)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, err := decodeTx(buf)
+	if err != nil {
+		return err
+	}
+	for i, instr := range ctx.Instructions {
+		fmt.Fprintf(c.App.Writer, "[%d] %s", i, instr.String())
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "Transaction hash: %x\n", ctx.Instructions.Hash())
+	return err
+}
+
+// decodeTx decodes a ClientTransaction, encoded with protobuf, either as
+// binary or as hex.
+func decodeTx(buf []byte) (byzcoin.ClientTransaction, error) {
+	if h, err := hex.DecodeString(string(bytes.TrimSpace(buf))); err == nil {
+		buf = h
+	}
+	var ctx byzcoin.ClientTransaction
+	if err := protobuf.Decode(buf, &ctx); err != nil {
+		return ctx, fmt.Errorf("couldn't decode the transaction: %v", err)
+	}
+	if len(ctx.Instructions) == 0 {
+		return ctx, errors.New("couldn't decode the transaction: it has no instructions")
+	}
+	for i, instr := range ctx.Instructions {
+		if instr.GetType() == byzcoin.InvalidInstrType {
+			return ctx, fmt.Errorf("couldn't decode the transaction: instruction %d is not a spawn, invoke or delete", i)
+		}
+	}
+	return ctx, nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
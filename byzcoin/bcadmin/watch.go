@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// defaultWatchInterval is the poll period of watchLatest until the block
+// interval of the chain is known.
+const defaultWatchInterval = 5 * time.Second
+
+// watchLatest polls the proof of the config instance once per block interval
+// and prints the latest block on a single line, overwriting the previous one,
+// until stop is closed. When the roster of the chain changes, the client
+// follows it.
+func watchLatest(w io.Writer, cl *byzcoin.Client, stop <-chan struct{}) error {
+	interval := defaultWatchInterval
+	for {
+		p, err := getProof(cl, byzcoin.ConfigInstanceID.Slice())
+		if err != nil {
+			log.Warn("Couldn't get the latest block:", err)
+			// Probe the roster again in case the node went down.
+			chosenServers.forget(cl)
+		} else {
+			sb := p.Proof.Latest
+			_, err = fmt.Fprintf(w, "\r\033[K%s Index: %d, BlockMaxHeight: %d, Roster: %s",
+				time.Now().Format("15:04:05"), sb.Index, sb.Height, fmtRoster(sb.Roster))
+			if err != nil {
+				return err
+			}
+
+			var cc byzcoin.ChainConfig
+			var value []byte
+			_, value, _, _, err = p.Proof.KeyValue()
+			if err == nil {
+				err = protobuf.DecodeWithConstructors(value, &cc, network.DefaultConstructors(cothority.Suite))
+			}
+			if err == nil && cc.BlockInterval > 0 {
+				interval = cc.BlockInterval
+			}
+
+			if !sb.Roster.ID.Equal(cl.Roster.ID) {
+				log.Lvl2("Roster changed to", fmtRoster(sb.Roster))
+				cl.Roster = *sb.Roster
+				cl.ServerNumber = 0
+				chosenServers.forget(cl)
+			}
+		}
+
+		select {
+		case <-stop:
+			_, err = fmt.Fprintln(w)
+			return err
+		case <-time.After(interval):
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 
 	"go.dedis.ch/cothority/v3/darc"
 )
@@ -40,6 +41,16 @@ func (s *Service) contractSecureDarcFromBytes(in []byte) (Contract, error) {
 	return c, nil
 }
 
+// Describe returns the description of the darc and its rules, one rule per
+// line with its action and expression.
+func (c *contractSecureDarc) Describe() string {
+	lines := []string{fmt.Sprintf("Desc: %s, Rules:", c.Description)}
+	for _, r := range c.Rules.List {
+		lines = append(lines, fmt.Sprintf("Action: %s - Expression: %s", r.Action, r.Expr))
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (c *contractSecureDarc) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) (sc []StateChange, cout []Coin, err error) {
 	cout = coins
 
@@ -32,6 +32,25 @@ type Contract interface {
 	Delete(ReadOnlyStateTrie, Instruction, []Coin) ([]StateChange, []Coin, error)
 }
 
+// ContractWithDescription can be implemented by contracts that can describe
+// the current value of their instances to humans, e.g. in debug dumps.
+type ContractWithDescription interface {
+	// Describe returns a short description of the value of the instance,
+	// which may span several lines.
+	Describe() string
+}
+
+// ContractWithVersion can be implemented by contracts that change the format
+// of the values of their instances over time. The version is stored with
+// every state change of the contract, so that the clients know how to decode
+// a value. Unlike RegisterContractVersion, it is about the data, not the
+// code. The contracts that don't implement it store version 0.
+type ContractWithVersion interface {
+	// ContractVersion returns the version of the format of the values the
+	// contract writes.
+	ContractVersion() uint32
+}
+
 // ContractFn is the type signature of the instance factory functions which can be
 // registered with the ByzCoin service.
 type ContractFn func(in []byte) (Contract, error)
@@ -47,6 +66,19 @@ func RegisterContract(s skipchain.GetService, contractID string, f ContractFn) e
 	return scs.(*Service).registerContract(contractID, f)
 }
 
+// RegisterContractVersion declares the version of the code of a contract the
+// node runs. The node refuses the blocks of the chains whose config pins
+// another version of the contract, so that nodes running different code
+// don't fork the chain. The version should change whenever the contract
+// executes an instruction differently than before.
+func RegisterContractVersion(s skipchain.GetService, contractID, version string) error {
+	scs := s.Service(ServiceName)
+	if scs == nil {
+		return errors.New("Didn't find our service: " + ServiceName)
+	}
+	return scs.(*Service).registerContractVersion(contractID, version)
+}
+
 // BasicContract is a type that contracts may choose to embed in order to provide
 // default implementations for the Contract interface.
 type BasicContract struct{}
@@ -192,6 +224,12 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 	return
 }
 
+// Describe returns the main values of the configuration.
+func (c *contractConfig) Describe() string {
+	return fmt.Sprintf("Interval: %v, MaxBlockSize: %d, Nodes: %d",
+		c.BlockInterval, c.MaxBlockSize, len(c.Roster.List))
+}
+
 func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) (sc []StateChange, cout []Coin, err error) {
 	cout = coins
 
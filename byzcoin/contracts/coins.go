@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/darc"
@@ -18,6 +19,19 @@ const ContractCoinID = "coin"
 // to this contract.
 var CoinName = iid("byzCoin")
 
+func init() {
+	byzcoin.RegisterArgConvention("spawn:"+ContractCoinID,
+		byzcoin.ArgConvention{Name: "type", Length: len(byzcoin.InstanceID{})},
+		byzcoin.ArgConvention{Name: "coinID"},
+		byzcoin.ArgConvention{Name: "darcID", Length: len(byzcoin.InstanceID{})})
+	coinsArg := byzcoin.ArgConvention{Name: "coins", Required: true, Length: 8}
+	byzcoin.RegisterArgConvention("invoke:"+ContractCoinID+".mint", coinsArg)
+	byzcoin.RegisterArgConvention("invoke:"+ContractCoinID+".transfer", coinsArg,
+		byzcoin.ArgConvention{Name: "destination", Required: true, Length: len(byzcoin.InstanceID{})})
+	byzcoin.RegisterArgConvention("invoke:"+ContractCoinID+".fetch", coinsArg)
+	byzcoin.RegisterArgConvention("invoke:" + ContractCoinID + ".store")
+}
+
 // ContractCoin is a coin implementation that holds one instance per coin.
 // If you spawn a new ContractCoin, it will create an account with a value
 // of 0 coins.
@@ -47,6 +61,11 @@ type contractCoin struct {
 	byzcoin.Coin
 }
 
+// Describe returns the balance of the account.
+func (c *contractCoin) Describe() string {
+	return fmt.Sprintf("Balance: %d of %x", c.Value, c.Name.Slice())
+}
+
 func (c *contractCoin) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
 	cout = coins
 
@@ -114,6 +114,26 @@ func TestCoin_InvokeMint(t *testing.T) {
 		sc[0])
 }
 
+func TestCoin_ArgConvention(t *testing.T) {
+	// The short coins argument of TestCoin_InvokeMint is refused before
+	// being sent.
+	_, err := byzcoin.NewArguments("invoke:coin.mint", map[string][]byte{"coins": coinOne[0:3]})
+	require.Error(t, err)
+
+	args, err := byzcoin.NewArguments("invoke:coin.transfer",
+		map[string][]byte{"coins": coinOne, "destination": make([]byte, 32)})
+	require.NoError(t, err)
+	require.Equal(t, coinOne, args.Search("coins"))
+	_, err = byzcoin.NewArguments("invoke:coin.transfer", map[string][]byte{"coins": coinOne})
+	require.Error(t, err)
+
+	args, err = byzcoin.NewArguments("spawn:coin", map[string][]byte{"coinID": []byte("account")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("account"), args.Search("coinID"))
+	_, err = byzcoin.NewArguments("spawn:coin", map[string][]byte{"coinId": []byte("account")})
+	require.Error(t, err)
+}
+
 func TestCoin_InvokeOverflow(t *testing.T) {
 	ci := byzcoin.Coin{
 		Value: ^uint64(0),
@@ -299,6 +319,9 @@ func (ct *cvTest) Store(key byzcoin.InstanceID, value []byte, contractID string,
 func (ct cvTest) GetValues(key []byte) (value []byte, version uint64, contractID string, darcID darc.ID, err error) {
 	return ct.values[string(key)], 0, ct.contractIDs[string(key)], ct.darcIDs[string(key)], nil
 }
+func (ct cvTest) GetContractVersion(key []byte) (uint32, error) {
+	return 0, nil
+}
 func (ct cvTest) GetValue(key []byte) ([]byte, error) {
 	return ct.values[string(key)], nil
 }
@@ -2,6 +2,7 @@ package contracts
 
 import (
 	"errors"
+	"fmt"
 
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/darc"
@@ -69,6 +70,11 @@ func (c *contractValue) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instr
 	}
 }
 
+// Describe returns the stored value.
+func (c *contractValue) Describe() string {
+	return fmt.Sprintf("Value: %x", c.value)
+}
+
 func (c *contractValue) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
 	cout = coins
 
@@ -79,6 +85,12 @@ func (c *contractValue) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instr
 		return
 	}
 
+	if inst.Delete.Soft {
+		sc = byzcoin.StateChanges{
+			byzcoin.NewTombstone(inst.InstanceID, ContractValueID, darcID),
+		}
+		return
+	}
 	sc = byzcoin.StateChanges{
 		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID, ContractValueID, nil, darcID),
 	}
@@ -0,0 +1,105 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+// ArgConvention describes an argument that a contract expects in a spawn or
+// an invoke.
+type ArgConvention struct {
+	Name string
+	// Required is true if the contract refuses the instruction without the
+	// argument.
+	Required bool
+	// Length is the length the value must have, or 0 if it can have any
+	// length.
+	Length int
+}
+
+// argConventions holds the arguments of the operations of the contracts,
+// indexed by the action of the operation, e.g. "spawn:coin" or
+// "invoke:coin.mint".
+var argConventions = struct {
+	sync.Mutex
+	ops map[darc.Action][]ArgConvention
+}{ops: make(map[darc.Action][]ArgConvention)}
+
+// RegisterArgConvention declares the arguments of an operation of a contract,
+// so that clients can assemble them with NewArguments. The action is the one
+// checked by the darcs, e.g. "spawn:coin" or "invoke:coin.mint". It is
+// usually called in the init function of the package of the contract, and
+// replaces the arguments registered before for this action.
+func RegisterArgConvention(action darc.Action, args ...ArgConvention) {
+	argConventions.Lock()
+	defer argConventions.Unlock()
+	argConventions.ops[action] = append([]ArgConvention{}, args...)
+}
+
+// GetArgConvention returns the arguments registered for the action.
+func GetArgConvention(action darc.Action) ([]ArgConvention, bool) {
+	argConventions.Lock()
+	defer argConventions.Unlock()
+	args, ok := argConventions.ops[action]
+	if !ok {
+		return nil, false
+	}
+	return append([]ArgConvention{}, args...), true
+}
+
+// NewArguments assembles the arguments of the action from the values, given
+// by name, in the order of the convention of the action. It returns an error
+// if the action has no convention, if a required argument is missing, if a
+// value has the wrong length, or if a value isn't an argument of the action,
+// for example because its name is misspelled.
+func NewArguments(action darc.Action, values map[string][]byte) (Arguments, error) {
+	convention, ok := GetArgConvention(action)
+	if !ok {
+		return nil, fmt.Errorf("no argument convention for '%s'", action)
+	}
+
+	var args Arguments
+	known := make(map[string]bool)
+	for _, ac := range convention {
+		known[ac.Name] = true
+		value, ok := values[ac.Name]
+		if !ok {
+			if ac.Required {
+				return nil, fmt.Errorf("argument '%s' of '%s' is missing", ac.Name, action)
+			}
+			continue
+		}
+		if ac.Length > 0 && len(value) != ac.Length {
+			return nil, fmt.Errorf("argument '%s' of '%s' must be %d bytes long, got %d",
+				ac.Name, action, ac.Length, len(value))
+		}
+		args = append(args, Argument{Name: ac.Name, Value: value})
+	}
+
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.New("unknown arguments for '" + string(action) + "': " +
+			strings.Join(unknown, ", "))
+	}
+	return args, nil
+}
+
+func init() {
+	darcArg := ArgConvention{Name: "darc", Required: true}
+	RegisterArgConvention("spawn:"+ContractDarcID, darcArg)
+	RegisterArgConvention("invoke:"+ContractDarcID+"."+cmdDarcEvolve, darcArg)
+	RegisterArgConvention("invoke:"+ContractDarcID+"."+cmdDarcEvolveUnrestriction, darcArg)
+	RegisterArgConvention("invoke:"+ContractConfigID+".update_config",
+		ArgConvention{Name: "config", Required: true})
+}
@@ -0,0 +1,44 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewArguments(t *testing.T) {
+	RegisterArgConvention("invoke:test_conventions.cmd",
+		ArgConvention{Name: "first", Required: true},
+		ArgConvention{Name: "second", Length: 2})
+
+	args, err := NewArguments("invoke:test_conventions.cmd",
+		map[string][]byte{"second": {1, 2}, "first": {3}})
+	require.NoError(t, err)
+	require.Equal(t, Arguments{{Name: "first", Value: []byte{3}}, {Name: "second", Value: []byte{1, 2}}}, args)
+
+	args, err = NewArguments("invoke:test_conventions.cmd", map[string][]byte{"first": nil})
+	require.NoError(t, err)
+	require.Equal(t, Arguments{{Name: "first"}}, args)
+
+	_, err = NewArguments("invoke:test_conventions.cmd", map[string][]byte{"second": {1, 2}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'first' of 'invoke:test_conventions.cmd' is missing")
+
+	_, err = NewArguments("invoke:test_conventions.cmd", map[string][]byte{"first": {}, "second": {1}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "must be 2 bytes long")
+
+	_, err = NewArguments("invoke:test_conventions.cmd", map[string][]byte{"first": {}, "scond": {1, 2}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown arguments for 'invoke:test_conventions.cmd': scond")
+
+	_, err = NewArguments("invoke:test_conventions.other", map[string][]byte{})
+	require.Error(t, err)
+
+	// The conventions of the built-in contracts are registered.
+	args, err = NewArguments("spawn:darc", map[string][]byte{"darc": {1}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, args.Search("darc"))
+	_, err = NewArguments("invoke:config.update_config", map[string][]byte{})
+	require.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package byzcoin
+
+import (
+	"fmt"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+)
+
+// darcVersions indexes every version of the darcs of a skipchain by its ID,
+// so that an intermediate version can be found without going through all the
+// darcs of the trie. The index of a skipchain is built the first time it is
+// needed and then kept up to date with the blocks that are applied.
+type darcVersions struct {
+	sync.Mutex
+	chains map[string]*darcVersionIndex
+}
+
+// darcVersionIndex holds the versions of the darcs of a skipchain up to the
+// block index.
+type darcVersionIndex struct {
+	index    int
+	versions map[string]darcVersion
+}
+
+// darcVersion locates a version of a darc in the history of its instance.
+type darcVersion struct {
+	baseID  darc.ID
+	version uint64
+}
+
+func newDarcVersions() *darcVersions {
+	return &darcVersions{chains: make(map[string]*darcVersionIndex)}
+}
+
+// lookup returns the version of the darc with the given ID. The last value is
+// false if there is no index for the skipchain at the given block index, in
+// which case it needs to be built.
+func (dv *darcVersions) lookup(scID skipchain.SkipBlockID, index int, id darc.ID) (darcVersion, bool, bool) {
+	dv.Lock()
+	defer dv.Unlock()
+	idx := dv.chains[string(scID)]
+	if idx == nil || idx.index != index {
+		return darcVersion{}, false, false
+	}
+	v, ok := idx.versions[string(id)]
+	return v, ok, true
+}
+
+// set stores the index of a skipchain, unless a more recent one is already
+// there.
+func (dv *darcVersions) set(scID skipchain.SkipBlockID, idx *darcVersionIndex) {
+	dv.Lock()
+	defer dv.Unlock()
+	if old := dv.chains[string(scID)]; old != nil && old.index >= idx.index {
+		return
+	}
+	dv.chains[string(scID)] = idx
+}
+
+// update adds the darcs of a block that has just been applied to the index of
+// its skipchain, if there is one. An index that missed a block is dropped, and
+// built again when it is needed.
+func (dv *darcVersions) update(scID skipchain.SkipBlockID, index int, scs StateChanges, st ReadOnlyStateTrie) {
+	dv.Lock()
+	defer dv.Unlock()
+	idx := dv.chains[string(scID)]
+	if idx == nil {
+		return
+	}
+	config, err := LoadConfigFromTrie(st)
+	if err != nil || idx.index != index-1 {
+		delete(dv.chains, string(scID))
+		return
+	}
+	isDarc := darcContracts(config)
+	for _, sc := range scs {
+		if sc.StateAction == Remove || !isDarc[sc.ContractID] {
+			continue
+		}
+		if d, err := darc.NewFromProtobuf(sc.Value); err == nil {
+			idx.versions[string(d.GetID())] = darcVersion{baseID: d.GetBaseID(), version: sc.Version}
+		}
+	}
+	idx.index = index
+}
+
+// darcContracts returns the set of contracts that hold a darc.
+func darcContracts(config *ChainConfig) map[string]bool {
+	isDarc := make(map[string]bool)
+	for _, c := range config.DarcContractIDs {
+		isDarc[c] = true
+	}
+	return isDarc
+}
+
+// findDarcVersion looks up a version of a darc, building the index of the
+// skipchain if it is missing or outdated.
+func (s *Service) findDarcVersion(scID skipchain.SkipBlockID, st ReadOnlyStateTrie, id darc.ID) (darcVersion, error) {
+	v, ok, indexed := s.darcVersions.lookup(scID, st.GetIndex(), id)
+	if !indexed {
+		idx, err := s.indexDarcVersions(scID, st)
+		if err != nil {
+			return darcVersion{}, err
+		}
+		v, ok = idx.versions[string(id)]
+		s.darcVersions.set(scID, idx)
+	}
+	if !ok {
+		return darcVersion{}, fmt.Errorf("darc %x not found", id)
+	}
+	return v, nil
+}
+
+// indexDarcVersions goes through all the darcs of the trie and their history
+// to index all their versions.
+func (s *Service) indexDarcVersions(scID skipchain.SkipBlockID, st ReadOnlyStateTrie) (*darcVersionIndex, error) {
+	idx := &darcVersionIndex{
+		index:    st.GetIndex(),
+		versions: make(map[string]darcVersion),
+	}
+	config, err := LoadConfigFromTrie(st)
+	if err != nil {
+		return nil, err
+	}
+	isDarc := darcContracts(config)
+	err = st.ForEach(func(k, v []byte) error {
+		if len(k) != prefixLength {
+			return nil
+		}
+		body, err := decodeStateChangeBody(v)
+		if err != nil || !isDarc[body.ContractID] {
+			// Not all key/value pairs are valid statechanges
+			return nil
+		}
+		// The values are only valid during ForEach, so the base ID must
+		// be copied.
+		if d, err := darc.NewFromProtobuf(body.Value); err == nil {
+			idx.versions[string(d.GetID())] = darcVersion{
+				baseID:  append(darc.ID{}, d.GetBaseID()...),
+				version: body.Version,
+			}
+		}
+		if body.Version == 0 {
+			return nil
+		}
+		entries, err := s.stateChangeStorage.getAll(k, scID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if d, err := darc.NewFromProtobuf(e.StateChange.Value); err == nil {
+				idx.versions[string(d.GetID())] = darcVersion{
+					baseID:  append(darc.ID{}, d.GetBaseID()...),
+					version: e.StateChange.Version,
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,86 @@
+package byzcoin
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// equivocation is a block that conflicts with the block already known at the
+// same index of the same chain.
+type equivocation struct {
+	index       int
+	known       skipchain.SkipBlockID
+	conflicting skipchain.SkipBlockID
+}
+
+// equivocations keeps the conflicting blocks seen by the node, which mean
+// that a leader signed two different blocks for the same index, or that the
+// chain forked. They are reported by the status endpoint, as they need the
+// attention of the administrators.
+type equivocations struct {
+	sync.Mutex
+	seen map[string][]equivocation
+}
+
+// add records the equivocation of the chain scID, if it is new, and returns
+// whether it is.
+func (e *equivocations) add(scID skipchain.SkipBlockID, eq equivocation) bool {
+	e.Lock()
+	defer e.Unlock()
+	if e.seen == nil {
+		e.seen = make(map[string][]equivocation)
+	}
+	key := string(scID)
+	for _, old := range e.seen[key] {
+		if old.conflicting.Equal(eq.conflicting) {
+			return false
+		}
+	}
+	e.seen[key] = append(e.seen[key], eq)
+	return true
+}
+
+// GetStatus returns the number of equivocations of every skipchain that has
+// some, and the index of the latest one.
+func (e *equivocations) GetStatus() *onet.Status {
+	e.Lock()
+	defer e.Unlock()
+	out := make(map[string]string)
+	for id, eqs := range e.seen {
+		key := fmt.Sprintf("%x", []byte(id))
+		out["Equivocations_"+key] = strconv.Itoa(len(eqs))
+		out["EquivocationIndex_"+key] = strconv.Itoa(eqs[len(eqs)-1].index)
+	}
+	return &onet.Status{Field: out}
+}
+
+// checkEquivocation returns an error if another block than sb is already
+// known at the index of sb, i.e. if the previous block already has a forward
+// link to another block. The conflict is recorded and logged as critical, as
+// it means that the leader equivocated or that the chain forked.
+func (s *Service) checkEquivocation(sb *skipchain.SkipBlock) error {
+	if sb.Index == 0 || len(sb.BackLinkIDs) == 0 {
+		return nil
+	}
+	prev := s.db().GetByID(sb.BackLinkIDs[0])
+	if prev == nil || len(prev.ForwardLink) == 0 || prev.ForwardLink[0] == nil {
+		return nil
+	}
+	known := prev.ForwardLink[0].To
+	if known.Equal(sb.Hash) {
+		return nil
+	}
+
+	eq := equivocation{index: sb.Index, known: known, conflicting: sb.Hash}
+	if s.equivocations.add(sb.SkipChainID(), eq) {
+		log.Errorf("%s CRITICAL: got block %x at index %d of %x, but block %x is already there: "+
+			"the leader equivocated or the chain forked", s.ServerIdentity(), sb.Hash, sb.Index,
+			sb.SkipChainID(), known)
+	}
+	return fmt.Errorf("block %x conflicts with block %x at index %d", sb.Hash, known, sb.Index)
+}
@@ -0,0 +1,55 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+// A second block at an index that already has a block is refused and
+// reported.
+func TestService_Equivocation(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	// Wait for the forward link of the genesis block to the first block.
+	genesis := s.service().db().GetByID(s.genesis.Hash)
+	for i := 0; len(genesis.ForwardLink) == 0; i++ {
+		require.True(t, i < 100, "no forward link from the genesis block")
+		time.Sleep(testInterval / 10)
+		genesis = s.service().db().GetByID(s.genesis.Hash)
+	}
+	block := s.service().db().GetByID(genesis.ForwardLink[0].To)
+	require.NotNil(t, block)
+	require.NoError(t, s.service().checkEquivocation(block))
+
+	// Same index, but another timestamp.
+	conflicting := block.Copy()
+	conflicting.ForwardLink = nil
+	var header DataHeader
+	require.NoError(t, protobuf.Decode(conflicting.Data, &header))
+	header.Timestamp++
+	conflicting.Data, err = protobuf.Encode(&header)
+	require.NoError(t, err)
+	conflicting.Hash = conflicting.CalculateHash()
+
+	for i := 0; i < 2; i++ {
+		require.False(t, s.service().verifySkipBlock(conflicting.Hash, conflicting))
+		err = s.service().checkEquivocation(conflicting)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "conflicts with block")
+	}
+
+	// The equivocation is only reported once.
+	status := s.service().equivocations.GetStatus()
+	key := fmt.Sprintf("%x", []byte(s.genesis.SkipChainID()))
+	require.Equal(t, "1", status.Field["Equivocations_"+key])
+	require.Equal(t, "1", status.Field["EquivocationIndex_"+key])
+}
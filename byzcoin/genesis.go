@@ -0,0 +1,103 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// missingGenesis keeps the chains whose genesis block is missing from the
+// database of the node and couldn't be fetched from the other nodes, with the
+// reason. The node doesn't start these chains, so they are reported by the
+// status endpoint, as they need the attention of the administrators.
+type missingGenesis struct {
+	sync.Mutex
+	chains map[string]string
+}
+
+func (m *missingGenesis) set(scID skipchain.SkipBlockID, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.chains == nil {
+		m.chains = make(map[string]string)
+	}
+	m.chains[string(scID)] = err.Error()
+}
+
+func (m *missingGenesis) remove(scID skipchain.SkipBlockID) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.chains, string(scID))
+}
+
+// GetStatus returns the reason why the genesis block of every chain that
+// misses it couldn't be fetched.
+func (m *missingGenesis) GetStatus() *onet.Status {
+	m.Lock()
+	defer m.Unlock()
+	out := make(map[string]string)
+	for id, reason := range m.chains {
+		out[fmt.Sprintf("MissingGenesis_%x", []byte(id))] = reason
+	}
+	return &onet.Status{Field: out}
+}
+
+// isByzCoinBlock returns true if the block is verified by ByzCoin.
+func isByzCoinBlock(sb *skipchain.SkipBlock) bool {
+	for _, x := range sb.VerifierIDs {
+		if x.Equal(Verify) {
+			return true
+		}
+	}
+	return false
+}
+
+// repairGenesis fetches the genesis blocks missing from the database, for the
+// ByzCoin chains of which the node has other blocks, from the roster of the
+// latest block of the chain. The chains whose genesis block can't be fetched
+// are reported by the status endpoint.
+func (s *Service) repairGenesis() {
+	chains, err := s.db().GetLatestSkipchains()
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get the chains:", err)
+		return
+	}
+	for id, latest := range chains {
+		gen := skipchain.SkipBlockID(id)
+		if !isByzCoinBlock(latest) || s.db().GetByID(gen) != nil {
+			continue
+		}
+		if err := s.fetchGenesis(gen, latest); err != nil {
+			log.Errorf("%s couldn't repair the missing genesis-block of chain %x: %s",
+				s.ServerIdentity(), gen, err)
+			s.missingGenesis.set(gen, err)
+			continue
+		}
+		logCatchup.printf(1, "%s fetched the missing genesis-block of chain %x",
+			s.ServerIdentity(), gen)
+		s.missingGenesis.remove(gen)
+	}
+}
+
+func (s *Service) fetchGenesis(gen skipchain.SkipBlockID, latest *skipchain.SkipBlock) error {
+	if latest == nil || latest.Roster == nil {
+		return errors.New("no block of the chain to find the nodes to ask")
+	}
+	r, err := catchupRoster(latest.Roster)
+	if err != nil {
+		return err
+	}
+	sb, err := skipchain.NewClient().GetSingleBlock(r, gen)
+	if err != nil {
+		return err
+	}
+	if sb.Index != 0 || !sb.CalculateHash().Equal(gen) {
+		return errors.New("got another block than the genesis-block")
+	}
+	_, err = s.db().StoreBlocks([]*skipchain.SkipBlock{sb})
+	return err
+}
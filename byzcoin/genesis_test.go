@@ -0,0 +1,69 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3/log"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// A node that lost the genesis block of a chain fetches it from the other
+// nodes when it starts, or reports the chain if it can't.
+func TestService_MissingGenesis(t *testing.T) {
+	defer func(trusted map[string]bool) {
+		catchupTrusted = trusted
+	}(catchupTrusted)
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 1, 1, 1)
+
+	scID := s.genesis.SkipChainID()
+	service := s.services[len(s.services)-1]
+	// The node finds the chain through its other blocks, so it must hold
+	// the new block before its genesis block is removed.
+	for i := 0; ; i++ {
+		latest, err := service.db().GetLatestByID(scID)
+		require.NoError(t, err)
+		if latest.Index > 0 {
+			break
+		}
+		require.True(t, i < 10, "the node didn't get the new block")
+		time.Sleep(testInterval)
+	}
+	removeGenesis := func() {
+		service.TestClose()
+		require.NoError(t, service.db().Update(func(tx *bbolt.Tx) error {
+			return tx.ForEach(func(_ []byte, b *bbolt.Bucket) error {
+				if b.Get(scID) == nil {
+					return nil
+				}
+				return b.Delete(scID)
+			})
+		}))
+		require.Nil(t, service.db().GetByID(scID))
+	}
+	key := fmt.Sprintf("MissingGenesis_%x", []byte(scID))
+
+	log.Lvl1("None of the nodes is trusted to fetch the genesis block")
+	catchupTrusted = map[string]bool{"unknown": true}
+	removeGenesis()
+	require.NoError(t, service.startAllChains())
+	require.Nil(t, service.db().GetByID(scID))
+	require.Contains(t, service.missingGenesis.GetStatus().Field[key], "trusted")
+
+	log.Lvl1("Fetching the genesis block from the other nodes")
+	catchupTrusted = nil
+	service.TestClose()
+	require.NoError(t, service.startAllChains())
+	require.NotNil(t, service.db().GetByID(scID))
+	require.Empty(t, service.missingGenesis.GetStatus().Field)
+	_, err := service.db().GetLatestByID(scID)
+	require.NoError(t, err)
+
+	// The repaired chain works as before.
+	addDummyTxs(t, s, 1, 1, 2)
+}
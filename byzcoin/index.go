@@ -0,0 +1,110 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractIndexID is the contract ID of the index entries. No contract can be
+// registered under this ID: the entries are only written by the state
+// changes of the contracts they belong to, and ByzCoin refuses the state
+// changes touching the entries of another contract.
+const ContractIndexID = "index"
+
+// indexPrefix separates the instance IDs of the index entries from the other
+// instance IDs.
+var indexPrefix = []byte("byzcoin-index")
+
+// NewIndexInstanceID returns the ID of the instance holding the index entry of
+// key in the namespace of the contract contractID.
+func NewIndexInstanceID(contractID string, key []byte) InstanceID {
+	h := sha256.New()
+	h.Write(indexPrefix)
+	// The length of the contract ID is included so that different contract
+	// IDs and keys cannot give the same ID.
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(contractID)))
+	h.Write(l[:])
+	h.Write([]byte(contractID))
+	h.Write(key)
+	return NewInstanceID(h.Sum(nil))
+}
+
+// NewIndexStateChange returns the state change a contract can return to
+// create, update or remove its index entry of key. For Create and Update, the
+// entry points to id. Like the other instances, the entry is controlled by
+// darcID.
+func NewIndexStateChange(sa StateAction, contractID string, key []byte, id InstanceID, darcID darc.ID) (StateChange, error) {
+	var value []byte
+	if sa != Remove {
+		var err error
+		value, err = protobuf.Encode(&IndexEntry{
+			ContractID: contractID,
+			Key:        key,
+			InstanceID: id,
+		})
+		if err != nil {
+			return StateChange{}, err
+		}
+	}
+	return NewStateChange(sa, NewIndexInstanceID(contractID, key), ContractIndexID, value, darcID), nil
+}
+
+// instructionContractID returns the ID of the contract that executes instr:
+// the contract to spawn, or the contract of the invoked or deleted instance.
+func instructionContractID(rst ReadOnlyStateTrie, instr Instruction) (string, error) {
+	if instr.Spawn != nil {
+		return instr.Spawn.ContractID, nil
+	}
+	_, _, contractID, _, err := rst.GetValues(instr.InstanceID.Slice())
+	if err == errKeyNotSet {
+		return "", nil
+	}
+	return contractID, err
+}
+
+// indexViolation returns why the state change sc, returned by the contract
+// contractID, is refused because of an index entry, or an empty string if it
+// is accepted.
+func indexViolation(rst ReadOnlyStateTrie, sc StateChange, contractID string) (string, error) {
+	value, _, oldContractID, _, err := rst.GetValues(sc.InstanceID)
+	if err == errKeyNotSet {
+		value, oldContractID, err = nil, "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	wasEntry := oldContractID == ContractIndexID
+	isEntry := sc.ContractID == ContractIndexID
+	switch {
+	case !wasEntry && !isEntry:
+		return "", nil
+	case sc.StateAction == Remove:
+		if !wasEntry {
+			return "", nil
+		}
+	case wasEntry && !isEntry:
+		return "tried to overwrite an index entry", nil
+	case !wasEntry && oldContractID != "":
+		return "tried to turn an instance into an index entry", nil
+	default:
+		value = sc.Value
+	}
+
+	var entry IndexEntry
+	if err = protobuf.Decode(value, &entry); err != nil {
+		return "returned an invalid index entry", nil
+	}
+	if entry.ContractID != contractID {
+		return fmt.Sprintf("tried to change an index entry of contract %s", entry.ContractID), nil
+	}
+	if !bytes.Equal(NewIndexInstanceID(entry.ContractID, entry.Key).Slice(), sc.InstanceID) {
+		return "stored an index entry under the wrong instance ID", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,158 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+)
+
+const indexedContract = "indexed"
+
+// indexedContractFunc is an example of a contract that indexes its instances
+// by name. The instances hold their name, so that the index entry can be
+// removed together with the instance. The squat command tries to write an
+// index entry of another contract.
+func indexedContractFunc(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+	value, _, _, darcID, err := cdb.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch inst.GetType() {
+	case SpawnType:
+		name := inst.Spawn.Args.Search("name")
+		id := inst.DeriveID("")
+		sc, err := NewIndexStateChange(Create, indexedContract, name, id, darcID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{
+			NewStateChange(Create, id, indexedContract, name, darcID),
+			sc,
+		}, c, nil
+	case InvokeType:
+		sc, err := NewIndexStateChange(Create, "other", value, inst.InstanceID, darcID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{sc}, c, nil
+	case DeleteType:
+		sc, err := NewIndexStateChange(Remove, indexedContract, value, inst.InstanceID, darcID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{
+			NewStateChange(Remove, inst.InstanceID, indexedContract, nil, darcID),
+			sc,
+		}, c, nil
+	}
+	return nil, nil, nil
+}
+
+func TestIndex_GetByIndex(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	for _, h := range s.hosts {
+		require.NoError(t, RegisterContract(h, indexedContract, adaptor(indexedContractFunc)))
+		require.Error(t, RegisterContract(h, ContractIndexID, adaptor(indexedContractFunc)))
+	}
+	d2 := s.darc.Copy()
+	require.NoError(t, d2.EvolveFrom(s.darc))
+	id := expression.Expr(s.signer.Identity().String())
+	require.NoError(t, d2.Rules.AddRule("spawn:"+indexedContract, id))
+	require.NoError(t, d2.Rules.AddRule("invoke:"+indexedContract+".squat", id))
+	require.NoError(t, d2.Rules.AddRule("delete:"+indexedContract, id))
+	s.testDarcEvolution(t, *d2, false)
+
+	counter := uint64(2)
+	// sent is the latest instruction, as signed by send.
+	var sent Instruction
+	send := func(instr Instruction) error {
+		instr.SignerCounter = []uint64{counter}
+		ctx, err := combineInstrsAndSign(s.signer, instr)
+		require.NoError(t, err)
+		sent = ctx.Instructions[0]
+		_, err = s.service().AddTransaction(&AddTxRequest{
+			Version:       CurrentVersion,
+			SkipchainID:   s.genesis.SkipChainID(),
+			Transaction:   ctx,
+			InclusionWait: 10,
+		})
+		if err == nil {
+			counter++
+		}
+		return err
+	}
+	spawn := createSpawnInstr(s.darc.GetBaseID(), indexedContract, "name", []byte("alice"))
+	require.NoError(t, send(spawn))
+
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	inst, err := cl.GetByIndex(indexedContract, []byte("alice"))
+	require.NoError(t, err)
+	require.Equal(t, indexedContract, inst.ContractID)
+	require.Equal(t, []byte("alice"), inst.Value)
+	require.True(t, inst.ID.Equal(sent.DeriveID("")))
+	_, err = cl.GetByIndex(indexedContract, []byte("bob"))
+	require.Error(t, err)
+	_, err = cl.GetByIndex("other", []byte("alice"))
+	require.Error(t, err)
+
+	// The keys are unique.
+	err = send(createSpawnInstr(s.darc.GetBaseID(), indexedContract, "name", []byte("alice")))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tried to create existing instanceID")
+
+	// A contract cannot write into the index of another contract.
+	err = send(createInvokeInstr(inst.ID, indexedContract, "squat", "", nil))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "index entry of contract other")
+
+	// The entry is removed together with the instance.
+	require.NoError(t, send(Instruction{
+		InstanceID: inst.ID,
+		Delete:     &Delete{ContractID: indexedContract},
+	}))
+	_, err = cl.GetByIndex(indexedContract, []byte("alice"))
+	require.Error(t, err)
+}
+
+func TestIndex_Violation(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte(""))
+	require.NoError(t, err)
+	id := NewInstanceID([]byte("instance"))
+	sc, err := NewIndexStateChange(Create, indexedContract, []byte("alice"), id, darc.ID{})
+	require.NoError(t, err)
+
+	reason, err := indexViolation(sst, sc, indexedContract)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+	reason, err = indexViolation(sst, sc, "other")
+	require.NoError(t, err)
+	require.Contains(t, reason, "index entry of contract "+indexedContract)
+
+	// The entry must be stored under the ID derived from its key.
+	moved := sc
+	moved.InstanceID = id.Slice()
+	reason, err = indexViolation(sst, moved, indexedContract)
+	require.NoError(t, err)
+	require.Contains(t, reason, "wrong instance ID")
+
+	// Once stored, the entry can only be changed as an index entry, and
+	// only by its contract.
+	require.NoError(t, sst.StoreAll(StateChanges{sc}))
+	overwrite := NewStateChange(Update, NewInstanceID(sc.InstanceID), indexedContract, []byte{}, darc.ID{})
+	reason, err = indexViolation(sst, overwrite, indexedContract)
+	require.NoError(t, err)
+	require.Contains(t, reason, "overwrite an index entry")
+	remove, err := NewIndexStateChange(Remove, indexedContract, []byte("alice"), id, darc.ID{})
+	require.NoError(t, err)
+	reason, err = indexViolation(sst, remove, "other")
+	require.NoError(t, err)
+	require.NotEmpty(t, reason)
+	reason, err = indexViolation(sst, remove, indexedContract)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+}
@@ -0,0 +1,121 @@
+package byzcoin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// logSubsystem tags the log calls of a part of the service, so that its debug
+// level can be raised without raising the global level of onet. The call
+// sites log through it instead of onet's LvlN functions:
+//
+//	logViewChange.printf(2, "...")
+type logSubsystem string
+
+const (
+	logBlock      = logSubsystem("block")
+	logCatchup    = logSubsystem("catchup")
+	logViewChange = logSubsystem("viewchange")
+	logStreaming  = logSubsystem("streaming")
+)
+
+var logSubsystems = []logSubsystem{logBlock, logCatchup, logViewChange, logStreaming}
+
+// maxLogLevel is the highest debug level used by the service.
+const maxLogLevel = 5
+
+var logLevels = struct {
+	sync.Mutex
+	levels map[logSubsystem]int
+}{levels: make(map[logSubsystem]int)}
+
+// lvl returns the level to give to onet's log for a message of the given
+// level. If the global level hides the message but the level of the
+// subsystem shows it, it returns 0, which onet always shows.
+func (sub logSubsystem) lvl(l int) int {
+	if l <= log.DebugVisible() {
+		return l
+	}
+	logLevels.Lock()
+	defer logLevels.Unlock()
+	if l <= logLevels.levels[sub] {
+		return 0
+	}
+	return l
+}
+
+var (
+	lvlFuncs   = []func(...interface{}){log.Lvl1, log.Lvl2, log.Lvl3, log.Lvl4, log.Lvl5}
+	llvlFuncs  = []func(...interface{}){log.LLvl1, log.LLvl2, log.LLvl3, log.LLvl4, log.LLvl5}
+	lvlfFuncs  = []func(string, ...interface{}){log.Lvlf1, log.Lvlf2, log.Lvlf3, log.Lvlf4, log.Lvlf5}
+	llvlfFuncs = []func(string, ...interface{}){log.LLvlf1, log.LLvlf2, log.LLvlf3, log.LLvlf4, log.LLvlf5}
+)
+
+// clampLevel keeps a level in the range of onet's LvlN functions.
+func clampLevel(l int) int {
+	if l < 1 {
+		return 1
+	}
+	if l > maxLogLevel {
+		return maxLogLevel
+	}
+	return l
+}
+
+// print is like onet's LvlN, with the level of the subsystem.
+func (sub logSubsystem) print(l int, args ...interface{}) {
+	if sub.lvl(l) == 0 {
+		llvlFuncs[clampLevel(l)-1](args...)
+	} else {
+		lvlFuncs[clampLevel(l)-1](args...)
+	}
+}
+
+// printf is like onet's LvlfN, with the level of the subsystem.
+func (sub logSubsystem) printf(l int, f string, args ...interface{}) {
+	if sub.lvl(l) == 0 {
+		llvlfFuncs[clampLevel(l)-1](f, args...)
+	} else {
+		lvlfFuncs[clampLevel(l)-1](f, args...)
+	}
+}
+
+// setLogLevel sets the debug level of a subsystem, 0 resets it to the global
+// level.
+func setLogLevel(name string, level int) error {
+	sub := logSubsystem(name)
+	known := false
+	for _, s := range logSubsystems {
+		known = known || s == sub
+	}
+	if !known {
+		return fmt.Errorf("unknown subsystem '%s', must be one of %v", name, logSubsystems)
+	}
+	if level < 0 || level > maxLogLevel {
+		return fmt.Errorf("level must be between 0 and %d", maxLogLevel)
+	}
+
+	logLevels.Lock()
+	defer logLevels.Unlock()
+	if level == 0 {
+		delete(logLevels.levels, sub)
+	} else {
+		logLevels.levels[sub] = level
+	}
+	return nil
+}
+
+// getLogLevels returns the levels of all the subsystems, sorted by name.
+func getLogLevels() []DebugLogLevel {
+	logLevels.Lock()
+	defer logLevels.Unlock()
+	out := make([]DebugLogLevel, len(logSubsystems))
+	for i, sub := range logSubsystems {
+		out[i] = DebugLogLevel{Subsystem: string(sub), Level: logLevels.levels[sub]}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subsystem < out[j].Subsystem })
+	return out
+}
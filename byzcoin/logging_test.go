@@ -0,0 +1,46 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestLogLevels(t *testing.T) {
+	defer func() {
+		for _, sub := range logSubsystems {
+			require.NoError(t, setLogLevel(string(sub), 0))
+		}
+	}()
+
+	require.Error(t, setLogLevel("unknown", 1))
+	require.Error(t, setLogLevel("viewchange", -1))
+	require.Error(t, setLogLevel("viewchange", maxLogLevel+1))
+
+	hidden := log.DebugVisible() + 1
+	if hidden > maxLogLevel {
+		t.Skip("all the levels are shown")
+	}
+	require.Equal(t, hidden, logViewChange.lvl(hidden))
+
+	require.NoError(t, setLogLevel("viewchange", hidden))
+	require.Equal(t, 0, logViewChange.lvl(hidden))
+	require.Equal(t, hidden, logBlock.lvl(hidden))
+	if hidden < maxLogLevel {
+		require.Equal(t, hidden+1, logViewChange.lvl(hidden+1))
+	}
+
+	levels := getLogLevels()
+	require.Len(t, levels, len(logSubsystems))
+	for _, l := range levels {
+		if l.Subsystem == "viewchange" {
+			require.Equal(t, hidden, l.Level)
+		} else {
+			require.Equal(t, 0, l.Level)
+		}
+	}
+
+	require.NoError(t, setLogLevel("viewchange", 0))
+	require.Equal(t, hidden, logViewChange.lvl(hidden))
+}
@@ -5,6 +5,8 @@ This holds the messages used to communicate with the service over the network.
 */
 
 import (
+	"fmt"
+
 	"go.dedis.ch/onet/v3/network"
 )
 
@@ -17,10 +19,29 @@ func init() {
 	)
 }
 
-// Version indicates what version this client runs. In the first development
-// phase, each next version will break the preceeding versions. Later on,
-// new versions might correctly interpret earlier versions.
+// Version indicates what version this client runs. The nodes accept the
+// requests of all the versions from MinVersion to CurrentVersion, so that
+// the clients keep working while the nodes are upgraded one after the other.
+// A new version can only add optional fields to the messages of the previous
+// ones; MinVersion is raised when the wire format of a version isn't
+// compatible anymore.
 type Version int
 
 // CurrentVersion is what we're running now
 const CurrentVersion Version = 1
+
+// MinVersion is the oldest version of the requests the nodes still accept.
+const MinVersion Version = 1
+
+// checkVersion returns an error if v is not between MinVersion and
+// CurrentVersion.
+func checkVersion(v Version) error {
+	return checkVersionRange(v, MinVersion, CurrentVersion)
+}
+
+func checkVersionRange(v, min, max Version) error {
+	if v < min || v > max {
+		return fmt.Errorf("version mismatch - got %d but need between %d and %d", v, min, max)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package byzcoin
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+)
+
+// ContractWithCost can be implemented by contracts that want to define the
+// cost of their instructions. Contracts that don't implement it are charged
+// the default cost, which is proportional to the size of the state changes
+// they return.
+//
+// The cost is accumulated per block and reported by the status endpoint. The
+// leader stops adding transactions to a block once their cost reaches
+// maxBlockCost, but no instruction is refused because of it.
+type ContractWithCost interface {
+	// Cost returns the cost of the instruction, given the state changes
+	// returned by the contract.
+	Cost(ReadOnlyStateTrie, Instruction, []StateChange) uint64
+}
+
+// instructionCost returns the cost of the instruction executed by the
+// contract c.
+func instructionCost(c Contract, st ReadOnlyStateTrie, instr Instruction, scs []StateChange) uint64 {
+	if cwc, ok := c.(ContractWithCost); ok {
+		return cwc.Cost(st, instr, scs)
+	}
+	return defaultCost(scs)
+}
+
+// defaultCost returns the number of bytes of the state changes.
+func defaultCost(scs []StateChange) uint64 {
+	var cost uint64
+	for _, sc := range scs {
+		cost += uint64(len(sc.InstanceID) + len(sc.ContractID) + len(sc.Value) + len(sc.DarcID))
+	}
+	return cost
+}
+
+// overCostBudget returns true if a transaction of the given cost doesn't fit
+// in a block whose transactions already cost blockCost, because of
+// maxBlockCost. A block without any cost takes any transaction.
+func overCostBudget(blockCost, cost uint64) bool {
+	if maxBlockCost == 0 || blockCost == 0 {
+		return false
+	}
+	// Written this way to avoid the overflow of blockCost + cost.
+	return cost > maxBlockCost || blockCost > maxBlockCost-cost
+}
+
+// blockCosts keeps the total cost of the latest block of every skipchain
+// and reports it to the status endpoint.
+type blockCosts struct {
+	sync.Mutex
+	latest map[string]blockCost
+}
+
+type blockCost struct {
+	index int
+	cost  uint64
+}
+
+func newBlockCosts() *blockCosts {
+	return &blockCosts{latest: make(map[string]blockCost)}
+}
+
+func (bc *blockCosts) update(scID skipchain.SkipBlockID, index int, cost uint64) {
+	bc.Lock()
+	defer bc.Unlock()
+	bc.latest[string(scID)] = blockCost{index: index, cost: cost}
+}
+
+// GetStatus returns the cost of the latest block of every skipchain.
+func (bc *blockCosts) GetStatus() *onet.Status {
+	bc.Lock()
+	defer bc.Unlock()
+	out := make(map[string]string)
+	for id, c := range bc.latest {
+		key := fmt.Sprintf("%x", []byte(id))
+		out["BlockCost_"+key] = strconv.FormatUint(c.cost, 10)
+		out["BlockIndex_"+key] = strconv.Itoa(c.index)
+	}
+	return &onet.Status{Field: out}
+}
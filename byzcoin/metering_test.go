@@ -0,0 +1,120 @@
+package byzcoin
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+type costlyContract struct {
+	BasicContract
+}
+
+func (c costlyContract) Cost(rst ReadOnlyStateTrie, inst Instruction, scs []StateChange) uint64 {
+	return 1000 * uint64(len(scs))
+}
+
+func TestMetering_InstructionCost(t *testing.T) {
+	scs := []StateChange{
+		NewStateChange(Create, NewInstanceID([]byte("a")), "dummy", []byte("value"), darc.ID(make([]byte, 32))),
+		NewStateChange(Remove, NewInstanceID([]byte("b")), "", nil, nil),
+	}
+	require.Equal(t, uint64(32+5+5+32+32), defaultCost(scs))
+	require.Equal(t, uint64(0), defaultCost(nil))
+
+	var c Contract = BasicContract{}
+	require.Equal(t, defaultCost(scs), instructionCost(c, nil, Instruction{}, scs))
+	c = costlyContract{}
+	require.Equal(t, uint64(2000), instructionCost(c, nil, Instruction{}, scs))
+}
+
+func TestService_BlockCost(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	_, _, scs, _, cost := s.service().createStateChanges(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(tx), noTimeout)
+	require.True(t, cost > 0)
+	// The counter of the signer is not part of the cost.
+	require.Equal(t, defaultCost(scs[:1]), cost)
+
+	s.sendTxAndWait(t, tx, 10)
+	s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+
+	status := s.service().blockCosts.GetStatus()
+	key := fmt.Sprintf("%x", []byte(s.genesis.SkipChainID()))
+	require.Equal(t, strconv.FormatUint(cost, 10), status.Field["BlockCost_"+key])
+	require.Equal(t, "1", status.Field["BlockIndex_"+key])
+}
+
+func TestMetering_OverCostBudget(t *testing.T) {
+	mbc := maxBlockCost
+	defer func() {
+		maxBlockCost = mbc
+	}()
+
+	maxBlockCost = 0
+	require.False(t, overCostBudget(1e6, 1e6))
+	maxBlockCost = 100
+	require.False(t, overCostBudget(0, 1000))
+	require.False(t, overCostBudget(50, 50))
+	require.True(t, overCostBudget(50, 51))
+	require.True(t, overCostBudget(1, ^uint64(0)))
+	require.True(t, overCostBudget(101, 0))
+}
+
+// The leader splits the blocks that are over the compute budget, even if
+// they are small enough.
+func TestService_CostBudget(t *testing.T) {
+	mbc := maxBlockCost
+	defer func() {
+		maxBlockCost = mbc
+	}()
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	tx2, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	_, _, _, _, cost := s.service().createStateChanges(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(tx1), noTimeout)
+	require.True(t, cost > 0)
+	maxBlockCost = cost
+
+	// Planning with createStateChanges.
+	_, txOut, _, _, _ := s.service().createStateChanges(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(tx1, tx2), time.Minute)
+	require.Equal(t, 1, len(txOut))
+	require.True(t, txOut[0].Accepted)
+
+	// Planning in the pipeline.
+	proc := &defaultTxProcessor{
+		scID:    s.genesis.SkipChainID(),
+		Service: s.service(),
+	}
+	states, err := proc.ProcessTx(tx1, &txProcessorState{sst: st.MakeStagingStateTrie()})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(states))
+	require.Equal(t, cost, states[0].cost)
+	states, err = proc.ProcessTx(tx2, states[0])
+	require.NoError(t, err)
+	require.Equal(t, 2, len(states))
+	require.Equal(t, 1, len(states[0].txs))
+	require.Equal(t, 1, len(states[1].txs))
+	require.True(t, states[1].txs[0].Accepted)
+	require.Equal(t, cost, states[1].cost)
+
+	// Without budget, both transactions go in the same block.
+	maxBlockCost = 0
+	_, txOut, _, _, _ = s.service().createStateChanges(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(tx1, tx2), time.Minute)
+	require.Equal(t, 2, len(txOut))
+}
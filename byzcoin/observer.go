@@ -0,0 +1,191 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+var observerUpdateMsgID network.MessageTypeID
+
+func init() {
+	observerUpdateMsgID = network.RegisterMessage(&observerUpdate{})
+}
+
+// observerUpdate is sent by the leader to the observers of a chain after each
+// new block. It only announces the block: the observers fetch the blocks
+// themselves, and verify their forward links.
+type observerUpdate struct {
+	ByzCoinID skipchain.SkipBlockID
+	Latest    skipchain.SkipBlockID
+	Index     int
+}
+
+// notifyObservers announces the new block sb to the observers of the chain.
+// It is called by the leader only, and doesn't wait for the observers.
+func (s *Service) notifyObservers(sb *skipchain.SkipBlock, observers []*network.ServerIdentity) {
+	msg := &observerUpdate{
+		ByzCoinID: sb.SkipChainID(),
+		Latest:    sb.Hash,
+		Index:     sb.Index,
+	}
+	for _, si := range observers {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+		go func(si *network.ServerIdentity) {
+			if err := s.SendRaw(si, msg); err != nil {
+				// Observers are not guaranteed to be online, and
+				// will get the block with the next announcement.
+				log.Lvlf2("%s couldn't announce block %d to observer %s: %v",
+					s.ServerIdentity(), msg.Index, si.Address, err)
+			}
+		}(si)
+	}
+}
+
+// handleObserverUpdate fetches the blocks announced by the leader of a chain
+// this node observes. A chain that is not known yet is fetched from its
+// genesis block, once its config lists this node as an observer.
+func (s *Service) handleObserverUpdate(env *network.Envelope) error {
+	msg, ok := env.Msg.(*observerUpdate)
+	if !ok {
+		return fmt.Errorf("%v failed to cast to observerUpdate", s.ServerIdentity())
+	}
+	if latest, err := s.db().GetLatestByID(msg.ByzCoinID); err == nil {
+		if latest.Index >= msg.Index {
+			return nil
+		}
+		// Only the nodes of the chain can announce its blocks.
+		if i, _ := latest.Roster.Search(env.ServerIdentity.ID); i < 0 {
+			return fmt.Errorf("%s is not in the roster of %x", env.ServerIdentity, msg.ByzCoinID)
+		}
+	}
+
+	s.closedMutex.Lock()
+	if s.closed {
+		s.closedMutex.Unlock()
+		return errors.New("closing")
+	}
+	s.working.Add(1)
+	s.closedMutex.Unlock()
+
+	id := string(msg.ByzCoinID)
+	s.updateTrieLock.Lock()
+	if s.observing[id] {
+		// The next announcement will be handled once it's done.
+		s.updateTrieLock.Unlock()
+		s.working.Done()
+		return nil
+	}
+	s.observing[id] = true
+	s.updateTrieLock.Unlock()
+
+	go func() {
+		defer s.working.Done()
+		defer func() {
+			s.updateTrieLock.Lock()
+			delete(s.observing, id)
+			s.updateTrieLock.Unlock()
+		}()
+		if err := s.observeChain(env.ServerIdentity, msg); err != nil {
+			log.Errorf("%s observer couldn't fetch the blocks of %x: %v",
+				s.ServerIdentity(), msg.ByzCoinID, err)
+		}
+	}()
+	return nil
+}
+
+// checkObserver makes sure that the latest config of the chain, as proven by
+// the leader from the genesis block, lists this node as an observer and the
+// leader in the roster. Otherwise anybody could make this node fetch and store
+// any chain.
+func (s *Service) checkObserver(leader *network.ServerIdentity, genesis *skipchain.SkipBlock) error {
+	cl := NewClient(genesis.Hash, *onet.NewRoster([]*network.ServerIdentity{leader}))
+	cl.Genesis = genesis
+	reply, err := cl.GetChainConfigAndProof()
+	if err != nil {
+		return fmt.Errorf("couldn't get the config of %x: %v", genesis.Hash, err)
+	}
+	if !reply.Config.IsObserver(s.ServerIdentity()) {
+		return fmt.Errorf("%s is not an observer of %x", s.ServerIdentity(), genesis.Hash)
+	}
+	if i, _ := reply.Proof.Latest.Roster.Search(leader.ID); i < 0 {
+		return fmt.Errorf("%s is not in the roster of %x", leader, genesis.Hash)
+	}
+	return nil
+}
+
+// observeChain fetches the blocks of the chain from the leader up to the
+// announced block. They are applied in order by updateTrieCallback, as the
+// chain is marked as observed. If the node is more than catchupDownloadAll
+// blocks behind, it downloads the whole DB instead.
+func (s *Service) observeChain(leader *network.ServerIdentity, msg *observerUpdate) error {
+	logCatchup.printf(2, "%s observer fetching the blocks of %x up to %d",
+		s.ServerIdentity(), msg.ByzCoinID, msg.Index)
+	cl := skipchain.NewClient()
+	roster := onet.NewRoster([]*network.ServerIdentity{leader})
+
+	if s.db().GetByID(msg.ByzCoinID) == nil {
+		genesis, err := cl.GetSingleBlock(roster, msg.ByzCoinID)
+		if err != nil {
+			return err
+		}
+		if genesis.Index != 0 || !genesis.CalculateHash().Equal(msg.ByzCoinID) ||
+			!genesis.Hash.Equal(msg.ByzCoinID) {
+			return errors.New("got a wrong genesis block")
+		}
+		if err := s.checkObserver(leader, genesis); err != nil {
+			return err
+		}
+		if _, err := s.db().StoreBlocks([]*skipchain.SkipBlock{genesis}); err != nil {
+			return err
+		}
+	}
+	if !s.hasByzCoinVerification(msg.ByzCoinID) {
+		return errors.New("not a byzcoin chain")
+	}
+
+	st, err := s.getStateTrie(msg.ByzCoinID)
+	if err != nil {
+		return err
+	}
+	if msg.Index-st.GetIndex() > catchupDownloadAll {
+		sb, err := cl.GetSingleBlock(roster, msg.Latest)
+		if err != nil {
+			return err
+		}
+		if sb.Index != msg.Index || !sb.SkipChainID().Equal(msg.ByzCoinID) {
+			return errors.New("got a wrong latest block")
+		}
+		return s.downloadDB(sb)
+	}
+
+	reply, err := s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
+		Genesis: msg.ByzCoinID,
+		Index:   st.GetIndex(),
+	})
+	if err != nil {
+		return err
+	}
+	latest := reply.SkipBlock
+	for latest.Index < msg.Index {
+		updates, err := cl.GetUpdateChainLevel(roster, latest.Hash, 1, catchupFetchBlocks)
+		if err != nil {
+			return err
+		}
+		if _, err = s.db().StoreBlocks(updates); err != nil {
+			return err
+		}
+		last := updates[len(updates)-1]
+		if last.Index <= latest.Index {
+			return fmt.Errorf("the leader only knows the blocks up to %d", last.Index)
+		}
+		latest = last
+	}
+	return nil
+}
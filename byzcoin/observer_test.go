@@ -0,0 +1,86 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// A node outside of the roster follows the chain once it is an observer, and
+// answers the requests for proofs.
+func TestService_Observer(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	observerServer := s.local.GenServers(1)[0]
+	observer := observerServer.Service(ServiceName).(*Service)
+	registerDummy([]*onet.Server{observerServer})
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	config.Observers = []*network.ServerIdentity{s.roster.List[1]}
+	err = config.sanityCheck(nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "must not be in the roster")
+	config.Observers = []*network.ServerIdentity{observer.ServerIdentity(), observer.ServerIdentity()}
+	err = config.sanityCheck(nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "given twice")
+
+	// A node only follows a chain whose config lists it as an observer.
+	err = observer.checkObserver(s.roster.List[0], s.genesis)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not an observer")
+
+	// An observer must leave the observers before joining the roster.
+	config.Observers = []*network.ServerIdentity{observer.ServerIdentity()}
+	require.NoError(t, config.sanityCheck(nil))
+	withObserver := onet.NewRoster(append(s.roster.List, observer.ServerIdentity()))
+	require.Error(t, config.checkNewRoster(*withObserver))
+
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+	instr.SignerCounter = []uint64{1}
+	ctx := ClientTransaction{Instructions: Instructions{instr}}
+	require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+	s.sendTxAndWait(t, ctx, 10)
+	require.NoError(t, observer.checkObserver(s.roster.List[0], s.genesis))
+
+	// The config must be proven from the genesis block of the chain.
+	tampered := s.genesis.Copy()
+	tampered.Roster = onet.NewRoster(s.roster.List[:1])
+	require.Error(t, observer.checkObserver(s.roster.List[0], tampered))
+	addDummyTxs(t, s, 2, 1, 2)
+
+	// The observer never becomes the leader.
+	leader, err := s.service().getLeader(scID)
+	require.NoError(t, err)
+	require.True(t, leader.Equal(s.roster.List[0]))
+
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	// The observer is busy while it fetches the blocks.
+	var resp *GetProofResponse
+	for i := 0; i < 50; i++ {
+		resp, err = observer.GetProof(&GetProof{
+			Version: CurrentVersion,
+			Key:     ConfigInstanceID.Slice(),
+			ID:      scID,
+		})
+		if err == nil && resp.Proof.Latest.Index == latest.Index {
+			break
+		}
+		time.Sleep(s.interval)
+	}
+	require.NoError(t, err)
+	require.Equal(t, latest.Index, resp.Proof.Latest.Index)
+	obsConfig, err := observer.LoadConfig(scID)
+	require.NoError(t, err)
+	require.True(t, obsConfig.IsObserver(observer.ServerIdentity()))
+}
@@ -3,12 +3,13 @@ package byzcoin
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"time"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
-	"go.dedis.ch/kyber/v3"
-	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 )
@@ -71,6 +72,10 @@ var ErrorVerifySkipchain = errors.New("stored skipblock is not properly evolved
 // the target of the last forward link
 var ErrorVerifyHash = errors.New("last forward link does not point to the latest block")
 
+// ErrorVerifyGenesis is returned if the proof doesn't start from the pinned
+// genesis block.
+var ErrorVerifyGenesis = errors.New("proof doesn't start from the pinned genesis block")
+
 // Verify takes a skipchain id and verifies that the proof is valid for this
 // skipchain. It verifies the proof, that the merkle-root is stored in the
 // skipblock of the proof and the fact that the skipblock is indeed part of the
@@ -87,7 +92,7 @@ func (p Proof) Verify(scID skipchain.SkipBlockID) error {
 	}
 
 	sbID := scID
-	var publics []kyber.Point
+	var roster *onet.Roster
 	for i, l := range p.Links {
 		if i == 0 {
 			// The first forward link is a pointer from []byte{} to the genesis
@@ -95,19 +100,18 @@ func (p Proof) Verify(scID skipchain.SkipBlockID) error {
 			if !l.To.Equal(scID) {
 				return ErrorVerifySkipchain
 			}
-			publics = l.NewRoster.ServicePublics(skipchain.ServiceName)
+			roster = l.NewRoster
 			continue
 		}
-		if err = l.Verify(pairing.NewSuiteBn256(), publics); err != nil {
+		// The roster of a reconfiguration is only accepted if it matches
+		// the ID signed by the previous roster.
+		if roster, err = l.VerifyFrom(roster); err != nil {
 			return ErrorVerifySkipchain
 		}
 		if !l.From.Equal(sbID) {
 			return ErrorVerifySkipchain
 		}
 		sbID = l.To
-		if l.NewRoster != nil {
-			publics = l.NewRoster.ServicePublics(skipchain.ServiceName)
-		}
 	}
 
 	// Check that the given latest block matches the last forward link target
@@ -118,6 +122,78 @@ func (p Proof) Verify(scID skipchain.SkipBlockID) error {
 	return nil
 }
 
+// VerifyFromGenesis is like Verify, but also makes sure that the chain of the
+// proof starts with the given genesis block. Verify trusts the roster given by
+// the first forward link of the proof, so a node could create another chain
+// that passes it. Here the roster has to be the one of the genesis block,
+// which is covered by its hash.
+func (p Proof) VerifyFromGenesis(genesis *skipchain.SkipBlock) error {
+	if genesis == nil || genesis.Index != 0 ||
+		!genesis.CalculateHash().Equal(genesis.Hash) {
+		return errors.New("invalid genesis block")
+	}
+	if len(p.Links) == 0 || p.Links[0].NewRoster == nil ||
+		!p.Links[0].To.Equal(genesis.Hash) {
+		return ErrorVerifyGenesis
+	}
+	same, err := p.Links[0].NewRoster.Equal(genesis.Roster)
+	if err != nil || !same {
+		return ErrorVerifyGenesis
+	}
+	return p.Verify(genesis.Hash)
+}
+
+// AuditProof is a proof of an instance that can be verified offline, knowing
+// only the hash of the genesis block. The genesis block gives the roster the
+// forward links of the proof start from.
+type AuditProof struct {
+	Key     []byte
+	Genesis skipchain.SkipBlock
+	Proof   Proof
+}
+
+// ErrorVerifyAuditKey is returned if the proof of an audit proof doesn't
+// prove the presence of its key.
+var ErrorVerifyAuditKey = errors.New("the key is not in the proof")
+
+// VerifyAuditProof verifies that the key of the audit proof is in the chain
+// starting with the genesis block of the given hash. It doesn't need any
+// access to the network.
+func VerifyAuditProof(bundle *AuditProof, genesisHash skipchain.SkipBlockID) error {
+	if bundle == nil {
+		return errors.New("no audit proof")
+	}
+	if !bundle.Genesis.Hash.Equal(genesisHash) {
+		return ErrorVerifyGenesis
+	}
+	if err := bundle.Proof.VerifyFromGenesis(&bundle.Genesis); err != nil {
+		return err
+	}
+	// Verify only checks the root of the trie, Exists checks the path from
+	// the root to the key.
+	ok, err := bundle.Proof.InclusionProof.Exists(bundle.Key)
+	if err != nil {
+		return ErrorVerifyTrie
+	}
+	if !ok {
+		return ErrorVerifyAuditKey
+	}
+	return nil
+}
+
+// Timestamp returns the time of the latest block of the proof, which is when
+// the state in the proof was captured. The index and the height of the block
+// are in p.Latest. The header of the block is covered by its hash, so the time
+// can be trusted once the proof is verified.
+func (p Proof) Timestamp() (time.Time, error) {
+	var header DataHeader
+	err := protobuf.DecodeWithConstructors(p.Latest.Data, &header, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("couldn't decode the header of the latest block: %v", err)
+	}
+	return time.Unix(0, header.Timestamp), nil
+}
+
 // KeyValue returns the key and the values stored in the proof. The caller
 // should check both the key and the value because it should not trust the
 // service to always return a key/value pair (via the proof) that corresponds
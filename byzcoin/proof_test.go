@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/byzcoinx"
@@ -57,6 +58,100 @@ func TestVerify(t *testing.T) {
 	require.Equal(t, ErrorVerifyTrieRoot, p.Verify(s.genesis.SkipChainID()))
 }
 
+func TestVerifyFromGenesis(t *testing.T) {
+	s := createSC(t)
+	p, err := NewProof(s.c, s.s, s.genesis.Hash, s.key)
+	require.Nil(t, err)
+	require.Nil(t, p.VerifyFromGenesis(s.genesis))
+	require.Equal(t, ErrorVerifyGenesis, p.VerifyFromGenesis(s.genesis2))
+
+	// A genesis block that doesn't match its hash is refused.
+	wrong := s.genesis.Copy()
+	wrong.Roster = s.genesis2.Roster
+	require.Error(t, p.VerifyFromGenesis(wrong))
+
+	// A proof signed by another roster than the one of the genesis block
+	// passes Verify, but not VerifyFromGenesis.
+	forged := s.genesis.Copy()
+	var forgedPrivs []kyber.Scalar
+	forged.Roster, forgedPrivs = genRoster(1)
+	link := genForwardLink(t, forged, s.sb2, forgedPrivs)[0]
+	p.Links[0].NewRoster = forged.Roster
+	p.Links[1] = *link
+	require.Nil(t, p.Verify(s.genesis.SkipChainID()))
+	require.Equal(t, ErrorVerifyGenesis, p.VerifyFromGenesis(s.genesis))
+}
+
+// The timestamp of a proof is the one of the header of its latest block.
+func TestProof_Timestamp(t *testing.T) {
+	s := createSC(t)
+	p, err := NewProof(s.c, s.s, s.genesis.Hash, s.key)
+	require.NoError(t, err)
+	ts, err := p.Timestamp()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), ts.UnixNano())
+
+	now := time.Now()
+	p.Latest.Data, err = protobuf.Encode(&DataHeader{Timestamp: now.UnixNano()})
+	require.NoError(t, err)
+	ts, err = p.Timestamp()
+	require.NoError(t, err)
+	require.True(t, now.Equal(ts))
+
+	p.Latest.Data = []byte{0xff}
+	_, err = p.Timestamp()
+	require.Error(t, err)
+}
+
+// An audit proof only verifies as long as it isn't tampered with.
+func TestVerifyAuditProof(t *testing.T) {
+	s := createSC(t)
+	newBundle := func() *AuditProof {
+		p, err := NewProof(s.c, s.s, s.genesis.Hash, s.key)
+		require.NoError(t, err)
+		return &AuditProof{Key: s.key, Genesis: *s.genesis.Copy(), Proof: *p}
+	}
+	require.NoError(t, VerifyAuditProof(newBundle(), s.genesis.Hash))
+	require.Error(t, VerifyAuditProof(nil, s.genesis.Hash))
+	require.Equal(t, ErrorVerifyGenesis, VerifyAuditProof(newBundle(), s.genesis2.Hash))
+
+	bundle := newBundle()
+	bundle.Genesis.Roster = s.genesis2.Roster
+	require.Error(t, VerifyAuditProof(bundle, s.genesis.Hash))
+
+	bundle = newBundle()
+	bundle.Key = []byte{1}
+	require.Equal(t, ErrorVerifyAuditKey, VerifyAuditProof(bundle, s.genesis.Hash))
+
+	bundle = newBundle()
+	bundle.Proof.Links = bundle.Proof.Links[:1]
+	require.Equal(t, ErrorVerifyHash, VerifyAuditProof(bundle, s.genesis.Hash))
+
+	bundle = newBundle()
+	bundle.Proof.Latest.Data = []byte{}
+	require.Error(t, VerifyAuditProof(bundle, s.genesis.Hash))
+
+	// Changing the value changes the leaf, which isn't on the path to the
+	// root anymore.
+	bundle = newBundle()
+	bundle.Proof.InclusionProof.Leaf.Value = []byte("tampered")
+	require.Equal(t, ErrorVerifyTrie, VerifyAuditProof(bundle, s.genesis.Hash))
+}
+
+// A new roster in a forward link has to match the signed roster ID.
+func TestProof_VerifyNewRoster(t *testing.T) {
+	s := createSC(t)
+	p, err := NewProof(s.c, s.s, s.genesis.Hash, s.key)
+	require.NoError(t, err)
+	require.NoError(t, p.Verify(s.genesis.SkipChainID()))
+	require.Equal(t, 2, len(p.Links))
+	require.NotNil(t, p.Links[1].NewRoster)
+
+	other, _ := genRoster(1)
+	p.Links[1].NewRoster = &onet.Roster{ID: p.Links[1].NewRoster.ID, List: other.List}
+	require.Equal(t, ErrorVerifySkipchain, p.Verify(s.genesis.SkipChainID()))
+}
+
 type sc struct {
 	c            *stateTrie             // a usable collectionDB to store key/value pairs
 	s            *skipchain.SkipBlockDB // a usable skipchain DB to store blocks
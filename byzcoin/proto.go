@@ -7,6 +7,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 )
 
 // PROTOSTART
@@ -23,6 +24,7 @@ import (
 // import "onet.proto";
 // import "darc.proto";
 // import "trie.proto";
+// import "network.proto";
 //
 // option java_package = "ch.epfl.dedis.lib.proto";
 // option java_outer_classname = "ByzCoinProto";
@@ -88,12 +90,47 @@ type AddTxRequest struct {
 	// How many block-intervals to wait for inclusion -
 	// missing value or 0 means return immediately.
 	InclusionWait int `protobuf:"opt"`
+	// IdempotencyToken is chosen by the client to identify the request, so
+	// that it can be sent again if the reply is lost. For some minutes, the
+	// node answers another request with the same token with the outcome of
+	// the transaction, instead of adding it again.
+	IdempotencyToken []byte `protobuf:"opt"`
+	// MaxWait, if bigger than 0, is how long to wait for the inclusion
+	// of the transaction, instead of 2 * InclusionWait block-intervals.
+	// The request still fails after InclusionWait blocks without the
+	// transaction.
+	MaxWait time.Duration `protobuf:"opt"`
+	// ReplyRefusal asks the node to return the refusal of a transaction
+	// that is in a block, but got refused, in AddTxResponse.Refused
+	// instead of as an error.
+	ReplyRefusal bool `protobuf:"opt"`
 }
 
 // AddTxResponse is the reply after an AddTxRequest is finished.
 type AddTxResponse struct {
 	// Version of the protocol
 	Version Version
+	// Results holds the results of the instructions of the transaction, as
+	// returned by the contracts implementing ContractWithResult. It is only
+	// set if the request waited for the inclusion of the transaction and
+	// at least one of its instructions has a result.
+	Results [][]byte `protobuf:"opt"`
+	// Pending is true if the transaction was not yet in a block when the
+	// reply was sent, because the request didn't wait for its inclusion.
+	Pending bool `protobuf:"opt"`
+	// Refused tells why the transaction got refused, if the request set
+	// ReplyRefusal.
+	Refused *TxRefusal `protobuf:"opt"`
+}
+
+// TxRefusal tells why a transaction that is in a block got refused.
+type TxRefusal struct {
+	// Instruction is the index of the refused instruction, or -1 if the
+	// whole transaction got refused, or if the node doesn't know why.
+	Instruction int
+	// Reason is the error of the refused instruction or transaction. It
+	// is empty if the node doesn't know why the transaction got refused.
+	Reason string
 }
 
 // GetProof returns the proof that the given key is in the trie.
@@ -117,6 +154,57 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetProofs returns the proofs of several keys, computed against the same
+// block, so that they are consistent with each other.
+type GetProofs struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up, at most 100 different ones.
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proofs returned will be starting at this
+	// block.
+	ID skipchain.SkipBlockID
+}
+
+// GetProofsResponse holds the proofs of the keys of a GetProofs request.
+type GetProofsResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proofs are the proofs of the keys that are not in Errors, in the order
+	// of the request. They all end with the same block.
+	Proofs []Proof
+	// Errors tells why the proofs of some keys couldn't be computed.
+	Errors []GetProofsError `protobuf:"opt"`
+}
+
+// GetProofsError is the reason why the proof of a key of a GetProofs request
+// couldn't be computed.
+type GetProofsError struct {
+	// Index of the key in the request.
+	Index int
+	Error string
+}
+
+// GetChainConfig requests the latest chain config of a ByzCoin chain.
+type GetChainConfig struct {
+	// Version of the protocol
+	Version Version
+	// ByzCoinID is the ID of the chain.
+	ByzCoinID skipchain.SkipBlockID
+}
+
+// GetChainConfigResponse holds the latest chain config, and the proof of the
+// config instance it was read from.
+type GetChainConfigResponse struct {
+	// Version of the protocol
+	Version Version
+	// Config is the decoded value of the config instance.
+	Config ChainConfig
+	// Proof of the config instance, up to the latest block.
+	Proof Proof
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -144,6 +232,43 @@ type ChainConfig struct {
 	Roster          onet.Roster
 	MaxBlockSize    int
 	DarcContractIDs []string
+	// ChainBoundSignatures makes the nodes refuse the signatures that are not
+	// bound to the ID of this chain. It can only be set once all the nodes
+	// and clients of the chain support it, and cannot be unset.
+	ChainBoundSignatures bool `protobuf:"opt"`
+	// RotationWindow is the number of block intervals without heartbeat
+	// from the leader after which the nodes ask for a new leader. 0 means
+	// the default of the nodes.
+	RotationWindow int `protobuf:"opt"`
+	// SpawnContractIDs is the list of the contracts that can be spawned on
+	// this chain. If it is empty, all the contracts can be spawned.
+	SpawnContractIDs []string `protobuf:"opt"`
+	// ContractVersions are the versions of the contracts the nodes must
+	// run to accept the blocks of this chain. The contracts that are not
+	// listed can run any version.
+	ContractVersions []ContractVersion `protobuf:"opt"`
+	// Observers are nodes that follow the chain without being part of the
+	// roster: they never become leader and don't count in the view-change
+	// threshold, but the leader announces them the new blocks, so that
+	// they can answer the requests for proofs.
+	Observers []*network.ServerIdentity `protobuf:"opt"`
+	// MaxInstructionsPerTx is the maximum number of instructions of a
+	// transaction. The transactions with more instructions are refused. 0
+	// means no limit.
+	MaxInstructionsPerTx int `protobuf:"opt"`
+	// ByzCoinVersion is the version of the rules of the chain. The features
+	// that change which transactions are accepted are only enabled once the
+	// chain is at their version, so that the nodes that are not upgraded
+	// yet don't compute another state. It can only be raised once all the
+	// nodes of the chain support the new version, and cannot be lowered.
+	ByzCoinVersion int `protobuf:"opt"`
+}
+
+// ContractVersion is the version of the code of a contract, as registered by
+// the nodes with RegisterContractVersion.
+type ContractVersion struct {
+	ContractID string
+	Version    string
 }
 
 // Proof represents everything necessary to verify a given
@@ -187,6 +312,19 @@ type Instruction struct {
 	// Signatures that are verified using the Darc controlling access to
 	// the instance.
 	Signatures [][]byte
+	// Preconditions are optional checks on the current state of instances
+	// that must all hold before the instruction is executed. If one of
+	// them fails, the whole transaction is refused.
+	Preconditions []Precondition `protobuf:"opt"`
+}
+
+// Precondition holds the version that an instance is expected to have when
+// the instruction is executed. This allows compare-and-set updates.
+type Precondition struct {
+	// InstanceID of the instance to check.
+	InstanceID InstanceID
+	// Version is the expected current version of the instance.
+	Version uint64
 }
 
 // Spawn is called upon an existing instance that will spawn a new instance.
@@ -213,6 +351,9 @@ type Invoke struct {
 type Delete struct {
 	// ContractID represents the kind of contract that is being deleted.
 	ContractID string
+	// Soft asks the contract to leave a tombstone of the instance instead of
+	// removing it, so that its deletion can still be proven.
+	Soft bool `protobuf:"opt"`
 }
 
 // Argument is a name/value pair that will be passed to the contract.
@@ -236,6 +377,10 @@ type ClientTransaction struct {
 type TxResult struct {
 	ClientTransaction ClientTransaction
 	Accepted          bool
+	// Results holds one result per instruction of an accepted transaction,
+	// as returned by the contracts implementing ContractWithResult. It is
+	// empty if none of the instructions has a result.
+	Results [][]byte `protobuf:"opt"`
 }
 
 // StateChange is one new state that will be applied to the collection.
@@ -252,6 +397,23 @@ type StateChange struct {
 	DarcID darc.ID
 	// Version is the monotonically increasing version of the instance
 	Version uint64
+	// Deleted is set for the tombstone left by a soft delete.
+	Deleted bool `protobuf:"opt"`
+	// ContractVersion is the version of the format of the value, as given
+	// by the contract if it implements ContractWithVersion.
+	ContractVersion uint32 `protobuf:"opt"`
+}
+
+// IndexEntry maps a key chosen by a contract, e.g. a user name, to one of its
+// instances. It is stored as the value of the instance
+// NewIndexInstanceID(ContractID, Key).
+type IndexEntry struct {
+	// ContractID is the contract that wrote the entry.
+	ContractID string
+	// Key is the key of the entry in the namespace of the contract.
+	Key []byte
+	// InstanceID is the indexed instance.
+	InstanceID InstanceID
 }
 
 // Coin is a generic structure holding any type of coin. Coins are defined
@@ -274,6 +436,14 @@ type StreamingResponse struct {
 	Block *skipchain.SkipBlock
 }
 
+// StreamProofRequest is a request asking the service to stream a proof of
+// the key in the chain specified by ID every time the key changes. The
+// proofs are streamed back as GetProofResponse.
+type StreamProofRequest struct {
+	ID  skipchain.SkipBlockID
+	Key []byte
+}
+
 // DownloadState requests the current global state of that node.
 // If it is the first call to the service, then Reset
 // must be true, else an error will be returned, or old data
@@ -290,6 +460,10 @@ type DownloadState struct {
 	Nonce uint64
 	// Length of the statechanges to download
 	Length int
+	// StartKey, if set for a new download, makes it start with the first
+	// entry whose key is strictly bigger, so that an interrupted download
+	// can be resumed.
+	StartKey []byte `protobuf:"opt"`
 }
 
 // DownloadStateResponse is returned by the service. If there are no
@@ -302,6 +476,9 @@ type DownloadStateResponse struct {
 	// is generated by the server, and will be set
 	// for every subsequent reply, too.
 	Nonce uint64
+	// Total is the number of DBKeyValues of the state when the download
+	// started. It is only set in the first reply.
+	Total int `protobuf:"opt"`
 }
 
 // DBKeyValue represents one element in bboltdb
@@ -313,11 +490,13 @@ type DBKeyValue struct {
 // StateChangeBody represents the body part of a state change, which is the
 // part that needs to be serialised and stored in a merkle tree.
 type StateChangeBody struct {
-	StateAction StateAction
-	ContractID  string
-	Value       []byte
-	Version     uint64
-	DarcID      darc.ID
+	StateAction     StateAction
+	ContractID      string
+	Value           []byte
+	Version         uint64
+	DarcID          darc.ID
+	Deleted         bool   `protobuf:"opt"`
+	ContractVersion uint32 `protobuf:"opt"`
 }
 
 // GetSignerCounters is a request to get the latest version for the specified
@@ -387,6 +566,120 @@ type CheckStateChangeValidityResponse struct {
 	BlockID      skipchain.SkipBlockID
 }
 
+// GetVersion is a request asking for the versions a node supports.
+type GetVersion struct {
+}
+
+// GetVersionResponse holds the range of versions of the requests the node
+// accepts.
+type GetVersionResponse struct {
+	Current Version
+	Min     Version
+	// ContractVersions are the versions of the contracts registered with
+	// RegisterContractVersion, sorted by contract ID.
+	ContractVersions []ContractVersion `protobuf:"opt"`
+	// ContractIDs are the IDs of all the contracts registered on the node,
+	// sorted.
+	ContractIDs []string `protobuf:"opt"`
+}
+
+// Exists is a request asking whether a key is in the state trie. Unlike
+// GetProof, the answer is not proven, so it is much smaller.
+type Exists struct {
+	// Version of the protocol
+	Version     Version
+	SkipChainID skipchain.SkipBlockID
+	Key         []byte
+}
+
+// ExistsResponse tells whether the key is in the state trie.
+type ExistsResponse struct {
+	// Version of the protocol
+	Version Version
+	Exists  bool
+	// Index is the index of the block of the state trie.
+	Index int
+}
+
+// GetInstancesByDarc is a request asking for the instances controlled by a
+// given darc. As there can be many of them, the response is paginated.
+type GetInstancesByDarc struct {
+	SkipChainID skipchain.SkipBlockID
+	DarcID      darc.ID
+	// Start is the index of the first instance to return.
+	Start int
+	// Length is the maximum number of instances to return.
+	Length int
+	// StartKey, if set, makes the listing resume after this instance, the
+	// last one of the previous page, instead of at Start. The trie is then
+	// only walked until the page is full, and Total is not set.
+	StartKey []byte `protobuf:"opt"`
+}
+
+// GetInstancesByDarcResponse holds the instances controlled by the darc,
+// starting at the requested index.
+type GetInstancesByDarcResponse struct {
+	InstanceIDs []InstanceID
+	// Total is the number of instances controlled by the darc.
+	Total int
+}
+
+// GetCoinSupply is a request asking for the sum of the coins of a type held
+// by all the coin instances.
+type GetCoinSupply struct {
+	// Version of the protocol
+	Version     Version
+	SkipChainID skipchain.SkipBlockID
+	// CoinType is the name of the coins to sum.
+	CoinType InstanceID
+}
+
+// GetCoinSupplyResponse holds the sum of the coins of the requested type.
+type GetCoinSupplyResponse struct {
+	// Version of the protocol
+	Version Version
+	Total   uint64
+	// Accounts is the number of coin instances holding coins of the type.
+	Accounts int
+	// Index is the index of the block of the state trie the sum is from.
+	Index int
+}
+
+// GetDownloadStatus is a request asking for the download of the state the
+// node is serving to another node. It is only allowed on loopback.
+type GetDownloadStatus struct {
+}
+
+// GetDownloadStatusResponse describes the downloads of the state the node is
+// serving.
+type GetDownloadStatusResponse struct {
+	Downloads []DownloadStatus
+}
+
+// DownloadStatus describes a download of the state the node is serving.
+type DownloadStatus struct {
+	// Active is false once all the entries have been served, or the
+	// download timed out.
+	Active    bool
+	ByzCoinID skipchain.SkipBlockID
+	Nonce     uint64
+	// Served is the number of entries sent so far, out of Total.
+	Served int
+	Total  int
+}
+
+// CancelDownload is a request to stop the downloads of the state the node is
+// serving, so that new downloads can start. If Nonce is not 0, only the
+// download with this nonce is stopped. It is only allowed on loopback.
+type CancelDownload struct {
+	Nonce uint64 `protobuf:"opt"`
+}
+
+// CancelDownloadResponse holds the nonces of the cancelled downloads.
+type CancelDownloadResponse struct {
+	Nonces []uint64
+}
+
 // DebugRequest returns the list of all byzcoins if byzcoinid is empty, else it returns
 // a dump of all instances if byzcoinid is given and exists.
 type DebugRequest struct {
@@ -413,6 +706,103 @@ type DebugResponseByzcoin struct {
 type DebugResponseState struct {
 	Key   []byte
 	State StateChangeBody
+	// Description is given by the contract of the instance, if it
+	// implements ContractWithDescription.
+	Description string `protobuf:"opt"`
+}
+
+// DebugSetLogRequest sets the debug level of a subsystem of the service:
+// block, catchup, viewchange or streaming. The messages of the subsystem up
+// to this level are shown, whatever the global level. Level 0 resets the
+// subsystem to the global level. An empty subsystem only returns the levels.
+// It is only allowed on loopback.
+type DebugSetLogRequest struct {
+	Subsystem string `protobuf:"opt"`
+	Level     int    `protobuf:"opt"`
+}
+
+// DebugSetLogResponse holds the levels of all the subsystems.
+type DebugSetLogResponse struct {
+	Levels []DebugLogLevel
+}
+
+// DebugLogLevel is the debug level of a subsystem, 0 if it follows the
+// global level.
+type DebugLogLevel struct {
+	Subsystem string
+	Level     int
+}
+
+// DebugReplayRequest asks the conode to execute again the transactions of the
+// blocks of a chain it stores, from the genesis block up to the block at
+// index To, or the latest block if To is 0. The trie root after every block
+// is compared to the one stored in the block. It is only allowed on loopback.
+type DebugReplayRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	To        int `protobuf:"opt"`
+}
+
+// DebugReplayResponse tells how many blocks have been replayed, and the first
+// block whose replayed state differs from the block, if any.
+type DebugReplayResponse struct {
+	Replayed int
+	// Diverged is true if the block at Index gives another trie root, or
+	// accepts other transactions, than the one stored, as told by Reason.
+	// Otherwise Index is the one of the last replayed block.
+	Diverged bool
+	Index    int
+	Reason   string
+}
+
+// DebugBisectRequest asks the conode to look for the first block between the
+// ones at index From and To, or the latest block if To is 0, whose replayed
+// trie root differs from the one stored in the block, with a binary search.
+// The reply is a DebugReplayResponse. It is only allowed on loopback.
+type DebugBisectRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	From      int `protobuf:"opt"`
+	To        int `protobuf:"opt"`
+}
+
+// DebugReconcileRequest asks the conode to compare the latest state change
+// stored in the history of the instances of a chain with their value in the
+// trie. Sample instances are picked at random, or all of them if Sample is 0.
+// It is only allowed on loopback.
+type DebugReconcileRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	Sample    int `protobuf:"opt"`
+}
+
+// DebugReconcileResponse tells how many instances have been compared, at the
+// given index of the trie, and those whose history differs from the trie.
+// Missing counts the instances without history, which is expected if the
+// history has been cleaned or disabled for a while.
+type DebugReconcileResponse struct {
+	Index      int
+	Checked    int
+	Missing    int
+	Mismatches []DebugReconcileMismatch
+}
+
+// DebugReconcileMismatch is an instance whose latest state change differs
+// from its value in the trie.
+type DebugReconcileMismatch struct {
+	InstanceID InstanceID
+	Reason     string
+}
+
+// PruneStateChanges asks the conode to remove from the history of the
+// instances of a chain the state changes of the blocks before BlockIndex,
+// except the latest state change of every instance. It is only allowed on
+// loopback.
+type PruneStateChanges struct {
+	ByzCoinID  skipchain.SkipBlockID
+	BlockIndex int
+}
+
+// PruneStateChangesResponse tells how many state changes have been removed.
+type PruneStateChangesResponse struct {
+	Removed int
 }
 
 // DebugRemoveRequest asks the conode to delete the given byzcoin-instance from its database.
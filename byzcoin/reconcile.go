@@ -0,0 +1,101 @@
+package byzcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// reconcileCandidate is an instance of the trie picked to be compared with
+// its history.
+type reconcileCandidate struct {
+	id   InstanceID
+	body StateChangeBody
+}
+
+// DebugReconcile compares the latest state change stored in the history of
+// some instances with their value in the trie. The instances are picked at
+// random among all the instances of the trie, or all of them are checked if
+// the sample isn't positive.
+func (s *Service) DebugReconcile(req *DebugReconcileRequest) (*DebugReconcileResponse, error) {
+	if sb := s.db().GetByID(req.ByzCoinID); sb == nil || sb.Index != 0 {
+		return nil, errors.New("unknown byzcoinID")
+	}
+	// No block must be applied between the reads of the trie and of the
+	// history.
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	st, err := s.getStateTrie(req.ByzCoinID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reservoir sampling, to go only once through the trie.
+	var sample []reconcileCandidate
+	seen := 0
+	err = st.ForEach(func(k, v []byte) error {
+		if len(k) != prefixLength {
+			return nil
+		}
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			// Not all key/value pairs are valid statechanges
+			return nil
+		}
+		c := reconcileCandidate{id: NewInstanceID(k), body: body}
+		seen++
+		if req.Sample <= 0 || len(sample) < req.Sample {
+			sample = append(sample, c)
+		} else if i := rand.Intn(seen); i < req.Sample {
+			sample[i] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &DebugReconcileResponse{Index: st.GetIndex()}
+	for _, c := range sample {
+		sce, ok, err := s.stateChangeStorage.getLast(c.id.Slice(), req.ByzCoinID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read the history of %x: %v", c.id.Slice(), err)
+		}
+		resp.Checked++
+		if !ok {
+			resp.Missing++
+			continue
+		}
+		if reason := reconcileDiff(c.body, sce.StateChange); reason != "" {
+			log.Warnf("%s: history of %x differs from the trie: %s", s.ServerIdentity(), c.id.Slice(), reason)
+			resp.Mismatches = append(resp.Mismatches, DebugReconcileMismatch{
+				InstanceID: c.id,
+				Reason:     reason,
+			})
+		}
+	}
+	return resp, nil
+}
+
+// reconcileDiff returns how the latest state change of an instance differs
+// from its value in the trie, or an empty string if they match.
+func reconcileDiff(body StateChangeBody, sc StateChange) string {
+	switch {
+	case sc.StateAction == Remove:
+		return fmt.Sprintf("the instance is deleted at version %d in the history", sc.Version)
+	case body.Version != sc.Version:
+		return fmt.Sprintf("version %d in the trie, %d in the history", body.Version, sc.Version)
+	case body.ContractID != sc.ContractID:
+		return fmt.Sprintf("contract %s in the trie, %s in the history", body.ContractID, sc.ContractID)
+	case !body.DarcID.Equal(sc.DarcID):
+		return fmt.Sprintf("darc %x in the trie, %x in the history", body.DarcID, sc.DarcID)
+	case body.Deleted != sc.Deleted:
+		return fmt.Sprintf("tombstone is %v in the trie, %v in the history", body.Deleted, sc.Deleted)
+	case !bytes.Equal(body.Value, sc.Value):
+		return "the values differ"
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The history of the instances matches the trie, until one of them is
+// changed only in the history.
+func TestService_DebugReconcile(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 2, 2, 1)
+	scID := s.genesis.SkipChainID()
+
+	resp, err := s.service().DebugReconcile(&DebugReconcileRequest{ByzCoinID: scID})
+	require.NoError(t, err)
+	require.Empty(t, resp.Mismatches)
+	require.True(t, resp.Checked > 5)
+	all := resp.Checked
+
+	resp, err = s.service().DebugReconcile(&DebugReconcileRequest{ByzCoinID: scID, Sample: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, resp.Checked)
+
+	// Store a newer version of the genesis darc only in the history.
+	darcID := NewInstanceID(s.darc.GetBaseID())
+	sce, ok, err := s.service().stateChangeStorage.getLast(darcID.Slice(), scID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	sc := sce.StateChange
+	sc.Version++
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	require.NoError(t, s.service().stateChangeStorage.append(StateChanges{sc}, latest))
+
+	resp, err = s.service().DebugReconcile(&DebugReconcileRequest{ByzCoinID: scID})
+	require.NoError(t, err)
+	require.Equal(t, all, resp.Checked)
+	require.Equal(t, 1, len(resp.Mismatches))
+	require.Equal(t, darcID, resp.Mismatches[0].InstanceID)
+	require.Contains(t, resp.Mismatches[0].Reason, "in the history")
+
+	_, err = s.service().DebugReconcile(&DebugReconcileRequest{ByzCoinID: latest.Hash})
+	require.Error(t, err)
+}
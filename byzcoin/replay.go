@@ -0,0 +1,208 @@
+package byzcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// DebugReplay executes again the transactions of the blocks of the chain, in
+// a state kept in memory, and stops at the first block whose trie root or
+// accepted transactions differ from the ones stored in the block. As every
+// root depends on all the blocks before it, the replay always starts at the
+// genesis block.
+func (s *Service) DebugReplay(req *DebugReplayRequest) (*DebugReplayResponse, error) {
+	if req.To < 0 {
+		return nil, errors.New("the index of the last block must not be negative")
+	}
+	sb := s.db().GetByID(req.ByzCoinID)
+	if sb == nil || sb.Index != 0 {
+		return nil, errors.New("unknown byzcoinID")
+	}
+
+	resp := &DebugReplayResponse{}
+	var sst *stagingStateTrie
+	for {
+		var err error
+		sst, err = s.replayStep(sst, req.ByzCoinID, sb, true)
+		resp.Replayed++
+		if err != nil {
+			s.replayDiverged(resp, req.ByzCoinID, sb.Index, err)
+			return resp, nil
+		}
+
+		if req.To > 0 && sb.Index == req.To || len(sb.ForwardLink) == 0 {
+			resp.Index = sb.Index
+			return resp, nil
+		}
+		next := s.db().GetByID(sb.ForwardLink[0].To)
+		if next == nil {
+			return nil, fmt.Errorf("the block after block %d is missing", sb.Index)
+		}
+		sb = next
+	}
+}
+
+// DebugBisect looks for the first block between From and To whose replayed
+// state differs from the block, with a binary search over the block index.
+// Every probe replays the blocks since the last block known to match, and
+// only compares the trie root of the probed block, so it expects a state that
+// diverged to stay diverged. The blocks before From are replayed and checked
+// like in DebugReplay.
+func (s *Service) DebugBisect(req *DebugBisectRequest) (*DebugReplayResponse, error) {
+	if req.From < 0 || req.To < 0 {
+		return nil, errors.New("the indexes of the blocks must not be negative")
+	}
+	if req.To > 0 && req.From > req.To {
+		return nil, errors.New("the first block must not be after the last one")
+	}
+	ids, err := s.chainBlockIDs(req.ByzCoinID, req.To)
+	if err != nil {
+		return nil, err
+	}
+	if req.From >= len(ids) {
+		return nil, fmt.Errorf("the chain has no block %d", req.From)
+	}
+
+	resp := &DebugReplayResponse{}
+	// replay executes the blocks after the one at index from, whose state is
+	// sst, up to the one at index to, and only compares the trie root of the
+	// last one. It doesn't change sst.
+	replay := func(sst *stagingStateTrie, from, to int) (*stagingStateTrie, error) {
+		if sst != nil {
+			sst = sst.Clone()
+		}
+		for i := from + 1; i <= to; i++ {
+			sb := s.db().GetByID(ids[i])
+			if sb == nil {
+				return nil, fmt.Errorf("block %d is missing", i)
+			}
+			var err error
+			sst, err = s.replayStep(sst, req.ByzCoinID, sb, i == to)
+			resp.Replayed++
+			if err != nil {
+				return nil, err
+			}
+		}
+		return sst, nil
+	}
+
+	// good is the index of the last block known to match, and sst its
+	// state.
+	good := req.From - 1
+	var sst *stagingStateTrie
+	for i := 0; i <= good; i++ {
+		sb := s.db().GetByID(ids[i])
+		if sb == nil {
+			return nil, fmt.Errorf("block %d is missing", i)
+		}
+		sst, err = s.replayStep(sst, req.ByzCoinID, sb, true)
+		resp.Replayed++
+		if err != nil {
+			s.replayDiverged(resp, req.ByzCoinID, i, err)
+			return resp, nil
+		}
+	}
+
+	bad := len(ids) - 1
+	if _, err = replay(sst, good, bad); err == nil {
+		resp.Index = bad
+		return resp, nil
+	}
+	for bad-good > 1 {
+		mid := (good + bad) / 2
+		st, midErr := replay(sst, good, mid)
+		if midErr != nil {
+			bad, err = mid, midErr
+		} else {
+			good, sst = mid, st
+		}
+	}
+	s.replayDiverged(resp, req.ByzCoinID, bad, err)
+	return resp, nil
+}
+
+// replayDiverged fills resp with the block at index whose replay gave err.
+func (s *Service) replayDiverged(resp *DebugReplayResponse, scID skipchain.SkipBlockID, index int, err error) {
+	log.Lvlf1("%s: replay of %x diverged at block %d: %v", s.ServerIdentity(), scID, index, err)
+	resp.Diverged = true
+	resp.Index = index
+	resp.Reason = err.Error()
+}
+
+// chainBlockIDs returns the IDs of the blocks of the chain, from the genesis
+// block up to the block at index to, or the latest block if to is 0.
+func (s *Service) chainBlockIDs(scID skipchain.SkipBlockID, to int) ([]skipchain.SkipBlockID, error) {
+	sb := s.db().GetByID(scID)
+	if sb == nil || sb.Index != 0 {
+		return nil, errors.New("unknown byzcoinID")
+	}
+	ids := []skipchain.SkipBlockID{sb.Hash}
+	for (to == 0 || sb.Index < to) && len(sb.ForwardLink) > 0 {
+		next := s.db().GetByID(sb.ForwardLink[0].To)
+		if next == nil {
+			return nil, fmt.Errorf("the block after block %d is missing", sb.Index)
+		}
+		sb = next
+		ids = append(ids, sb.Hash)
+	}
+	if to > 0 && sb.Index != to {
+		return nil, fmt.Errorf("the chain has no block %d", to)
+	}
+	return ids, nil
+}
+
+// replayStep executes the transactions of the block sb on the state sst, or
+// on a new state if sst is nil, and returns the new state. It returns an
+// error if the transactions are accepted or refused unlike in the block, or,
+// if checkRoot is true, if the trie root differs from the one of the block.
+func (s *Service) replayStep(sst *stagingStateTrie, scID skipchain.SkipBlockID,
+	sb *skipchain.SkipBlock, checkRoot bool) (*stagingStateTrie, error) {
+	var header DataHeader
+	if err := protobuf.Decode(sb.Data, &header); err != nil {
+		return nil, fmt.Errorf("couldn't decode the header of block %d: %v", sb.Index, err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, fmt.Errorf("couldn't decode the body of block %d: %v", sb.Index, err)
+	}
+	if sst == nil {
+		nonce, err := s.loadNonceFromTxs(body.TxResults)
+		if err != nil {
+			return nil, err
+		}
+		sst, err = newMemStagingStateTrie(nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sst, err := s.replayBlock(sst, scID, body.TxResults)
+	if err != nil {
+		return nil, err
+	}
+	if checkRoot && !bytes.Equal(sst.GetRoot(), header.TrieRoot) {
+		return nil, fmt.Errorf("the trie root is %x instead of %x", sst.GetRoot(), header.TrieRoot)
+	}
+	return sst, nil
+}
+
+// replayBlock executes the transactions of a block on sst, and returns an
+// error if a transaction is accepted or refused unlike in the block.
+func (s *Service) replayBlock(sst *stagingStateTrie, scID skipchain.SkipBlockID, txs TxResults) (*stagingStateTrie, error) {
+	for i, tx := range txs {
+		_, sstTemp, _, _, err := s.processOneTx(sst, scID, tx.ClientTransaction)
+		if (err == nil) != tx.Accepted {
+			return nil, fmt.Errorf("transaction %d is accepted: %v instead of %v (error: %v)",
+				i, err == nil, tx.Accepted, err)
+		}
+		if err == nil {
+			sst = sstTemp
+		}
+	}
+	return sst, nil
+}
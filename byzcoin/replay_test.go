@@ -0,0 +1,125 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// The replay of a chain gives the trie roots of its blocks, until a block
+// whose root has been changed.
+func TestService_DebugReplay(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 3, 2, 1)
+	scID := s.genesis.SkipChainID()
+
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	resp, err := s.service().DebugReplay(&DebugReplayRequest{ByzCoinID: scID})
+	require.NoError(t, err)
+	require.False(t, resp.Diverged, resp.Reason)
+	require.Equal(t, latest.Index, resp.Index)
+	require.Equal(t, latest.Index+1, resp.Replayed)
+
+	resp, err = s.service().DebugReplay(&DebugReplayRequest{ByzCoinID: scID, To: 1})
+	require.NoError(t, err)
+	require.False(t, resp.Diverged)
+	require.Equal(t, 1, resp.Index)
+	require.Equal(t, 2, resp.Replayed)
+
+	_, err = s.service().DebugReplay(&DebugReplayRequest{ByzCoinID: latest.Hash})
+	require.Error(t, err)
+
+	// Change the root stored in block 2.
+	reply, err := s.service().skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: 2})
+	require.NoError(t, err)
+	sb := reply.SkipBlock
+	var header DataHeader
+	require.NoError(t, protobuf.Decode(sb.Data, &header))
+	header.TrieRoot[0] ^= 0xff
+	sb.Data, err = protobuf.Encode(&header)
+	require.NoError(t, err)
+	overwriteBlock(t, s, sb)
+
+	resp, err = s.service().DebugReplay(&DebugReplayRequest{ByzCoinID: scID})
+	require.NoError(t, err)
+	require.True(t, resp.Diverged)
+	require.Equal(t, 2, resp.Index)
+	require.Equal(t, 3, resp.Replayed)
+	require.Contains(t, resp.Reason, "trie root")
+}
+
+// Bisecting a chain finds the first block whose replay diverges, in the given
+// range of blocks.
+func TestService_DebugBisect(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 6, 1, 1)
+	scID := s.genesis.SkipChainID()
+
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	resp, err := s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID})
+	require.NoError(t, err)
+	require.False(t, resp.Diverged, resp.Reason)
+	require.Equal(t, latest.Index, resp.Index)
+
+	_, err = s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID, From: 3, To: 2})
+	require.Error(t, err)
+	_, err = s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID, To: latest.Index + 1})
+	require.Error(t, err)
+
+	// Refuse the transaction of block 3 in the stored block, so that the
+	// replay of every block from block 3 on diverges.
+	reply, err := s.service().skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: 3})
+	require.NoError(t, err)
+	sb := reply.SkipBlock
+	var body DataBody
+	require.NoError(t, protobuf.Decode(sb.Payload, &body))
+	require.Len(t, body.TxResults, 1)
+	body.TxResults[0].Accepted = false
+	sb.Payload, err = protobuf.Encode(&body)
+	require.NoError(t, err)
+	overwriteBlock(t, s, sb)
+
+	for _, from := range []int{0, 1, 3} {
+		resp, err = s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID, From: from})
+		require.NoError(t, err)
+		require.True(t, resp.Diverged)
+		require.Equal(t, 3, resp.Index)
+		require.Contains(t, resp.Reason, "transaction 0 is accepted")
+	}
+
+	// A block before the range that diverges is found while replaying up
+	// to the range.
+	resp, err = s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID, From: 5})
+	require.NoError(t, err)
+	require.True(t, resp.Diverged)
+	require.Equal(t, 3, resp.Index)
+
+	resp, err = s.service().DebugBisect(&DebugBisectRequest{ByzCoinID: scID, To: 2})
+	require.NoError(t, err)
+	require.False(t, resp.Diverged)
+	require.Equal(t, 2, resp.Index)
+}
+
+// overwriteBlock replaces the block with the same hash in the database.
+func overwriteBlock(t *testing.T, s *ser, sb *skipchain.SkipBlock) {
+	buf, err := network.Marshal(sb)
+	require.NoError(t, err)
+	require.NoError(t, s.service().db().Update(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(_ []byte, b *bbolt.Bucket) error {
+			if b.Get(sb.Hash) == nil {
+				return nil
+			}
+			return b.Put(sb.Hash, buf)
+		})
+	}))
+}
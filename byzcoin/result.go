@@ -0,0 +1,55 @@
+package byzcoin
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// maxResultSize is the maximal size of the result of one instruction.
+const maxResultSize = 1024
+
+// ContractWithResult can be implemented by contracts that want to return a
+// small value to the client for each of their instructions, e.g. the ID of
+// the instance created by a spawn. The results are stored in the TxResult of
+// the transaction, which is part of the hash of the block, and are returned
+// by AddTransaction if the client waits for the inclusion.
+//
+// Every node computes the results when it executes the instruction, so they
+// must be deterministic.
+type ContractWithResult interface {
+	// Result returns the result of the instruction, given the state changes
+	// returned by the contract. A nil result means that the instruction has
+	// no result.
+	Result(ReadOnlyStateTrie, Instruction, []StateChange) ([]byte, error)
+}
+
+// instructionResult returns the result of the instruction executed by the
+// contract c, or nil if c is nil or doesn't implement ContractWithResult.
+func instructionResult(c Contract, st ReadOnlyStateTrie, instr Instruction, scs []StateChange) ([]byte, error) {
+	cwr, ok := c.(ContractWithResult)
+	if !ok {
+		return nil, nil
+	}
+	res, err := cwr.Result(st, instr, scs)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > maxResultSize {
+		return nil, fmt.Errorf("result of %d bytes is bigger than %d bytes", len(res), maxResultSize)
+	}
+	return res, nil
+}
+
+// equalResults returns true if the results a and b are the same. A nil
+// result is the same as an empty one, as they are encoded the same way.
+func equalResults(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package byzcoin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/protobuf"
+)
+
+const resultContractID = "result"
+
+// resultContract spawns instances holding the value of the "value" argument
+// and returns their ID as result.
+type resultContract struct {
+	BasicContract
+}
+
+func (c resultContract) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+	return []StateChange{
+		NewStateChange(Create, inst.DeriveID(""), resultContractID, inst.Spawn.Args.Search("value"), darcID),
+	}, coins, nil
+}
+
+func (c resultContract) Result(rst ReadOnlyStateTrie, inst Instruction, scs []StateChange) ([]byte, error) {
+	if bytes.Equal(inst.Spawn.Args.Search("value"), []byte("big")) {
+		return make([]byte, maxResultSize+1), nil
+	}
+	return scs[0].InstanceID, nil
+}
+
+func TestResult_InstructionResult(t *testing.T) {
+	scs := []StateChange{
+		NewStateChange(Create, NewInstanceID([]byte("a")), resultContractID, nil, nil),
+	}
+	res, err := instructionResult(BasicContract{}, nil, Instruction{}, scs)
+	require.NoError(t, err)
+	require.Nil(t, res)
+
+	instr := createSpawnInstr(darc.ID{}, resultContractID, "value", nil)
+	res, err = instructionResult(resultContract{}, nil, instr, scs)
+	require.NoError(t, err)
+	require.Equal(t, scs[0].InstanceID, res)
+
+	instr = createSpawnInstr(darc.ID{}, resultContractID, "value", []byte("big"))
+	_, err = instructionResult(resultContract{}, nil, instr, scs)
+	require.Error(t, err)
+}
+
+func TestResult_Hash(t *testing.T) {
+	ctx := ClientTransaction{Instructions: []Instruction{createSpawnInstr(darc.ID{}, resultContractID, "value", nil)}}
+	txr := TxResults{{ClientTransaction: ctx, Accepted: true}}
+	h := txr.Hash()
+
+	// The hash of the transactions without results doesn't change.
+	txr[0].Results = [][]byte{}
+	require.Equal(t, h, txr.Hash())
+
+	txr[0].Results = [][]byte{[]byte("a")}
+	hRes := txr.Hash()
+	require.NotEqual(t, h, hRes)
+	txr[0].Results = [][]byte{[]byte("b")}
+	require.NotEqual(t, hRes, txr.Hash())
+	txr[0].Results = [][]byte{nil}
+	require.NotEqual(t, h, txr.Hash())
+}
+
+func TestService_Results(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	for _, h := range s.hosts {
+		require.NoError(t, RegisterContract(h, resultContractID, func(in []byte) (Contract, error) {
+			return resultContract{}, nil
+		}))
+	}
+	d2 := s.darc.Copy()
+	require.NoError(t, d2.EvolveFrom(s.darc))
+	require.NoError(t, d2.Rules.AddRule("spawn:"+resultContractID, expression.Expr(s.signer.Identity().String())))
+	s.testDarcEvolution(t, *d2, false)
+
+	spawn := createSpawnInstr(s.darc.GetBaseID(), resultContractID, "value", []byte("a"))
+	spawn.SignerCounter = []uint64{2}
+	ctx, err := combineInstrsAndSign(s.signer, spawn)
+	require.NoError(t, err)
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	resp, err := cl.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	id := ctx.Instructions[0].DeriveID("")
+	require.Equal(t, [][]byte{id.Slice()}, resp.Results)
+
+	// The results are stored in the block.
+	proof, err := cl.GetProof(id.Slice())
+	require.NoError(t, err)
+	var body DataBody
+	require.NoError(t, protobuf.Decode(proof.Proof.Latest.Payload, &body))
+	require.Equal(t, 1, len(body.TxResults))
+	require.Equal(t, resp.Results, body.TxResults[0].Results)
+
+	// Instructions with too big results are refused.
+	spawn = createSpawnInstr(s.darc.GetBaseID(), resultContractID, "value", []byte("big"))
+	spawn.SignerCounter = []uint64{3}
+	ctx, err = combineInstrsAndSign(s.signer, spawn)
+	require.NoError(t, err)
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bigger than")
+}
@@ -11,7 +11,10 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -55,8 +58,82 @@ var catchupFetchBlocks = 10
 // How many DB-entries to download in one go.
 var catchupFetchDBEntries = 100
 
+// How many keys a GetProofs request can look up.
+var getProofsMaxKeys = 100
+
+// How many times an interrupted download of the DB is resumed from the last
+// entry received before giving up on the node.
+var catchupDownloadResumes = 3
+
+// How many blocks a node can be behind before it stops catching up on its
+// own, and requires a manual intervention, like a fresh DB download. 0 means
+// no limit. It can be set with the BYZCOIN_CATCHUP_MAX_DISTANCE environment
+// variable.
+var catchupMaxDistance = 0
+
+const envCatchupMaxDistance = "BYZCOIN_CATCHUP_MAX_DISTANCE"
+
+// Whether a node that couldn't apply a block to its state, because the root
+// of the resulting state didn't match the block, downloads the state from
+// the other nodes. Otherwise it refuses to apply any further block of that
+// chain until it is restarted. It can be set with the
+// BYZCOIN_RECOVER_ON_STORE_FAILURE environment variable.
+var recoverOnStoreFailure = false
+
+const envRecoverOnStoreFailure = "BYZCOIN_RECOVER_ON_STORE_FAILURE"
+
+// How many downloads of the state a node serves at the same time, so that
+// several nodes can catch up at once. It can be set with the
+// BYZCOIN_MAX_DOWNLOADS environment variable.
+var maxDownloads = 4
+
+const envMaxDownloads = "BYZCOIN_MAX_DOWNLOADS"
+
+// The public keys, in hex, of the only nodes from which this node downloads
+// blocks and state when catching up. If it is empty, all the nodes of the
+// roster are used. It can be set with the BYZCOIN_CATCHUP_TRUSTED environment
+// variable, as a comma separated list of public keys.
+var catchupTrusted map[string]bool
+
+const envCatchupTrusted = "BYZCOIN_CATCHUP_TRUSTED"
+
+// The factor by which the leader can stretch the block interval at most,
+// while the proposals of blocks take longer than the block interval, e.g.
+// because followers are slow to verify them. 1 disables the backoff. It can
+// be set with the BYZCOIN_MAX_BLOCK_BACKOFF environment variable.
+var maxBlockBackoff = 8
+
+const envMaxBlockBackoff = "BYZCOIN_MAX_BLOCK_BACKOFF"
+
+// The maximal total cost of the transactions that the leader puts in one
+// block, as computed by instructionCost, so that blocks that are small but
+// expensive to verify are split. A transaction that is over the budget alone
+// gets a block of its own. 0 means no limit. It can be set with the
+// BYZCOIN_MAX_BLOCK_COST environment variable. Only the leader uses it, the
+// other nodes accept blocks of any cost.
+var maxBlockCost uint64
+
+const envMaxBlockCost = "BYZCOIN_MAX_BLOCK_COST"
+
 var rotationWindow time.Duration = 10
 
+// The number of block intervals after which a leader that keeps failing to
+// create blocks steps down. It must be smaller than rotationWindow to be
+// useful, and is lowered for the chains with a smaller rotation window. It
+// can be set with the BYZCOIN_WATCHDOG_WINDOW environment variable.
+var watchdogWindow = defaultWatchdogWindow
+
+const defaultWatchdogWindow = 5
+
+// minRotationWindow is the smallest rotation window a chain can set. It is
+// above the default watchdog window, so that with the default, a stuck
+// leader steps down before the followers ask for a new one. It doesn't
+// depend on BYZCOIN_WATCHDOG_WINDOW, as all the nodes must agree on which
+// configurations are valid.
+const minRotationWindow = defaultWatchdogWindow + 1
+
+const envWatchdogWindow = "BYZCOIN_WATCHDOG_WINDOW"
+
 const noTimeout time.Duration = 0
 
 const collectTxProtocol = "CollectTxProtocol"
@@ -80,6 +157,123 @@ func init() {
 	viewChangeMsgID = network.RegisterMessage(&viewchange.InitReq{})
 }
 
+// envOnce makes sure the environment variables are only parsed once, by the
+// first service, as all the services share them.
+var envOnce sync.Once
+var envErr error
+
+// loadEnv parses the environment variables configuring the service, and
+// returns an error if one of them is invalid.
+func loadEnv() error {
+	envOnce.Do(func() {
+		envErr = parseEnv()
+	})
+	return envErr
+}
+
+// parseEnv sets the variables of the service that are given in the
+// environment.
+func parseEnv() error {
+	var err error
+	if d := os.Getenv(envCatchupMaxDistance); d != "" {
+		if catchupMaxDistance, err = strconv.Atoi(d); err != nil {
+			return fmt.Errorf("invalid %s: %v", envCatchupMaxDistance, err)
+		}
+	}
+	if r := os.Getenv(envRecoverOnStoreFailure); r != "" {
+		if recoverOnStoreFailure, err = strconv.ParseBool(r); err != nil {
+			return fmt.Errorf("invalid %s: %v", envRecoverOnStoreFailure, err)
+		}
+	}
+	if b := os.Getenv(envMaxBlockBackoff); b != "" {
+		if maxBlockBackoff, err = strconv.Atoi(b); err != nil {
+			return fmt.Errorf("invalid %s: %v", envMaxBlockBackoff, err)
+		}
+		if maxBlockBackoff < 1 {
+			return errors.New(envMaxBlockBackoff + " must be at least 1")
+		}
+	}
+	if w := os.Getenv(envWatchdogWindow); w != "" {
+		if watchdogWindow, err = strconv.Atoi(w); err != nil {
+			return fmt.Errorf("invalid %s: %v", envWatchdogWindow, err)
+		}
+		if watchdogWindow < 1 {
+			return errors.New(envWatchdogWindow + " must be at least 1")
+		}
+	}
+	if c := os.Getenv(envMaxBlockCost); c != "" {
+		if maxBlockCost, err = strconv.ParseUint(c, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s: %v", envMaxBlockCost, err)
+		}
+	}
+	if d := os.Getenv(envMaxDownloads); d != "" {
+		if maxDownloads, err = strconv.Atoi(d); err != nil {
+			return fmt.Errorf("invalid %s: %v", envMaxDownloads, err)
+		}
+		if maxDownloads < 1 {
+			return errors.New(envMaxDownloads + " must be at least 1")
+		}
+	}
+	if t := os.Getenv(envCatchupTrusted); t != "" {
+		if catchupTrusted, err = parseTrustedNodes(t); err != nil {
+			return fmt.Errorf("invalid %s: %v", envCatchupTrusted, err)
+		}
+	}
+	if q := os.Getenv(envStateChangeQueue); q != "" {
+		if stateChangeQueueSize, err = strconv.Atoi(q); err != nil {
+			return fmt.Errorf("invalid %s: %v", envStateChangeQueue, err)
+		}
+		if stateChangeQueueSize < 0 {
+			return errors.New(envStateChangeQueue + " must not be negative")
+		}
+	}
+	return nil
+}
+
+// parseTrustedNodes parses a comma separated list of public keys in hex.
+func parseTrustedNodes(list string) (map[string]bool, error) {
+	trusted := make(map[string]bool)
+	for _, pub := range strings.Split(list, ",") {
+		pub = strings.ToLower(strings.TrimSpace(pub))
+		if pub == "" {
+			continue
+		}
+		if _, err := hex.DecodeString(pub); err != nil {
+			return nil, fmt.Errorf("public key %s is not in hex: %v", pub, err)
+		}
+		trusted[pub] = true
+	}
+	return trusted, nil
+}
+
+// trustedForCatchup returns the nodes of the list from which this node
+// downloads blocks and state when catching up, in the same order.
+func trustedForCatchup(list []*network.ServerIdentity) []*network.ServerIdentity {
+	if len(catchupTrusted) == 0 {
+		return list
+	}
+	var trusted []*network.ServerIdentity
+	for _, si := range list {
+		if catchupTrusted[si.Public.String()] {
+			trusted = append(trusted, si)
+		}
+	}
+	return trusted
+}
+
+// catchupRoster returns the roster of the nodes of r from which this node
+// downloads blocks when catching up.
+func catchupRoster(r *onet.Roster) (*onet.Roster, error) {
+	if len(catchupTrusted) == 0 {
+		return r, nil
+	}
+	trusted := trustedForCatchup(r.List)
+	if len(trusted) == 0 {
+		return nil, errors.New("none of the nodes of the roster is trusted for catching up")
+	}
+	return onet.NewRoster(trusted), nil
+}
+
 // GenNonce returns a random nonce.
 func GenNonce() (n Nonce) {
 	random.Bytes(n[:], random.New())
@@ -95,11 +289,28 @@ type Service struct {
 	// responsible for, one for each skipchain.
 	stateTries     map[string]*stateTrie
 	stateTriesLock sync.Mutex
+	// trieDBs holds the databases of the tries if every chain has its own
+	// file, see envTrieStorage.
+	trieDBs trieDBs
 	// We need to store the state changes for keeping track
 	// of the history of an instance
-	stateChangeStorage *stateChangeStorage
+	stateChangeStorage stateChangeBackend
+	// stateChangeQueue stores the state changes in the background. It is
+	// the stateChangeStorage if BYZCOIN_STATECHANGE_QUEUE is set, else it
+	// only stores the blocks left in the queue by the previous run.
+	stateChangeQueue *stateChangeQueue
 	// notifications is used for client transaction and block notification
 	notifications bcNotifications
+	// txRejections keeps why the latest transactions have been refused.
+	txRejections txRejections
+	// txTokens keeps the outcome of the transactions sent with an
+	// idempotency token.
+	txTokens txTokens
+	// equivocations keeps the conflicting blocks seen by the node.
+	equivocations equivocations
+	// missingGenesis keeps the chains whose genesis block couldn't be
+	// repaired.
+	missingGenesis missingGenesis
 
 	// pollChan maintains a map of channels that can be used to stop the
 	// polling go-routing.
@@ -119,16 +330,29 @@ type Service struct {
 
 	// contracts map kinds to kind specific verification functions
 	contracts map[string]ContractFn
+	// contractVersions holds the versions of the code of the contracts,
+	// as registered with RegisterContractVersion.
+	contractVersions    map[string]string
+	contractVersionsMut sync.Mutex
 
 	storage *bcStorage
 
-	createSkipChainMut sync.Mutex
+	// createSkipChainLocks prevents the concurrent creation of the same
+	// chain, identified by its roster and genesis darc.
+	createSkipChainLocks genesisLocker
 
 	darcToSc    map[string]skipchain.SkipBlockID
 	darcToScMut sync.Mutex
 
 	stateChangeCache stateChangeCache
 
+	// blockCosts holds the total cost of the instructions of the latest
+	// block of every skipchain.
+	blockCosts *blockCosts
+
+	// darcVersions indexes the versions of the darcs of every skipchain.
+	darcVersions *darcVersions
+
 	closed        bool
 	closedMutex   sync.Mutex
 	working       sync.WaitGroup
@@ -140,8 +364,20 @@ type Service struct {
 	catchingUp            bool
 	catchingUpHistory     map[string]time.Time
 	catchingUpHistoryLock sync.Mutex
-
-	downloadState downloadState
+	// storeFailures holds, per chain, the index of the block that couldn't
+	// be applied to the state. No further block of these chains is
+	// applied. It is protected by updateTrieLock.
+	storeFailures map[string]int
+	// observing holds the chains whose blocks are being fetched by this
+	// node as an observer. It is protected by updateTrieLock.
+	observing map[string]bool
+
+	// downloads are the downloads of the state this node serves to other
+	// nodes, indexed by their nonce. It is protected by updateTrieLock.
+	downloads map[uint64]*downloadState
+	// downloadProgress, if set, is called by downloadDB after every chunk
+	// of the state it received.
+	downloadProgress func(skipchain.SkipBlockID, DownloadProgress)
 }
 
 type downloadState struct {
@@ -149,8 +385,41 @@ type downloadState struct {
 	nonce uint64
 	read  chan DBKeyValue
 	stop  chan bool
+	// ended is closed once all the entries have been read, or the
+	// download has been stopped or timed out.
+	ended chan bool
+	// total is the number of entries of the state, and served the number
+	// of entries sent so far.
+	total  int
+	served int
 }
 
+// active returns false once the download ended.
+func (ds *downloadState) active() bool {
+	select {
+	case <-ds.ended:
+		return false
+	default:
+		return true
+	}
+}
+
+// DownloadProgress tells how much of the state has been received by a node
+// downloading it from another node.
+type DownloadProgress struct {
+	// Entries is the number of DBKeyValues received so far.
+	Entries int
+	// Total is the number of DBKeyValues of the state, or 0 if the other
+	// node didn't tell it.
+	Total int
+	// Bytes is the size of the keys and values received so far.
+	Bytes int
+}
+
+// downloadLogInterval is the minimal time between two log messages about the
+// progress of a download of the state.
+const downloadLogInterval = 10 * time.Second
+
 // storageID reflects the data we're storing - we could store more
 // than one structure.
 var storageID = []byte("ByzCoin")
@@ -178,14 +447,8 @@ type bcStorage struct {
 // structure.
 func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 	*CreateGenesisBlockResponse, error) {
-	// We use a big mutex here because we do not want to allow concurrent
-	// creation of genesis blocks.
-	// TODO an optimisation would be to lock on the skipchainID.
-	s.createSkipChainMut.Lock()
-	defer s.createSkipChainMut.Unlock()
-
-	if req.Version != CurrentVersion {
-		return nil, fmt.Errorf("version mismatch - got %d but need %d", req.Version, CurrentVersion)
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
 	}
 	if req.Roster.List == nil {
 		return nil, errors.New("must provide a roster")
@@ -217,6 +480,17 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 		return nil, err
 	}
 
+	// The skipchain ID is not known before the genesis block is created,
+	// so lock on the roster and the genesis darc of the would-be chain.
+	// Different chains can be created concurrently.
+	rosterHash := sha256.Sum256(rosterBuf)
+	h := sha256.New()
+	h.Write(rosterHash[:])
+	h.Write(req.GenesisDarc.GetBaseID())
+	lockKey := h.Sum(nil)
+	s.createSkipChainLocks.lock(lockKey)
+	defer s.createSkipChainLocks.unlock(lockKey)
+
 	// The user must include at least one contract that can be parsed as a
 	// DARC and it must exist.
 	if len(req.DarcContractIDs) == 0 {
@@ -274,8 +548,8 @@ func (s *Service) CreateGenesisBlock(req *CreateGenesisBlock) (
 
 // AddTransaction requests to apply a new transaction to the ledger.
 func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
-	if req.Version != CurrentVersion {
-		return nil, errors.New("version mismatch")
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
 	}
 
 	if len(req.Transaction.Instructions) == 0 {
@@ -306,11 +580,34 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 	if txsz > maxsz {
 		return nil, errors.New("transaction too large")
 	}
+	if config, err := s.LoadConfig(req.SkipchainID); err == nil {
+		if err := config.checkInstructions(len(req.Transaction.Instructions)); err != nil {
+			return nil, err
+		}
+	}
 
 	for i, instr := range req.Transaction.Instructions {
 		log.Lvlf2("Instruction[%d]: %s", i, instr.Action())
 	}
 
+	if req.MaxWait < 0 {
+		return nil, errors.New("the maximum wait must not be negative")
+	}
+
+	ctxHash := req.Transaction.Instructions.Hash()
+	var token string
+	if len(req.IdempotencyToken) > 0 {
+		if len(req.IdempotencyToken) > maxTxTokenLength {
+			return nil, fmt.Errorf("idempotency token is longer than %d bytes", maxTxTokenLength)
+		}
+		// The skipchain ID has a fixed length, so the keys of different
+		// chains cannot collide.
+		token = string(req.SkipchainID) + string(req.IdempotencyToken)
+		if _, ok := s.txTokens.getOrAdd(token, ctxHash); ok {
+			return s.retryTransaction(req, token, ctxHash)
+		}
+	}
+
 	// Note to my future self: s.txBuffer.add used to be out here. It used to work
 	// even. But while investigating other race conditions, we realized that
 	// IF there will be a wait channel, THEN it must exist before the call to add().
@@ -319,14 +616,19 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 	// add() after createWaitChannel() solves this, but then we need a second add() for the
 	// no inclusion wait case.
 
+	var results [][]byte
 	if req.InclusionWait > 0 {
 		// Wait for InclusionWait new blocks and look if our transaction is in it.
 		interval, _, err := s.LoadBlockInfo(req.SkipchainID)
 		if err != nil {
+			// The transaction is not buffered, so a retry must be able
+			// to add it.
+			if token != "" {
+				s.txTokens.remove(token)
+			}
 			return nil, errors.New("couldn't get block info: " + err.Error())
 		}
 
-		ctxHash := req.Transaction.Instructions.Hash()
 		ch := s.notifications.createWaitChannel(ctxHash)
 		defer s.notifications.deleteWaitChannel(ctxHash)
 
@@ -339,17 +641,18 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		// In case we don't have any blocks, because there are no transactions,
 		// have a hard timeout in twice the minimal expected time to create the
 		// blocks.
-		tooLongDur := time.Duration(req.InclusionWait) * interval * 2
+		tooLongDur := inclusionTimeout(req, interval)
 		tooLong := time.After(tooLongDur)
 
 		blocksLeft := req.InclusionWait
 
 		for found := false; !found; {
 			select {
-			case success := <-ch:
-				if !success {
-					return nil, errors.New("transaction is in block, but got refused")
+			case res := <-ch:
+				if !res.Accepted {
+					return refusedTxReply(req, s.txRejections.get(ctxHash))
 				}
+				results = res.Results
 				found = true
 			case id := <-blockCh:
 				if id.Equal(req.SkipchainID) {
@@ -359,7 +662,7 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 					return nil, fmt.Errorf("did not find transaction after %v blocks", req.InclusionWait)
 				}
 			case <-tooLong:
-				return nil, fmt.Errorf("transaction didn't get included after %v (2 * t_block * %d)", tooLongDur, req.InclusionWait)
+				return nil, inclusionTimeoutError(req, tooLongDur)
 			}
 		}
 	} else {
@@ -368,6 +671,117 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 
 	return &AddTxResponse{
 		Version: CurrentVersion,
+		Results: results,
+		Pending: req.InclusionWait <= 0,
+	}, nil
+}
+
+// retryTransaction answers a request whose idempotency token is already known
+// with the outcome of the transaction sent with it. If it is still pending,
+// the transaction is added again, in case the first copy has been lost, and
+// the reply waits for it like the first request did. The signer counters make
+// sure that it is applied only once.
+func (s *Service) retryTransaction(req *AddTxRequest, token string, ctxHash []byte) (*AddTxResponse, error) {
+	// Register before looking at the token, so that no block is missed.
+	blockCh := make(chan skipchain.SkipBlockID, 10)
+	z := s.notifications.registerForBlocks(blockCh)
+	defer s.notifications.unregisterForBlocks(z)
+
+	var tooLong <-chan time.Time
+	var tooLongDur time.Duration
+	if req.InclusionWait > 0 {
+		interval, _, err := s.LoadBlockInfo(req.SkipchainID)
+		if err != nil {
+			return nil, errors.New("couldn't get block info: " + err.Error())
+		}
+		tooLongDur = inclusionTimeout(req, interval)
+		tooLong = time.After(tooLongDur)
+	}
+
+	blocksLeft := req.InclusionWait
+	resent := false
+	for {
+		tok, ok := s.txTokens.get(token)
+		if !ok {
+			return nil, errors.New("idempotency token expired while waiting for the transaction")
+		}
+		if !bytes.Equal(tok.ctxHash, ctxHash) {
+			return nil, errors.New("idempotency token is already used for another transaction")
+		}
+		if tok.pending && !resent {
+			s.txBuffer.add(string(req.SkipchainID), req.Transaction)
+			resent = true
+		}
+		switch {
+		case !tok.pending && !tok.accepted:
+			return refusedTxReply(req, tok.reason)
+		case !tok.pending:
+			return &AddTxResponse{
+				Version: CurrentVersion,
+				Results: tok.results,
+			}, nil
+		case req.InclusionWait <= 0:
+			return &AddTxResponse{
+				Version: CurrentVersion,
+				Pending: true,
+			}, nil
+		case blocksLeft == 0:
+			return nil, fmt.Errorf("did not find transaction after %v blocks", req.InclusionWait)
+		}
+
+		select {
+		case id := <-blockCh:
+			if id.Equal(req.SkipchainID) {
+				blocksLeft--
+			}
+		case <-tooLong:
+			return nil, inclusionTimeoutError(req, tooLongDur)
+		}
+	}
+}
+
+// inclusionTimeout returns how long the request waits for the inclusion of
+// its transaction, given the block interval of the chain.
+func inclusionTimeout(req *AddTxRequest, interval time.Duration) time.Duration {
+	if req.MaxWait > 0 {
+		return req.MaxWait
+	}
+	return time.Duration(req.InclusionWait) * interval * 2
+}
+
+func inclusionTimeoutError(req *AddTxRequest, dur time.Duration) error {
+	if req.MaxWait > 0 {
+		return fmt.Errorf("transaction didn't get included after %v (maximum wait)", dur)
+	}
+	return fmt.Errorf("transaction didn't get included after %v (2 * t_block * %d)", dur, req.InclusionWait)
+}
+
+// refusedTxError returns the error for a transaction that is in a block but
+// has been refused, with the reason if it is known.
+func refusedTxError(reason error) error {
+	if reason != nil {
+		return fmt.Errorf("transaction is in block, but got refused: %s", reason)
+	}
+	return errors.New("transaction is in block, but got refused")
+}
+
+// refusedTxReply answers req for a transaction that is in a block but has
+// been refused, with the reason if it is known. The refusal is only put in
+// the reply if the client asked for it, the others get an error.
+func refusedTxReply(req *AddTxRequest, reason error) (*AddTxResponse, error) {
+	if !req.ReplyRefusal {
+		return nil, refusedTxError(reason)
+	}
+	refusal := &TxRefusal{Instruction: -1}
+	if ie, ok := reason.(InstructionError); ok {
+		refusal.Instruction = ie.Index
+		refusal.Reason = ie.Err.Error()
+	} else if reason != nil {
+		refusal.Reason = reason.Error()
+	}
+	return &AddTxResponse{
+		Version: CurrentVersion,
+		Refused: refusal,
 	}, nil
 }
 
@@ -379,8 +793,8 @@ func (s *Service) GetProof(req *GetProof) (resp *GetProofResponse, err error) {
 	if s.catchingUp {
 		return nil, errors.New("currently catching up on our state")
 	}
-	if req.Version != CurrentVersion {
-		return nil, errors.New("version mismatch")
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
 	}
 
 	log.Lvlf2("Returning proof for %x from chain '%x'", req.Key, req.ID)
@@ -414,12 +828,114 @@ func (s *Service) GetProof(req *GetProof) (resp *GetProofResponse, err error) {
 	return
 }
 
+// GetProofs returns the proofs of several keys, all computed with the same
+// state of the trie. The keys whose proof cannot be computed are listed in
+// the errors of the response, instead of failing the whole request.
+func (s *Service) GetProofs(req *GetProofs) (*GetProofsResponse, error) {
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
+	}
+	if len(req.Keys) == 0 {
+		return nil, errors.New("no keys given")
+	}
+	if len(req.Keys) > getProofsMaxKeys {
+		return nil, fmt.Errorf("%d keys given, but at most %d are allowed", len(req.Keys), getProofsMaxKeys)
+	}
+	seen := make(map[string]bool)
+	for _, key := range req.Keys {
+		if seen[string(key)] {
+			return nil, fmt.Errorf("key %x is given twice", key)
+		}
+		seen[string(key)] = true
+	}
+
+	// No block must be applied between the proofs.
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, errors.New("currently catching up on our state")
+	}
+
+	log.Lvlf2("Returning %d proofs from chain '%x'", len(req.Keys), req.ID)
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, errors.New("cannot find skipblock while getting proofs")
+	}
+	st, err := s.GetReadOnlyStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, err
+	}
+	resp := &GetProofsResponse{Version: CurrentVersion}
+	for i, key := range req.Keys {
+		proof, err := NewProof(st, s.db(), req.ID, key)
+		if err == nil {
+			err = proof.Verify(sb.SkipChainID())
+		}
+		if err != nil {
+			resp.Errors = append(resp.Errors, GetProofsError{Index: i, Error: err.Error()})
+			continue
+		}
+		resp.Proofs = append(resp.Proofs, *proof)
+	}
+	return resp, nil
+}
+
+// GetChainConfig returns the chain config of the latest block, with the proof
+// of the config instance. The trie must be up to date with the latest block
+// known to this node.
+func (s *Service) GetChainConfig(req *GetChainConfig) (*GetChainConfigResponse, error) {
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
+	}
+
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, errors.New("currently catching up on our state")
+	}
+
+	log.Lvlf2("Returning chain config of chain '%x'", req.ByzCoinID)
+
+	latest, err := s.db().GetLatestByID(req.ByzCoinID)
+	if err != nil {
+		return nil, errors.New("cannot find the latest block: " + err.Error())
+	}
+	st, err := s.GetReadOnlyStateTrie(req.ByzCoinID)
+	if err != nil {
+		return nil, err
+	}
+	if st.GetIndex() != latest.Index {
+		return nil, fmt.Errorf("the trie is at block %d, but the latest block is %d",
+			st.GetIndex(), latest.Index)
+	}
+	proof, err := NewProof(st, s.db(), req.ByzCoinID, ConfigInstanceID.Slice())
+	if err != nil {
+		return nil, err
+	}
+	if err = proof.Verify(req.ByzCoinID); err != nil {
+		return nil, err
+	}
+	if !proof.Latest.Hash.Equal(latest.Hash) {
+		return nil, errors.New("the proof doesn't end with the latest block")
+	}
+	config, err := LoadConfigFromTrie(st)
+	if err != nil {
+		return nil, err
+	}
+	return &GetChainConfigResponse{
+		Version: CurrentVersion,
+		Config:  *config,
+		Proof:   *proof,
+	}, nil
+}
+
 // CheckAuthorization verifies whether a given combination of identities can
 // fulfill a given rule of a given darc. Because all darcs are now used in
 // an online fashion, we need to offer this check.
 func (s *Service) CheckAuthorization(req *CheckAuthorization) (resp *CheckAuthorizationResponse, err error) {
-	if req.Version != CurrentVersion {
-		return nil, errors.New("version mismatch")
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
 	}
 	log.Lvlf2("%s getting authorizations of darc %x", s.ServerIdentity(), req.DarcID)
 
@@ -432,21 +948,30 @@ func (s *Service) CheckAuthorization(req *CheckAuthorization) (resp *CheckAuthor
 	if err != nil {
 		return nil, errors.New("couldn't find darc: " + err.Error())
 	}
-	getDarcs := func(s string, latest bool) *darc.Darc {
-		if !latest {
-			log.Error("cannot handle intermediate darcs")
-			return nil
-		}
-		id, err := hex.DecodeString(strings.Replace(s, "darc:", "", 1))
+	getDarcs := func(str string, latest bool) *darc.Darc {
+		id, err := hex.DecodeString(strings.Replace(str, "darc:", "", 1))
 		if err != nil || len(id) != 32 {
-			log.Error("invalid darc id", s, len(id), err)
+			log.Error("invalid darc id", str, len(id), err)
 			return nil
 		}
-		d, err := LoadDarcFromTrie(st, id)
+		if latest {
+			if d, err := LoadDarcFromTrie(st, id); err == nil {
+				return d
+			}
+		}
+		// The ID is the one of an intermediate version of the darc.
+		d, err := s.loadDarcVersion(req.ByzCoinID, st, id)
 		if err != nil {
-			log.Error("didn't find darc")
+			log.Error("didn't find darc:", err)
 			return nil
 		}
+		if latest {
+			d, err = LoadDarcFromTrie(st, d.GetBaseID())
+			if err != nil {
+				log.Error("didn't find darc:", err)
+				return nil
+			}
+		}
 		return d
 	}
 	var ids []string
@@ -462,7 +987,42 @@ func (s *Service) CheckAuthorization(req *CheckAuthorization) (resp *CheckAuthor
 	return resp, nil
 }
 
-// GetSignerCounters gets the latest signer counters for the given identities.
+// loadDarcVersion returns the version of a darc whose ID is given, which
+// is not necessarily the latest one. The versions that are not in the trie
+// are read from the history of the darcs, so the history must be enabled.
+// Versions other than the first one are found through an index of all the
+// versions of the darcs.
+func (s *Service) loadDarcVersion(scID skipchain.SkipBlockID, st ReadOnlyStateTrie, id darc.ID) (*darc.Darc, error) {
+	baseID, version := id, uint64(0)
+	d, err := LoadDarcFromTrie(st, id)
+	if err != nil {
+		v, err := s.findDarcVersion(scID, st, id)
+		if err != nil {
+			return nil, err
+		}
+		baseID, version = v.baseID, v.version
+		d, err = LoadDarcFromTrie(st, baseID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.GetID().Equal(id) {
+		return d, nil
+	}
+	// The darc has evolved since that version.
+	sce, ok, err := s.stateChangeStorage.getByVersion(baseID, version, scID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("version %d of darc %x is not in the history", version, baseID)
+	}
+	return darc.NewFromProtobuf(sce.StateChange.Value)
+}
+
+// GetSignerCounters gets the latest signer counters for the given identities,
+// in the same order. The counter of an identity that never signed an
+// instruction is 0.
 func (s *Service) GetSignerCounters(req *GetSignerCounters) (*GetSignerCountersResponse, error) {
 	st, err := s.GetReadOnlyStateTrie(req.SkipchainID)
 	if err != nil {
@@ -498,27 +1058,58 @@ func (s *Service) DownloadState(req *DownloadState) (resp *DownloadStateResponse
 		return nil, errors.New("length must be bigger than 0")
 	}
 
+	var total int
+	ds := s.downloads[req.Nonce]
 	if req.Nonce == 0 {
 		log.Lvl2("Creating new download")
-		if !s.downloadState.id.IsNull() {
-			log.Lvlf2("Aborting download of nonce %x", s.downloadState.nonce)
-			close(s.downloadState.stop)
+		if err := s.freeDownloadSlot(); err != nil {
+			return nil, err
 		}
 		sb := s.db().GetByID(req.ByzCoinID)
 		if sb == nil || sb.Index > 0 {
 			return nil, errors.New("unknown byzcoinID")
 		}
-		s.downloadState.id = req.ByzCoinID
-		s.downloadState.read = make(chan DBKeyValue)
-		s.downloadState.stop = make(chan bool)
+		var db *bbolt.DB
+		var bucketName []byte
+		db, bucketName, err = s.trieBucket(fmt.Sprintf("%x", req.ByzCoinID))
+		if err != nil {
+			return nil, err
+		}
+		err = db.View(func(tx *bbolt.Tx) error {
+			total = tx.Bucket(bucketName).Stats().KeyN
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 		nonce := binary.LittleEndian.Uint64(random.Bits(64, true, random.New()))
-		s.downloadState.nonce = nonce
-		go func(ds downloadState) {
-			idStr := fmt.Sprintf("%x", ds.id)
-			db, bucketName := s.GetAdditionalBucket([]byte(idStr))
+		for nonce == 0 || s.downloads[nonce] != nil {
+			nonce = binary.LittleEndian.Uint64(random.Bits(64, true, random.New()))
+		}
+		ds = &downloadState{
+			id:    req.ByzCoinID,
+			nonce: nonce,
+			read:  make(chan DBKeyValue),
+			stop:  make(chan bool),
+			ended: make(chan bool),
+			total: total,
+		}
+		if s.downloads == nil {
+			s.downloads = make(map[uint64]*downloadState)
+		}
+		s.downloads[nonce] = ds
+		startKey := req.StartKey
+		go func(ds *downloadState) {
 			err := db.View(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				return bucket.ForEach(func(k []byte, v []byte) error {
+				c := tx.Bucket(bucketName).Cursor()
+				k, v := c.First()
+				if startKey != nil {
+					k, v = c.Seek(startKey)
+					if bytes.Equal(k, startKey) {
+						k, v = c.Next()
+					}
+				}
+				for ; k != nil; k, v = c.Next() {
 					key := make([]byte, len(k))
 					copy(key, k)
 					value := make([]byte, len(v))
@@ -530,41 +1121,122 @@ func (s *Service) DownloadState(req *DownloadState) (resp *DownloadStateResponse
 					case <-time.After(time.Minute):
 						return errors.New("timed out while waiting for next read")
 					}
-					return nil
-				})
+				}
+				return nil
 			})
 			if err != nil {
-				log.Error("while serving current database:", err)
+				log.Errorf("while serving download %x of the database: %v", ds.nonce, err)
 			}
+			// ended is closed first, so that the download is not
+			// active anymore once its last entry has been read.
+			close(ds.ended)
 			close(ds.read)
-		}(s.downloadState)
-	} else if !s.downloadState.id.Equal(req.ByzCoinID) || req.Nonce != s.downloadState.nonce {
-		return nil, errors.New("download has been aborted in favor of another download")
+		}(ds)
+	} else if ds == nil || !ds.id.Equal(req.ByzCoinID) {
+		return nil, errors.New("unknown download, it has been cancelled or replaced")
 	}
 
 	resp = &DownloadStateResponse{
-		Nonce: s.downloadState.nonce,
+		Nonce: ds.nonce,
+		Total: total,
 	}
-query:
 	for i := 0; i < req.Length; i++ {
-		select {
-		case kv, ok := <-s.downloadState.read:
-			if !ok {
-				break query
-			}
-			resp.KeyValues = append(resp.KeyValues, kv)
+		kv, ok := <-ds.read
+		if !ok {
+			break
 		}
+		resp.KeyValues = append(resp.KeyValues, kv)
+		ds.served++
 	}
 	return
 }
 
-func entryToResponse(sce *StateChangeEntry, ok bool, err error) (*GetInstanceVersionResponse, error) {
-	if !ok {
-		err = errKeyNotSet
+// freeDownloadSlot makes sure that a new download can be served, by removing
+// the downloads that ended if there are already maxDownloads of them. It
+// returns an error if all of them are still active. The caller must hold
+// updateTrieLock.
+func (s *Service) freeDownloadSlot() error {
+	if len(s.downloads) < maxDownloads {
+		return nil
+	}
+	for nonce, ds := range s.downloads {
+		if !ds.active() {
+			delete(s.downloads, nonce)
+		}
+	}
+	if len(s.downloads) >= maxDownloads {
+		return fmt.Errorf("already serving %d downloads, try again later", len(s.downloads))
+	}
+	return nil
+}
+
+// stopDownload stops the download with the given nonce and frees its slot.
+// The caller must hold updateTrieLock.
+func (s *Service) stopDownload(nonce uint64) {
+	ds := s.downloads[nonce]
+	if ds == nil {
+		return
+	}
+	close(ds.stop)
+	delete(s.downloads, nonce)
+}
+
+// GetDownloadStatus returns the downloads of the state this node is serving
+// to other nodes, sorted by nonce.
+func (s *Service) GetDownloadStatus(req *GetDownloadStatus) (*GetDownloadStatusResponse, error) {
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	resp := &GetDownloadStatusResponse{}
+	for _, ds := range s.downloads {
+		resp.Downloads = append(resp.Downloads, DownloadStatus{
+			Active:    ds.active(),
+			ByzCoinID: ds.id,
+			Nonce:     ds.nonce,
+			Served:    ds.served,
+			Total:     ds.total,
+		})
+	}
+	sort.Slice(resp.Downloads, func(i, j int) bool {
+		return resp.Downloads[i].Nonce < resp.Downloads[j].Nonce
+	})
+	return resp, nil
+}
+
+// CancelDownload stops the downloads of the state this node is serving, so
+// that new downloads can start. If the nonce of the request is not 0, only the
+// download with this nonce is stopped.
+func (s *Service) CancelDownload(req *CancelDownload) (*CancelDownloadResponse, error) {
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if len(s.downloads) == 0 {
+		return nil, errors.New("no download to cancel")
 	}
+	resp := &CancelDownloadResponse{}
+	if req.Nonce != 0 {
+		if s.downloads[req.Nonce] == nil {
+			return nil, fmt.Errorf("no download with nonce %x", req.Nonce)
+		}
+		resp.Nonces = []uint64{req.Nonce}
+	} else {
+		for nonce := range s.downloads {
+			resp.Nonces = append(resp.Nonces, nonce)
+		}
+		sort.Slice(resp.Nonces, func(i, j int) bool { return resp.Nonces[i] < resp.Nonces[j] })
+	}
+	for _, nonce := range resp.Nonces {
+		log.Lvlf2("Cancelling download of nonce %x", nonce)
+		s.stopDownload(nonce)
+	}
+	return resp, nil
+}
+
+func entryToResponse(sce *StateChangeEntry, ok bool, err error) (*GetInstanceVersionResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		return nil, errKeyNotSet
+	}
 
 	return &GetInstanceVersionResponse{
 		StateChange: sce.StateChange,
@@ -588,6 +1260,26 @@ func (s *Service) GetLastInstanceVersion(req *GetLastInstanceVersion) (*GetInsta
 	return entryToResponse(&sce, ok, err)
 }
 
+// PruneStateChanges removes the old state changes of the history of the
+// instances of a chain, but keeps the latest state change of every instance.
+// The blocks keep being processed, their state changes are stored before or
+// after the pruning.
+func (s *Service) PruneStateChanges(req *PruneStateChanges) (*PruneStateChangesResponse, error) {
+	if sb := s.db().GetByID(req.ByzCoinID); sb == nil || sb.Index != 0 {
+		return nil, errors.New("unknown byzcoinID")
+	}
+	if req.BlockIndex <= 0 {
+		return nil, errors.New("the block index must be bigger than 0")
+	}
+	removed, err := s.stateChangeStorage.prune(req.ByzCoinID, req.BlockIndex)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't prune the state changes: %v", err)
+	}
+	log.Lvlf2("%s: removed %d state changes before block %d of %x", s.ServerIdentity(),
+		removed, req.BlockIndex, req.ByzCoinID)
+	return &PruneStateChangesResponse{Removed: removed}, nil
+}
+
 // GetAllInstanceVersion looks for all the state changes of an instance
 // and responds with both the state change and the block index for
 // each version
@@ -611,12 +1303,12 @@ func (s *Service) GetAllInstanceVersion(req *GetAllInstanceVersion) (res *GetAll
 // one stored in the block
 func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*CheckStateChangeValidityResponse, error) {
 	sce, ok, err := s.stateChangeStorage.getByVersion(req.InstanceID[:], req.Version, req.SkipChainID)
-	if !ok {
-		err = errKeyNotSet
-	}
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		return nil, errKeyNotSet
+	}
 
 	sb, err := s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
 		Genesis: req.SkipChainID,
@@ -642,6 +1334,157 @@ func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*Chec
 	}, nil
 }
 
+// GetVersion returns the versions of the requests this node accepts, and the
+// versions of its contracts.
+func (s *Service) GetVersion(req *GetVersion) (*GetVersionResponse, error) {
+	resp := &GetVersionResponse{
+		Current: CurrentVersion,
+		Min:     MinVersion,
+	}
+	s.contractVersionsMut.Lock()
+	for id, v := range s.contractVersions {
+		resp.ContractVersions = append(resp.ContractVersions, ContractVersion{ContractID: id, Version: v})
+	}
+	s.contractVersionsMut.Unlock()
+	sort.Slice(resp.ContractVersions, func(i, j int) bool {
+		return resp.ContractVersions[i].ContractID < resp.ContractVersions[j].ContractID
+	})
+	for id := range s.contracts {
+		resp.ContractIDs = append(resp.ContractIDs, id)
+	}
+	sort.Strings(resp.ContractIDs)
+	return resp, nil
+}
+
+// Exists returns whether the key is in the state trie, without the proof
+// GetProof would return. A soft deleted instance still exists, as its
+// tombstone is in the trie.
+func (s *Service) Exists(req *Exists) (*ExistsResponse, error) {
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, errors.New("currently catching up on our state")
+	}
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
+	}
+
+	st, err := s.getStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, err
+	}
+	_, _, _, _, err = st.GetValues(req.Key)
+	if err != nil && err != errKeyNotSet {
+		return nil, err
+	}
+	return &ExistsResponse{
+		Version: CurrentVersion,
+		Exists:  err == nil,
+		Index:   st.GetIndex(),
+	}, nil
+}
+
+// GetInstancesByDarc returns the IDs of the instances that are controlled by
+// the given darc. It goes through the whole state trie, so the order of the
+// instances only stays the same as long as no instance is added or removed.
+func (s *Service) GetInstancesByDarc(req *GetInstancesByDarc) (*GetInstancesByDarcResponse, error) {
+	if req.Start < 0 {
+		return nil, errors.New("start must not be negative")
+	}
+	if req.Length <= 0 {
+		return nil, errors.New("length must be bigger than 0")
+	}
+	st, err := s.getStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetInstancesByDarcResponse{}
+	errPageFull := errors.New("page is full")
+	add := func(k, v []byte) error {
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			// Not all key/value pairs are valid statechanges
+			return nil
+		}
+		// The tombstones of soft deleted instances are not listed.
+		if body.Deleted || !body.DarcID.Equal(req.DarcID) {
+			return nil
+		}
+		if req.StartKey != nil {
+			resp.InstanceIDs = append(resp.InstanceIDs, NewInstanceID(k))
+			if len(resp.InstanceIDs) == req.Length {
+				return errPageFull
+			}
+			return nil
+		}
+		if resp.Total >= req.Start && len(resp.InstanceIDs) < req.Length {
+			resp.InstanceIDs = append(resp.InstanceIDs, NewInstanceID(k))
+		}
+		resp.Total++
+		return nil
+	}
+	if req.StartKey != nil {
+		err = st.ForEachAfter(req.StartKey, add)
+	} else {
+		err = st.ForEach(add)
+	}
+	if err != nil && err != errPageFull {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// coinContractID is the ID of the coin contract of the contracts package,
+// which imports this package.
+const coinContractID = "coin"
+
+// GetCoinSupply returns the sum of the coins of the given type held by all
+// the coin instances. It goes through the whole state trie, and starts again
+// if a block is added meanwhile, so that the sum is the one of the block of
+// the returned index.
+func (s *Service) GetCoinSupply(req *GetCoinSupply) (*GetCoinSupplyResponse, error) {
+	if err := checkVersion(req.Version); err != nil {
+		return nil, err
+	}
+	st, err := s.getStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	for try := 0; try < 3; try++ {
+		index := st.GetIndex()
+		resp := &GetCoinSupplyResponse{Version: CurrentVersion, Index: index}
+		supply := Coin{Name: req.CoinType}
+		err = st.ForEach(func(k, v []byte) error {
+			body, err := decodeStateChangeBody(v)
+			if err != nil || body.Deleted || body.ContractID != coinContractID {
+				return nil
+			}
+			var c Coin
+			if err := protobuf.Decode(body.Value, &c); err != nil {
+				return fmt.Errorf("couldn't decode coin instance %x: %v", k, err)
+			}
+			if !c.Name.Equal(req.CoinType) {
+				return nil
+			}
+			if err := supply.SafeAdd(c.Value); err != nil {
+				return errors.New("the supply doesn't fit in 64 bits")
+			}
+			resp.Accounts++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if st.GetIndex() == index {
+			resp.Total = supply.Value
+			return resp, nil
+		}
+	}
+	return nil, errors.New("the blocks are added faster than the state can be read")
+}
+
 type leafNode struct {
 	Prefix []bool
 	Key    []byte
@@ -652,7 +1495,10 @@ type leafNode struct {
 // we normally get from embedding onet.ServiceProcessor in order to
 // hook it and get a look at the http.Request.
 func (s *Service) ProcessClientRequest(req *http.Request, path string, buf []byte) ([]byte, *onet.StreamingTunnel, error) {
-	if path == "Debug" {
+	// The path is the name of the request type.
+	switch path {
+	case "DebugRequest", "GetDownloadStatus", "CancelDownload", "DebugSetLogRequest", "DebugReplayRequest",
+		"DebugBisectRequest", "DebugReconcileRequest", "PruneStateChanges":
 		h, _, err := net.SplitHostPort(req.RemoteAddr)
 		if err != nil {
 			return nil, nil, err
@@ -660,7 +1506,7 @@ func (s *Service) ProcessClientRequest(req *http.Request, path string, buf []byt
 		ip := net.ParseIP(h)
 
 		if !ip.IsLoopback() {
-			return nil, nil, errors.New("the 'debug'-endpoint is only allowed on loopback")
+			return nil, nil, fmt.Errorf("the '%s'-endpoint is only allowed on loopback", path)
 		}
 	}
 
@@ -711,7 +1557,8 @@ func (s *Service) Debug(req *DebugRequest) (resp *DebugResponse, err error) {
 					}
 					scb := StateChangeBody{}
 					err = protobuf.Decode(ln.Value, &scb)
-					resp.Dump = append(resp.Dump, DebugResponseState{Key: ln.Key, State: scb})
+					resp.Dump = append(resp.Dump, DebugResponseState{Key: ln.Key, State: scb,
+						Description: s.describeInstance(scb)})
 				}
 			}
 			return nil
@@ -721,6 +1568,18 @@ func (s *Service) Debug(req *DebugRequest) (resp *DebugResponse, err error) {
 	return
 }
 
+// DebugSetLog sets the debug level of a subsystem and returns the levels of
+// all of them.
+func (s *Service) DebugSetLog(req *DebugSetLogRequest) (*DebugSetLogResponse, error) {
+	if req.Subsystem != "" {
+		if err := setLogLevel(req.Subsystem, req.Level); err != nil {
+			return nil, err
+		}
+		log.Lvlf1("%s: log level of %s set to %d", s.ServerIdentity(), req.Subsystem, req.Level)
+	}
+	return &DebugSetLogResponse{Levels: getLogLevels()}, nil
+}
+
 // DebugRemove deletes an existing byzcoin-instance from the conode.
 func (s *Service) DebugRemove(req *DebugRemoveRequest) (*DebugResponse, error) {
 	if err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public, req.ByzCoinID, req.Signature); err != nil {
@@ -747,19 +1606,12 @@ func (s *Service) DebugRemove(req *DebugRemoveRequest) (*DebugResponse, error) {
 	_, exists = s.stateTries[idStrHex]
 	if exists {
 		log.Lvl2("Removing state-trie")
-		db, bn := s.GetAdditionalBucket([]byte(idStrHex))
-		if db == nil {
-			return nil, errors.New("didn't find trie for this byzcoin-ID")
-		}
-		err := db.Update(func(tx *bbolt.Tx) error {
-			return tx.DeleteBucket(bn)
-		})
-		if err != nil {
+		if err := s.removeTrieBucket(idStrHex); err != nil {
+			s.stateTriesLock.Unlock()
 			return nil, err
 		}
-		delete(s.stateTries, idStr)
-		err = s.db().RemoveSkipchain(req.ByzCoinID)
-		if err != nil {
+		delete(s.stateTries, idStrHex)
+		if err := s.db().RemoveSkipchain(req.ByzCoinID); err != nil {
 			log.Error("couldn't remove the whole chain:", err)
 		}
 	}
@@ -801,6 +1653,13 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 	var mr []byte
 	var sst *stagingStateTrie
 
+	// The refused transactions are stored in the block too, so a batch
+	// where all the transactions fail still gives a block. Only an empty
+	// batch is an error.
+	if len(tx) == 0 {
+		return nil, errors.New("no transactions")
+	}
+
 	if scID.IsNull() {
 		// For a genesis block, we create a throwaway staging trie.
 		// There is no need to verify the darc because the caller does
@@ -832,7 +1691,7 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 			return nil, errors.New(
 				"Could not get latest block from the skipchain: " + err.Error())
 		}
-		log.Lvlf3("Creating block #%d with %d transactions", sbLatest.Index+1,
+		logBlock.printf(3, "Creating block #%d with %d transactions", sbLatest.Index+1,
 			len(tx))
 		sb = sbLatest.Copy()
 
@@ -848,11 +1707,8 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 	var err error
 	var txRes TxResults
 
-	log.Lvl3("Creating state changes")
-	mr, txRes, scs, _ = s.createStateChanges(sst, scID, tx, noTimeout)
-	if len(txRes) == 0 {
-		return nil, errors.New("no transactions")
-	}
+	logBlock.print(3, "Creating state changes")
+	mr, txRes, scs, _, _ = s.createStateChanges(sst, scID, tx, noTimeout)
 
 	// Store transactions in the body
 	body := &DataBody{TxResults: txRes}
@@ -860,12 +1716,24 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 	if err != nil {
 		return nil, errors.New("Couldn't marshal data: " + err.Error())
 	}
-
+
+	// The followers refuse a block that is not newer than the previous
+	// one, even if the clock of the leader is late.
+	timestamp := time.Now().UnixNano()
+	if !scID.IsNull() {
+		prev, err := blockTimestamp(sb)
+		if err != nil {
+			return nil, err
+		}
+		if timestamp <= prev {
+			timestamp = prev + 1
+		}
+	}
 	header := &DataHeader{
 		TrieRoot:              mr,
 		ClientTransactionHash: txRes.Hash(),
 		StateChangesHash:      scs.Hash(),
-		Timestamp:             time.Now().UnixNano(),
+		Timestamp:             timestamp,
 	}
 	sb.Data, err = protobuf.Encode(header)
 	if err != nil {
@@ -880,13 +1748,13 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		TargetSkipChainID: scID,
 	}
 
-	log.Lvlf3("Storing skipblock with %d transactions.", len(txRes))
+	logBlock.printf(3, "Storing skipblock with %d transactions.", len(txRes))
 	var ssbReply *skipchain.StoreSkipBlockReply
 
 	if sb.Roster.List[0].Equal(s.ServerIdentity()) {
 		ssbReply, err = s.skService().StoreSkipBlockInternal(&ssb)
 	} else {
-		log.Lvl2("Sending new block to other node", sb.Roster.List[0])
+		logBlock.print(2, "Sending new block to other node", sb.Roster.List[0])
 		ssbReply = &skipchain.StoreSkipBlockReply{}
 		err = skipchain.NewClient().SendProtobuf(sb.Roster.List[0], &ssb, ssbReply)
 		if err != nil {
@@ -922,17 +1790,29 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 // sb is a block in the byzcoin instance that we want
 // to download.
 func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
-	log.Lvlf2("%s: downloading DB", s.ServerIdentity())
+	logCatchup.printf(2, "%s: downloading DB", s.ServerIdentity())
 	idStr := fmt.Sprintf("%x", sb.SkipChainID())
 
 	// Loop over all nodes that are not the leader and
 	// not subleaders, to avoid overloading those nodes.
 	nodes := len(sb.Roster.List)
 	subLeaders := int(math.Ceil(math.Pow(float64(nodes), 1./3.)))
-	for ri := 1 + subLeaders; ri < nodes; ri++ {
+	var sources []*network.ServerIdentity
+	if 1+subLeaders < nodes {
+		sources = trustedForCatchup(sb.Roster.List[1+subLeaders:])
+	}
+	// If only the leader or the subleaders are trusted, they have to be
+	// used anyway.
+	if len(sources) == 0 && len(catchupTrusted) > 0 {
+		sources = trustedForCatchup(sb.Roster.List)
+	}
+	for _, si := range sources {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
 		// Create a roster with just the node we want to
 		// download from.
-		roster := onet.NewRoster(sb.Roster.List[ri : ri+1])
+		roster := onet.NewRoster([]*network.ServerIdentity{si})
 
 		err := func() error {
 			// First delete an existing stateTrie. There
@@ -941,11 +1821,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 			_, err := s.getStateTrie(sb.SkipChainID())
 			if err == nil {
 				// Suppose we _do_ have a statetrie
-				db, stBucket := s.GetAdditionalBucket(sb.SkipChainID())
-				err := db.Update(func(tx *bbolt.Tx) error {
-					return tx.DeleteBucket(stBucket)
-				})
-				if err != nil {
+				if err := s.removeTrieBucket(idStr); err != nil {
 					log.Fatal("Cannot delete existing trie while trying to download:", err)
 				}
 				s.stateTriesLock.Lock()
@@ -958,16 +1834,44 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 			var db *bbolt.DB
 			var bucketName []byte
 			var nonce uint64
+			var progress DownloadProgress
+			lastLog := time.Now()
+			// The last key stored, to resume the download after it
+			// if it is interrupted.
+			var lastKey []byte
+			resumes := 0
 			for {
 				// Note: we trust the chain therefore even if the reply is corrupted,
-				// it will be detected by difference in the root hash
-				resp, err := cl.DownloadState(sb.SkipChainID(), nonce, catchupFetchDBEntries)
+				// it will be detected by difference in the root hash. This
+				// is also the case if the state changed between an
+				// interrupted download and its resumption.
+				var resp *DownloadStateResponse
+				var err error
+				if nonce == 0 && lastKey != nil {
+					resp, err = cl.ResumeDownloadState(sb.SkipChainID(), lastKey, catchupFetchDBEntries)
+				} else {
+					resp, err = cl.DownloadState(sb.SkipChainID(), nonce, catchupFetchDBEntries)
+				}
 				if err != nil {
-					return errors.New("cannot download trie: " + err.Error())
+					if lastKey == nil || resumes >= catchupDownloadResumes {
+						return errors.New("cannot download trie: " + err.Error())
+					}
+					resumes++
+					log.Warnf("%s: download of the trie interrupted, resuming after key %x: %v",
+						s.ServerIdentity(), lastKey, err)
+					nonce = 0
+					time.Sleep(time.Second)
+					continue
 				}
 				if db == nil {
-					db, bucketName = s.GetAdditionalBucket([]byte(idStr))
+					db, bucketName, err = s.trieBucket(idStr)
+					if err != nil {
+						return err
+					}
+				}
+				if nonce == 0 {
 					nonce = resp.Nonce
+					progress.Total = resp.Total
 				}
 				// And store all entries in our local database.
 				err = db.Update(func(tx *bbolt.Tx) error {
@@ -983,6 +1887,10 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 				if err != nil {
 					log.Fatal("Couldn't store entries:", err)
 				}
+				s.reportDownload(sb.SkipChainID(), si, &progress, resp.KeyValues, &lastLog)
+				if len(resp.KeyValues) > 0 {
+					lastKey = resp.KeyValues[len(resp.KeyValues)-1].Key
+				}
 				if len(resp.KeyValues) < catchupFetchDBEntries {
 					break
 				}
@@ -994,7 +1902,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 				return errors.New("couldn't load state trie: " + err.Error())
 			}
 			if sb.Index != st.GetIndex() {
-				log.Lvl2("Downloading corresponding block")
+				logCatchup.print(2, "Downloading corresponding block")
 				skCl := skipchain.NewClient()
 				// TODO: add a client API to fetch a specific block and its proof
 				search, err := skCl.GetSingleBlockByIndex(roster, sb.SkipChainID(), st.GetIndex())
@@ -1011,12 +1919,22 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 			if !bytes.Equal(st.GetRoot(), header.TrieRoot) {
 				return errors.New("got wrong database, merkle roots don't work out")
 			}
+			// Don't use a state whose pinned contract versions are
+			// not the ones of the node, as it couldn't apply the next
+			// blocks anyway.
+			config, err := LoadConfigFromTrie(st)
+			if err != nil {
+				return errors.New("couldn't load the config of the state: " + err.Error())
+			}
+			if err := s.checkContractVersions(config); err != nil {
+				return err
+			}
 
 			// Finally initialize the stateTrie using the new database.
 			s.stateTriesLock.Lock()
 			s.stateTries[idStr] = st
 			s.stateTriesLock.Unlock()
-			log.Lvlf1("%s: successfully downloaded database for chain %s", s.ServerIdentity(),
+			logCatchup.printf(1, "%s: successfully downloaded database for chain %s", s.ServerIdentity(),
 				idStr)
 			return nil
 		}()
@@ -1025,9 +1943,37 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		}
 		log.Errorf("Couldn't load database from %s - got error %s", roster.List[0], err)
 	}
+	if len(catchupTrusted) > 0 {
+		return errors.New("none of the trusted nodes was able to give us a copy of the state")
+	}
 	return errors.New("none of the non-leader and non-subleader nodes were able to give us a copy of the state")
 }
 
+// reportDownload adds the received key/values to the progress of the
+// download from si, logs it once per downloadLogInterval and passes it to
+// the downloadProgress callback.
+func (s *Service) reportDownload(scID skipchain.SkipBlockID, si *network.ServerIdentity,
+	progress *DownloadProgress, kvs []DBKeyValue, lastLog *time.Time) {
+	progress.Entries += len(kvs)
+	for _, kv := range kvs {
+		progress.Bytes += len(kv.Key) + len(kv.Value)
+	}
+	msg := fmt.Sprintf("%s: downloaded %d", s.ServerIdentity(), progress.Entries)
+	if progress.Total > 0 {
+		msg += fmt.Sprintf("/%d", progress.Total)
+	}
+	msg += fmt.Sprintf(" entries (%d bytes) of the state of %x from %s", progress.Bytes, scID, si)
+	if time.Since(*lastLog) >= downloadLogInterval {
+		log.Lvl1(msg)
+		*lastLog = time.Now()
+	} else {
+		log.Lvl3(msg)
+	}
+	if s.downloadProgress != nil {
+		s.downloadProgress(scID, *progress)
+	}
+}
+
 // catchupAll calls catchup for every byzcoin instance stored in this system.
 func (s *Service) catchupAll() error {
 	s.closedMutex.Lock()
@@ -1081,7 +2027,7 @@ func (s *Service) catchupFromID(r *onet.Roster, scID skipchain.SkipBlockID, sbID
 	s.catchingUpHistory[string(scID)] = time.Now().Add(catchupMinimumInterval)
 	s.catchingUpHistoryLock.Unlock()
 
-	log.Lvlf1("%s: catching up with chain %x", s.ServerIdentity(), scID)
+	logCatchup.printf(1, "%s: catching up with chain %x", s.ServerIdentity(), scID)
 
 	s.updateTrieLock.Lock()
 	if s.catchingUp {
@@ -1091,6 +2037,13 @@ func (s *Service) catchupFromID(r *onet.Roster, scID skipchain.SkipBlockID, sbID
 	s.catchingUp = true
 	s.updateTrieLock.Unlock()
 
+	r, err := catchupRoster(r)
+	if err != nil {
+		s.updateTrieLock.Lock()
+		s.catchingUp = false
+		s.updateTrieLock.Unlock()
+		return err
+	}
 	cl := skipchain.NewClient()
 	sb, err := cl.GetSingleBlock(r, sbID)
 	if err != nil {
@@ -1105,7 +2058,8 @@ func (s *Service) catchupFromID(r *onet.Roster, scID skipchain.SkipBlockID, sbID
 // catchUp takes a skipblock as reference for the roster, the current index,
 // and the skipchainID to download either new blocks if it's less than
 // `catchupDownloadAll` behind, or calls downloadDB to start the download of
-// the full DB over the network.
+// the full DB over the network. If the node is more than `catchupMaxDistance`
+// behind, it doesn't catch up at all.
 func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	defer func() {
 		s.updateTrieLock.Lock()
@@ -1113,7 +2067,7 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		s.updateTrieLock.Unlock()
 	}()
 
-	log.Lvlf2("%v Catching up %x / %d", s.ServerIdentity(), sb.SkipChainID(), sb.Index)
+	logCatchup.printf(2, "%v Catching up %x / %d", s.ServerIdentity(), sb.SkipChainID(), sb.Index)
 
 	// Load the trie.
 	download := false
@@ -1122,12 +2076,19 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		log.Warn(s.ServerIdentity(), "problem with trie:", err)
 		download = true
 	} else {
-		download = sb.Index-st.GetIndex() > catchupDownloadAll
+		distance := sb.Index - st.GetIndex()
+		if catchupMaxDistance > 0 && distance > catchupMaxDistance {
+			log.Errorf("%v is %d blocks behind for %x, which is more than %d: "+
+				"too far behind, this requires a manual intervention or a fresh DB download",
+				s.ServerIdentity(), distance, sb.SkipChainID(), catchupMaxDistance)
+			return
+		}
+		download = distance > catchupDownloadAll
 	}
 
 	// Check if we are updating the right index.
 	if download {
-		log.Lvl2(s.ServerIdentity(), "Downloading whole DB for catching up")
+		logCatchup.print(2, s.ServerIdentity(), "Downloading whole DB for catching up")
 		err := s.downloadDB(sb)
 		if err != nil {
 			log.Error("Error while downloading trie:", err)
@@ -1155,10 +2116,15 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	latest := req.SkipBlock
 
 	// Fetch all missing blocks to fill the hole
+	roster, err := catchupRoster(sb.Roster)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "cannot catch up:", err)
+		return
+	}
 	cl := skipchain.NewClient()
 	for trieIndex < sb.Index {
-		log.Lvlf1("%s: our index: %d - latest known index: %d", s.ServerIdentity(), trieIndex, sb.Index)
-		updates, err := cl.GetUpdateChainLevel(sb.Roster, latest.Hash, 1, catchupFetchBlocks)
+		logCatchup.printf(1, "%s: our index: %d - latest known index: %d", s.ServerIdentity(), trieIndex, sb.Index)
+		updates, err := cl.GetUpdateChainLevel(roster, latest.Hash, 1, catchupFetchBlocks)
 		if err != nil {
 			log.Error("Couldn't update blocks: " + err.Error())
 			return
@@ -1173,7 +2139,29 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		latest = updates[len(updates)-1]
 		trieIndex = latest.Index
 	}
-	log.Lvlf2("%v Done catch up %x / %d", s.ServerIdentity(), sb.SkipChainID(), trieIndex)
+	logCatchup.printf(2, "%v Done catch up %x / %d", s.ServerIdentity(), sb.SkipChainID(), trieIndex)
+}
+
+// recoverState replaces the state of the chain of sb with the one of the
+// other nodes, after sb couldn't be applied. If it succeeds, the following
+// blocks are applied again.
+func (s *Service) recoverState(sb *skipchain.SkipBlock) {
+	defer func() {
+		s.updateTrieLock.Lock()
+		s.catchingUp = false
+		s.updateTrieLock.Unlock()
+	}()
+
+	logCatchup.printf(1, "%s downloading the state of %x after block %d failed", s.ServerIdentity(),
+		sb.SkipChainID(), sb.Index)
+	if err := s.downloadDB(sb); err != nil {
+		log.Errorf("%s couldn't recover the state of %x, it stays halted: %v",
+			s.ServerIdentity(), sb.SkipChainID(), err)
+		return
+	}
+	s.updateTrieLock.Lock()
+	delete(s.storeFailures, string(sb.SkipChainID()))
+	s.updateTrieLock.Unlock()
 }
 
 // updateTrieCallback is registered in skipchain and is called after a
@@ -1211,7 +2199,8 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	// In the case of a genesis block, we need to let it pass so we
 	// learn about it because the callback won't be called after the
 	// catch up
-	if len(sb.ForwardLink) > 0 && !s.catchingUp && sb.Index != 0 {
+	catchingUp := s.catchingUp || s.observing[string(sb.SkipChainID())]
+	if len(sb.ForwardLink) > 0 && !catchingUp && sb.Index != 0 {
 		return nil
 	}
 
@@ -1240,12 +2229,25 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 		}
 	}
 
+	// Refuse to go on with a chain whose state couldn't be updated, as
+	// the following blocks would only fail, too.
+	if failed, ok := s.storeFailures[string(sb.SkipChainID())]; ok {
+		log.Lvlf2("%s not applying block %d of %x: block %d couldn't be applied",
+			s.ServerIdentity(), sb.Index, sb.SkipChainID(), failed)
+		return fmt.Errorf("state of %x is halted since block %d", sb.SkipChainID(), failed)
+	}
+
 	// Load the trie.
 	st, err := s.getStateTrie(sb.SkipChainID())
 	if err != nil {
 		return fmt.Errorf("could not load trie: %v", err)
 	}
 
+	// Never follow a block that conflicts with one we already know.
+	if err := s.checkEquivocation(sb); err != nil {
+		return err
+	}
+
 	// Check if we are updating the right index.
 	trieIndex := st.GetIndex()
 	if sb.Index <= trieIndex {
@@ -1254,7 +2256,7 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 		log.Lvlf4("%v updating trie for block %d refused, current trie block is %d", s.ServerIdentity(), sb.Index, trieIndex)
 		return nil
 	} else if sb.Index > trieIndex+1 {
-		if s.catchingUp {
+		if catchingUp {
 			log.Warn(s.ServerIdentity(), "Got new block while catching up - ignoring block for now")
 			return nil
 		}
@@ -1280,11 +2282,29 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	}
 
 	log.Lvlf2("%s Updating transactions for %x on index %v", s.ServerIdentity(), sb.SkipChainID(), sb.Index)
-	_, _, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout)
+	_, _, scs, _, cost := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout)
+
+	// Like in verifySkipBlock, the followers that didn't sign the block
+	// and the nodes catching up must not apply a block if they run other
+	// versions of the pinned contracts, as they might compute another
+	// state. The block is applied once the node runs the pinned versions,
+	// when it catches up again.
+	if err := s.checkBlockContractVersions(st, scs); err != nil {
+		log.Errorf("%s not applying block %d of %x: %v", s.ServerIdentity(), sb.Index, sb.SkipChainID(), err)
+		return err
+	}
 
 	log.Lvlf3("%s Storing index %d with %d state changes %v", s.ServerIdentity(), sb.Index, len(scs), scs.ShortStrings())
-	// Update our global state using all state changes.
+	// Update our global state using all state changes. If it fails, the
+	// whole update is rolled back and the state stays at trieIndex.
 	if err = st.VerifiedStoreAll(scs, sb.Index, header.TrieRoot); err != nil {
+		log.Errorf("%s CRITICAL: couldn't apply block %d of %x, the state "+
+			"stays at block %d: %v", s.ServerIdentity(), sb.Index, sb.SkipChainID(), trieIndex, err)
+		s.storeFailures[string(sb.SkipChainID())] = sb.Index
+		if recoverOnStoreFailure && !s.catchingUp {
+			s.catchingUp = true
+			go s.recoverState(sb)
+		}
 		return err
 	}
 
@@ -1293,10 +2313,14 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 		panic("Couldn't append the state changes to the storage - this might " +
 			"mean that the db is broken. Error: " + err.Error())
 	}
+	s.blockCosts.update(sb.SkipChainID(), sb.Index, cost)
+	s.darcVersions.update(sb.SkipChainID(), sb.Index, scs, st)
 
 	// Notify all waiting channels for processed ClientTransactions.
 	for _, t := range body.TxResults {
-		s.notifications.informWaitChannel(t.ClientTransaction.Instructions.Hash(), t.Accepted)
+		h := t.ClientTransaction.Instructions.Hash()
+		s.txTokens.update(h, t, s.txRejections.get(h))
+		s.notifications.informWaitChannel(h, t)
 	}
 	s.notifications.informBlock(sb.SkipChainID())
 
@@ -1330,22 +2354,32 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	if err != nil {
 		return err
 	}
-	// Check if the polling needs to be updated.
+	// Check if the polling needs to be updated. Only the latest stored
+	// block decides, so that a node catching up doesn't start polling
+	// because of the roster of an older block: the callback of the newer
+	// block follows.
+	latest, err := s.db().GetLatestByID(sb.SkipChainID())
+	isLatest := err != nil || latest.Index <= sb.Index
 	s.pollChanMut.Lock()
 	scIDstr := string(sb.SkipChainID())
-	if nodeIsLeader {
+	if !isLatest {
+		logBlock.printf(3, "%s block %d is not the latest, leaving the polling as it is", s.ServerIdentity(), sb.Index)
+	} else if nodeIsLeader {
 		if _, ok := s.pollChan[scIDstr]; !ok {
-			log.Lvlf2("%s new leader started polling for %x", s.ServerIdentity(), sb.SkipChainID())
+			logBlock.printf(2, "%s new leader started polling for %x", s.ServerIdentity(), sb.SkipChainID())
 			s.pollChan[scIDstr] = s.startPolling(sb.SkipChainID())
 		}
 	} else {
 		if c, ok := s.pollChan[scIDstr]; ok {
-			log.Lvlf2("%s old leader stopped polling for %x", s.ServerIdentity(), sb.SkipChainID())
+			logBlock.printf(2, "%s old leader stopped polling for %x", s.ServerIdentity(), sb.SkipChainID())
 			close(c)
 			delete(s.pollChan, scIDstr)
 		}
 	}
 	s.pollChanMut.Unlock()
+	if isLatest && nodeIsLeader && len(bcConfig.Observers) > 0 {
+		s.notifyObservers(sb, bcConfig.Observers)
+	}
 
 	// Check if viewchange needs to be started/stopped
 	// Check whether the heartbeat monitor exists, if it doesn't we start a
@@ -1354,14 +2388,15 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	if err != nil {
 		return err
 	}
+	window := interval * bcConfig.getRotationWindow()
 	if nodeInNew {
 		// Update or start heartbeats
 		if s.heartbeats.exists(string(sb.SkipChainID())) {
-			log.Lvlf3("%s sending heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), interval*rotationWindow)
-			s.heartbeats.updateTimeout(string(sb.SkipChainID()), interval*rotationWindow)
+			logViewChange.printf(3, "%s sending heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), window)
+			s.heartbeats.updateTimeout(string(sb.SkipChainID()), window)
 		} else {
-			log.Lvlf2("%s starting heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), interval*rotationWindow)
-			err = s.heartbeats.start(string(sb.SkipChainID()), interval*rotationWindow, s.heartbeatsTimeout)
+			logViewChange.printf(2, "%s starting heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), window)
+			err = s.heartbeats.start(string(sb.SkipChainID()), window, s.heartbeatsTimeout)
 			if err != nil {
 				log.Errorf("%s heartbeat failed to start with error: %s", s.ServerIdentity(), err.Error())
 			}
@@ -1372,29 +2407,33 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 
 		if s.viewChangeMan.started(sb.SkipChainID()) && view != nil {
 			s.viewChangeMan.done(*view)
+			// The roster of the config is the one of the next
+			// block, which decides the threshold.
+			s.viewChangeMan.setFaultThreshold(sb.SkipChainID(), faultThreshold(&bcConfig.Roster))
 		} else {
 			// clean previous states as a new block has been added in the mean time
 			// making them thus invalid
 			s.viewChangeMan.stop(sb.SkipChainID())
 
 			// Start viewchange monitor that will fire if we don't get updates in time.
-			log.Lvlf2("%s started viewchangeMonitor for %x", s.ServerIdentity(), sb.SkipChainID())
+			logViewChange.printf(2, "%s started viewchangeMonitor for %x", s.ServerIdentity(), sb.SkipChainID())
 			s.viewChangeMan.add(s.sendViewChangeReq, s.sendNewView, s.isLeader, string(sb.SkipChainID()))
-			s.viewChangeMan.start(s.ServerIdentity().ID, sb.SkipChainID(), initialDur, s.getFaultThreshold(sb.Hash))
+			s.viewChangeMan.start(s.ServerIdentity().ID, sb.SkipChainID(), initialDur, faultThreshold(&bcConfig.Roster))
 		}
 	} else {
 		if s.heartbeats.exists(scIDstr) {
-			log.Lvlf2("%s stopping heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), interval*rotationWindow)
+			logViewChange.printf(2, "%s stopping heartbeat monitor for %x with window %v", s.ServerIdentity(), sb.SkipChainID(), window)
 			s.heartbeats.stop(scIDstr)
 		}
 	}
 	if !nodeInNew && s.viewChangeMan.started(sb.SkipChainID()) {
-		log.Lvlf2("%s not in roster, but viewChangeMonitor started - stopping now for %x", s.ServerIdentity(), sb.SkipChainID())
+		logViewChange.printf(2, "%s not in roster, but viewChangeMonitor started - stopping now for %x", s.ServerIdentity(), sb.SkipChainID())
 		s.viewChangeMan.stop(sb.SkipChainID())
 	}
 
 	// At this point everything should be stored.
 	s.streamingMan.notify(string(sb.SkipChainID()), sb)
+	s.streamingMan.notifyProofs(sb.SkipChainID(), st, s.db())
 
 	log.Lvlf4("%s updated trie for %x with root %x", s.ServerIdentity(), sb.SkipChainID(), st.GetRoot())
 	return nil
@@ -1450,7 +2489,10 @@ func (s *Service) getStateTrie(id skipchain.SkipBlockID) (*stateTrie, error) {
 	idStr := fmt.Sprintf("%x", id)
 	col := s.stateTries[idStr]
 	if col == nil {
-		db, name := s.GetAdditionalBucket([]byte(idStr))
+		db, name, err := s.trieBucket(idStr)
+		if err != nil {
+			return nil, err
+		}
 		st, err := loadStateTrie(db, name)
 		if err != nil {
 			return nil, err
@@ -1471,7 +2513,10 @@ func (s *Service) createStateTrie(id skipchain.SkipBlockID, nonce []byte) (*stat
 	if s.stateTries[idStr] != nil {
 		return nil, errors.New("state trie already exists")
 	}
-	db, name := s.GetAdditionalBucket([]byte(idStr))
+	db, name, err := s.trieBucket(idStr)
+	if err != nil {
+		return nil, err
+	}
 	st, err := newStateTrie(db, name, nonce)
 	if err != nil {
 		return nil, err
@@ -1546,12 +2591,41 @@ func (s *Service) LoadBlockInfo(scID skipchain.SkipBlockID) (time.Duration, int,
 	return config.BlockInterval, config.MaxBlockSize, nil
 }
 
+// stepDown is called by a leader that cannot create blocks anymore. It stops
+// polling for new transactions, so that the followers don't get any
+// heartbeats anymore, and requests a view-change.
+func (s *Service) stepDown(scID skipchain.SkipBlockID) {
+	s.pollChanMut.Lock()
+	if c, ok := s.pollChan[string(scID)]; ok {
+		close(c)
+		delete(s.pollChan, string(scID))
+	}
+	s.pollChanMut.Unlock()
+
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get latest block:", err)
+		return
+	}
+	req := viewchange.InitReq{
+		SignerID: s.ServerIdentity().ID,
+		View: viewchange.View{
+			ID:          latest.Hash,
+			Gen:         scID,
+			LeaderIndex: 1,
+		},
+	}
+	s.viewChangeMan.addReq(req)
+}
+
 func (s *Service) startPolling(scID skipchain.SkipBlockID) chan bool {
 	pipeline := txPipeline{
 		processor: &defaultTxProcessor{
 			stopCollect: make(chan bool),
 			scID:        scID,
 			Service:     s,
+			lastBlock:   time.Now(),
+			stepDown:    s.stepDown,
 		},
 	}
 	st, err := s.getStateTrie(scID)
@@ -1625,6 +2699,11 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		return false
 	}
 
+	if err := s.checkEquivocation(newSB); err != nil {
+		log.Error(s.ServerIdentity(), "refusing block:", err)
+		return false
+	}
+
 	if s.viewChangeMan.waiting(string(newSB.SkipChainID())) && isViewChangeTx(body.TxResults) == nil {
 		log.Error(s.ServerIdentity(), "we are not accepting blocks when a view-change is in progress")
 		return false
@@ -1652,7 +2731,7 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		}
 		sst = st.MakeStagingStateTrie()
 	}
-	mtr, txOut, scs, _ := s.createStateChanges(sst, newSB.SkipChainID(), body.TxResults, noTimeout)
+	mtr, txOut, scs, _, _ := s.createStateChanges(sst, newSB.SkipChainID(), body.TxResults, noTimeout)
 
 	// Check that the locally generated list of accepted/rejected txs match the list
 	// the leader proposed.
@@ -1666,6 +2745,10 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			log.Lvl2(s.ServerIdentity(), "Client Transaction accept mistmatch on tx", i)
 			return false
 		}
+		if !equalResults(txOut[i].Results, body.TxResults[i].Results) {
+			log.Lvl2(s.ServerIdentity(), "Client Transaction results mismatch on tx", i)
+			return false
+		}
 	}
 
 	// Check that the hashes in DataHeader are right.
@@ -1701,6 +2784,12 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			return false
 		}
 	}
+	// The config after the block is used, so that a block pinning the
+	// versions of upgraded contracts is accepted by the upgraded nodes.
+	if err := s.checkContractVersions(config); err != nil {
+		log.Error(s.ServerIdentity(), "refusing block:", err)
+		return false
+	}
 
 	window := 4 * config.BlockInterval
 	if window < minTimestampWindow {
@@ -1716,10 +2805,48 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		return false
 	}
 
+	// The blocks are ordered by their timestamps, too.
+	if newSB.Index > 0 {
+		if err := s.checkTimestampAfterPrevious(newSB, header.Timestamp); err != nil {
+			log.Error(s.ServerIdentity(), "refusing block:", err)
+			return false
+		}
+	}
+
 	log.Lvl4(s.ServerIdentity(), "verification completed")
 	return true
 }
 
+// checkTimestampAfterPrevious returns an error if the timestamp of the new
+// block sb is not strictly after the one of the previous block.
+func (s *Service) checkTimestampAfterPrevious(sb *skipchain.SkipBlock, timestamp int64) error {
+	if len(sb.BackLinkIDs) == 0 {
+		return errors.New("block without back link")
+	}
+	prev := s.db().GetByID(sb.BackLinkIDs[0])
+	if prev == nil {
+		return fmt.Errorf("previous block %x is unknown", sb.BackLinkIDs[0])
+	}
+	prevTimestamp, err := blockTimestamp(prev)
+	if err != nil {
+		return err
+	}
+	if timestamp <= prevTimestamp {
+		return fmt.Errorf("timestamp %v is not after the one of the previous block, %v",
+			time.Unix(0, timestamp), time.Unix(0, prevTimestamp))
+	}
+	return nil
+}
+
+// blockTimestamp returns the timestamp in the header of sb.
+func blockTimestamp(sb *skipchain.SkipBlock) (int64, error) {
+	var header DataHeader
+	if err := protobuf.Decode(sb.Data, &header); err != nil {
+		return 0, fmt.Errorf("couldn't decode the header of block %d: %v", sb.Index, err)
+	}
+	return header.Timestamp, nil
+}
+
 func txSize(txr ...TxResult) (out int) {
 	// It's too bad to have to marshal this and throw it away just to know
 	// how big it would be. Protobuf should support finding the length without
@@ -1743,16 +2870,20 @@ func txSize(txr ...TxResult) (out int) {
 //
 // If timeout is not 0, createStateChanges will stop running instructions after
 // that long, in order for the caller to determine how many instructions fit in
-// a block interval.
+// a block interval. It then also drops the transactions that are bigger than
+// a block.
+//
+// The returned cost is the sum of the costs of the instructions of the
+// accepted transactions, as defined by ContractWithCost.
 //
 // State caching is implemented here, which is critical to performance, because
 // on the leader it reduces the number of contract executions by 1/3 and on
 // followers by 1/2.
-func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipBlockID, txIn TxResults, timeout time.Duration) (merkleRoot []byte, txOut TxResults, states StateChanges, sstTemp *stagingStateTrie) {
+func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipBlockID, txIn TxResults, timeout time.Duration) (merkleRoot []byte, txOut TxResults, states StateChanges, sstTemp *stagingStateTrie, cost uint64) {
 	// If what we want is in the cache, then take it from there. Otherwise
 	// ignore the error and compute the state changes.
 	var err error
-	merkleRoot, txOut, states, err = s.stateChangeCache.get(scID, txIn.Hash())
+	merkleRoot, txOut, states, cost, err = s.stateChangeCache.get(scID, txIn.Hash())
 	if err == nil {
 		log.Lvlf3("%s: loaded state changes %x from cache", s.ServerIdentity(), scID)
 		return
@@ -1772,25 +2903,26 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 
 	for _, tx := range txIn {
 		txsz := txSize(tx)
+		// A transaction bigger than a block would be proposed again and
+		// again, so the leader drops it. The blocks to verify are taken
+		// as they are, as the maximum size may have changed since they
+		// have been created.
+		if timeout != noTimeout && txsz > maxsz {
+			s.dropTx(tx.ClientTransaction, txsz, maxsz)
+			continue
+		}
 
 		var sstTempC *stagingStateTrie
 		var statesTemp StateChanges
-		statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction)
+		var costTemp uint64
+		var results [][]byte
+		statesTemp, sstTempC, costTemp, results, err = s.processOneTx(sstTemp, scID, tx.ClientTransaction)
 		if err != nil {
 			tx.Accepted = false
+			tx.Results = nil
 			txOut = append(txOut, tx)
 			log.Error(s.ServerIdentity(), err)
 		} else {
-			// We would like to be able to check if this txn is so big it could never fit into a block,
-			// and if so, drop it. But we can't with the current API of createStateChanges.
-			// For now, the only thing we can do is accept or refuse them, but they will go into a block
-			// one way or the other.
-			// TODO: In issue #1409, we will refactor things such that we can drop transactions in here.
-			//if txsz > maxsz {
-			//	log.Errorf("%s transaction size %v is bigger than one block (%v), dropping it.", s.ServerIdentity(), txsz, maxsz)
-			//	continue clientTransactions
-			//}
-
 			// Planning mode:
 			//
 			// Timeout is used when the leader calls createStateChanges as
@@ -1805,93 +2937,200 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 				// just like we do for a timeout. The caller will make a block with
 				// what's in txOut.
 				if blocksz+txsz > maxsz {
-					log.Lvlf3("stopping block creation when %v > %v, with len(txOut) of %v", blocksz+txsz, maxsz, len(txOut))
+					logBlock.printf(3, "stopping block creation when %v > %v, with len(txOut) of %v", blocksz+txsz, maxsz, len(txOut))
+					return
+				}
+
+				// The same goes for the compute budget, except that an
+				// expensive transaction is always accepted in an empty
+				// block, or it would never be.
+				if overCostBudget(cost, costTemp) {
+					logBlock.printf(3, "stopping block creation when cost %v > %v, with len(txOut) of %v", cost+costTemp, maxBlockCost, len(txOut))
 					return
 				}
 			}
 
 			tx.Accepted = true
+			tx.Results = results
 			sstTemp = sstTempC
 			blocksz += txsz
+			cost += costTemp
 			states = append(states, statesTemp...)
 			txOut = append(txOut, tx)
 		}
 	}
 
-	// Store the result in the cache before returning.
+	// Store the result in the cache before returning. This includes the
+	// batches where all the transactions got refused, as their block still
+	// needs to be verified and stored.
 	merkleRoot = sstTemp.GetRoot()
-	if len(states) != 0 && len(txOut) != 0 {
-		s.stateChangeCache.update(scID, txOut.Hash(), merkleRoot, txOut, states)
+	if len(txOut) != 0 {
+		s.stateChangeCache.update(scID, txOut.Hash(), merkleRoot, txOut, states, cost)
 	}
 	return
 }
 
-func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction) (StateChanges, *stagingStateTrie, error) {
+// dropTx refuses a transaction that can't fit in a block of maxsz bytes, so
+// that it is never proposed. The clients waiting for it on this node get the
+// reason.
+func (s *Service) dropTx(tx ClientTransaction, txsz, maxsz int) {
+	err := fmt.Errorf("transaction of %d bytes is bigger than the maximum block size of %d bytes", txsz, maxsz)
+	logBlock.print(2, s.ServerIdentity(), "dropping transaction:", err)
+	h := tx.Instructions.Hash()
+	s.txRejections.add(h, err)
+	s.notifications.informWaitChannel(h, TxResult{ClientTransaction: tx})
+}
+
+// InstructionError tells which instruction of a transaction has been
+// refused, and why.
+type InstructionError struct {
+	// Index of the instruction in the transaction.
+	Index int
+	Err   error
+}
+
+func (e InstructionError) Error() string {
+	return fmt.Sprintf("instruction %d: %s", e.Index, e.Err)
+}
+
+// processOneTx executes all the instructions of tx on a copy of sst. The
+// results of the instructions are only returned if at least one of them has
+// a result.
+func (s *Service) processOneTx(sst *stagingStateTrie, scID skipchain.SkipBlockID, tx ClientTransaction) (StateChanges, *stagingStateTrie, uint64, [][]byte, error) {
 	// Make a new trie for each instruction. If the instruction is
 	// sucessfully implemented and changes applied, then keep it
 	// otherwise dump it.
-	sst = sst.Clone()
 	h := tx.Instructions.Hash()
+	if err := tx.Instructions.checkDuplicates(); err != nil {
+		err = fmt.Errorf("%s %s", s.ServerIdentity(), err)
+		s.txRejections.add(h, err)
+		return nil, nil, 0, nil, err
+	}
+	// The followers check it again, so that a leader cannot accept a
+	// transaction with too many instructions. Without a configuration,
+	// this is the genesis transaction.
+	if config, err := LoadConfigFromTrie(sst); err == nil {
+		if err = config.checkInstructions(len(tx.Instructions)); err != nil {
+			err = fmt.Errorf("%s %s", s.ServerIdentity(), err)
+			s.txRejections.add(h, err)
+			return nil, nil, 0, nil, err
+		}
+	}
+	sst = sst.Clone()
+	hChain := tx.Instructions.HashWithChain(scID)
 	var statesTemp StateChanges
 	var cin []Coin
-	for _, instr := range tx.Instructions {
-		scs, cout, err := s.executeInstruction(sst, cin, instr, h)
+	var cost uint64
+	results := make([][]byte, len(tx.Instructions))
+	hasResult := false
+	for i, instr := range tx.Instructions {
+		scs, cout, c, res, err := s.processInstruction(sst, instr, cin, h, hChain)
 		if err != nil {
-			_, _, cid, _, err2 := sst.GetValues(instr.InstanceID.Slice())
-			if err2 != nil {
-				err = fmt.Errorf("%s - while getting value: %s", err, err2)
-			}
-			return nil, nil, fmt.Errorf("%s Contract %s got Instruction %s and returned error: %s", s.ServerIdentity(), cid, instr, err)
+			err = InstructionError{Index: i, Err: err}
+			s.txRejections.add(h, err)
+			return nil, nil, 0, nil, err
 		}
-		var counterScs StateChanges
-		if counterScs, err = incrementSignerCounters(sst, instr.SignerIdentities); err != nil {
-			return nil, nil, fmt.Errorf("%s failed to update signature counters: %s", s.ServerIdentity(), err)
+		statesTemp = append(statesTemp, scs...)
+		cin = cout
+		cost += c
+		results[i] = res
+		hasResult = hasResult || res != nil
+	}
+	if len(cin) != 0 {
+		log.Warn(s.ServerIdentity(), "Leftover coins detected, discarding.")
+	}
+	if !hasResult {
+		results = nil
+	}
+	return statesTemp, sst, cost, results, nil
+}
+
+// processInstruction executes one instruction of a transaction with the hash
+// h, and stores its state changes, including the ones of the signer
+// counters, in sst. It also returns the result of the instruction, if its
+// contract implements ContractWithResult.
+func (s *Service) processInstruction(sst *stagingStateTrie, instr Instruction, cin []Coin, h, hChain []byte) (StateChanges, []Coin, uint64, []byte, error) {
+	// The preconditions are checked against the state including the
+	// changes of the previous instructions of the transaction.
+	if err := instr.VerifyPreconditions(sst); err != nil {
+		return nil, nil, 0, nil, fmt.Errorf("%s %s", s.ServerIdentity(), err)
+	}
+	// The tombstone of a soft deleted instance is only kept as a
+	// proof, it cannot be invoked nor deleted anymore.
+	if deleted, err := sst.isDeleted(instr.InstanceID.Slice()); err != nil {
+		return nil, nil, 0, nil, fmt.Errorf("%s %s", s.ServerIdentity(), err)
+	} else if deleted {
+		return nil, nil, 0, nil, fmt.Errorf("%s instance %x has been deleted", s.ServerIdentity(), instr.InstanceID.Slice())
+	}
+	scs, cout, c, result, err := s.executeInstruction(sst, cin, instr, signedDigest(sst, instr, h, hChain))
+	if err != nil {
+		_, _, cid, _, err2 := sst.GetValues(instr.InstanceID.Slice())
+		if err2 != nil && err2 != errKeyNotSet {
+			err = fmt.Errorf("%s - while getting value: %s", err, err2)
 		}
+		return nil, nil, 0, nil, fmt.Errorf("%s Contract %s got Instruction %s and returned error: %s", s.ServerIdentity(), cid, instr, err)
+	}
+	var counterScs StateChanges
+	if counterScs, err = incrementSignerCounters(sst, instr.SignerIdentities); err != nil {
+		return nil, nil, 0, nil, fmt.Errorf("%s failed to update signature counters: %s", s.ServerIdentity(), err)
+	}
 
-		// Verify the validity of the state-changes:
-		//  - refuse to update non-existing instances
-		//  - refuse to create existing instances
-		//  - refuse to delete non-existing instances
-		for _, sc := range scs {
-			var reason string
-			switch sc.StateAction {
-			case Create:
-				if v, err := sst.Get(sc.InstanceID); err != nil || v != nil {
-					reason = "tried to create existing instanceID"
-				}
-			case Update:
-				if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
-					reason = "tried to update non-existing instanceID"
-				}
-			case Remove:
-				if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
-					reason = "tried to remove non-existing instanceID"
-				}
+	// The index entries among the state changes must belong to the
+	// contract that returned them.
+	scContractID, err := instructionContractID(sst, instr)
+	if err != nil {
+		return nil, nil, 0, nil, fmt.Errorf("%s couldn't get contractID from instruction %+v", s.ServerIdentity(), instr)
+	}
+
+	// Verify the validity of the state-changes:
+	//  - refuse to update non-existing instances
+	//  - refuse to create existing instances
+	//  - refuse to delete non-existing instances
+	//  - refuse to update or delete the tombstones of soft deleted instances
+	//  - refuse to touch the index entries of other contracts
+	for _, sc := range scs {
+		var reason string
+		switch sc.StateAction {
+		case Create:
+			if v, err := sst.Get(sc.InstanceID); err != nil || v != nil {
+				reason = "tried to create existing instanceID"
 			}
-			if reason != "" {
-				_, _, contractID, _, err := sst.GetValues(instr.InstanceID.Slice())
-				if err != nil {
-					return nil, nil, fmt.Errorf("%s couldn't get contractID from instruction %+v", s.ServerIdentity(), instr)
-				}
-				return nil, nil, fmt.Errorf("%s: contract %s %s", s.ServerIdentity(), contractID, reason)
+		case Update:
+			if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
+				reason = "tried to update non-existing instanceID"
+			}
+		case Remove:
+			if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
+				reason = "tried to remove non-existing instanceID"
+			}
+		}
+		if reason == "" && (sc.StateAction == Update || sc.StateAction == Remove) {
+			if deleted, err := sst.isDeleted(sc.InstanceID); err != nil || deleted {
+				reason = "tried to change deleted instanceID"
+			}
+		}
+		if reason == "" {
+			if reason, err = indexViolation(sst, sc, scContractID); err != nil {
+				return nil, nil, 0, nil, fmt.Errorf("%s couldn't verify index entry: %s", s.ServerIdentity(), err)
 			}
-			log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction, sc.InstanceID, sc.ContractID)
-			err = sst.StoreAll(StateChanges{sc})
+		}
+		if reason != "" {
+			_, _, contractID, _, err := sst.GetValues(instr.InstanceID.Slice())
 			if err != nil {
-				return nil, nil, fmt.Errorf("%s StoreAll failed: %s", s.ServerIdentity(), err)
+				return nil, nil, 0, nil, fmt.Errorf("%s couldn't get contractID from instruction %+v", s.ServerIdentity(), instr)
 			}
+			return nil, nil, 0, nil, fmt.Errorf("%s: contract %s %s", s.ServerIdentity(), contractID, reason)
 		}
-		if err = sst.StoreAll(counterScs); err != nil {
-			return nil, nil, fmt.Errorf("%s StoreAll failed to add counter changes: %s", s.ServerIdentity(), err)
+		log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction, sc.InstanceID, sc.ContractID)
+		err = sst.StoreAll(StateChanges{sc})
+		if err != nil {
+			return nil, nil, 0, nil, fmt.Errorf("%s StoreAll failed: %s", s.ServerIdentity(), err)
 		}
-		statesTemp = append(statesTemp, scs...)
-		statesTemp = append(statesTemp, counterScs...)
-		cin = cout
 	}
-	if len(cin) != 0 {
-		log.Warn(s.ServerIdentity(), "Leftover coins detected, discarding.")
+	if err = sst.StoreAll(counterScs); err != nil {
+		return nil, nil, 0, nil, fmt.Errorf("%s StoreAll failed to add counter changes: %s", s.ServerIdentity(), err)
 	}
-	return statesTemp, sst, nil
+	return append(scs, counterScs...), cout, c, result, nil
 }
 
 // GetContractConstructor gets the contract constructor of the contract
@@ -1901,7 +3140,52 @@ func (s *Service) GetContractConstructor(contractName string) (ContractFn, bool)
 	return fn, exists
 }
 
-func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Instruction, ctxHash []byte) (scs StateChanges, cout []Coin, err error) {
+// describeInstance returns the description of the instance given by its
+// contract, or an empty string if the contract doesn't implement
+// ContractWithDescription.
+func (s *Service) describeInstance(body StateChangeBody) (desc string) {
+	if body.Deleted {
+		return "deleted"
+	}
+	fn, exists := s.GetContractConstructor(body.ContractID)
+	if !exists {
+		return ""
+	}
+	// The contracts don't expect to be called on arbitrary values.
+	defer func() {
+		if re := recover(); re != nil {
+			desc = ""
+		}
+	}()
+	c, err := fn(body.Value)
+	if err != nil || c == nil {
+		return ""
+	}
+	if cwd, ok := c.(ContractWithDescription); ok {
+		return cwd.Describe()
+	}
+	return ""
+}
+
+// signedDigest returns the digest the signatures of the instruction have to
+// be verified against. Signatures bound to the chain are always accepted,
+// while the ones on the legacy digest are only accepted as long as the chain
+// doesn't have ChainBoundSignatures set.
+func signedDigest(st ReadOnlyStateTrie, instr Instruction, h, hChain []byte) []byte {
+	if len(instr.Signatures) == 0 || len(instr.SignerIdentities) == 0 ||
+		instr.SignerIdentities[0].Verify(hChain, instr.Signatures[0]) == nil {
+		return hChain
+	}
+	config, err := LoadConfigFromTrie(st)
+	if err != nil || !config.ChainBoundSignatures {
+		// Without a configuration, this is the genesis transaction
+		// which is not verified anyway.
+		return h
+	}
+	return hChain
+}
+
+func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Instruction, ctxHash []byte) (scs StateChanges, cout []Coin, cost uint64, result []byte, err error) {
 	defer func() {
 		if re := recover(); re != nil {
 			err = fmt.Errorf("%s", re)
@@ -1913,6 +3197,15 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 		err = errors.New("Couldn't get contract type of instruction: " + err.Error())
 		return
 	}
+	if err == errKeyNotSet {
+		// Invoke and Delete can only be called on existing instances, so
+		// don't let the client believe the contract is unknown.
+		switch instr.GetType() {
+		case InvokeType, DeleteType:
+			err = fmt.Errorf("%v: %x", errInstanceNotFound, instr.InstanceID.Slice())
+			return
+		}
+	}
 
 	contractFactory, exists := s.contracts[contractID]
 	if !exists && ConfigInstanceID.Equal(instr.InstanceID) {
@@ -1927,20 +3220,34 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 		err = fmt.Errorf("leader is dropping instruction of unknown contract \"%s\" on instance \"%x\"", contractID, instr.InstanceID.Slice())
 		return
 	}
+	// The spawn allowlist of the chain applies whatever the darcs allow,
+	// except to the genesis transaction spawning the configuration.
+	if instr.GetType() == SpawnType && !ConfigInstanceID.Equal(instr.InstanceID) {
+		config, err2 := LoadConfigFromTrie(st)
+		if err2 != nil {
+			err = err2
+			return
+		}
+		if !config.AllowsSpawn(instr.Spawn.ContractID) {
+			err = fmt.Errorf("contract %s is not in the spawn allowlist of the chain", instr.Spawn.ContractID)
+			return
+		}
+	}
+
 	// Now we call the contract function with the data of the key.
 	log.Lvlf3("%s Calling contract '%s'", s.ServerIdentity(), contractID)
 
 	c, err := contractFactory(contents)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, 0, nil, err
 	}
 	if c == nil {
-		return nil, nil, errors.New("contract factory returned nil contract instance")
+		return nil, nil, 0, nil, errors.New("contract factory returned nil contract instance")
 	}
 
 	err = c.VerifyInstruction(st, instr, ctxHash)
 	if err != nil {
-		return nil, nil, fmt.Errorf("instruction verification failed: %v", err)
+		return nil, nil, 0, nil, fmt.Errorf("instruction verification failed: %v", err)
 	}
 
 	switch instr.GetType() {
@@ -1951,7 +3258,14 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 	case DeleteType:
 		scs, cout, err = c.Delete(st, instr, cin)
 	default:
-		return nil, nil, errors.New("unexpected contract type")
+		return nil, nil, 0, nil, errors.New("unexpected contract type")
+	}
+	if err == nil && instr.GetType() == DeleteType && instr.Delete.Soft {
+		for _, sc := range scs {
+			if sc.StateAction == Remove && bytes.Equal(sc.InstanceID, instr.InstanceID.Slice()) {
+				return nil, nil, 0, nil, fmt.Errorf("contract %s doesn't support soft delete", contractID)
+			}
+		}
 	}
 
 	// As the InstanceID of each sc is not necessarily the same as the
@@ -1976,11 +3290,70 @@ func (s *Service) executeInstruction(st ReadOnlyStateTrie, cin []Coin, instr Ins
 
 		scs[i].Version = ver
 		vv[hex.EncodeToString(sc.InstanceID)] = ver
+
+		if sc.ContractVersion == 0 {
+			scs[i].ContractVersion = s.contractVersion(c, contractID, sc)
+		}
 	}
 
+	cost = instructionCost(c, st, instr, scs)
+	if err == nil {
+		var rc Contract
+		if rc, err = s.resultContract(c, contractID, st, instr); err == nil {
+			result, err = instructionResult(rc, st, instr, scs)
+		}
+	}
 	return
 }
 
+// resultContract returns the contract giving the result of instr, which has
+// been executed by c, the contract of the instance contractID. A spawn is
+// executed by the contract of its darc, but its result is given by the
+// spawned contract, which is made from a nil value as the instance doesn't
+// exist yet. If the spawned contract cannot be made from a nil value, it
+// returns nil, and the spawn has no result.
+func (s *Service) resultContract(c Contract, contractID string, st ReadOnlyStateTrie, instr Instruction) (Contract, error) {
+	resultID, err := instructionContractID(st, instr)
+	if err != nil {
+		return nil, err
+	}
+	if resultID == contractID {
+		return c, nil
+	}
+	factory, ok := s.contracts[resultID]
+	if !ok {
+		return c, nil
+	}
+	rc, err := factory(nil)
+	if err != nil {
+		return nil, nil
+	}
+	return rc, nil
+}
+
+// contractVersion returns the version of the format of the value of the state
+// change. The contract that executed the instruction gives the version of its
+// own instances, the others are asked through their value.
+func (s *Service) contractVersion(c Contract, contractID string, sc StateChange) uint32 {
+	if sc.StateAction == Remove {
+		return 0
+	}
+	if sc.ContractID != contractID {
+		factory, ok := s.contracts[sc.ContractID]
+		if !ok {
+			return 0
+		}
+		var err error
+		if c, err = factory(sc.Value); err != nil || c == nil {
+			return 0
+		}
+	}
+	if cv, ok := c.(ContractWithVersion); ok {
+		return cv.ContractVersion()
+	}
+	return 0
+}
+
 func (s *Service) getLeader(scID skipchain.SkipBlockID) (*network.ServerIdentity, error) {
 	scConfig, err := s.LoadConfig(scID)
 	if err != nil {
@@ -2025,7 +3398,7 @@ func (s *Service) getTxs(leader *network.ServerIdentity, roster *onet.Roster, sc
 	// Then we make sure who's the leader
 	actualLeader, err := s.getLeader(scID)
 	if err != nil {
-		log.Lvlf2("%s: could not find a leader on %x with error: %s", s.ServerIdentity(), scID, err)
+		logBlock.printf(2, "%s: could not find a leader on %x with error: %s", s.ServerIdentity(), scID, err)
 		return []ClientTransaction{}
 	}
 	if !leader.Equal(actualLeader) {
@@ -2085,6 +3458,11 @@ func (s *Service) cleanupGoroutines() {
 	}
 	s.pollChanMut.Unlock()
 	s.pollChanWG.Wait()
+
+	// The blocks that cannot be stored now are stored at the next start.
+	if err := s.stateChangeQueue.close(); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't store the state changes of the queue:", err)
+	}
 }
 
 func (s *Service) monitorLeaderFailure() {
@@ -2101,7 +3479,7 @@ func (s *Service) monitorLeaderFailure() {
 		for {
 			select {
 			case key := <-s.heartbeatsTimeout:
-				log.Lvlf3("%s: missed heartbeat for %x", s.ServerIdentity(), key)
+				logViewChange.printf(3, "%s: missed heartbeat for %x", s.ServerIdentity(), key)
 				gen := []byte(key)
 
 				genBlock := s.db().GetByID(gen)
@@ -2132,7 +3510,7 @@ func (s *Service) monitorLeaderFailure() {
 					s.viewChangeMan.addReq(req)
 				}
 			case <-s.closeLeaderMonitorChan:
-				log.Lvl2(s.ServerIdentity(), "closing heartbeat timeout monitor")
+				logViewChange.print(2, s.ServerIdentity(), "closing heartbeat timeout monitor")
 				return
 			}
 		}
@@ -2142,14 +3520,66 @@ func (s *Service) monitorLeaderFailure() {
 // registerContract stores the contract in a map and will
 // call it whenever a contract needs to be done.
 func (s *Service) registerContract(contractID string, c ContractFn) error {
+	if contractID == ContractIndexID {
+		return fmt.Errorf("the contract ID %s is reserved for the index entries", contractID)
+	}
 	s.contracts[contractID] = c
 	return nil
 }
 
+// registerContractVersion stores the version of the code of the contract.
+func (s *Service) registerContractVersion(contractID, version string) error {
+	if contractID == "" || version == "" {
+		return errors.New("the contract ID and the version must not be empty")
+	}
+	s.contractVersionsMut.Lock()
+	defer s.contractVersionsMut.Unlock()
+	s.contractVersions[contractID] = version
+	return nil
+}
+
+// checkContractVersions returns an error if the node runs another version of
+// a contract than the one pinned by the config.
+func (s *Service) checkContractVersions(config *ChainConfig) error {
+	s.contractVersionsMut.Lock()
+	defer s.contractVersionsMut.Unlock()
+	var mismatches []string
+	for _, cv := range config.ContractVersions {
+		if v := s.contractVersions[cv.ContractID]; v != cv.Version {
+			mismatches = append(mismatches, fmt.Sprintf("%s is at version '%s' instead of '%s'",
+				cv.ContractID, v, cv.Version))
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.New("contract versions differ from the chain: " + strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// checkBlockContractVersions returns an error if the node runs other versions
+// of the contracts than the ones pinned by the config after the state changes
+// of a block are applied to st. If the state changes cannot be applied, the
+// error is left to the update of st.
+func (s *Service) checkBlockContractVersions(st *stateTrie, scs StateChanges) error {
+	sst := st.MakeStagingStateTrie()
+	if err := sst.StoreAll(scs); err != nil {
+		return nil
+	}
+	config, err := LoadConfigFromTrie(sst)
+	if err != nil {
+		return nil
+	}
+	return s.checkContractVersions(config)
+}
+
 // startAllChains loads the configuration, updates the data in the service if
 // it finds a valid config-file and synchronises skipblocks if it can contact
 // other nodes.
 func (s *Service) startAllChains() error {
+	// Storing the fetched blocks calls updateTrieCallback, which needs
+	// closedMutex.
+	s.repairGenesis()
+
 	s.closedMutex.Lock()
 	defer s.closedMutex.Unlock()
 	if !s.closed {
@@ -2169,7 +3599,7 @@ func (s *Service) startAllChains() error {
 	}
 	s.stateTries = make(map[string]*stateTrie)
 	s.notifications = bcNotifications{
-		waitChannels: make(map[string]chan bool),
+		waitChannels: make(map[string]chan TxResult),
 	}
 	s.closed = false
 
@@ -2185,6 +3615,10 @@ func (s *Service) startAllChains() error {
 	}
 
 	for _, gen := range gasr.IDs {
+		if s.db().GetByID(gen) == nil {
+			log.Errorf("%s ignoring chain with missing genesis-block %x", s.ServerIdentity(), gen)
+			continue
+		}
 		if !s.hasByzCoinVerification(gen) {
 			continue
 		}
@@ -2195,10 +3629,6 @@ func (s *Service) startAllChains() error {
 			continue
 		}
 
-		if s.db().GetByID(gen) == nil {
-			log.Errorf("%s ignoring chain with missing genesis-block %x", s.ServerIdentity(), gen)
-			continue
-		}
 		latest, err := s.db().GetLatestByID(gen)
 		if err != nil {
 			log.Errorf("%s ignoring chain %x where latest block cannot be found: %s",
@@ -2211,7 +3641,7 @@ func (s *Service) startAllChains() error {
 			continue
 		}
 		if leader.Equal(s.ServerIdentity()) {
-			log.Lvlf2("%s: Starting as a leader for chain %x", s.ServerIdentity(), latest.SkipChainID())
+			logBlock.printf(2, "%s: Starting as a leader for chain %x", s.ServerIdentity(), latest.SkipChainID())
 			s.pollChanMut.Lock()
 			s.pollChan[string(gen)] = s.startPolling(gen)
 			s.pollChanMut.Unlock()
@@ -2230,17 +3660,20 @@ func (s *Service) startAllChains() error {
 		if s.heartbeats.exists(string(gen)) {
 			return errors.New("we are just starting the service, there should be no existing heartbeat monitors")
 		}
-		log.Lvlf2("%s started heartbeat monitor for block %d of %x", s.ServerIdentity(), latest.Index, gen)
-		s.heartbeats.start(string(gen), interval*rotationWindow, s.heartbeatsTimeout)
+		logViewChange.printf(2, "%s started heartbeat monitor for block %d of %x", s.ServerIdentity(), latest.Index, gen)
+		s.heartbeats.start(string(gen), interval*s.loadRotationWindow(gen), s.heartbeatsTimeout)
 
 		// initiate the view-change manager
 		initialDur, err := s.computeInitialDuration(gen)
 		if err != nil {
 			return err
 		}
+		config, err := s.LoadConfig(gen)
+		if err != nil {
+			return err
+		}
 		s.viewChangeMan.add(s.sendViewChangeReq, s.sendNewView, s.isLeader, string(gen))
-		s.viewChangeMan.start(s.ServerIdentity().ID, gen, initialDur, s.getFaultThreshold(gen))
-		// TODO fault threshold might change
+		s.viewChangeMan.start(s.ServerIdentity().ID, gen, initialDur, faultThreshold(&config.Roster))
 	}
 
 	// Running catchupAll in background so it doesn't stop the other
@@ -2264,12 +3697,7 @@ func (s *Service) hasByzCoinVerification(gen skipchain.SkipBlockID) bool {
 		// if it does, just say "not ours".
 		return false
 	}
-	for _, x := range sb.VerifierIDs {
-		if x.Equal(Verify) {
-			return true
-		}
-	}
-	return false
+	return isByzCoinBlock(sb)
 }
 
 // saves this service's config information
@@ -2309,11 +3737,13 @@ func newService(c *onet.Context) (onet.Service, error) {
 	s := &Service{
 		ServiceProcessor:       onet.NewServiceProcessor(c),
 		contracts:              make(map[string]ContractFn),
+		contractVersions:       make(map[string]string),
 		txBuffer:               newTxBuffer(),
 		storage:                &bcStorage{},
 		darcToSc:               make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:       newStateChangeCache(),
-		stateChangeStorage:     newStateChangeStorage(c),
+		blockCosts:             newBlockCosts(),
+		darcVersions:           newDarcVersions(),
 		heartbeatsTimeout:      make(chan string, 1),
 		closeLeaderMonitorChan: make(chan bool, 1),
 		heartbeats:             newHeartbeats(),
@@ -2321,11 +3751,38 @@ func newService(c *onet.Context) (onet.Service, error) {
 		streamingMan:           streamingManager{},
 		closed:                 true,
 		catchingUpHistory:      make(map[string]time.Time),
+		storeFailures:          make(map[string]int),
+		observing:              make(map[string]bool),
+	}
+	if err := loadEnv(); err != nil {
+		return nil, err
 	}
-	err := s.RegisterHandlers(
+	var err error
+	s.stateChangeStorage, err = newStateChangeBackend(c, os.Getenv(envStateChangeStorage))
+	if err != nil {
+		return nil, err
+	}
+	db, bucket := c.GetAdditionalBucket(bucketStateChangeQueue)
+	s.stateChangeQueue, err = newStateChangeQueue(s.stateChangeStorage, stateChangeQueueSize, db, bucket,
+		func(id skipchain.SkipBlockID) *skipchain.SkipBlock {
+			return s.db().GetByID(id)
+		})
+	if err != nil {
+		return nil, err
+	}
+	if stateChangeQueueSize > 0 {
+		s.stateChangeStorage = s.stateChangeQueue
+	}
+	s.trieDBs.perChain, err = parseTrieStorage(os.Getenv(envTrieStorage))
+	if err != nil {
+		return nil, err
+	}
+	err = s.RegisterHandlers(
 		s.CreateGenesisBlock,
 		s.AddTransaction,
 		s.GetProof,
+		s.GetProofs,
+		s.GetChainConfig,
 		s.CheckAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
@@ -2333,16 +3790,34 @@ func newService(c *onet.Context) (onet.Service, error) {
 		s.GetLastInstanceVersion,
 		s.GetAllInstanceVersion,
 		s.CheckStateChangeValidity,
+		s.GetInstancesByDarc,
+		s.GetCoinSupply,
+		s.Exists,
+		s.GetVersion,
+		s.GetDownloadStatus,
+		s.CancelDownload,
 		s.Debug,
+		s.DebugSetLog,
+		s.DebugReplay,
+		s.DebugBisect,
+		s.DebugReconcile,
+		s.PruneStateChanges,
 		s.DebugRemove)
 	if err != nil {
 		log.ErrFatal(err, "Couldn't register messages")
 	}
 
-	if err := s.RegisterStreamingHandlers(s.StreamTransactions); err != nil {
+	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.StreamProof); err != nil {
 		log.ErrFatal(err, "Couldn't register streaming messages")
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
+	s.RegisterProcessorFunc(observerUpdateMsgID, s.handleObserverUpdate)
+	s.ServiceProcessor.RegisterStatusReporter("ByzCoin", s.blockCosts)
+	s.ServiceProcessor.RegisterStatusReporter("ByzCoinEquivocations", &s.equivocations)
+	s.ServiceProcessor.RegisterStatusReporter("ByzCoinMissingGenesis", &s.missingGenesis)
+	if stateChangeQueueSize > 0 {
+		s.ServiceProcessor.RegisterStatusReporter("ByzCoinStateChanges", s.stateChangeQueue)
+	}
 
 	s.registerContract(ContractConfigID, contractConfigFromBytes)
 	s.registerContract(ContractDarcID, s.contractSecureDarcFromBytes)
@@ -2404,6 +3879,10 @@ func newService(c *onet.Context) (onet.Service, error) {
 		return nil, fmt.Errorf("unknown db version number %v", ver)
 	}
 
+	if err := s.setupTrieStorage(); err != nil {
+		return nil, err
+	}
+
 	// initialize the stats of the storage
 	s.stateChangeStorage.calculateSize()
 
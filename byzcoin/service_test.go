@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/eddsa"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/kyber/v3/util/random"
@@ -32,6 +34,7 @@ const slowContract = "slow"
 const panicContract = "panic"
 const invalidContract = "invalid"
 const stateChangeCacheContract = "stateChangeCacheTest"
+const versionedContractID = "versioned"
 
 func TestMain(m *testing.M) {
 	log.MainTest(m)
@@ -102,6 +105,76 @@ func TestService_CreateGenesisBlock(t *testing.T) {
 	require.Equal(t, maxsz, genesisMsg.MaxBlockSize)
 }
 
+// Distinct ledgers can be created concurrently on the same node.
+func TestService_CreateGenesisBlockConcurrent(t *testing.T) {
+	s := newSerN(t, 0, testInterval, 4, false)
+	defer s.local.CloseAll()
+	service := s.services[1]
+
+	nbr := 5
+	ids := make(chan skipchain.SkipBlockID, nbr)
+	errs := make(chan error, nbr)
+	for i := 0; i < nbr; i++ {
+		go func() {
+			signer := darc.NewSignerEd25519(nil, nil)
+			genesisMsg, err := DefaultGenesisMsg(CurrentVersion, s.roster, []string{"spawn:dummy"}, signer.Identity())
+			if err != nil {
+				errs <- err
+				return
+			}
+			genesisMsg.BlockInterval = testInterval
+			resp, err := service.CreateGenesisBlock(genesisMsg)
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids <- resp.Skipblock.SkipChainID()
+		}()
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < nbr; i++ {
+		select {
+		case err := <-errs:
+			require.NoError(t, err)
+		case id := <-ids:
+			require.False(t, seen[string(id)])
+			seen[string(id)] = true
+			_, _, err := service.LoadBlockInfo(id)
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, 0, service.createSkipChainLocks.size())
+}
+
+// The debug dump holds the descriptions given by the contracts.
+func TestService_DebugDescribe(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	resp, err := s.service().Debug(&DebugRequest{ByzCoinID: s.genesis.SkipChainID()})
+	require.NoError(t, err)
+	descs := make(map[string]string)
+	for _, inst := range resp.Dump {
+		descs[inst.State.ContractID] = inst.Description
+	}
+	require.Contains(t, descs[ContractConfigID], "Interval: "+testInterval.String())
+	require.Contains(t, descs[ContractDarcID], "Desc: "+string(s.darc.Description))
+	spawn := darc.Action("spawn:" + dummyContract)
+	require.Contains(t, descs[ContractDarcID], "Action: "+string(spawn)+
+		" - Expression: "+string(s.darc.Rules.Get(spawn)))
+
+	// Contracts without description give an empty one.
+	require.Equal(t, "", s.service().describeInstance(StateChangeBody{
+		ContractID: dummyContract,
+		Value:      s.value,
+	}))
+	require.Equal(t, "deleted", s.service().describeInstance(StateChangeBody{
+		ContractID: dummyContract,
+		Deleted:    true,
+	}))
+}
+
 func TestService_AddTransaction(t *testing.T) {
 	testAddTransaction(t, testInterval, 0, false)
 }
@@ -368,6 +441,45 @@ func TestService_AddTransaction_ValidInvalid(t *testing.T) {
 	require.Nil(t, err)
 }
 
+// The maximum wait replaces the timeout computed from the block interval.
+func TestService_AddTransaction_MaxWait(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	_, err = s.service().AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   tx1,
+		InclusionWait: 10,
+		MaxWait:       -time.Second,
+	})
+	require.Error(t, err)
+
+	_, err = s.service().AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   tx1,
+		InclusionWait: 10,
+		MaxWait:       time.Millisecond,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "maximum wait")
+
+	// The first transaction is still included.
+	tx2, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	_, err = s.service().AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   tx2,
+		InclusionWait: 5,
+		MaxWait:       20 * testInterval,
+	})
+	require.NoError(t, err)
+}
+
 func TestService_GetProof(t *testing.T) {
 	s := newSer(t, 2, testInterval)
 	defer s.local.CloseAll()
@@ -410,6 +522,139 @@ func TestService_GetProof(t *testing.T) {
 	require.Error(t, err)
 }
 
+// The proofs of several keys are computed against the same block.
+func TestService_GetProofs(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	serKey := s.tx.Instructions[0].Hash()
+	darcKey := NewInstanceID(s.darc.GetBaseID()).Slice()
+	keys := [][]byte{serKey, ConfigInstanceID.Slice(), darcKey}
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	rep, err := cl.GetProofs(keys)
+	require.NoError(t, err)
+	require.Empty(t, rep.Errors)
+	require.Equal(t, len(keys), len(rep.Proofs))
+	for i, p := range rep.Proofs {
+		require.True(t, p.InclusionProof.Match(keys[i]))
+		require.Equal(t, rep.Proofs[0].Latest.Hash, p.Latest.Hash)
+	}
+	_, v0, _, _, err := rep.Proofs[0].KeyValue()
+	require.NoError(t, err)
+	require.Equal(t, s.value, v0)
+
+	_, err = s.service().GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Keys:    [][]byte{serKey, darcKey, serKey},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "given twice")
+
+	keys = nil
+	for i := 0; i <= getProofsMaxKeys; i++ {
+		keys = append(keys, NewInstanceID([]byte{byte(i)}).Slice())
+	}
+	_, err = s.service().GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Keys:    keys,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "at most")
+	_, err = s.service().GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Keys:    keys[:getProofsMaxKeys],
+	})
+	require.NoError(t, err)
+}
+
+func TestService_GetChainConfig(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	config, err := cl.GetChainConfig()
+	require.NoError(t, err)
+	require.Equal(t, s.interval, config.BlockInterval)
+	require.Equal(t, int(defaultMaxBlockSize), config.MaxBlockSize)
+	require.True(t, config.Roster.ID.Equal(s.roster.ID))
+
+	latest, err := s.service().db().GetLatestByID(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	rep, err := cl.GetChainConfigAndProof()
+	require.NoError(t, err)
+	require.Equal(t, latest.Hash, rep.Proof.Latest.Hash)
+	require.True(t, rep.Proof.InclusionProof.Match(ConfigInstanceID.Slice()))
+
+	_, err = s.service().GetChainConfig(&GetChainConfig{
+		Version:   CurrentVersion,
+		ByzCoinID: skipchain.SkipBlockID("unknown chain"),
+	})
+	require.Error(t, err)
+}
+
+// A new proof of a key is streamed only when a block changes it.
+func TestService_StreamProof(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	recv := func(c chan *GetProofResponse) *GetProofResponse {
+		select {
+		case resp := <-c:
+			require.NotNil(t, resp)
+			require.NoError(t, resp.Proof.Verify(scID))
+			return resp
+		case <-time.After(10 * s.interval):
+			require.Fail(t, "didn't get a proof in time")
+		}
+		return nil
+	}
+	counterKey := publicVersionKey(s.signer.Identity().String())
+	counter := func(resp *GetProofResponse) uint64 {
+		if !resp.Proof.InclusionProof.Match(counterKey) {
+			return 0
+		}
+		_, buf, _, _, err := resp.Proof.KeyValue()
+		require.NoError(t, err)
+		return binary.LittleEndian.Uint64(buf)
+	}
+
+	counterChan, counterStop, err := s.service().StreamProof(&StreamProofRequest{ID: scID, Key: counterKey})
+	require.NoError(t, err)
+	darcChan, darcStop, err := s.service().StreamProof(&StreamProofRequest{ID: scID, Key: s.darc.GetBaseID()})
+	require.NoError(t, err)
+	defer close(darcStop)
+	c0 := counter(recv(counterChan))
+	require.True(t, recv(darcChan).Proof.InclusionProof.Match(s.darc.GetBaseID()))
+
+	for i := uint64(1); i <= 2; i++ {
+		addDummyTxs(t, s, 1, 1, int(c0+i))
+		require.Equal(t, c0+i, counter(recv(counterChan)))
+	}
+	// The genesis darc didn't change.
+	select {
+	case <-darcChan:
+		require.Fail(t, "got a proof of an unchanged key")
+	default:
+	}
+
+	close(counterStop)
+	select {
+	case _, ok := <-counterChan:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		require.Fail(t, "the stream wasn't closed")
+	}
+
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	_, _, err = s.service().StreamProof(&StreamProofRequest{ID: latest.Hash, Key: counterKey})
+	require.Error(t, err)
+}
+
 func TestService_DarcProxy(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -602,6 +847,149 @@ func TestService_BadDataHeader(t *testing.T) {
 	require.Error(t, err)
 }
 
+// A block must be newer than the previous one.
+func TestService_TimestampNotAfterPrevious(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	ser := s.services[0]
+	c := ser.Context
+	err := skipchain.RegisterVerification(c, Verify, func(newID []byte, newSB *skipchain.SkipBlock) bool {
+		// Give the block the timestamp of the previous one.
+		prev := ser.db().GetByID(newSB.BackLinkIDs[0])
+		require.NotNil(t, prev)
+		prevTimestamp, err := blockTimestamp(prev)
+		require.NoError(t, err)
+		var header DataHeader
+		require.NoError(t, protobuf.Decode(newSB.Data, &header))
+		require.True(t, header.Timestamp > prevTimestamp)
+		require.NoError(t, ser.checkTimestampAfterPrevious(newSB, header.Timestamp))
+		header.Timestamp = prevTimestamp
+		newSB.Data, err = protobuf.Encode(&header)
+		require.NoError(t, err)
+
+		return ser.verifySkipBlock(newID, newSB)
+	})
+	require.NoError(t, err)
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	_, err = ser.AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   tx,
+		InclusionWait: 5,
+	})
+	require.Error(t, err)
+}
+
+// A batch where all the transactions are refused must still give a block
+// recording the refusals, and the transactions must not be retried.
+func TestService_AllTxsRefused(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	// Wrong signer counters make the verification of all the transactions
+	// fail.
+	nbrTxs := 3
+	for i := 0; i < nbrTxs; i++ {
+		tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, uint64(10+i))
+		require.NoError(t, err)
+		if i < nbrTxs-1 {
+			s.sendTx(t, tx)
+			continue
+		}
+		_, err = s.service().AddTransaction(&AddTxRequest{
+			Version:       CurrentVersion,
+			SkipchainID:   scID,
+			Transaction:   tx,
+			InclusionWait: 5,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "got refused")
+	}
+
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	var refused int
+	for sb := latest; sb.Index > 0; sb = s.service().db().GetByID(sb.BackLinkIDs[0]) {
+		txs, err := txResultsFromBlock(sb)
+		require.NoError(t, err)
+		for _, tx := range txs {
+			require.False(t, tx.Accepted)
+			refused++
+		}
+	}
+	require.Equal(t, nbrTxs, refused)
+
+	// No more blocks are created, as nothing is left to retry.
+	time.Sleep(3 * testInterval)
+	sb, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	require.Equal(t, latest.Index, sb.Index)
+}
+
+// The client gets to know which instruction of a refused transaction failed
+// and why.
+func TestService_RefusedReason(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	spawn := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	spawn.SignerCounter = []uint64{1}
+	unknown := createSpawnInstr(s.darc.GetBaseID(), "unknown", "data", s.value)
+	unknown.SignerCounter = []uint64{2}
+	ctx, err := combineInstrsAndSign(s.signer, spawn, unknown)
+	require.NoError(t, err)
+	_, err = s.service().AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   ctx,
+		InclusionWait: 5,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "transaction is in block, but got refused: instruction 1: ")
+	require.Contains(t, err.Error(), "unknown")
+
+	reason := s.service().txRejections.get(ctx.Instructions.Hash())
+	require.IsType(t, InstructionError{}, reason)
+	require.Equal(t, 1, reason.(InstructionError).Index)
+
+	// The clients asking for it get the refusal in the reply, also from a
+	// node that is not the leader.
+	unknown.SignerCounter = []uint64{1}
+	ctx, err = combineInstrsAndSign(s.signer, unknown)
+	require.NoError(t, err)
+	resp, err := s.services[1].AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   s.genesis.SkipChainID(),
+		Transaction:   ctx,
+		InclusionWait: 5,
+		ReplyRefusal:  true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Refused)
+	require.Equal(t, 0, resp.Refused.Instruction)
+	require.Contains(t, resp.Refused.Reason, "unknown")
+
+	// The client returns it as error. As the refused transactions didn't
+	// increase the counter of the signer, the next one is still 1.
+	unknown = createSpawnInstr(s.darc.GetBaseID(), "unknown", "data", []byte("other"))
+	unknown.SignerCounter = []uint64{1}
+	ctx, err = combineInstrsAndSign(s.signer, unknown)
+	require.NoError(t, err)
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	cl.ServerNumber = 0
+	resp, err = cl.AddTransactionAndWait(ctx, 5)
+	require.Error(t, err)
+	require.IsType(t, &TxRefusal{}, err)
+	require.Equal(t, resp.Refused, err)
+	require.Equal(t, 0, resp.Refused.Instruction)
+	require.Contains(t, resp.Refused.Reason, "unknown")
+	require.Contains(t, err.Error(), "transaction is in block, but got refused: instruction 0: ")
+}
+
 func txResultsFromBlock(sb *skipchain.SkipBlock) (TxResults, error) {
 	var body DataBody
 	err := protobuf.DecodeWithConstructors(sb.Payload, &body, network.DefaultConstructors(cothority.Suite))
@@ -1013,7 +1401,7 @@ func TestService_StateChange(t *testing.T) {
 	ct1 := ClientTransaction{Instructions: instrs}
 	ct2 := ClientTransaction{Instructions: instrs2}
 
-	_, txOut, scs, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ct1, ct2), noTimeout)
+	_, txOut, scs, _, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ct1, ct2), noTimeout)
 	require.Equal(t, 2, len(txOut))
 	require.True(t, txOut[0].Accepted)
 	require.False(t, txOut[1].Accepted)
@@ -1078,14 +1466,14 @@ func TestService_StateChangeVerification(t *testing.T) {
 	require.Nil(t, err)
 
 	log.Lvl1("Failing updating and removing non-existing instances")
-	mkroot1, txOut, scs, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
+	mkroot1, txOut, scs, _, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
 		InstanceID: iid,
 		Invoke:     &Invoke{},
 	}}}), noTimeout)
 	require.Equal(t, 0, len(scs))
 	require.Equal(t, 1, len(txOut))
 	require.Equal(t, false, txOut[0].Accepted)
-	mkroot2, txOut, scs, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
+	mkroot2, txOut, scs, _, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
 		InstanceID: iid,
 		Delete:     &Delete{},
 	}}}), noTimeout)
@@ -1099,28 +1487,28 @@ func TestService_StateChangeVerification(t *testing.T) {
 		InstanceID: iid,
 		Spawn:      &Spawn{ContractID: cid},
 	}}})
-	mkroot1, txOut, scs, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), txs, noTimeout)
+	mkroot1, txOut, scs, _, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), txs, noTimeout)
 	require.Equal(t, 3, len(scs))
 	require.Equal(t, 1, len(txOut))
 	require.Equal(t, true, txOut[0].Accepted)
 	require.Nil(t, cdb.StoreAll(scs, 0))
 	// Clear cache so that the transactions get re-evaluated
 	delete(s.service().stateChangeCache.cache, string(s.genesis.SkipChainID()))
-	mkroot2, txOut, scs, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), txs, noTimeout)
+	mkroot2, txOut, scs, _, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), txs, noTimeout)
 	require.Equal(t, 0, len(scs))
 	require.Equal(t, 1, len(txOut))
 	require.Equal(t, false, txOut[0].Accepted)
 	require.True(t, bytes.Equal(mkroot1, mkroot2))
 
 	log.Lvl1("Accept updating and removing existing instance")
-	_, txOut, scs, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
+	_, txOut, scs, _, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
 		InstanceID: iid,
 		Invoke:     &Invoke{},
 	}}}), noTimeout)
 	require.Equal(t, 3, len(scs))
 	require.Equal(t, 1, len(txOut))
 	require.Equal(t, true, txOut[0].Accepted)
-	_, txOut, scs, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
+	_, txOut, scs, _, _ = s.service().createStateChanges(cdb.MakeStagingStateTrie(), s.genesis.SkipChainID(), NewTxResults(ClientTransaction{Instructions: Instructions{{
 		InstanceID: iid,
 		Delete:     &Delete{},
 	}}}), noTimeout)
@@ -1377,6 +1765,76 @@ func TestService_CheckAuthorization(t *testing.T) {
 	require.Contains(t, resp.Actions, darc.Action("spawn:"+ContractDarcID))
 }
 
+// A rule can delegate to a version of a darc that is not the latest one.
+func TestService_CheckAuthorizationIntermediateDarc(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	d1 := s.darc.Copy()
+	require.NoError(t, d1.EvolveFrom(s.darc))
+	s.testDarcEvolution(t, *d1, false)
+	d2 := d1.Copy()
+	require.NoError(t, d2.EvolveFrom(d1))
+	s.testDarcEvolution(t, *d2, false)
+
+	// The second darc delegates its spawn rule to the first evolution of
+	// the genesis darc.
+	id2 := []darc.Identity{darc.NewSignerEd25519(nil, nil).Identity()}
+	darc2 := darc.NewDarc(darc.InitRules(id2, id2), []byte("second darc"))
+	darc2.Rules.AddRule("spawn:"+ContractDarcID,
+		expression.Expr(darc.NewIdentityDarc(d1.GetID()).String()))
+	darc2Buf, err := darc2.ToProto()
+	require.NoError(t, err)
+	counters, err := s.service().GetSignerCounters(&GetSignerCounters{
+		SignerIDs:   []string{s.signer.Identity().String()},
+		SkipchainID: scID,
+	})
+	require.NoError(t, err)
+	instr := createSpawnInstr(s.darc.GetBaseID(), ContractDarcID, "darc", darc2Buf)
+	instr.SignerCounter[0] = counters.Counters[0] + 1
+	ctx, err := combineInstrsAndSign(s.signer, instr)
+	require.NoError(t, err)
+	s.sendTx(t, ctx)
+	s.waitProof(t, NewInstanceID(darc2.GetBaseID()))
+
+	resp, err := s.service().CheckAuthorization(&CheckAuthorization{
+		Version:    CurrentVersion,
+		ByzCoinID:  scID,
+		DarcID:     darc2.GetBaseID(),
+		Identities: []darc.Identity{s.signer.Identity()},
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Actions, darc.Action("spawn:"+ContractDarcID))
+
+	// Every version can be read.
+	st, err := s.service().GetReadOnlyStateTrie(scID)
+	require.NoError(t, err)
+	for _, d := range []*darc.Darc{s.darc, d1, d2} {
+		dv, err := s.service().loadDarcVersion(scID, st, d.GetID())
+		require.NoError(t, err)
+		require.True(t, dv.Equal(d))
+	}
+	_, err = s.service().loadDarcVersion(scID, st, genID().Slice())
+	require.Error(t, err)
+
+	// The index of the versions follows the new blocks.
+	d3 := d2.Copy()
+	require.NoError(t, d3.EvolveFrom(d2))
+	s.testDarcEvolution(t, *d3, false)
+	st, err = s.service().GetReadOnlyStateTrie(scID)
+	require.NoError(t, err)
+	v, ok, indexed := s.service().darcVersions.lookup(scID, st.GetIndex(), d2.GetID())
+	require.True(t, indexed)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), v.version)
+	for _, d := range []*darc.Darc{d1, d2, d3} {
+		dv, err := s.service().loadDarcVersion(scID, st, d.GetID())
+		require.NoError(t, err)
+		require.True(t, dv.Equal(d))
+	}
+}
+
 func TestService_GetLeader(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -1503,6 +1961,52 @@ func TestService_SetConfigRosterNewLeader(t *testing.T) {
 	}
 }
 
+// TestService_LeaderTransition checks that after a change of the leader,
+// only the new leader polls, and that the pipeline of the old leader stops
+// on its own.
+func TestService_LeaderTransition(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	rosterR := onet.NewRoster([]*network.ServerIdentity{
+		s.roster.List[1], s.roster.List[2], s.roster.List[3], s.roster.List[0]})
+	ctx, _ := createConfigTxWithCounter(t, testInterval, *rosterR, defaultMaxBlockSize, s, 1)
+	s.sendTxAndWait(t, ctx, 10)
+
+	scID := s.genesis.SkipChainID()
+	polling := func(i int) bool {
+		s.services[i].pollChanMut.Lock()
+		defer s.services[i].pollChanMut.Unlock()
+		_, ok := s.services[i].pollChan[string(scID)]
+		return ok
+	}
+	for i := 0; i < 10 && (polling(0) || !polling(1)); i++ {
+		time.Sleep(testInterval / 2)
+	}
+	for i := range s.services {
+		require.Equal(t, i == 1, polling(i), "wrong polling state of node %d", i)
+	}
+
+	// A pipeline of the old leader that is still running doesn't collect
+	// nor propose anymore, and doesn't request a view-change.
+	proc := &defaultTxProcessor{
+		stopCollect: make(chan bool),
+		scID:        scID,
+		Service:     s.service(),
+		lastBlock:   time.Now().Add(-time.Hour),
+		stepDown: func(skipchain.SkipBlockID) {
+			require.Fail(t, "the old leader shouldn't step down")
+		},
+	}
+	txs, err := proc.CollectTx()
+	require.NoError(t, err)
+	require.Empty(t, txs)
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	err = proc.ProposeBlock(&txProcessorState{sst: st.MakeStagingStateTrie()})
+	require.Equal(t, errNotLeader, err)
+}
+
 func TestService_SetConfigRosterNewNodes(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -1638,6 +2142,45 @@ func TestService_SetConfigRosterSwitchNodes(t *testing.T) {
 	}
 }
 
+// The fault threshold of the view-change follows the size of the roster.
+func TestService_SetConfigRosterFaultThreshold(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	checkThreshold := func(expected int) {
+		for i := 0; i < 10; i++ {
+			f, ok := s.service().viewChangeMan.faultThreshold(scID)
+			if ok && f == expected {
+				return
+			}
+			time.Sleep(testInterval)
+		}
+		require.Fail(t, "the fault threshold has not been updated", "expected %d", expected)
+	}
+	checkThreshold(1)
+
+	_, newRoster, _ := s.local.MakeSRS(cothority.Suite, 2, ByzCoinID)
+	roster := onet.NewRoster(s.roster.List)
+	counter := 1
+	setRoster := func(list []*network.ServerIdentity) {
+		roster = onet.NewRoster(list)
+		ctx, _ := createConfigTxWithCounter(t, testInterval, *roster, defaultMaxBlockSize, s, counter)
+		counter++
+		s.sendTxAndWait(t, ctx, 10)
+	}
+
+	log.Lvl1("Growing the roster to 6 nodes")
+	setRoster(append(roster.List, newRoster.List[0]))
+	checkThreshold(1)
+	setRoster(append(roster.List, newRoster.List[1]))
+	checkThreshold(2)
+
+	log.Lvl1("Shrinking the roster to 5 nodes")
+	setRoster(roster.List[:len(roster.List)-1])
+	checkThreshold(1)
+}
+
 // Replaces all nodes from the previous roster with new nodes
 func TestService_SetConfigRosterReplace(t *testing.T) {
 	s := newSer(t, 1, testInterval)
@@ -1689,11 +2232,48 @@ func addDummyTxs(t *testing.T, s *ser, nbr int, perCTx int, count int) int {
 	return count
 }
 
-func TestService_SetConfigRosterDownload(t *testing.T) {
+// The leader drops the transactions that are bigger than a block, instead of
+// proposing them again and again.
+func TestService_DropOversizedTx(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
 
-	ids := []darc.Identity{s.signer.Identity()}
+	_, maxsz, err := s.service().LoadBlockInfo(scID)
+	require.NoError(t, err)
+	big, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, make([]byte, maxsz), s.signer, 1)
+	require.NoError(t, err)
+	small, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	_, txOut, _, _, _ := s.service().createStateChanges(st.MakeStagingStateTrie(), scID, NewTxResults(big, small), time.Minute)
+	require.Equal(t, 1, len(txOut))
+	require.True(t, txOut[0].Accepted)
+	require.Equal(t, small.Instructions.Hash(), txOut[0].ClientTransaction.Instructions.Hash())
+	reason := s.service().txRejections.get(big.Instructions.Hash())
+	require.Error(t, reason)
+	require.Contains(t, reason.Error(), "bigger than the maximum block size")
+
+	proc := &defaultTxProcessor{
+		scID:    scID,
+		Service: s.service(),
+	}
+	states, err := proc.ProcessTx(big, &txProcessorState{sst: st.MakeStagingStateTrie()})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(states))
+	require.Empty(t, states[0].txs)
+
+	// The chain keeps going.
+	s.sendTxAndWait(t, small, 10)
+}
+
+func TestService_SetConfigRosterDownload(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	ids := []darc.Identity{s.signer.Identity()}
 	testDarc := darc.NewDarc(darc.InitRules(ids, ids), []byte("testDarc"))
 	testDarcBuf, err := testDarc.ToProto()
 	require.Nil(t, err)
@@ -1729,6 +2309,162 @@ func TestService_SetConfigRosterDownload(t *testing.T) {
 	require.True(t, reply.Proof.InclusionProof.Match(testDarc.GetBaseID()))
 }
 
+func TestService_CancelDownload(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 3, 3, 1)
+
+	status, err := s.service().GetDownloadStatus(&GetDownloadStatus{})
+	require.NoError(t, err)
+	require.Empty(t, status.Downloads)
+	_, err = s.service().CancelDownload(&CancelDownload{})
+	require.Error(t, err)
+
+	resp, err := s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    2,
+	})
+	require.NoError(t, err)
+	status, err = s.service().GetDownloadStatus(&GetDownloadStatus{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(status.Downloads))
+	require.True(t, status.Downloads[0].Active)
+	require.Equal(t, resp.Nonce, status.Downloads[0].Nonce)
+	require.Equal(t, 2, status.Downloads[0].Served)
+	require.Equal(t, resp.Total, status.Downloads[0].Total)
+
+	_, err = s.service().CancelDownload(&CancelDownload{Nonce: resp.Nonce + 1})
+	require.Error(t, err)
+	cancel, err := s.service().CancelDownload(&CancelDownload{Nonce: resp.Nonce})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{resp.Nonce}, cancel.Nonces)
+	_, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Nonce:     resp.Nonce,
+		Length:    2,
+	})
+	require.Error(t, err)
+	status, err = s.service().GetDownloadStatus(&GetDownloadStatus{})
+	require.NoError(t, err)
+	require.Empty(t, status.Downloads)
+
+	// A new download can be served until the end.
+	resp, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    resp.Total + 1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, resp.Total, len(resp.KeyValues))
+	status, err = s.service().GetDownloadStatus(&GetDownloadStatus{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(status.Downloads))
+	require.False(t, status.Downloads[0].Active)
+	require.Equal(t, resp.Total, status.Downloads[0].Served)
+
+	// Without a nonce, all the downloads are cancelled.
+	_, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.NoError(t, err)
+	cancel, err = s.service().CancelDownload(&CancelDownload{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(cancel.Nonces))
+}
+
+// A download started after a key returns the entries following it.
+func TestService_DownloadStateResume(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 2, 2, 1)
+
+	all, err := s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1000,
+	})
+	require.NoError(t, err)
+	require.True(t, len(all.KeyValues) > 3)
+
+	for _, i := range []int{0, 2, len(all.KeyValues) - 1} {
+		resp, err := s.service().DownloadState(&DownloadState{
+			ByzCoinID: s.genesis.SkipChainID(),
+			Length:    1000,
+			StartKey:  all.KeyValues[i].Key,
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(all.KeyValues)-i-1, len(resp.KeyValues))
+		for j, kv := range resp.KeyValues {
+			require.Equal(t, all.KeyValues[i+j+1], kv)
+		}
+	}
+
+	// A key between two entries is not in the download.
+	key := append(all.KeyValues[1].Key, 0)
+	resp, err := s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1000,
+		StartKey:  key,
+	})
+	require.NoError(t, err)
+	require.Equal(t, all.KeyValues[2:], resp.KeyValues)
+}
+
+// Two nodes can download the state at the same time, and the number of
+// downloads is bounded.
+func TestService_DownloadStateConcurrent(t *testing.T) {
+	defer func(max int) {
+		maxDownloads = max
+	}(maxDownloads)
+	maxDownloads = 2
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 3, 3, 1)
+
+	// Both downloads are interleaved, and neither aborts the other.
+	var nonces [2]uint64
+	var entries [2]int
+	var total int
+	for done := false; !done; {
+		done = true
+		for i := range nonces {
+			resp, err := s.service().DownloadState(&DownloadState{
+				ByzCoinID: s.genesis.SkipChainID(),
+				Nonce:     nonces[i],
+				Length:    2,
+			})
+			require.NoError(t, err)
+			if nonces[i] == 0 {
+				total = resp.Total
+			}
+			nonces[i] = resp.Nonce
+			entries[i] += len(resp.KeyValues)
+			done = done && len(resp.KeyValues) == 0
+		}
+	}
+	require.NotEqual(t, nonces[0], nonces[1])
+	require.Equal(t, total, entries[0])
+	require.Equal(t, total, entries[1])
+
+	// The ended downloads make room for new ones, but not the active ones.
+	_, err := s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.NoError(t, err)
+	_, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.NoError(t, err)
+	_, err = s.service().DownloadState(&DownloadState{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Length:    1,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already serving 2 downloads")
+}
+
 func TestService_DownloadState(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -1762,9 +2498,9 @@ func TestService_DownloadState(t *testing.T) {
 	})
 	require.NotNil(t, err)
 
-	// Start one download and check it is aborted
+	// Start one download and check it is not aborted
 	// if we start a second download.
-	log.Lvl1("Check aborting of download")
+	log.Lvl1("Check concurrent downloads")
 	resp, err = s.service().DownloadState(&DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     0,
@@ -1788,13 +2524,13 @@ func TestService_DownloadState(t *testing.T) {
 	require.Nil(t, err)
 	nonce2 := resp.Nonce
 	require.NotEqual(t, nonce1, nonce2)
-	// Now 1st download should fail
+	// 1st download should still continue
 	resp, err = s.service().DownloadState(&DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
 		Nonce:     nonce1,
 		Length:    1,
 	})
-	require.NotNil(t, err)
+	require.Nil(t, err)
 	// And 2nd download should still continue
 	resp, err = s.service().DownloadState(&DownloadState{
 		ByzCoinID: s.genesis.SkipChainID(),
@@ -1815,6 +2551,7 @@ func TestService_DownloadState(t *testing.T) {
 
 	// Start a new download and go till the end
 	length := 0
+	total := 0
 	var nonce uint64
 	for {
 		resp, err = s.service().DownloadState(&DownloadState{
@@ -1823,6 +2560,11 @@ func TestService_DownloadState(t *testing.T) {
 			Length:    10,
 		})
 		require.Nil(t, err)
+		if nonce == 0 {
+			total = resp.Total
+		} else {
+			require.Equal(t, 0, resp.Total)
+		}
 		if len(resp.KeyValues) == 0 {
 			break
 		}
@@ -1833,16 +2575,31 @@ func TestService_DownloadState(t *testing.T) {
 	// are copied, so we cannot know in advance how many
 	// entries we copy...
 	require.True(t, length > 40)
+	require.Equal(t, length, total)
 
 	time.Sleep(time.Second)
+	// Download in smaller chunks to check the progress in between.
+	defer func(n int) {
+		catchupFetchDBEntries = n
+	}(catchupFetchDBEntries)
+	catchupFetchDBEntries = 10
 	// Try to re-create the trie on a new service -
 	// do it twice
 	for i := 0; i < 2; i++ {
 		servers, _, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
 		services := s.local.GetServices(servers, ByzCoinID)
 		service := services[0].(*Service)
+		var progress []DownloadProgress
+		service.downloadProgress = func(scID skipchain.SkipBlockID, p DownloadProgress) {
+			require.True(t, scID.Equal(s.genesis.SkipChainID()))
+			progress = append(progress, p)
+		}
 		err := service.downloadDB(s.genesis)
 		require.Nil(t, err)
+		require.True(t, len(progress) > 1)
+		last := progress[len(progress)-1]
+		require.Equal(t, last.Total, last.Entries)
+		require.True(t, last.Bytes > last.Entries)
 		st, err := service.getStateTrie(s.genesis.Hash)
 		require.Nil(t, err)
 		val, _, _, _, err := st.GetValues(make([]byte, 32))
@@ -1944,7 +2701,7 @@ func TestService_StateChangeCache(t *testing.T) {
 
 	txs := NewTxResults(tx1, tx2)
 	require.NoError(t, err)
-	root, txOut, states, _ := s.service().createStateChanges(sst, scID, txs, noTimeout)
+	root, txOut, states, _, _ := s.service().createStateChanges(sst, scID, txs, noTimeout)
 	require.Equal(t, 2, len(txOut))
 	require.Equal(t, 1, ctr)
 	// we expect one state change to increment the signature counter
@@ -1957,7 +2714,7 @@ func TestService_StateChangeCache(t *testing.T) {
 	// createStateChanges when making the block), then it should load it from the
 	// cache, which means that ctr is still one (we do not call the
 	// contract twice).
-	root1, txOut1, states1, _ := s.service().createStateChanges(sst, scID, txOut, noTimeout)
+	root1, txOut1, states1, _, _ := s.service().createStateChanges(sst, scID, txOut, noTimeout)
 	require.Equal(t, 1, ctr)
 	require.Equal(t, root, root1)
 	require.Equal(t, txOut, txOut1)
@@ -1967,13 +2724,821 @@ func TestService_StateChangeCache(t *testing.T) {
 	// again, i.e., ctr == 2.
 	s.service().stateChangeCache = newStateChangeCache()
 	require.NoError(t, err)
-	root2, txOut2, states2, _ := s.service().createStateChanges(sst, scID, txs, noTimeout)
+	root2, txOut2, states2, _, _ := s.service().createStateChanges(sst, scID, txs, noTimeout)
 	require.Equal(t, root, root2)
 	require.Equal(t, txOut, txOut2)
 	require.Equal(t, states, states2)
 	require.Equal(t, 2, ctr)
 }
 
+// Invoking or deleting a non-existing instance must return a specific error
+// and not complain about an unknown contract.
+func TestService_NonExistingInstance(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+
+	invoke := createInvokeInstr(genID(), dummyContract, "update", "data", []byte{})
+	invoke.SignerCounter = []uint64{1}
+	ctx, err := combineInstrsAndSign(s.signer, invoke)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errInstanceNotFound.Error())
+	require.NotContains(t, err.Error(), "unknown contract")
+
+	del := Instruction{
+		InstanceID:    genID(),
+		Delete:        &Delete{ContractID: dummyContract},
+		SignerCounter: []uint64{1},
+	}
+	ctx, err = combineInstrsAndSign(s.signer, del)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errInstanceNotFound.Error())
+
+	// Failing instructions on an existing instance don't return that
+	// error.
+	ctx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), "unknown", []byte{}, s.signer, 1)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), ctx)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), errInstanceNotFound.Error())
+}
+
+// Instructions with preconditions must only be executed if the instances
+// have the expected versions.
+func TestService_Preconditions(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	darcID := NewInstanceID(s.darc.GetBaseID())
+
+	spawn := func(pcs ...Precondition) ClientTransaction {
+		instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+		instr.Preconditions = pcs
+		ctx, err := combineInstrsAndSign(s.signer, instr)
+		require.NoError(t, err)
+		return ctx
+	}
+
+	// The genesis darc has version 0.
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), spawn(Precondition{darcID, 0}))
+	require.NoError(t, err)
+
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), spawn(Precondition{darcID, 1}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "precondition failed")
+
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), spawn(Precondition{genID(), 0}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+
+	// The preconditions are part of the hash, so they are signed.
+	require.NotEqual(t, spawn().Instructions[0].Hash(),
+		spawn(Precondition{darcID, 0}).Instructions[0].Hash())
+
+	// The version is checked including the changes of the previous
+	// instructions of the same transaction.
+	first := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	first.SignerIdentities = []darc.Identity{s.signer.Identity()}
+	id := NewInstanceID(first.Hash())
+	second := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	second.SignerCounter = []uint64{2}
+	second.Preconditions = []Precondition{{id, 0}}
+	ctx, err := combineInstrsAndSign(s.signer, first, second)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), ctx)
+	require.NoError(t, err)
+}
+
+func TestService_DuplicateInstructions(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+
+	instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	instr.SignerCounter = []uint64{1}
+	other := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	other.SignerCounter = []uint64{2}
+	ctx, err := combineInstrsAndSign(s.signer, instr, other)
+	require.NoError(t, err)
+	// The same signed spawn derives the same instance ID.
+	ctx.Instructions = append(ctx.Instructions, ctx.Instructions[0])
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), s.genesis.SkipChainID(), ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instruction 2 is a duplicate of instruction 0")
+}
+
+// The version of the format of the values is stored with the state changes
+// of the contracts that give one, and is 0 for the others.
+func TestService_ContractVersion(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	// The updates keep the contract of the instance.
+	cb := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		if inst.GetType() == InvokeType {
+			_, _, _, darcID, err := cdb.GetValues(inst.InstanceID.Slice())
+			if err != nil {
+				return nil, nil, err
+			}
+			return []StateChange{
+				NewStateChange(Update, inst.InstanceID, versionedContractID, inst.Invoke.Args[0].Value, darcID),
+			}, nil, nil
+		}
+		return dummyContractFunc(cdb, inst, c)
+	}
+	require.NoError(t, RegisterContract(s.hosts[0], versionedContractID, func([]byte) (Contract, error) {
+		return &versionedContract{contractAdaptorNV{cb: cb}}, nil
+	}))
+
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	sst := st.MakeStagingStateTrie()
+	counter := uint64(1)
+	// process returns the state changes and the ID of the instance the
+	// signed instruction spawns.
+	process := func(instr Instruction) (StateChanges, InstanceID) {
+		instr.SignerCounter = []uint64{counter}
+		counter++
+		ctx, err := combineInstrsAndSign(s.signer, instr)
+		require.NoError(t, err)
+		scs, sstNew, _, _, err := s.service().processOneTx(sst, scID, ctx)
+		require.NoError(t, err)
+		sst = sstNew
+		return scs, NewInstanceID(ctx.Instructions[0].Hash())
+	}
+
+	// The darc contract spawns the instance, the version comes from the
+	// spawned contract.
+	spawn := createSpawnInstr(s.darc.GetBaseID(), versionedContractID, "data", s.value)
+	scs, id := process(spawn)
+	for _, sc := range scs {
+		if bytes.Equal(sc.InstanceID, id.Slice()) {
+			require.Equal(t, uint32(2), sc.ContractVersion)
+		} else {
+			require.Equal(t, uint32(0), sc.ContractVersion)
+		}
+	}
+	ver, err := sst.GetContractVersion(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), ver)
+
+	process(createInvokeInstr(id, versionedContractID, "update", "data", []byte("new value")))
+	ver, err = sst.GetContractVersion(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), ver)
+
+	spawn = createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	_, id = process(spawn)
+	ver, err = sst.GetContractVersion(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), ver)
+
+	_, err = sst.GetContractVersion(genID().Slice())
+	require.Equal(t, errKeyNotSet, err)
+}
+
+// A soft delete leaves a tombstone of the instance, on which no more
+// instructions are accepted. Contracts that don't support it refuse the
+// soft delete.
+func TestService_SoftDelete(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	// hardDelete only removes its instances, and its invoke updates the
+	// instance given as argument.
+	hardDelete := "hardDelete"
+	require.NoError(t, RegisterContract(s.hosts[0], hardDelete, adaptorNoVerify(
+		func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+			switch inst.GetType() {
+			case DeleteType:
+				return []StateChange{
+					NewStateChange(Remove, inst.InstanceID, "", nil, nil),
+				}, nil, nil
+			case InvokeType:
+				target := NewInstanceID(inst.Invoke.Args[0].Value)
+				return []StateChange{
+					NewStateChange(Update, target, dummyContract, s.value, s.darc.GetBaseID()),
+				}, nil, nil
+			}
+			return nil, nil, errors.New("spawn is not supported")
+		})))
+
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	sst := st.MakeStagingStateTrie()
+	counter := uint64(1)
+	process := func(instr Instruction) error {
+		instr.SignerCounter = []uint64{counter}
+		ctx, err := combineInstrsAndSign(s.signer, instr)
+		require.NoError(t, err)
+		_, sstNew, _, _, err := s.service().processOneTx(sst, scID, ctx)
+		if err == nil {
+			sst = sstNew
+			counter++
+		}
+		return err
+	}
+	softDelete := func(id InstanceID, contractID string) error {
+		return process(Instruction{
+			InstanceID: id,
+			Delete:     &Delete{ContractID: contractID, Soft: true},
+		})
+	}
+
+	spawn := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	spawn.SignerIdentities = []darc.Identity{s.signer.Identity()}
+	spawn.SignerCounter = []uint64{counter}
+	require.NoError(t, process(spawn))
+	id := NewInstanceID(spawn.Hash())
+	require.NoError(t, softDelete(id, dummyContract))
+	val, ver, cid, _, err := sst.GetValues(id.Slice())
+	require.NoError(t, err)
+	require.Empty(t, val)
+	require.Equal(t, uint64(1), ver)
+	require.Equal(t, dummyContract, cid)
+	deleted, err := sst.isDeleted(id.Slice())
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	err = process(createInvokeInstr(id, dummyContract, "update", "data", s.value))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has been deleted")
+	err = process(Instruction{
+		InstanceID: id,
+		Delete:     &Delete{ContractID: dummyContract},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has been deleted")
+
+	// The darc doesn't allow to spawn the contract, so store it directly.
+	hardID := genID()
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Create, hardID, hardDelete, s.value, s.darc.GetBaseID()),
+	}))
+	err = softDelete(hardID, hardDelete)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't support soft delete")
+	_, _, _, _, err = sst.GetValues(hardID.Slice())
+	require.NoError(t, err)
+
+	// No contract can change the tombstone, even through another instance.
+	err = process(createInvokeInstr(hardID, hardDelete, "update", "target", id.Slice()))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tried to change deleted instanceID")
+
+	// The tombstones are not listed.
+	require.NoError(t, sst.Commit())
+	resp, err := s.service().GetInstancesByDarc(&GetInstancesByDarc{
+		SkipChainID: scID,
+		DarcID:      s.darc.GetBaseID(),
+		Length:      100,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.InstanceIDs, hardID)
+	require.NotContains(t, resp.InstanceIDs, id)
+}
+
+// Signatures bound to another chain are always refused, and the legacy
+// signatures are refused once the chain requires chain bound signatures.
+func TestService_ChainBoundSignatures(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	sign := func(bcID skipchain.SkipBlockID, instr Instruction) ClientTransaction {
+		ctx := ClientTransaction{Instructions: Instructions{instr}}
+		if bcID == nil {
+			require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+		} else {
+			require.NoError(t, ctx.FillSignersAndSignWithChain(bcID, s.signer))
+		}
+		return ctx
+	}
+	spawn := func(bcID skipchain.SkipBlockID, counter uint64) ClientTransaction {
+		instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+		instr.SignerCounter = []uint64{counter}
+		return sign(bcID, instr)
+	}
+	process := func(ctx ClientTransaction) error {
+		st, err := s.service().getStateTrie(scID)
+		require.NoError(t, err)
+		_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), scID, ctx)
+		return err
+	}
+
+	require.NoError(t, process(spawn(nil, 1)))
+	require.NoError(t, process(spawn(scID, 1)))
+	require.Error(t, process(spawn(genID().Slice(), 1)))
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	updateConfig := func(counter uint64) Instruction {
+		configBuf, err := protobuf.Encode(config)
+		require.NoError(t, err)
+		instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+		instr.SignerCounter = []uint64{counter}
+		return instr
+	}
+	config.ChainBoundSignatures = true
+	s.sendTxAndWait(t, sign(nil, updateConfig(1)), 10)
+
+	require.Error(t, process(spawn(nil, 2)))
+	require.NoError(t, process(spawn(scID, 2)))
+	require.Error(t, process(spawn(genID().Slice(), 2)))
+
+	config.ChainBoundSignatures = false
+	err = process(sign(scID, updateConfig(2)))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot be disabled")
+}
+
+func TestService_SpawnAllowlist(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	process := func(instr Instruction, counter uint64) error {
+		instr.SignerCounter = []uint64{counter}
+		ctx := ClientTransaction{Instructions: Instructions{instr}}
+		require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+		st, err := s.service().getStateTrie(scID)
+		require.NoError(t, err)
+		_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), scID, ctx)
+		return err
+	}
+	spawn := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+	require.NoError(t, process(spawn, 1))
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	updateConfig := func(counter uint64) ClientTransaction {
+		configBuf, err := protobuf.Encode(config)
+		require.NoError(t, err)
+		instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+		instr.SignerCounter = []uint64{counter}
+		ctx := ClientTransaction{Instructions: Instructions{instr}}
+		require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+		return ctx
+	}
+	config.SpawnContractIDs = []string{ContractDarcID}
+	s.sendTxAndWait(t, updateConfig(1), 10)
+
+	err = process(spawn, 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not in the spawn allowlist")
+
+	config.SpawnContractIDs = []string{ContractDarcID, ""}
+	err = process(updateConfig(2).Instructions[0], 2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "empty contract ID")
+
+	config.SpawnContractIDs = []string{ContractDarcID, dummyContract}
+	s.sendTxAndWait(t, updateConfig(2), 10)
+	require.NoError(t, process(spawn, 3))
+}
+
+func TestService_ContractVersions(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	for _, h := range s.hosts {
+		require.NoError(t, RegisterContractVersion(h, dummyContract, "1"))
+	}
+	require.Error(t, RegisterContractVersion(s.hosts[0], dummyContract, ""))
+	resp, err := s.service().GetVersion(&GetVersion{})
+	require.NoError(t, err)
+	require.Equal(t, []ContractVersion{{dummyContract, "1"}}, resp.ContractVersions)
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	config.ContractVersions = []ContractVersion{{dummyContract, "1"}, {dummyContract, "2"}}
+	require.Error(t, config.sanityCheck(nil))
+
+	// The nodes accept the blocks once the version they run is pinned.
+	config.ContractVersions = []ContractVersion{{dummyContract, "1"}}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+	instr.SignerCounter = []uint64{1}
+	ctx := ClientTransaction{Instructions: Instructions{instr}}
+	require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+	s.sendTxAndWait(t, ctx, 10)
+	config, err = s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	require.Equal(t, []ContractVersion{{dummyContract, "1"}}, config.ContractVersions)
+	require.NoError(t, s.service().checkContractVersions(config))
+
+	// A node running another version refuses the blocks.
+	require.NoError(t, RegisterContractVersion(s.hosts[0], dummyContract, "2"))
+	err = s.service().checkContractVersions(config)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dummy is at version '2' instead of '1'")
+	require.NoError(t, RegisterContractVersion(s.hosts[0], dummyContract, "1"))
+	counter := addDummyTxs(t, s, 1, 1, 2)
+
+	// A follower running another version doesn't apply the blocks the
+	// other nodes signed without it.
+	follower := s.services[len(s.services)-1]
+	st, err := follower.getStateTrie(scID)
+	require.NoError(t, err)
+	waitTrieIndex := func(index int) {
+		for i := 0; st.GetIndex() < index; i++ {
+			require.True(t, i < 100, "follower didn't apply block %d", index)
+			time.Sleep(testInterval / 10)
+		}
+	}
+	latest, err := s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	waitTrieIndex(latest.Index)
+	require.NoError(t, RegisterContractVersion(s.hosts[len(s.hosts)-1], dummyContract, "2"))
+	counter = addDummyTxs(t, s, 1, 1, counter)
+	latest, err = s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	for i := 0; ; i++ {
+		require.True(t, i < 100, "follower didn't get the block")
+		if follower.db().GetByID(latest.Hash) != nil {
+			break
+		}
+		time.Sleep(testInterval / 10)
+	}
+	require.Equal(t, latest.Index-1, st.GetIndex())
+
+	// Once it runs the pinned version, it catches up at its next catch up
+	// request, whose minimum interval the test doesn't wait for.
+	require.NoError(t, RegisterContractVersion(s.hosts[len(s.hosts)-1], dummyContract, "1"))
+	follower.catchingUpHistoryLock.Lock()
+	delete(follower.catchingUpHistory, string(scID))
+	follower.catchingUpHistoryLock.Unlock()
+	addDummyTxs(t, s, 1, 1, counter)
+	latest, err = s.service().db().GetLatestByID(scID)
+	require.NoError(t, err)
+	waitTrieIndex(latest.Index)
+}
+
+// The transactions with more instructions than allowed by the config are
+// refused by the node receiving them, and by the followers.
+func TestService_MaxInstructionsPerTx(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	config.MaxInstructionsPerTx = -1
+	require.Error(t, config.sanityCheck(nil))
+
+	config.MaxInstructionsPerTx = 2
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+	instr.SignerCounter = []uint64{1}
+	ctx := ClientTransaction{Instructions: Instructions{instr}}
+	require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+	s.sendTxAndWait(t, ctx, 10)
+
+	var instrs []Instruction
+	for i := 0; i < 3; i++ {
+		instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", []byte{byte(i)})
+		instr.SignerCounter = []uint64{uint64(2 + i)}
+		instrs = append(instrs, instr)
+	}
+	tx, err := combineInstrsAndSign(s.signer, instrs...)
+	require.NoError(t, err)
+	_, err = s.service().AddTransaction(&AddTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: scID,
+		Transaction: tx,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "at most 2 are allowed")
+
+	// A leader accepting it anyway would be refused by the followers.
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), scID, tx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "at most 2 are allowed")
+
+	addDummyTxs(t, s, 1, 2, 2)
+}
+
+// The threshold expressions are refused until the chain is at the version
+// that has them, as the nodes that are not upgraded can't parse them.
+func TestService_ByzCoinVersion(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	config, err := s.service().LoadConfig(scID)
+	require.NoError(t, err)
+	require.Equal(t, 0, config.ByzCoinVersion)
+	config.ByzCoinVersion = CurrentByzCoinVersion + 1
+	require.Error(t, config.sanityCheck(nil))
+	config.ByzCoinVersion = 0
+	require.Error(t, config.sanityCheck(&ChainConfig{ByzCoinVersion: 1}))
+
+	// The darcs with thresholds are stored, like the older nodes do, but
+	// their rules don't verify.
+	d2 := s.darc.Copy()
+	require.NoError(t, d2.EvolveFrom(s.darc))
+	expr := expression.InitThresholdExpr(1, s.signer.Identity().String())
+	require.NoError(t, d2.Rules.UpdateRule("spawn:dummy", expr))
+	s.testDarcEvolution(t, *d2, false)
+
+	tx, err := combineInstrsAndSign(s.signer,
+		createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", []byte{1}))
+	require.NoError(t, err)
+	tx.Instructions[0].SignerCounter = []uint64{2}
+	require.NoError(t, tx.FillSignersAndSignWith(s.signer))
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	_, _, _, _, err = s.service().processOneTx(st.MakeStagingStateTrie(), scID, tx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "threshold expressions need byzcoin version")
+
+	config.ByzCoinVersion = ByzCoinVersionThreshold
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	instr := createInvokeInstr(ConfigInstanceID, ContractConfigID, "update_config", "config", configBuf)
+	instr.SignerCounter = []uint64{2}
+	ctx := ClientTransaction{Instructions: Instructions{instr}}
+	require.NoError(t, ctx.FillSignersAndSignWith(s.signer))
+	s.sendTxAndWait(t, ctx, 10)
+
+	addDummyTxs(t, s, 1, 1, 3)
+}
+
+func TestService_Version(t *testing.T) {
+	// The older versions of the window are accepted.
+	require.NoError(t, checkVersionRange(1, 1, 3))
+	require.NoError(t, checkVersionRange(2, 1, 3))
+	require.NoError(t, checkVersionRange(3, 1, 3))
+	require.Error(t, checkVersionRange(0, 1, 3))
+	require.Error(t, checkVersionRange(4, 1, 3))
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	resp, err := cl.GetVersion()
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, resp.Current)
+	require.Equal(t, MinVersion, resp.Min)
+	require.Contains(t, resp.ContractIDs, ContractDarcID)
+	require.Contains(t, resp.ContractIDs, dummyContract)
+
+	getProof := func(v Version) error {
+		_, err := s.service().GetProof(&GetProof{
+			Version: v,
+			Key:     ConfigInstanceID.Slice(),
+			ID:      s.genesis.SkipChainID(),
+		})
+		return err
+	}
+	require.NoError(t, getProof(MinVersion))
+	require.NoError(t, getProof(CurrentVersion))
+	err = getProof(MinVersion - 1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "version mismatch")
+	require.Error(t, getProof(CurrentVersion+1))
+}
+
+func TestService_Exists(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	id := NewInstanceID(tx.Instructions[0].Hash())
+
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	found, err := cl.Exists(id.Slice())
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = cl.Exists(genID().Slice())
+	require.NoError(t, err)
+	require.False(t, found)
+
+	resp, err := s.service().Exists(&Exists{
+		Version:     CurrentVersion,
+		SkipChainID: s.genesis.SkipChainID(),
+		Key:         id.Slice(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Index)
+	_, err = s.service().Exists(&Exists{
+		Version:     CurrentVersion,
+		SkipChainID: genID().Slice(),
+		Key:         id.Slice(),
+	})
+	require.Error(t, err)
+
+	// The answer is much smaller than the proof, even on a chain with a
+	// single block.
+	proof, err := cl.GetProof(id.Slice())
+	require.NoError(t, err)
+	proofBuf, err := protobuf.Encode(proof)
+	require.NoError(t, err)
+	existsBuf, err := protobuf.Encode(resp)
+	require.NoError(t, err)
+	log.Lvlf1("proof: %d bytes, exists: %d bytes", len(proofBuf), len(existsBuf))
+	require.True(t, 10*len(existsBuf) < len(proofBuf))
+}
+
+func TestService_IdempotencyToken(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	cl.ServerNumber = 0
+	token := NewIdempotencyToken()
+	resp, err := cl.AddTransactionWithToken(tx, 0, token)
+	require.NoError(t, err)
+	require.True(t, resp.Pending)
+
+	// The reply got lost: the retry waits for the transaction sent the
+	// first time, which is only applied once.
+	resp, err = cl.AddTransactionWithToken(tx, 10, token)
+	require.NoError(t, err)
+	require.False(t, resp.Pending)
+	resp, err = cl.AddTransactionWithToken(tx, 0, token)
+	require.NoError(t, err)
+	require.False(t, resp.Pending)
+	found, err := cl.Exists(NewInstanceID(tx.Instructions[0].Hash()).Slice())
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// Without the token, the retry is refused because of the counter.
+	_, err = cl.AddTransactionAndWait(tx, 10)
+	require.Error(t, err)
+
+	// A token cannot be used for another transaction.
+	tx2, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	_, err = cl.AddTransactionWithToken(tx2, 10, token)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "another transaction")
+	_, err = cl.AddTransactionWithToken(tx2, 10, make([]byte, maxTxTokenLength+1))
+	require.Error(t, err)
+	resp, err = cl.AddTransactionWithToken(tx2, 10, NewIdempotencyToken())
+	require.NoError(t, err)
+	require.False(t, resp.Pending)
+
+	// The first copy got lost before it was buffered: the retry adds it.
+	tx3, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 3)
+	require.NoError(t, err)
+	token = NewIdempotencyToken()
+	s.service().txTokens.getOrAdd(string(s.genesis.SkipChainID())+string(token), tx3.Instructions.Hash())
+	resp, err = cl.AddTransactionWithToken(tx3, 10, token)
+	require.NoError(t, err)
+	require.False(t, resp.Pending)
+}
+
+func TestService_GetInstancesByDarc(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	req := &GetInstancesByDarc{
+		SkipChainID: s.genesis.SkipChainID(),
+		DarcID:      s.darc.GetBaseID(),
+		Length:      100,
+	}
+	resp, err := s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	before := resp.Total
+	require.Equal(t, before, len(resp.InstanceIDs))
+
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx1, 10)
+	tx2, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx2, 10)
+
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Equal(t, before+2, resp.Total)
+	require.Contains(t, resp.InstanceIDs, NewInstanceID(tx1.Instructions[0].Hash()))
+	require.Contains(t, resp.InstanceIDs, NewInstanceID(tx2.Instructions[0].Hash()))
+
+	// Pagination
+	req.Start = 1
+	req.Length = 1
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.InstanceIDs))
+	req.Start = before + 2
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(resp.InstanceIDs))
+	req.Length = 0
+	_, err = s.service().GetInstancesByDarc(req)
+	require.Error(t, err)
+
+	// Unknown darc
+	req.DarcID = genID().Slice()
+	req.Start = 0
+	req.Length = 1
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Equal(t, 0, resp.Total)
+
+	// The next pages resume after the last instance of the previous one.
+	req.DarcID = s.darc.GetBaseID()
+	req.Length = before + 2
+	all, err := s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	req.StartKey = all.InstanceIDs[0].Slice()
+	req.Length = 1
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Equal(t, all.InstanceIDs[1:2], resp.InstanceIDs)
+	req.StartKey = all.InstanceIDs[before+1].Slice()
+	resp, err = s.service().GetInstancesByDarc(req)
+	require.NoError(t, err)
+	require.Empty(t, resp.InstanceIDs)
+
+	// The client fetches all the pages.
+	ids, err := NewClient(s.genesis.SkipChainID(), *s.roster).GetInstancesByDarc(s.darc.GetBaseID(), 1)
+	require.NoError(t, err)
+	require.Equal(t, all.InstanceIDs, ids)
+}
+
+func TestService_GetCoinSupply(t *testing.T) {
+	s := newSer(t, 0, testInterval)
+	defer s.local.CloseAll()
+	for _, h := range s.hosts {
+		require.NoError(t, RegisterContract(h, coinContractID, adaptor(dummyContractFunc)))
+	}
+	genesisMsg, err := DefaultGenesisMsg(CurrentVersion, s.roster,
+		[]string{"spawn:" + dummyContract, "spawn:" + coinContractID}, s.signer.Identity())
+	require.NoError(t, err)
+	genesisMsg.BlockInterval = testInterval
+	resp, err := s.service().CreateGenesisBlock(genesisMsg)
+	require.NoError(t, err)
+	s.genesis = resp.Skipblock
+	s.darc = &genesisMsg.GenesisDarc
+	s.interval = testInterval
+
+	gold, silver := genID(), genID()
+	accounts := []struct {
+		contractID string
+		coin       Coin
+	}{
+		{coinContractID, Coin{Name: gold, Value: 10}},
+		{coinContractID, Coin{Name: gold, Value: 20}},
+		{coinContractID, Coin{Name: silver, Value: 5}},
+		{coinContractID, Coin{Name: gold, Value: 0}},
+		// Not a coin instance, so it isn't counted.
+		{dummyContract, Coin{Name: gold, Value: 100}},
+	}
+	for i, a := range accounts {
+		buf, err := protobuf.Encode(&a.coin)
+		require.NoError(t, err)
+		tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), a.contractID, buf, s.signer, uint64(i+1))
+		require.NoError(t, err)
+		s.sendTxAndWait(t, tx, 10)
+	}
+
+	cl := NewClient(s.genesis.SkipChainID(), *s.roster)
+	supply, err := cl.GetCoinSupply(gold)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30), supply.Total)
+	require.Equal(t, 3, supply.Accounts)
+	require.Equal(t, len(accounts), supply.Index)
+	supply, err = cl.GetCoinSupply(silver)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), supply.Total)
+	require.Equal(t, 1, supply.Accounts)
+	supply, err = cl.GetCoinSupply(genID())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), supply.Total)
+	require.Equal(t, 0, supply.Accounts)
+
+	_, err = s.service().GetCoinSupply(&GetCoinSupply{
+		Version:     CurrentVersion + 1,
+		SkipChainID: s.genesis.SkipChainID(),
+		CoinType:    gold,
+	})
+	require.Error(t, err)
+}
+
 // Check that we got no error from an existing state trie
 func TestService_UpdateTrieCallback(t *testing.T) {
 	s := newSer(t, 1, testInterval)
@@ -1985,6 +3550,52 @@ func TestService_UpdateTrieCallback(t *testing.T) {
 	require.Nil(t, err)
 }
 
+// The history of an instance is kept from the block index given to the
+// pruning, and its latest version is never removed.
+func TestService_PruneStateChanges(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 4, 1, 1)
+	scID := s.genesis.SkipChainID()
+	req := &GetAllInstanceVersion{
+		SkipChainID: scID,
+		InstanceID:  NewInstanceID(publicVersionKey(s.signer.Identity().String())),
+	}
+
+	res, err := s.service().GetAllInstanceVersion(req)
+	require.NoError(t, err)
+	n := len(res.StateChanges)
+	require.True(t, n >= 4)
+	index := res.StateChanges[n-2].BlockIndex
+	size := s.service().stateChangeStorage.(*stateChangeStorage).size
+
+	resp, err := s.service().PruneStateChanges(&PruneStateChanges{ByzCoinID: scID, BlockIndex: index})
+	require.NoError(t, err)
+	require.True(t, resp.Removed >= n-2)
+	require.True(t, s.service().stateChangeStorage.(*stateChangeStorage).size < size)
+
+	pruned, err := s.service().GetAllInstanceVersion(req)
+	require.NoError(t, err)
+	require.Equal(t, res.StateChanges[n-2:], pruned.StateChanges)
+
+	_, err = s.service().PruneStateChanges(&PruneStateChanges{ByzCoinID: scID, BlockIndex: 1000})
+	require.NoError(t, err)
+	pruned, err = s.service().GetAllInstanceVersion(req)
+	require.NoError(t, err)
+	require.Equal(t, res.StateChanges[n-1:], pruned.StateChanges)
+
+	// The chain keeps working and storing its history.
+	addDummyTxs(t, s, 1, 1, 5)
+	pruned, err = s.service().GetAllInstanceVersion(req)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(pruned.StateChanges))
+
+	_, err = s.service().PruneStateChanges(&PruneStateChanges{ByzCoinID: scID})
+	require.Error(t, err)
+	_, err = s.service().PruneStateChanges(&PruneStateChanges{ByzCoinID: genID().Slice(), BlockIndex: 1})
+	require.Error(t, err)
+}
+
 // This tests that the state change storage will actually
 // store them and increase the versions accordingly over
 // several transactions and instructions
@@ -2154,6 +3765,174 @@ func TestService_StateChangeStorageCatchUp(t *testing.T) {
 	require.True(t, false, "the new conode has never caught up in the last 10s")
 }
 
+// Checks that blocks are still processed when the history is disabled and
+// that the history queries return a clear error.
+func TestService_StateChangeStorageDisabled(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	for _, service := range s.services {
+		service.stateChangeStorage = noStateChangeStorage{}
+	}
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	pr := s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+	require.True(t, pr.InclusionProof.Match(tx.Instructions[0].Hash()))
+
+	_, err = s.service().GetLastInstanceVersion(&GetLastInstanceVersion{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  NewInstanceID(tx.Instructions[0].Hash()),
+	})
+	require.Equal(t, errHistoryDisabled, err)
+}
+
+// Only the trusted nodes are used to catch up.
+func TestService_CatchUpTrusted(t *testing.T) {
+	defer func(trusted map[string]bool) {
+		catchupTrusted = trusted
+	}(catchupTrusted)
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	addDummyTxs(t, s, 1, 1, 1)
+
+	pub1 := s.roster.List[1].Public.String()
+	pub2 := s.roster.List[2].Public.String()
+	trusted, err := parseTrustedNodes(" " + pub1 + ",," + strings.ToUpper(pub2))
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{pub1: true, pub2: true}, trusted)
+	_, err = parseTrustedNodes("not hex")
+	require.Error(t, err)
+
+	catchupTrusted = nil
+	require.Equal(t, s.roster.List, trustedForCatchup(s.roster.List))
+	r, err := catchupRoster(s.roster)
+	require.NoError(t, err)
+	require.Equal(t, s.roster, r)
+
+	// The node usually used to download the state is not trusted, so a
+	// subleader has to be used.
+	catchupTrusted = map[string]bool{pub1: true}
+	require.Equal(t, s.roster.List[1:2], trustedForCatchup(s.roster.List))
+	servers, _, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	service := s.local.GetServices(servers, ByzCoinID)[0].(*Service)
+	require.NoError(t, service.downloadDB(s.genesis))
+
+	catchupTrusted = map[string]bool{"1234": true}
+	_, err = catchupRoster(s.roster)
+	require.Error(t, err)
+	servers, _, _ = s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	service = s.local.GetServices(servers, ByzCoinID)[0].(*Service)
+	err = service.downloadDB(s.genesis)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "none of the trusted nodes")
+}
+
+// An invalid environment variable is an error of the service, and doesn't
+// stop the program.
+func TestService_ParseEnv(t *testing.T) {
+	defer func(d int) {
+		catchupMaxDistance = d
+	}(catchupMaxDistance)
+	defer os.Unsetenv(envCatchupMaxDistance)
+
+	require.NoError(t, os.Setenv(envCatchupMaxDistance, "12"))
+	require.NoError(t, parseEnv())
+	require.Equal(t, 12, catchupMaxDistance)
+	require.NoError(t, os.Setenv(envCatchupMaxDistance, "twelve"))
+	err := parseEnv()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), envCatchupMaxDistance)
+}
+
+// A node too far behind must not try to catch up on its own.
+func TestService_CatchUpMaxDistance(t *testing.T) {
+	defer func(d int) {
+		catchupMaxDistance = d
+	}(catchupMaxDistance)
+	catchupMaxDistance = 5
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// Without the limit, the node would try forever to get this block
+	// that doesn't exist.
+	sb := s.genesis.Copy()
+	sb.GenesisID = s.genesis.SkipChainID()
+	sb.Index += 10
+	done := make(chan bool)
+	go func() {
+		s.service().catchUp(sb)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * testInterval):
+		t.Fatal("catch up didn't stop")
+	}
+
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 0, st.GetIndex())
+}
+
+// A block whose trie root doesn't match the local state must not be applied,
+// and the node must refuse to apply further blocks of that chain.
+func TestService_StoreFailure(t *testing.T) {
+	// A single node, so that its key is enough to sign the forward links.
+	s := newSerN(t, 1, testInterval, 1, false)
+	defer s.local.CloseAll()
+	scID := s.genesis.SkipChainID()
+
+	body, err := protobuf.Encode(&DataBody{})
+	require.NoError(t, err)
+	header, err := protobuf.Encode(&DataHeader{
+		TrieRoot:              genID().Slice(),
+		ClientTransactionHash: TxResults{}.Hash(),
+		StateChangesHash:      StateChanges{}.Hash(),
+		Timestamp:             time.Now().UnixNano(),
+	})
+	require.NoError(t, err)
+	// The blocks are linked from the previous one, else the database
+	// refuses them.
+	privs := []kyber.Scalar{s.hosts[0].ServerIdentity.ServicePrivate(skipchain.ServiceName)}
+	prev := s.service().db().GetByID(scID)
+	newBlock := func(index int) *skipchain.SkipBlock {
+		sb := s.genesis.Copy()
+		sb.GenesisID = scID
+		sb.Index = index
+		sb.BackLinkIDs = []skipchain.SkipBlockID{prev.Hash}
+		sb.ForwardLink = nil
+		sb.Payload = body
+		sb.Data = header
+		sb.Hash = sb.CalculateHash()
+		from := prev.Copy()
+		from.ForwardLink = genForwardLink(t, prev, sb, privs)
+		_, err := s.service().db().StoreBlocks([]*skipchain.SkipBlock{from, sb})
+		require.NoError(t, err)
+		prev = sb
+		return sb
+	}
+
+	// Storing the block applies it.
+	newBlock(1)
+	st, err := s.service().getStateTrie(scID)
+	require.NoError(t, err)
+	require.Equal(t, 0, st.GetIndex())
+	s.service().updateTrieLock.Lock()
+	failed, ok := s.service().storeFailures[string(scID)]
+	s.service().updateTrieLock.Unlock()
+	require.True(t, ok)
+	require.Equal(t, 1, failed)
+
+	err = s.service().updateTrieCallback(newBlock(2).Hash)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "halted since block 1")
+	require.Equal(t, 0, st.GetIndex())
+}
+
 // Tests that a conode can't be overflowed by catching requests
 func TestService_TestCatchUpHistory(t *testing.T) {
 	s := newSer(t, 1, testInterval)
@@ -2372,6 +4151,7 @@ func newSerN(t *testing.T, step int, interval time.Duration, n int, viewchange b
 			"spawn:" + panicContract,
 			"spawn:" + slowContract,
 			"spawn:" + stateChangeCacheContract,
+			"spawn:" + versionedContractID,
 			"delete:" + dummyContract,
 		}, s.signer.Identity())
 	require.Nil(t, err)
@@ -2459,6 +4239,16 @@ func adaptorNoVerify(cb func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin)
 	}
 }
 
+// versionedContract is a dummy contract that writes the values of its
+// instances in version 2 of their format.
+type versionedContract struct {
+	contractAdaptorNV
+}
+
+func (versionedContract) ContractVersion() uint32 {
+	return 2
+}
+
 func invalidContractFunc(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
 	return nil, nil, errors.New("this invalid contract always returns an error")
 }
@@ -2489,6 +4279,11 @@ func dummyContractFunc(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]Sta
 			NewStateChange(Update, inst.InstanceID, dummyContract, inst.Invoke.Args[0].Value, darcID),
 		}, nil, nil
 	case DeleteType:
+		if inst.Delete.Soft {
+			return []StateChange{
+				NewTombstone(inst.InstanceID, inst.Delete.ContractID, darcID),
+			}, nil, nil
+		}
 		return []StateChange{
 			NewStateChange(Remove, inst.InstanceID, "", nil, darcID),
 		}, nil, nil
@@ -24,6 +24,7 @@ type stateChangeValue struct {
 	merkleRoot []byte
 	txOut      []TxResult
 	states     StateChanges
+	cost       uint64
 }
 
 func newStateChangeCache() stateChangeCache {
@@ -32,7 +33,7 @@ func newStateChangeCache() stateChangeCache {
 	}
 }
 
-func (c *stateChangeCache) get(scID skipchain.SkipBlockID, digest []byte) (merkleRoot []byte, txOut TxResults, states StateChanges, err error) {
+func (c *stateChangeCache) get(scID skipchain.SkipBlockID, digest []byte) (merkleRoot []byte, txOut TxResults, states StateChanges, cost uint64, err error) {
 	c.Lock()
 	defer c.Unlock()
 	key := string(scID)
@@ -49,10 +50,11 @@ func (c *stateChangeCache) get(scID skipchain.SkipBlockID, digest []byte) (merkl
 	merkleRoot = out.merkleRoot
 	txOut = out.txOut
 	states = out.states
+	cost = out.cost
 	return
 }
 
-func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, merkleRoot []byte, txOut TxResults, states StateChanges) {
+func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, merkleRoot []byte, txOut TxResults, states StateChanges, cost uint64) {
 	c.Lock()
 	defer c.Unlock()
 	key := string(scID)
@@ -61,5 +63,6 @@ func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, mer
 		merkleRoot: merkleRoot,
 		txOut:      txOut,
 		states:     states,
+		cost:       cost,
 	}
 }
@@ -0,0 +1,318 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// How many blocks of state changes can wait to be stored in the history of
+// the instances. If it is 0, which is the default, the state changes are
+// stored before the block is applied, else they are stored in the background,
+// in the order of the blocks. It can be set with the
+// BYZCOIN_STATECHANGE_QUEUE environment variable.
+var stateChangeQueueSize = 0
+
+const envStateChangeQueue = "BYZCOIN_STATECHANGE_QUEUE"
+
+// How long the queue waits before trying again to store state changes that
+// couldn't be stored, doubled after every failure up to
+// stateChangeQueueMaxBackoff.
+var stateChangeQueueBackoff = 100 * time.Millisecond
+
+const stateChangeQueueMaxBackoff = 10 * time.Second
+
+// bucketStateChangeQueue is the bucket holding the blocks of the queue until
+// their state changes are stored.
+var bucketStateChangeQueue = []byte("statechangequeue")
+
+type queuedStateChanges struct {
+	scs StateChanges
+	// sb is nil for the blocks loaded from the database, until the queue
+	// gets them with their ID.
+	sb   *skipchain.SkipBlock
+	sbID skipchain.SkipBlockID
+	// key is the key of the block in the bucket of the queue.
+	key []byte
+}
+
+// persistedStateChanges is how a queued block is kept in the database.
+type persistedStateChanges struct {
+	BlockID      skipchain.SkipBlockID
+	StateChanges StateChanges
+}
+
+// stateChangeQueue stores the state changes in the background, so that a
+// block can be applied without waiting for the history to be written.
+//
+// The state changes are stored one block after the other, in the order they
+// have been appended. If the backend fails, the same block is tried again
+// until it is stored, so no block is skipped. Once the queue is full, append
+// waits for a place, which stops the processing of the blocks rather than
+// losing their history. The queries flush the queue first, so they see all
+// the blocks appended before them.
+//
+// The state changes of every queued block are also kept in a bucket of the
+// database until they are stored, so that the blocks still queued when the
+// node stops are loaded and stored again when it starts.
+type stateChangeQueue struct {
+	stateChangeBackend
+	size     int
+	db       *bbolt.DB
+	bucket   []byte
+	getBlock func(skipchain.SkipBlockID) *skipchain.SkipBlock
+
+	sync.Mutex
+	cond    *sync.Cond
+	pending []queuedStateChanges
+	running bool
+	// closing stops the queue at the first failure of the backend, instead
+	// of trying again.
+	closing bool
+	// queued is the number of blocks appended, and stored the number of
+	// blocks given to the backend.
+	queued uint64
+	stored uint64
+	// err is the error of the block the queue is trying to store, nil if
+	// the last try succeeded.
+	err      error
+	failures int
+}
+
+// newStateChangeQueue returns a queue storing the state changes in backend,
+// and starts to store the blocks left in the bucket of the database by the
+// previous run of the node. getBlock returns the blocks of these.
+func newStateChangeQueue(backend stateChangeBackend, size int, db *bbolt.DB, bucket []byte,
+	getBlock func(skipchain.SkipBlockID) *skipchain.SkipBlock) (*stateChangeQueue, error) {
+	q := &stateChangeQueue{stateChangeBackend: backend, size: size, db: db,
+		bucket: bucket, getBlock: getBlock}
+	q.cond = sync.NewCond(&q.Mutex)
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var p persistedStateChanges
+			if err := protobuf.Decode(v, &p); err != nil {
+				return fmt.Errorf("couldn't decode queued state changes: %v", err)
+			}
+			q.pending = append(q.pending, queuedStateChanges{scs: p.StateChanges,
+				sbID: p.BlockID, key: append([]byte{}, k...)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(q.pending) > 0 {
+		log.Lvlf1("storing the state changes of %d blocks left in the queue", len(q.pending))
+		q.queued = uint64(len(q.pending))
+		q.running = true
+		go q.run()
+	}
+	return q, nil
+}
+
+// append queues the state changes of the block. It only waits if the queue is
+// full.
+func (q *stateChangeQueue) append(scs StateChanges, sb *skipchain.SkipBlock) error {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.pending) >= q.size {
+		q.cond.Wait()
+	}
+	key, err := q.persist(scs, sb)
+	if err != nil {
+		return err
+	}
+	q.pending = append(q.pending, queuedStateChanges{scs: scs, sb: sb, sbID: sb.Hash, key: key})
+	q.queued++
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	return nil
+}
+
+// persist keeps the state changes of the block in the database until they are
+// stored, and returns their key. The keys follow the order of the blocks.
+func (q *stateChangeQueue) persist(scs StateChanges, sb *skipchain.SkipBlock) ([]byte, error) {
+	buf, err := protobuf.Encode(&persistedStateChanges{BlockID: sb.Hash, StateChanges: scs})
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 8)
+	err = q.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(q.bucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, buf)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't queue the state changes of block %d: %v", sb.Index, err)
+	}
+	return key, nil
+}
+
+// run stores the queued blocks until the queue is empty, or the backend fails
+// while the queue is closing.
+func (q *stateChangeQueue) run() {
+	backoff := stateChangeQueueBackoff
+	for {
+		q.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.cond.Broadcast()
+			q.Unlock()
+			return
+		}
+		next := q.pending[0]
+		q.Unlock()
+
+		var err error
+		if next.sb == nil {
+			if next.sb = q.getBlock(next.sbID); next.sb == nil {
+				err = fmt.Errorf("block %x is missing", next.sbID)
+			}
+		}
+		if err == nil {
+			err = q.stateChangeBackend.append(next.scs, next.sb)
+		}
+
+		q.Lock()
+		if err != nil {
+			if next.sb != nil {
+				err = fmt.Errorf("couldn't store the state changes of block %d of %x: %v",
+					next.sb.Index, next.sb.SkipChainID(), err)
+			}
+			q.err = err
+			q.failures++
+			closing := q.closing
+			if closing {
+				q.running = false
+			}
+			q.cond.Broadcast()
+			q.Unlock()
+			log.Error("CRITICAL:", err)
+			if closing {
+				return
+			}
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > stateChangeQueueMaxBackoff {
+				backoff = stateChangeQueueMaxBackoff
+			}
+			continue
+		}
+		q.Unlock()
+
+		// If the block cannot be removed from the database, it is
+		// stored again at the next start, which gives the same
+		// entries.
+		err = q.db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(q.bucket).Delete(next.key)
+		})
+		if err != nil {
+			log.Error("couldn't remove stored state changes from the queue:", err)
+		}
+
+		q.Lock()
+		backoff = stateChangeQueueBackoff
+		q.err = nil
+		q.pending = q.pending[1:]
+		q.stored++
+		q.cond.Broadcast()
+		q.Unlock()
+	}
+}
+
+// flush waits until all the blocks appended before the call are stored. It
+// returns early with an error if the backend fails to store one of them.
+func (q *stateChangeQueue) flush() error {
+	q.Lock()
+	defer q.Unlock()
+	target := q.queued
+	for q.stored < target {
+		if q.err != nil {
+			return q.err
+		}
+		q.cond.Wait()
+	}
+	return nil
+}
+
+// close stores the queued blocks and stops the queue. If the backend fails,
+// it returns the error without trying again: the blocks left are stored when
+// the node starts again. Appending a block starts the queue again.
+func (q *stateChangeQueue) close() error {
+	q.Lock()
+	defer q.Unlock()
+	q.closing = true
+	for q.running {
+		q.cond.Wait()
+	}
+	q.closing = false
+	if len(q.pending) > 0 {
+		return q.err
+	}
+	return nil
+}
+
+func (q *stateChangeQueue) getAll(iid []byte, sid skipchain.SkipBlockID) ([]StateChangeEntry, error) {
+	if err := q.flush(); err != nil {
+		return nil, err
+	}
+	return q.stateChangeBackend.getAll(iid, sid)
+}
+
+func (q *stateChangeQueue) getByVersion(iid []byte, ver uint64, sid skipchain.SkipBlockID) (StateChangeEntry, bool, error) {
+	if err := q.flush(); err != nil {
+		return StateChangeEntry{}, false, err
+	}
+	return q.stateChangeBackend.getByVersion(iid, ver, sid)
+}
+
+func (q *stateChangeQueue) getByBlock(sid skipchain.SkipBlockID, idx int) (StateChangeEntries, error) {
+	if err := q.flush(); err != nil {
+		return nil, err
+	}
+	return q.stateChangeBackend.getByBlock(sid, idx)
+}
+
+func (q *stateChangeQueue) getLast(iid []byte, sid skipchain.SkipBlockID) (StateChangeEntry, bool, error) {
+	if err := q.flush(); err != nil {
+		return StateChangeEntry{}, false, err
+	}
+	return q.stateChangeBackend.getLast(iid, sid)
+}
+
+func (q *stateChangeQueue) prune(sid skipchain.SkipBlockID, index int) (int, error) {
+	if err := q.flush(); err != nil {
+		return 0, err
+	}
+	return q.stateChangeBackend.prune(sid, index)
+}
+
+// GetStatus returns how many blocks are waiting to be stored, and the error
+// of the backend if it fails to store them.
+func (q *stateChangeQueue) GetStatus() *onet.Status {
+	q.Lock()
+	defer q.Unlock()
+	out := map[string]string{
+		"Queued":   strconv.Itoa(len(q.pending)),
+		"Stored":   strconv.FormatUint(q.stored, 10),
+		"Failures": strconv.Itoa(q.failures),
+	}
+	if q.err != nil {
+		out["Error"] = q.err.Error()
+	}
+	return &onet.Status{Field: out}
+}
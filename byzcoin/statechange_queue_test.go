@@ -0,0 +1,184 @@
+package byzcoin
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/skipchain"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// failingStateChangeStorage fails to append the state changes as long as
+// fail is set.
+type failingStateChangeStorage struct {
+	*stateChangeStorage
+	sync.Mutex
+	fail bool
+}
+
+func (s *failingStateChangeStorage) setFail(fail bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.fail = fail
+}
+
+func (s *failingStateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) error {
+	s.Lock()
+	fail := s.fail
+	s.Unlock()
+	if fail {
+		return errors.New("disk is full")
+	}
+	return s.stateChangeStorage.append(scs, sb)
+}
+
+// The queue stores all the blocks in order, even if the backend fails for a
+// while.
+func TestStateChangeQueue(t *testing.T) {
+	defer func(backoff time.Duration) {
+		stateChangeQueueBackoff = backoff
+	}(stateChangeQueueBackoff)
+	stateChangeQueueBackoff = time.Millisecond
+
+	storage, name := generateDB(t)
+	defer os.Remove(name)
+	backend := &failingStateChangeStorage{stateChangeStorage: storage}
+	q := newTestStateChangeQueue(t, backend, nil)
+
+	n := 10
+	iid := genID().Slice()
+	sbs := make([]*skipchain.SkipBlock, n)
+	for i := range sbs {
+		sbs[i] = createBlock()
+		sbs[i].GenesisID = sbs[0].Hash
+		sbs[i].Index = i
+	}
+	appendBlocks := func(from, to int) {
+		for i := from; i < to; i++ {
+			sc := StateChange{InstanceID: iid, Value: []byte{byte(i)}, Version: uint64(i)}
+			require.NoError(t, q.append(StateChanges{sc}, sbs[i]))
+		}
+	}
+
+	appendBlocks(0, n/2)
+	entries, err := q.getAll(iid, sbs[0].SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, n/2, len(entries))
+
+	// The backend fails: the queries return the error and the blocks stay
+	// in the queue until the backend works again.
+	backend.setFail(true)
+	appendBlocks(n/2, n/2+1)
+	_, err = q.getAll(iid, sbs[0].SkipChainID())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "disk is full")
+	status := q.GetStatus().Field
+	require.Equal(t, "1", status["Queued"])
+	require.Contains(t, status["Error"], "block 5")
+	require.NotEqual(t, "0", status["Failures"])
+
+	backend.setFail(false)
+	appendBlocks(n/2+1, n)
+	require.NoError(t, q.flush())
+
+	entries, err = q.getAll(iid, sbs[0].SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, n, len(entries))
+	for i, e := range entries {
+		require.Equal(t, uint64(i), e.StateChange.Version)
+		require.Equal(t, i, e.BlockIndex)
+	}
+	sce, ok, err := q.getLast(iid, sbs[0].SkipChainID())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(n-1), sce.StateChange.Version)
+
+	status = q.GetStatus().Field
+	require.Equal(t, "0", status["Queued"])
+	require.Equal(t, "10", status["Stored"])
+	require.Empty(t, status["Error"])
+}
+
+// The blocks left in the queue when it is closed are stored by the next
+// queue.
+func TestStateChangeQueue_Close(t *testing.T) {
+	storage, name := generateDB(t)
+	defer os.Remove(name)
+	backend := &failingStateChangeStorage{stateChangeStorage: storage}
+	iid := genID().Slice()
+	sbs := []*skipchain.SkipBlock{createBlock(), createBlock()}
+	sbs[1].GenesisID = sbs[0].Hash
+	sbs[1].Index = 1
+	getBlock := func(id skipchain.SkipBlockID) *skipchain.SkipBlock {
+		for _, sb := range sbs {
+			if sb.Hash.Equal(id) {
+				return sb
+			}
+		}
+		return nil
+	}
+
+	q := newTestStateChangeQueue(t, backend, getBlock)
+	require.NoError(t, q.append(StateChanges{{InstanceID: iid, Version: 0}}, sbs[0]))
+	require.NoError(t, q.close())
+	backend.setFail(true)
+	require.NoError(t, q.append(StateChanges{{InstanceID: iid, Version: 1}}, sbs[1]))
+	err := q.close()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "disk is full")
+
+	backend.setFail(false)
+	q = newTestStateChangeQueue(t, backend, getBlock)
+	entries, err := q.getAll(iid, sbs[0].SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, 1, entries[1].BlockIndex)
+	require.NoError(t, q.close())
+
+	// Once stored, the blocks are removed from the database.
+	q = newTestStateChangeQueue(t, backend, getBlock)
+	require.Empty(t, q.pending)
+}
+
+// newTestStateChangeQueue returns a queue of two blocks storing the state
+// changes in backend, and keeping the queued blocks in its database.
+func newTestStateChangeQueue(t *testing.T, backend *failingStateChangeStorage,
+	getBlock func(skipchain.SkipBlockID) *skipchain.SkipBlock) *stateChangeQueue {
+	require.NoError(t, backend.db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketStateChangeQueue)
+		return err
+	}))
+	q, err := newStateChangeQueue(backend, 2, backend.db, bucketStateChangeQueue, getBlock)
+	require.NoError(t, err)
+	return q
+}
+
+// The history of a chain is complete when the state changes are stored in
+// the background.
+func TestService_StateChangeQueue(t *testing.T) {
+	defer func(size int) {
+		stateChangeQueueSize = size
+	}(stateChangeQueueSize)
+	stateChangeQueueSize = 1
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	require.IsType(t, &stateChangeQueue{}, s.service().stateChangeStorage)
+	addDummyTxs(t, s, 4, 1, 1)
+
+	res, err := s.service().GetAllInstanceVersion(&GetAllInstanceVersion{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  NewInstanceID(publicVersionKey(s.signer.Identity().String())),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.StateChanges)
+	for i := 1; i < len(res.StateChanges); i++ {
+		require.Equal(t, res.StateChanges[i-1].StateChange.Version+1,
+			res.StateChanges[i].StateChange.Version)
+	}
+	require.Equal(t, uint64(4), res.StateChanges[len(res.StateChanges)-1].StateChange.Version)
+}
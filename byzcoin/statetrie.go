@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
@@ -11,11 +12,15 @@ import (
 )
 
 var errKeyNotSet = errors.New("key not set")
+var errInstanceNotFound = errors.New("instance not found")
 
 // ReadOnlyStateTrie is the read-only interface for StagingStateTrie and
 // StateTrie.
 type ReadOnlyStateTrie interface {
 	GetValues(key []byte) (value []byte, version uint64, contractID string, darcID darc.ID, err error)
+	// GetContractVersion returns the version of the format of the value of
+	// an instance, as given by its contract with ContractWithVersion.
+	GetContractVersion(key []byte) (uint32, error)
 	GetProof(key []byte) (*trie.Proof, error)
 	GetIndex() int
 	GetNonce() ([]byte, error)
@@ -74,6 +79,26 @@ func (t *stagingStateTrie) GetValues(key []byte) (value []byte, version uint64,
 	return
 }
 
+// GetContractVersion returns the version of the format of the value stored
+// under key. An error is returned if the key does not exist.
+func (t *stagingStateTrie) GetContractVersion(key []byte) (uint32, error) {
+	return getContractVersion(t.Get, key)
+}
+
+// isDeleted returns true if key holds the tombstone of a soft deleted
+// instance.
+func (t *stagingStateTrie) isDeleted(key []byte) (bool, error) {
+	buf, err := t.Get(key)
+	if err != nil || buf == nil {
+		return false, err
+	}
+	vals, err := decodeStateChangeBody(buf)
+	if err != nil {
+		return false, err
+	}
+	return vals.Deleted, nil
+}
+
 // Commit commits the staged data to the source trie.
 func (t *stagingStateTrie) Commit() error {
 	return t.StagingTrie.Commit()
@@ -149,8 +174,8 @@ func (t *stateTrie) VerifiedStoreAll(scs StateChanges, index int, expectedRoot [
 		if err := t.SetMetadataWithBucket([]byte(trieIndexKey), indexBuf, b); err != nil {
 			return err
 		}
-		if !bytes.Equal(t.GetRootWithBucket(b), expectedRoot) {
-			return errors.New("root verfication failed")
+		if root := t.GetRootWithBucket(b); !bytes.Equal(root, expectedRoot) {
+			return fmt.Errorf("root verification failed: expected %x, got %x", expectedRoot, root)
 		}
 		return nil
 	})
@@ -182,6 +207,29 @@ func (t *stateTrie) GetValues(key []byte) (value []byte, version uint64, contrac
 	return
 }
 
+// GetContractVersion returns the version of the format of the value stored
+// under key. An error is returned if the key does not exist.
+func (t *stateTrie) GetContractVersion(key []byte) (uint32, error) {
+	return getContractVersion(t.Get, key)
+}
+
+// getContractVersion decodes the version of the format of the value stored
+// under key, which get returns.
+func getContractVersion(get func([]byte) ([]byte, error), key []byte) (uint32, error) {
+	buf, err := get(key)
+	if err != nil {
+		return 0, err
+	}
+	if buf == nil {
+		return 0, errKeyNotSet
+	}
+	body, err := decodeStateChangeBody(buf)
+	if err != nil {
+		return 0, err
+	}
+	return body.ContractVersion, nil
+}
+
 // GetIndex gets the latest index.
 func (t *stateTrie) GetIndex() int {
 	indexBuf := t.GetMetadata([]byte(trieIndexKey))
@@ -1,20 +1,63 @@
 package byzcoin
 
 import (
+	"errors"
 	"sync"
 
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 )
 
 func init() {
-	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{})
+	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{},
+		&StreamProofRequest{})
 }
 
 type streamingManager struct {
 	sync.Mutex
 	// key: skipchain ID, value: slice of listeners
 	listeners map[string][]chan *StreamingResponse
+	// key: skipchain ID, value: listeners of a key, by their ID
+	proofListeners map[string]map[int]*proofListener
+	nextProofID    int
+}
+
+// proofListener follows the changes of a key. Only the latest proof is
+// kept for a listener that is slower than the blocks, so that it never
+// holds back the processing of the blocks.
+type proofListener struct {
+	key []byte
+	// exists and version describe the key in the last proof sent.
+	exists  bool
+	version uint64
+	out     chan *GetProofResponse
+}
+
+// send replaces the proof waiting to be read, if any, by resp. It must be
+// called with the lock of the manager.
+func (l *proofListener) send(resp *GetProofResponse) {
+	select {
+	case l.out <- resp:
+	default:
+		select {
+		case <-l.out:
+		default:
+		}
+		l.out <- resp
+	}
+}
+
+// keyVersion returns whether the key is in the trie and its version.
+func keyVersion(st ReadOnlyStateTrie, key []byte) (bool, uint64, error) {
+	_, version, _, _, err := st.GetValues(key)
+	if err == errKeyNotSet {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, version, nil
 }
 
 func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
@@ -26,6 +69,7 @@ func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
 		return
 	}
 
+	logStreaming.printf(4, "sending block %d of %x to %d listeners", block.Index, []byte(scID), len(ls))
 	for _, c := range ls {
 		c <- &StreamingResponse{
 			Block: block,
@@ -33,6 +77,73 @@ func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
 	}
 }
 
+// notifyProofs sends a new proof to the listeners of the chain whose key
+// changed in st, which must be the trie of the latest block.
+func (s *streamingManager) notifyProofs(scID skipchain.SkipBlockID, st ReadOnlyStateTrie, db *skipchain.SkipBlockDB) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, l := range s.proofListeners[string(scID)] {
+		exists, version, err := keyVersion(st, l.key)
+		if err != nil {
+			log.Errorf("couldn't read key %x for proof listener %d: %v", l.key, id, err)
+			continue
+		}
+		if exists == l.exists && version == l.version {
+			continue
+		}
+		p, err := NewProof(st, db, scID, l.key)
+		if err != nil {
+			log.Errorf("couldn't create proof of %x for listener %d: %v", l.key, id, err)
+			continue
+		}
+		logStreaming.printf(4, "sending proof of %x at version %d to listener %d", l.key, version, id)
+		l.exists, l.version = exists, version
+		l.send(&GetProofResponse{Version: CurrentVersion, Proof: *p})
+	}
+}
+
+// newProofListener registers a listener for the key, whose first proof is
+// already waiting to be read.
+func (s *streamingManager) newProofListener(scID string, key []byte, exists bool,
+	version uint64, first *GetProofResponse) (chan *GetProofResponse, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.proofListeners == nil {
+		s.proofListeners = make(map[string]map[int]*proofListener)
+	}
+	if s.proofListeners[scID] == nil {
+		s.proofListeners[scID] = make(map[int]*proofListener)
+	}
+	id := s.nextProofID
+	s.nextProofID++
+	l := &proofListener{
+		key:     key,
+		exists:  exists,
+		version: version,
+		out:     make(chan *GetProofResponse, 1),
+	}
+	l.out <- first
+	s.proofListeners[scID][id] = l
+	return l.out, id
+}
+
+func (s *streamingManager) stopProofListener(scID string, id int) {
+	s.Lock()
+	defer s.Unlock()
+
+	l, ok := s.proofListeners[scID][id]
+	if !ok {
+		panic("proof listener does not exist")
+	}
+	close(l.out)
+	delete(s.proofListeners[scID], id)
+	if len(s.proofListeners[scID]) == 0 {
+		delete(s.proofListeners, scID)
+	}
+}
+
 func (s *streamingManager) newListener(scID string) (chan *StreamingResponse, int) {
 	s.Lock()
 	defer s.Unlock()
@@ -70,9 +181,53 @@ func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResp
 	stopChan := make(chan bool)
 	key := string(msg.ID)
 	outChan, idx := s.streamingMan.newListener(key)
+	logStreaming.printf(3, "%s: new listener %d for %x", s.ServerIdentity(), idx, msg.ID)
 	go func() {
 		<-stopChan
+		logStreaming.printf(3, "%s: stopping listener %d for %x", s.ServerIdentity(), idx, msg.ID)
 		s.streamingMan.stopListener(key, idx)
 	}()
 	return outChan, stopChan, nil
 }
+
+// StreamProof sends a proof of the key to the client, and then a new one
+// every time a block changes the version of the key, or adds or removes it,
+// until the client closes the connection.
+func (s *Service) StreamProof(msg *StreamProofRequest) (chan *GetProofResponse, chan bool, error) {
+	sb := s.db().GetByID(msg.ID)
+	if sb == nil || sb.Index != 0 {
+		return nil, nil, errors.New("unknown byzcoinID")
+	}
+
+	// No block must be applied between the first proof and the
+	// registration of the listener.
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, nil, errors.New("currently catching up on our state")
+	}
+	st, err := s.GetReadOnlyStateTrie(msg.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	exists, version, err := keyVersion(st, msg.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := NewProof(st, s.db(), msg.ID, msg.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stopChan := make(chan bool)
+	key := string(msg.ID)
+	outChan, id := s.streamingMan.newProofListener(key, msg.Key, exists, version,
+		&GetProofResponse{Version: CurrentVersion, Proof: *p})
+	logStreaming.printf(3, "%s: new proof listener %d for %x in %x", s.ServerIdentity(), id, msg.Key, msg.ID)
+	go func() {
+		<-stopChan
+		logStreaming.printf(3, "%s: stopping proof listener %d for %x", s.ServerIdentity(), id, msg.ID)
+		s.streamingMan.stopProofListener(key, id)
+	}()
+	return outChan, stopChan, nil
+}
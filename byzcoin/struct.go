@@ -11,6 +11,7 @@ import (
 
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 	bbolt "go.etcd.io/bbolt"
 )
@@ -52,8 +53,10 @@ func (sces StateChangeEntries) Swap(i, j int) {
 // can correctly work on those copies.
 func (sc StateChange) Copy() StateChange {
 	c := StateChange{
-		StateAction: sc.StateAction,
-		Version:     sc.Version,
+		StateAction:     sc.StateAction,
+		Version:         sc.Version,
+		Deleted:         sc.Deleted,
+		ContractVersion: sc.ContractVersion,
 	}
 	c.InstanceID = append([]byte{}, sc.InstanceID...)
 	c.ContractID = sc.ContractID
@@ -62,6 +65,86 @@ func (sc StateChange) Copy() StateChange {
 	return c
 }
 
+// stateChangeBackend is the interface used by the service to keep track of the
+// history of the instances. The default implementation is stateChangeStorage,
+// that stores the state changes in the bbolt database of the conode.
+type stateChangeBackend interface {
+	// append stores the state changes of the given block.
+	append(scs StateChanges, sb *skipchain.SkipBlock) error
+	// getAll returns all the stored versions of an instance.
+	getAll(iid []byte, sid skipchain.SkipBlockID) ([]StateChangeEntry, error)
+	// getByVersion returns the entry of an instance at the given version.
+	getByVersion(iid []byte, ver uint64, sid skipchain.SkipBlockID) (StateChangeEntry, bool, error)
+	// getByBlock returns the entries stored for the given block index.
+	getByBlock(sid skipchain.SkipBlockID, idx int) (StateChangeEntries, error)
+	// getLast returns the latest entry of an instance.
+	getLast(iid []byte, sid skipchain.SkipBlockID) (StateChangeEntry, bool, error)
+	// calculateSize initializes the statistics of the backend.
+	calculateSize() error
+	// prune removes the entries of the blocks before the given index,
+	// except the latest entry of every instance, and returns how many
+	// entries have been removed.
+	prune(sid skipchain.SkipBlockID, index int) (int, error)
+}
+
+// Values of the environment variable envStateChangeStorage.
+const (
+	stateChangeStorageBbolt = "bbolt"
+	stateChangeStorageNone  = "none"
+)
+
+// envStateChangeStorage selects the backend used to store the history of the
+// instances. It can be stateChangeStorageBbolt, which is the default, or
+// stateChangeStorageNone to disable the history.
+const envStateChangeStorage = "BYZCOIN_STATECHANGE_STORAGE"
+
+var errHistoryDisabled = errors.New("the history of the instances is disabled on this node")
+
+// newStateChangeBackend returns the backend corresponding to kind. An empty
+// kind returns the default backend.
+func newStateChangeBackend(c *onet.Context, kind string) (stateChangeBackend, error) {
+	switch kind {
+	case "", stateChangeStorageBbolt:
+		return newStateChangeStorage(c), nil
+	case stateChangeStorageNone:
+		return noStateChangeStorage{}, nil
+	default:
+		return nil, fmt.Errorf("unknown state change storage \"%s\"", kind)
+	}
+}
+
+// noStateChangeStorage drops all the state changes. It trades the history
+// queries for disk space, every query returns errHistoryDisabled.
+type noStateChangeStorage struct{}
+
+func (noStateChangeStorage) append(StateChanges, *skipchain.SkipBlock) error {
+	return nil
+}
+
+func (noStateChangeStorage) getAll([]byte, skipchain.SkipBlockID) ([]StateChangeEntry, error) {
+	return nil, errHistoryDisabled
+}
+
+func (noStateChangeStorage) getByVersion([]byte, uint64, skipchain.SkipBlockID) (StateChangeEntry, bool, error) {
+	return StateChangeEntry{}, false, errHistoryDisabled
+}
+
+func (noStateChangeStorage) getByBlock(skipchain.SkipBlockID, int) (StateChangeEntries, error) {
+	return nil, errHistoryDisabled
+}
+
+func (noStateChangeStorage) getLast([]byte, skipchain.SkipBlockID) (StateChangeEntry, bool, error) {
+	return StateChangeEntry{}, false, errHistoryDisabled
+}
+
+func (noStateChangeStorage) calculateSize() error {
+	return nil
+}
+
+func (noStateChangeStorage) prune(skipchain.SkipBlockID, int) (int, error) {
+	return 0, errHistoryDisabled
+}
+
 // stateChangeStorage stores the state changes using their instance ID, the block index and
 // their version to yield a key. This key has the property to sort the key-value pairs
 // first by instance ID and then by version so we can use the BoltDB key traversal.
@@ -299,6 +382,66 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 	return err
 }
 
+// prune removes the entries of the blocks before the given index, except
+// the latest entry of every instance, so that the latest state of every
+// instance can still be read. The keys are collected before being deleted,
+// so that no cursor is moved after a deletion.
+func (s *stateChangeStorage) prune(sid skipchain.SkipBlockID, index int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	size := s.size
+	var removed int
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		var sizes []int
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; {
+			nextK, nextV := c.Next()
+			last := nextK == nil || !bytes.HasPrefix(nextK, k[:prefixLength])
+			var idx int64
+			err := binary.Read(bytes.NewBuffer(k[prefixLength+versionLength:]), binary.BigEndian, &idx)
+			if err != nil {
+				return err
+			}
+			if !last && idx < int64(index) {
+				keys = append(keys, append([]byte{}, k...))
+				sizes = append(sizes, len(v))
+			}
+			k, v = nextK, nextV
+		}
+
+		for i, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			size -= sizes[i]
+		}
+		removed = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.size = size
+	return removed, nil
+}
+
+// decodeEntry decodes an entry from a copy of the value, as the decoded byte
+// slices would otherwise point to the memory of the database, which is only
+// valid during the transaction.
+func decodeEntry(v []byte, sce *StateChangeEntry) error {
+	buf := make([]byte, len(v))
+	copy(buf, v)
+	return protobuf.Decode(buf, sce)
+}
+
 // this generates a storage key using the instance ID and the version
 func (s *stateChangeStorage) key(iid []byte, ver uint64, idx int64) ([]byte, error) {
 	b := bytes.Buffer{}
@@ -410,7 +553,7 @@ func (s *stateChangeStorage) getAll(iid []byte, sid skipchain.SkipBlockID) (entr
 		c := b.Cursor()
 		for k, v := c.Seek(iid); bytes.HasPrefix(k, iid); k, v = c.Next() {
 			var sce StateChangeEntry
-			err = protobuf.Decode(v, &sce)
+			err = decodeEntry(v, &sce)
 			if err != nil {
 				return err
 			}
@@ -449,7 +592,7 @@ func (s *stateChangeStorage) getByVersion(iid []byte,
 		c := b.Cursor()
 		k, v := c.Seek(prefix)
 		if k != nil && bytes.HasPrefix(k, prefix) {
-			err := protobuf.Decode(v, &sce)
+			err := decodeEntry(v, &sce)
 			if err != nil {
 				return err
 			}
@@ -479,7 +622,7 @@ func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (ent
 		for k, v := c.First(); k != nil; k, v = c.Next() {
 			if bytes.HasSuffix(k, suffix.Bytes()) {
 				var sce StateChangeEntry
-				err = protobuf.Decode(v, &sce)
+				err = decodeEntry(v, &sce)
 				if err != nil {
 					return err
 				}
@@ -517,7 +660,7 @@ func (s *stateChangeStorage) getLast(iid []byte, sid skipchain.SkipBlockID) (sce
 		k, v := c.Prev()
 
 		if bytes.HasPrefix(k, iid) {
-			err := protobuf.Decode(v, &sce)
+			err := decodeEntry(v, &sce)
 			if err != nil {
 				return err
 			}
@@ -551,33 +694,180 @@ func (c *Coin) SafeSub(a uint64) error {
 	return errors.New("uint64 underflow")
 }
 
+// maxTxRejections is the number of refused transactions for which the reason
+// is kept.
+const maxTxRejections = 1000
+
+// txRejections keeps the reason why the latest transactions have been
+// refused, so that AddTransaction can return it to the client. Only the
+// reasons of the last maxTxRejections transactions are kept.
+type txRejections struct {
+	sync.Mutex
+	reasons map[string]error
+	order   []string
+}
+
+func (r *txRejections) add(ctxHash []byte, reason error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.reasons == nil {
+		r.reasons = make(map[string]error)
+	}
+	key := string(ctxHash)
+	if _, ok := r.reasons[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.reasons[key] = reason
+	if len(r.order) > maxTxRejections {
+		delete(r.reasons, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// get returns why the transaction has been refused, or nil if the reason is
+// not known.
+func (r *txRejections) get(ctxHash []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.reasons[string(ctxHash)]
+}
+
+const (
+	// maxTxTokens is the number of idempotency tokens for which the outcome
+	// of the transaction is kept.
+	maxTxTokens = 1000
+	// txTokenTTL is how long the idempotency tokens are kept.
+	txTokenTTL = 10 * time.Minute
+	// maxTxTokenLength is the maximal length of an idempotency token.
+	maxTxTokenLength = 64
+)
+
+// txToken is the outcome of a transaction sent with an idempotency token.
+type txToken struct {
+	ctxHash  []byte
+	pending  bool
+	accepted bool
+	results  [][]byte
+	reason   error
+	expires  time.Time
+}
+
+// txTokens keeps the outcome of the latest transactions sent with an
+// idempotency token, so that AddTransaction can answer a retry without adding
+// the transaction again. The tokens are kept for txTokenTTL, and at most
+// maxTxTokens of them.
+type txTokens struct {
+	sync.Mutex
+	tokens map[string]*txToken
+	order  []string
+}
+
+// getOrAdd returns the outcome of the transaction sent with the token, or
+// records the token as pending for the transaction with the hash ctxHash and
+// returns false if the token is not known.
+func (t *txTokens) getOrAdd(token string, ctxHash []byte) (txToken, bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.expire()
+	if tok, ok := t.tokens[token]; ok {
+		return *tok, true
+	}
+	if t.tokens == nil {
+		t.tokens = make(map[string]*txToken)
+	}
+	t.tokens[token] = &txToken{
+		ctxHash: ctxHash,
+		pending: true,
+		expires: time.Now().Add(txTokenTTL),
+	}
+	t.order = append(t.order, token)
+	if len(t.order) > maxTxTokens {
+		delete(t.tokens, t.order[0])
+		t.order = t.order[1:]
+	}
+	return txToken{}, false
+}
+
+// remove forgets the token, when its transaction couldn't be added.
+func (t *txTokens) remove(token string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.tokens, token)
+	for i, tok := range t.order {
+		if tok == token {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// get returns the outcome of the transaction sent with the token.
+func (t *txTokens) get(token string) (txToken, bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.expire()
+	tok, ok := t.tokens[token]
+	if !ok {
+		return txToken{}, false
+	}
+	return *tok, true
+}
+
+// update stores the outcome of the transaction in the pending tokens sent
+// with it. The reason is only kept if the transaction has been refused.
+func (t *txTokens) update(ctxHash []byte, res TxResult, reason error) {
+	t.Lock()
+	defer t.Unlock()
+	for _, tok := range t.tokens {
+		if !tok.pending || !bytes.Equal(tok.ctxHash, ctxHash) {
+			continue
+		}
+		tok.pending = false
+		tok.accepted = res.Accepted
+		tok.results = res.Results
+		if !res.Accepted {
+			tok.reason = reason
+		}
+	}
+}
+
+// expire removes the tokens older than txTokenTTL. As they all live for the
+// same time, they expire in the order they have been added.
+func (t *txTokens) expire() {
+	now := time.Now()
+	for len(t.order) > 0 && now.After(t.tokens[t.order[0]].expires) {
+		delete(t.tokens, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
 type bcNotifications struct {
 	sync.Mutex
 	// waitChannels will be informed by Service.updateTrieCallback that a
 	// given ClientTransaction has been included. updateTrieCallback will
-	// send true for a valid ClientTransaction and false for an invalid
-	// ClientTransaction.
-	waitChannels map[string]chan bool
+	// send the TxResult of the ClientTransaction, which tells whether it is
+	// valid and holds the results of its instructions.
+	waitChannels map[string]chan TxResult
 	// blockListeners will be notified every time a block is created.
 	// It is up to them to filter out block creations on chains they are not
 	// interested in.
 	blockListeners []chan skipchain.SkipBlockID
 }
 
-func (bc *bcNotifications) createWaitChannel(ctxHash []byte) chan bool {
+func (bc *bcNotifications) createWaitChannel(ctxHash []byte) chan TxResult {
 	bc.Lock()
 	defer bc.Unlock()
-	ch := make(chan bool, 1)
+	ch := make(chan TxResult, 1)
 	bc.waitChannels[string(ctxHash)] = ch
 	return ch
 }
 
-func (bc *bcNotifications) informWaitChannel(ctxHash []byte, valid bool) {
+func (bc *bcNotifications) informWaitChannel(ctxHash []byte, res TxResult) {
 	bc.Lock()
 	defer bc.Unlock()
 	ch := bc.waitChannels[string(ctxHash)]
 	if ch != nil {
-		ch <- valid
+		ch <- res
 	}
 }
 
@@ -620,6 +910,13 @@ func (bc *bcNotifications) unregisterForBlocks(i int) {
 	bc.blockListeners[i] = nil
 }
 
+// ByzCoinVersionThreshold is the version of the chains that accept the
+// threshold expressions in the rules of the darcs.
+const ByzCoinVersionThreshold = 1
+
+// CurrentByzCoinVersion is the latest version of the chains the node supports.
+const CurrentByzCoinVersion = ByzCoinVersionThreshold
+
 func (c ChainConfig) sanityCheck(old *ChainConfig) error {
 	if c.BlockInterval <= 0 {
 		return errors.New("block interval is less or equal to zero")
@@ -636,17 +933,111 @@ func (c ChainConfig) sanityCheck(old *ChainConfig) error {
 	if len(c.Roster.List) < 3 {
 		return errors.New("need at least 3 nodes to have a majority")
 	}
+	if c.RotationWindow < 0 {
+		return errors.New("negative rotation window")
+	}
+	if c.RotationWindow > 0 && c.RotationWindow < minRotationWindow {
+		return fmt.Errorf("rotation window must be at least %d", minRotationWindow)
+	}
+	for _, id := range c.SpawnContractIDs {
+		if id == "" {
+			return errors.New("empty contract ID in the spawn allowlist")
+		}
+	}
+	pinned := make(map[string]bool)
+	for _, cv := range c.ContractVersions {
+		if cv.ContractID == "" || cv.Version == "" {
+			return errors.New("empty contract ID or version in the contract versions")
+		}
+		if pinned[cv.ContractID] {
+			return fmt.Errorf("contract %s has more than one version", cv.ContractID)
+		}
+		pinned[cv.ContractID] = true
+	}
+	if err := c.checkObservers(c.Roster); err != nil {
+		return err
+	}
+	if c.MaxInstructionsPerTx < 0 {
+		return errors.New("negative maximum number of instructions per transaction")
+	}
+	if c.ByzCoinVersion < 0 || c.ByzCoinVersion > CurrentByzCoinVersion {
+		return fmt.Errorf("byzcoin version must be between 0 and %d", CurrentByzCoinVersion)
+	}
 	if old != nil {
+		if old.ChainBoundSignatures && !c.ChainBoundSignatures {
+			return errors.New("chain bound signatures cannot be disabled")
+		}
+		if c.ByzCoinVersion < old.ByzCoinVersion {
+			return errors.New("byzcoin version cannot be lowered")
+		}
 		return old.checkNewRoster(c.Roster)
 	}
 	return nil
 }
 
+// getRotationWindow returns the number of block intervals without heartbeat
+// from the leader after which the nodes ask for a new leader.
+func (c ChainConfig) getRotationWindow() time.Duration {
+	if c.RotationWindow > 0 {
+		return time.Duration(c.RotationWindow)
+	}
+	return rotationWindow
+}
+
+// getWatchdogWindow returns the number of block intervals after which a
+// leader that fails to create blocks steps down. It is kept below the
+// rotation window of the chain, so that the leader steps down before the
+// followers ask for a new one.
+func (c ChainConfig) getWatchdogWindow() int {
+	if w := int(c.getRotationWindow()) - 1; watchdogWindow > w {
+		return w
+	}
+	return watchdogWindow
+}
+
+// AllowsSpawn returns true if the instances of the contract can be spawned on
+// the chain, which is the case for all the contracts if SpawnContractIDs is
+// empty.
+func (c ChainConfig) AllowsSpawn(contractID string) bool {
+	if len(c.SpawnContractIDs) == 0 {
+		return true
+	}
+	for _, id := range c.SpawnContractIDs {
+		if id == contractID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInstructions returns an error if a transaction with n instructions is
+// not allowed on the chain.
+func (c ChainConfig) checkInstructions(n int) error {
+	if c.MaxInstructionsPerTx > 0 && n > c.MaxInstructionsPerTx {
+		return fmt.Errorf("transaction has %d instructions, but at most %d are allowed",
+			n, c.MaxInstructionsPerTx)
+	}
+	return nil
+}
+
+// CheckNewConfig returns an error if the nodes would refuse to update the
+// configuration from c to newConfig, e.g. because more than one node is
+// added or removed.
+func (c ChainConfig) CheckNewConfig(newConfig ChainConfig) error {
+	return newConfig.sanityCheck(&c)
+}
+
 // checkNewRoster makes sure that the new roster follows the rules we need
 // in byzcoin:
 //   - no new node can join as leader
 //   - only one node joining or leaving
+//   - no observer in the roster, so an observer must be removed from the
+//     observers before joining the roster
 func (c ChainConfig) checkNewRoster(newRoster onet.Roster) error {
+	if err := c.checkObservers(newRoster); err != nil {
+		return err
+	}
+
 	// Check new leader was in old roster
 	if index, _ := c.Roster.Search(newRoster.List[0].ID); index < 0 {
 		return errors.New("new leader must be in previous roster")
@@ -667,3 +1058,89 @@ func (c ChainConfig) checkNewRoster(newRoster onet.Roster) error {
 	}
 	return nil
 }
+
+// checkObservers makes sure that the observers are neither given twice nor
+// part of the roster.
+func (c ChainConfig) checkObservers(roster onet.Roster) error {
+	seen := make(map[network.ServerIdentityID]bool)
+	for _, si := range c.Observers {
+		if si == nil {
+			return errors.New("empty observer")
+		}
+		if seen[si.ID] {
+			return fmt.Errorf("observer %s is given twice", si.Address)
+		}
+		seen[si.ID] = true
+		if i, _ := roster.Search(si.ID); i >= 0 {
+			return fmt.Errorf("observer %s must not be in the roster", si.Address)
+		}
+	}
+	return nil
+}
+
+// IsObserver returns true if the node is one of the observers of the chain.
+func (c ChainConfig) IsObserver(si *network.ServerIdentity) bool {
+	for _, o := range c.Observers {
+		if o.Equal(si) {
+			return true
+		}
+	}
+	return false
+}
+
+// genesisLocker serializes the creation of genesis blocks for the same
+// would-be chain, while the creation of different chains can proceed in
+// parallel. Its zero value is ready to use.
+type genesisLocker struct {
+	sync.Mutex
+	// the key type is string because []byte is not allowed
+	// in Go maps as keys.
+	chains map[string]*genesisLock
+}
+
+type genesisLock struct {
+	sync.Mutex
+	// users counts the goroutines holding or waiting for the lock, so
+	// that it can be removed from the map once nobody uses it.
+	users int
+}
+
+func (gl *genesisLocker) lock(key []byte) {
+	gl.Lock()
+	// Lazy initialization.
+	if gl.chains == nil {
+		gl.chains = make(map[string]*genesisLock)
+	}
+	l, ok := gl.chains[string(key)]
+	if !ok {
+		l = &genesisLock{}
+		gl.chains[string(key)] = l
+	}
+	l.users++
+	gl.Unlock()
+
+	l.Lock()
+}
+
+func (gl *genesisLocker) unlock(key []byte) {
+	gl.Lock()
+	defer gl.Unlock()
+	l, ok := gl.chains[string(key)]
+	if !ok {
+		return
+	}
+	// As the users are counted under the lock of the map, nobody can get
+	// the entry after it has been removed.
+	l.users--
+	if l.users == 0 {
+		delete(gl.chains, string(key))
+	}
+	l.Unlock()
+}
+
+// size returns the number of chains currently being created.
+func (gl *genesisLocker) size() int {
+	gl.Lock()
+	defer gl.Unlock()
+	return len(gl.chains)
+}
@@ -1,14 +1,19 @@
 package byzcoin
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/protobuf"
 	bbolt "go.etcd.io/bbolt"
 )
@@ -284,6 +289,59 @@ func TestStateChangeStorage_MaxNbrBlock(t *testing.T) {
 	require.Equal(t, n/l-store.maxNbrBlock, entries[0].BlockIndex)
 }
 
+// Checks that pruning keeps the recent entries and the latest entry of
+// every instance
+func TestStateChangeStorage_Prune(t *testing.T) {
+	store, name := generateDB(t)
+	defer os.Remove(name)
+
+	n := 5
+	iid := genID().Slice()
+	oldIID := genID().Slice()
+
+	sb := createBlock()
+	for i := 0; i < n; i++ {
+		sb.Index = i
+		scs := StateChanges{{InstanceID: iid, Version: uint64(i), Value: []byte{byte(i)}}}
+		if i == 0 {
+			scs = append(scs, StateChange{InstanceID: oldIID, Value: []byte{}})
+		}
+		require.NoError(t, store.append(scs, sb))
+	}
+	size := store.size
+
+	removed, err := store.prune(sb.SkipChainID(), 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, removed)
+	pruned := store.size
+	require.True(t, pruned < size)
+	require.NoError(t, store.calculateSize())
+	require.Equal(t, pruned, store.size)
+
+	entries, err := store.getAll(iid, sb.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, 3, entries[0].BlockIndex)
+	require.Equal(t, uint64(4), entries[1].StateChange.Version)
+
+	entries, err = store.getAll(oldIID, sb.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+
+	// Pruning beyond the last block keeps only the latest entries.
+	removed, err = store.prune(sb.SkipChainID(), n+1)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	sce, ok, err := store.getLast(iid, sb.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(n-1), sce.StateChange.Version)
+
+	removed, err = store.prune(genID().Slice(), n)
+	require.NoError(t, err)
+	require.Equal(t, 0, removed)
+}
+
 func TestStateChangeStorage_Race(t *testing.T) {
 	store, name := generateDB(t)
 	defer os.Remove(name)
@@ -343,3 +401,152 @@ func generateDB(t *testing.T) (*stateChangeStorage, string) {
 
 	return &scs, tmpDB.Name()
 }
+
+// Checks that the history can be disabled
+func TestStateChangeStorage_Disabled(t *testing.T) {
+	_, err := newStateChangeBackend(nil, "unknown")
+	require.Error(t, err)
+
+	scs, err := newStateChangeBackend(nil, stateChangeStorageNone)
+	require.NoError(t, err)
+	require.NoError(t, scs.calculateSize())
+
+	sb := createBlock()
+	require.NoError(t, scs.append(generateStateChanges(3), sb))
+
+	_, err = scs.getAll(make([]byte, prefixLength), sb.SkipChainID())
+	require.Equal(t, errHistoryDisabled, err)
+	_, ok, err := scs.getLast(make([]byte, prefixLength), sb.SkipChainID())
+	require.False(t, ok)
+	require.Equal(t, errHistoryDisabled, err)
+}
+
+func TestTxRejections(t *testing.T) {
+	var r txRejections
+	require.Nil(t, r.get([]byte("tx")))
+	r.add([]byte("tx"), errors.New("refused"))
+	require.EqualError(t, r.get([]byte("tx")), "refused")
+
+	// Only the latest reasons are kept.
+	for i := 0; i < maxTxRejections; i++ {
+		r.add([]byte(fmt.Sprintf("tx%d", i)), errors.New("refused"))
+	}
+	require.Nil(t, r.get([]byte("tx")))
+	require.NotNil(t, r.get([]byte("tx0")))
+	require.Equal(t, maxTxRejections, len(r.reasons))
+}
+
+func TestTxTokens(t *testing.T) {
+	var tt txTokens
+	_, ok := tt.getOrAdd("a", []byte("tx"))
+	require.False(t, ok)
+	tok, ok := tt.getOrAdd("a", []byte("other"))
+	require.True(t, ok)
+	require.True(t, tok.pending)
+	require.Equal(t, []byte("tx"), tok.ctxHash)
+
+	tt.update([]byte("tx"), TxResult{Accepted: false}, errors.New("refused"))
+	tok, ok = tt.get("a")
+	require.True(t, ok)
+	require.False(t, tok.pending)
+	require.False(t, tok.accepted)
+	require.EqualError(t, refusedTxError(tok.reason), "transaction is in block, but got refused: refused")
+
+	// The outcome of a transaction doesn't change once it is known.
+	tt.update([]byte("tx"), TxResult{Accepted: true}, nil)
+	tok, _ = tt.get("a")
+	require.False(t, tok.accepted)
+
+	_, ok = tt.getOrAdd("b", []byte("tx2"))
+	require.False(t, ok)
+	tt.update([]byte("tx2"), TxResult{Accepted: true, Results: [][]byte{[]byte("res")}}, nil)
+	tok, _ = tt.get("b")
+	require.True(t, tok.accepted)
+	require.Equal(t, [][]byte{[]byte("res")}, tok.results)
+
+	_, ok = tt.getOrAdd("c", []byte("tx3"))
+	require.False(t, ok)
+	tt.remove("c")
+	_, ok = tt.get("c")
+	require.False(t, ok)
+	require.Equal(t, []string{"a", "b"}, tt.order)
+
+	// The tokens expire.
+	tt.tokens["a"].expires = time.Now().Add(-time.Second)
+	_, ok = tt.get("a")
+	require.False(t, ok)
+	_, ok = tt.get("b")
+	require.True(t, ok)
+
+	// Only the latest tokens are kept.
+	for i := 0; i < maxTxTokens; i++ {
+		tt.getOrAdd(fmt.Sprintf("token%d", i), []byte("tx"))
+	}
+	_, ok = tt.get("b")
+	require.False(t, ok)
+	require.Equal(t, maxTxTokens, len(tt.tokens))
+}
+
+// Different chains can be locked in parallel, while the same chain is only
+// locked once at a time.
+func TestGenesisLocker(t *testing.T) {
+	var gl genesisLocker
+	gl.lock([]byte("a"))
+
+	done := make(chan bool)
+	go func() {
+		gl.lock([]byte("b"))
+		gl.unlock([]byte("b"))
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking another chain blocked")
+	}
+
+	go func() {
+		gl.lock([]byte("a"))
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("the same chain got locked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	gl.unlock([]byte("a"))
+	<-done
+	require.Equal(t, 1, gl.size())
+	gl.unlock([]byte("a"))
+	require.Equal(t, 0, gl.size())
+}
+
+// The rotation window of a chain must be above the default watchdog window,
+// and the watchdog window of a node is kept below the one of the chain.
+func TestChainConfig_RotationWindow(t *testing.T) {
+	l := onet.NewLocalTest(cothority.Suite)
+	defer l.CloseAll()
+	_, roster, _ := l.GenTree(3, false)
+	config := ChainConfig{
+		BlockInterval: testInterval,
+		Roster:        *roster,
+		MaxBlockSize:  defaultMaxBlockSize,
+	}
+	require.NoError(t, config.sanityCheck(nil))
+	require.Equal(t, rotationWindow, config.getRotationWindow())
+
+	for _, w := range []int{-1, 1, defaultWatchdogWindow} {
+		config.RotationWindow = w
+		require.Error(t, config.sanityCheck(nil), "window %d", w)
+	}
+	config.RotationWindow = minRotationWindow
+	require.NoError(t, config.sanityCheck(nil))
+	require.Equal(t, time.Duration(minRotationWindow), config.getRotationWindow())
+	require.Equal(t, defaultWatchdogWindow, config.getWatchdogWindow())
+
+	defer func(w int) {
+		watchdogWindow = w
+	}(watchdogWindow)
+	watchdogWindow = 20
+	require.Equal(t, minRotationWindow-1, config.getWatchdogWindow())
+}
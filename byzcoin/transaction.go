@@ -11,6 +11,7 @@ import (
 
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
@@ -92,8 +93,34 @@ func (ctx *ClientTransaction) FillSignersAndSignWith(signers ...darc.Signer) err
 
 // SignWith signs all the instructions with the same signers. If some instructions need to be signed by different sets
 // of signers, then use the SignWith method of Instruction.
+//
+// The signatures are not bound to a chain, so they are refused by the chains
+// that have ChainBoundSignatures set. Use SignWithChain for those.
 func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
-	digest := ctx.Instructions.Hash()
+	return ctx.signDigest(ctx.Instructions.Hash(), signers...)
+}
+
+// FillSignersAndSignWithChain is like FillSignersAndSignWith, but binds the
+// signatures to the chain bcID.
+func (ctx *ClientTransaction) FillSignersAndSignWithChain(bcID skipchain.SkipBlockID, signers ...darc.Signer) error {
+	var ids []darc.Identity
+	for _, signer := range signers {
+		ids = append(ids, signer.Identity())
+	}
+	for i := range ctx.Instructions {
+		ctx.Instructions[i].SignerIdentities = ids
+	}
+	return ctx.SignWithChain(bcID, signers...)
+}
+
+// SignWithChain signs all the instructions with the same signers, like
+// SignWith, but the signatures are bound to the chain bcID and cannot be
+// replayed on another chain.
+func (ctx *ClientTransaction) SignWithChain(bcID skipchain.SkipBlockID, signers ...darc.Signer) error {
+	return ctx.signDigest(ctx.Instructions.HashWithChain(bcID), signers...)
+}
+
+func (ctx *ClientTransaction) signDigest(digest []byte, signers ...darc.Signer) error {
 	for i := range ctx.Instructions {
 		if err := ctx.Instructions[i].SignWith(digest, signers...); err != nil {
 			return err
@@ -119,6 +146,11 @@ func (instr Instruction) Hash() []byte {
 	case DeleteType:
 		h.Write([]byte{2})
 		h.Write([]byte(instr.Delete.ContractID))
+		// Only hash the flag if it is set, so that the hash of the
+		// existing instructions doesn't change.
+		if instr.Delete.Soft {
+			h.Write([]byte{1})
+		}
 	}
 	for _, a := range args {
 		nameBuf := []byte(a.Name)
@@ -144,6 +176,14 @@ func (instr Instruction) Hash() []byte {
 		h.Write(lenBuf)
 		h.Write(buf)
 	}
+	// The preconditions are only hashed if there are some, so that the
+	// hash of the instructions without preconditions doesn't change.
+	for _, pc := range instr.Preconditions {
+		h.Write(pc.InstanceID[:])
+		verBuf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(verBuf, pc.Version)
+		h.Write(verBuf)
+	}
 	return h.Sum(nil)
 }
 
@@ -217,13 +257,36 @@ func (instr Instruction) String() string {
 	out += fmt.Sprintf("\tidentities: %v\n", instr.SignerIdentities)
 	out += fmt.Sprintf("\tcounters: %v\n", instr.SignerCounter)
 	out += fmt.Sprintf("\tsignatures: %d\n", len(instr.Signatures))
+	for _, pc := range instr.Preconditions {
+		out += fmt.Sprintf("\tprecondition: %v has version %d\n", pc.InstanceID, pc.Version)
+	}
 	return out
 }
 
+// VerifyPreconditions returns an error if one of the preconditions of the
+// instruction doesn't hold in the given trie.
+func (instr Instruction) VerifyPreconditions(rst ReadOnlyStateTrie) error {
+	for _, pc := range instr.Preconditions {
+		_, ver, _, _, err := rst.GetValues(pc.InstanceID.Slice())
+		if err == errKeyNotSet {
+			return fmt.Errorf("precondition failed: instance %x does not exist", pc.InstanceID.Slice())
+		}
+		if err != nil {
+			return err
+		}
+		if ver != pc.Version {
+			return fmt.Errorf("precondition failed: instance %x has version %d instead of %d",
+				pc.InstanceID.Slice(), ver, pc.Version)
+		}
+	}
+	return nil
+}
+
 // SignWith creates a signed version of the instruction. The signature is
 // created on msg, which must be the hash of the ClientTransaction which
-// contains the instruction. Otherwise the verification will fail on the server
-// side.
+// contains the instruction, or the result of HashWithChain for the chains
+// requiring signatures bound to them. Otherwise the verification will fail on
+// the server side.
 func (instr *Instruction) SignWith(msg []byte, signers ...darc.Signer) error {
 	if len(signers) != len(instr.SignerIdentities) {
 		return errors.New("the number of signers does not match the number of identities")
@@ -297,6 +360,15 @@ func (instr Instruction) Verify(st ReadOnlyStateTrie, msg []byte) error {
 		}
 	}
 
+	// The nodes that are not upgraded yet can't parse the threshold
+	// expressions, so they are refused, also in the delegated darcs, until
+	// the chain is at a version that has them.
+	threshold := config.ByzCoinVersion >= ByzCoinVersionThreshold
+	expr := d.Rules.Get(darc.Action(instr.Action()))
+	if !threshold && expr.HasThreshold() {
+		return fmt.Errorf("threshold expressions need byzcoin version %d", ByzCoinVersionThreshold)
+	}
+
 	// check the expression
 	getDarc := func(str string, latest bool) *darc.Darc {
 		if len(str) < 5 || string(str[0:5]) != "darc:" {
@@ -310,9 +382,12 @@ func (instr Instruction) Verify(st ReadOnlyStateTrie, msg []byte) error {
 		if err != nil {
 			return nil
 		}
+		if !threshold && d.Rules.GetSignExpr().HasThreshold() {
+			return nil
+		}
 		return d
 	}
-	return darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, instr.GetIdentityStrings()...)
+	return darc.EvalExpr(expr, getDarc, instr.GetIdentityStrings()...)
 }
 
 // InstrType is the instruction type, which can be spawn, invoke or delete.
@@ -353,6 +428,44 @@ func (instrs Instructions) Hash() []byte {
 	return h.Sum(nil)
 }
 
+// checkDuplicates returns an error if the same spawn instruction is present
+// more than once. Otherwise the second one would fail later with a confusing
+// error, as it derives the ID of the instance created by the first one. The
+// other instructions can be repeated, e.g. to invoke the same command twice.
+func (instrs Instructions) checkDuplicates() error {
+	seen := make(map[InstanceID]int)
+	for i, instr := range instrs {
+		if instr.GetType() != SpawnType {
+			continue
+		}
+		id := instr.DeriveID("")
+		if j, ok := seen[id]; ok {
+			return fmt.Errorf("instruction %d is a duplicate of instruction %d", i, j)
+		}
+		seen[id] = i
+	}
+	return nil
+}
+
+// chainBoundDomain separates the chain-bound digests from all the other
+// hashes signed by the identities.
+var chainBoundDomain = []byte("byzcoin-chain-bound-v1")
+
+// HashWithChain returns the digest that binds the signatures of the
+// instructions to the chain bcID. As the signer counters are per chain, a
+// signature on Hash could be replayed on another chain using the same
+// identities.
+func (instrs Instructions) HashWithChain(bcID skipchain.SkipBlockID) []byte {
+	h := sha256.New()
+	h.Write(chainBoundDomain)
+	lenBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenBuf, uint64(len(bcID)))
+	h.Write(lenBuf)
+	h.Write(bcID)
+	h.Write(instrs.Hash())
+	return h.Sum(nil)
+}
+
 // TxResults is a list of results from executed transactions.
 type TxResults []TxResult
 
@@ -366,10 +479,14 @@ func NewTxResults(ct ...ClientTransaction) TxResults {
 	return out
 }
 
-// Hash returns the sha256 hash of all of the transactions.
+// Hash returns the sha256 hash of all of the transactions. The results of
+// the instructions are only hashed if there are any, so that the hash of the
+// blocks without results doesn't change.
 func (txr TxResults) Hash() []byte {
 	one := []byte{1}
 	zero := []byte{0}
+	results := []byte{2}
+	lenBuf := make([]byte, 8)
 
 	h := sha256.New()
 	for _, tx := range txr {
@@ -379,6 +496,16 @@ func (txr TxResults) Hash() []byte {
 		} else {
 			h.Write(zero[:])
 		}
+		if len(tx.Results) > 0 {
+			h.Write(results)
+			binary.LittleEndian.PutUint64(lenBuf, uint64(len(tx.Results)))
+			h.Write(lenBuf)
+			for _, res := range tx.Results {
+				binary.LittleEndian.PutUint64(lenBuf, uint64(len(res)))
+				h.Write(lenBuf)
+				h.Write(res)
+			}
+		}
 	}
 	return h.Sum(nil)
 }
@@ -395,6 +522,16 @@ func NewStateChange(sa StateAction, iID InstanceID, contractID string, value []b
 	}
 }
 
+// NewTombstone returns the state change a contract can return on a soft
+// delete. It keeps the instance with an empty value and the Deleted flag set,
+// so that the deletion can be proven. ByzCoin refuses any further instruction
+// on the instance.
+func NewTombstone(iID InstanceID, contractID string, darcID darc.ID) StateChange {
+	sc := NewStateChange(Update, iID, contractID, nil, darcID)
+	sc.Deleted = true
+	return sc
+}
+
 func (sc StateChange) toString(withValue bool) string {
 	var out string
 	out += "\nstatechange\n"
@@ -402,6 +539,9 @@ func (sc StateChange) toString(withValue bool) string {
 	out += fmt.Sprintf("\tcontractID: %s\n", string(sc.ContractID))
 	out += fmt.Sprintf("\tkey: %x\n", sc.InstanceID)
 	out += fmt.Sprintf("\tversion: %d\n", sc.Version)
+	if sc.Deleted {
+		out += "\tdeleted: true\n"
+	}
 	if withValue {
 		out += fmt.Sprintf("\tvalue: %x", sc.Value)
 	}
@@ -426,11 +566,13 @@ func (sc *StateChange) Key() []byte {
 // Val returns the value that should be used in a key/value database.
 func (sc *StateChange) Val() []byte {
 	v := StateChangeBody{
-		StateAction: sc.StateAction,
-		ContractID:  sc.ContractID,
-		Value:       sc.Value,
-		Version:     sc.Version,
-		DarcID:      sc.DarcID,
+		StateAction:     sc.StateAction,
+		ContractID:      sc.ContractID,
+		Value:           sc.Value,
+		Version:         sc.Version,
+		DarcID:          sc.DarcID,
+		Deleted:         sc.Deleted,
+		ContractVersion: sc.ContractVersion,
 	}
 	buf, err := protobuf.Encode(&v)
 	if err != nil {
@@ -75,6 +75,52 @@ func TestTransaction_Signing(t *testing.T) {
 	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
 }
 
+func TestTransaction_SigningBLS(t *testing.T) {
+	signer := darc.NewSignerBLS(nil, nil)
+	ids := []darc.Identity{signer.Identity()}
+	d := darc.NewDarc(darc.InitRules(ids, ids), []byte("genesis darc"))
+	d.Rules.AddRule("spawn:dummy_kind", d.Rules.GetSignExpr())
+	require.Nil(t, d.Verify(true))
+
+	ctx, err := createOneClientTx(d.GetBaseID(), "dummy_kind", []byte("dummy_value"), signer)
+	require.Nil(t, err)
+
+	mdb := trie.NewMemDB()
+	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
+	require.NoError(t, err)
+	sst := &stagingStateTrie{*tr.MakeStagingTrie()}
+
+	configBuf, err := protobuf.Encode(&ChainConfig{DarcContractIDs: []string{"darc"}})
+	require.NoError(t, err)
+	darcBuf, err := d.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll([]StateChange{
+		{
+			InstanceID:  NewInstanceID(nil).Slice(),
+			StateAction: Create,
+			ContractID:  ContractConfigID,
+			Value:       configBuf,
+		},
+		{
+			InstanceID:  d.GetBaseID(),
+			StateAction: Create,
+			ContractID:  ContractDarcID,
+			Value:       darcBuf,
+			DarcID:      d.GetBaseID(),
+		},
+	}))
+	require.NoError(t, setSignerCounter(sst, signer.Identity().String(), 0))
+
+	ctxHash := ctx.Instructions.Hash()
+	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
+
+	// A signature of another BLS key is refused.
+	sig, err := darc.NewSignerBLS(nil, nil).Sign(ctxHash)
+	require.NoError(t, err)
+	ctx.Instructions[0].Signatures[0] = sig
+	require.Error(t, ctx.Instructions[0].Verify(sst, ctxHash))
+}
+
 func setSignerCounter(sst *stagingStateTrie, id string, v uint64) error {
 	key := publicVersionKey(id)
 	verBuf := make([]byte, 8)
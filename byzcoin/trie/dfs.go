@@ -47,6 +47,53 @@ func (t *Trie) dfs(p nodeProcessor, nodeKey []byte, b Bucket) error {
 	return errors.New("invalid node type")
 }
 
+// dfsAfter is like dfs, but only calls OnLeaf, for the leaves that come after
+// the path of the bits in the depth first order, so that a traversal can be
+// resumed where it stopped. The subtrees before the path are not visited.
+func (t *Trie) dfsAfter(p nodeProcessor, nodeKey []byte, b Bucket, bits []bool, depth int) error {
+	nodeVal := b.Get(nodeKey)
+	if len(nodeVal) == 0 {
+		return errors.New("node key does not exist in copyTo")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		return nil
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if !isAfter(t.binSlice(node.Key), bits) {
+			return nil
+		}
+		return p.OnLeaf(node, node.hash(t.nonce), nodeVal)
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if !bits[depth] {
+			return t.dfsAfter(p, node.Right, b, bits, depth+1)
+		}
+		if err := t.dfsAfter(p, node.Left, b, bits, depth+1); err != nil {
+			return err
+		}
+		return t.dfs(p, node.Right, b)
+	}
+	return errors.New("invalid node type")
+}
+
+// isAfter returns true if the path a comes after the path b in the depth first
+// order, where the left child, true, comes first.
+func isAfter(a, b []bool) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return b[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
 type countNodeProcessor struct {
 	total  int
 	leaves []leafNode
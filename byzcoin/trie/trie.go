@@ -580,6 +580,21 @@ func (t *Trie) ForEach(cb func(k, v []byte) error) error {
 	})
 }
 
+// ForEachAfter is like ForEach, but starts with the key/value pair following
+// key in the order of ForEach, so that an iteration stopped at a key can be
+// resumed. The key doesn't need to be in the trie anymore.
+func (t *Trie) ForEachAfter(key []byte, cb func(k, v []byte) error) error {
+	p := leafCallbackProcessor{cb}
+	bits := t.binSlice(key)
+	return t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return errors.New("no root key")
+		}
+		return t.dfsAfter(&p, rootKey, b, bits, 0)
+	})
+}
+
 // IsValid checks whether the trie is valid.
 func (t *Trie) IsValid() error {
 	p := countNodeProcessor{}
@@ -330,6 +330,43 @@ func TestIsValid(t *testing.T) {
 	require.NotNil(t, testTrie.IsValid())
 }
 
+func TestForEachAfter(t *testing.T) {
+	testMemAndDisk(t, testForEachAfter)
+}
+
+func testForEachAfter(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, testTrie.Set([]byte{byte(i)}, []byte{byte(i)}))
+	}
+
+	var all [][]byte
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		all = append(all, append([]byte{}, k...))
+		return nil
+	}))
+	require.Equal(t, 50, len(all))
+
+	// Resuming after any key gives the rest of the iteration, even if the
+	// key has been deleted meanwhile.
+	for i, key := range all {
+		if i%2 == 0 {
+			require.NoError(t, testTrie.Delete(key))
+		}
+		var rest [][]byte
+		require.NoError(t, testTrie.ForEachAfter(key, func(k, v []byte) error {
+			rest = append(rest, append([]byte{}, k...))
+			return nil
+		}))
+		if i == len(all)-1 {
+			require.Empty(t, rest)
+		} else {
+			require.Equal(t, all[i+1:], rest)
+		}
+	}
+}
+
 func TestQuickCheck(t *testing.T) {
 	mem := NewMemDB()
 	defer mem.Close()
@@ -0,0 +1,200 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	trieStorageShared   = "shared"
+	trieStoragePerChain = "perchain"
+)
+
+// envTrieStorage selects where the tries of the chains are stored. With
+// trieStorageShared, the default, they are buckets of the database of the
+// node. With trieStoragePerChain, every chain has its own database file next
+// to the database of the node, so that a chain can be backed up, compacted or
+// removed without touching the others. The tries found in the database of the
+// node are moved to their own files when the node starts.
+const envTrieStorage = "BYZCOIN_TRIE_STORAGE"
+
+// trieMigrationBatch is the number of entries copied in one transaction when
+// a trie is moved to its own file.
+const trieMigrationBatch = 10000
+
+// trieDBs holds the databases of the tries stored in their own files.
+type trieDBs struct {
+	sync.Mutex
+	perChain bool
+	dbs      map[string]*bbolt.DB
+}
+
+// parseTrieStorage returns whether kind asks for per-chain databases. An
+// empty kind is trieStorageShared.
+func parseTrieStorage(kind string) (bool, error) {
+	switch kind {
+	case "", trieStorageShared:
+		return false, nil
+	case trieStoragePerChain:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown trie storage \"%s\"", kind)
+	}
+}
+
+// trieDBPath returns the path of the database file of the trie of the chain
+// idStr, next to the database of the node.
+func (s *Service) trieDBPath(idStr string) string {
+	db, _ := s.GetAdditionalBucket([]byte("check-db-version"))
+	return fmt.Sprintf("%s_%s.db", strings.TrimSuffix(db.Path(), ".db"), idStr)
+}
+
+// trieBucket returns the database and the bucket of the trie of the chain
+// idStr, which is the ID of the chain in hex. The bucket is created if it
+// doesn't exist yet.
+func (s *Service) trieBucket(idStr string) (*bbolt.DB, []byte, error) {
+	if !s.trieDBs.perChain {
+		db, name := s.GetAdditionalBucket([]byte(idStr))
+		return db, name, nil
+	}
+
+	s.trieDBs.Lock()
+	defer s.trieDBs.Unlock()
+	name := []byte(idStr)
+	if db, ok := s.trieDBs.dbs[idStr]; ok {
+		return db, name, nil
+	}
+	// The timeout avoids waiting forever for the lock of a file that is
+	// still opened somewhere else.
+	db, err := bbolt.Open(s.trieDBPath(idStr), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't open the database of the trie: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(name)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if s.trieDBs.dbs == nil {
+		s.trieDBs.dbs = make(map[string]*bbolt.DB)
+	}
+	s.trieDBs.dbs[idStr] = db
+	return db, name, nil
+}
+
+// removeTrieBucket removes the trie of the chain idStr. A trie in its own
+// file is removed together with the file.
+func (s *Service) removeTrieBucket(idStr string) error {
+	if !s.trieDBs.perChain {
+		db, name := s.GetAdditionalBucket([]byte(idStr))
+		return db.Update(func(tx *bbolt.Tx) error {
+			return tx.DeleteBucket(name)
+		})
+	}
+
+	s.trieDBs.Lock()
+	defer s.trieDBs.Unlock()
+	if db, ok := s.trieDBs.dbs[idStr]; ok {
+		if err := db.Close(); err != nil {
+			return err
+		}
+		delete(s.trieDBs.dbs, idStr)
+	}
+	err := os.Remove(s.trieDBPath(idStr))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// setupTrieStorage moves the tries of the database of the node to their own
+// files if the tries are stored per chain. Otherwise it makes sure that no
+// trie has been moved before, as they would not be found.
+func (s *Service) setupTrieStorage() error {
+	gasr, err := s.skService().GetAllSkipChainIDs(&skipchain.GetAllSkipChainIDs{})
+	if err != nil {
+		return err
+	}
+	for _, gen := range gasr.IDs {
+		if !s.hasByzCoinVerification(gen) {
+			continue
+		}
+		idStr := fmt.Sprintf("%x", gen)
+		if !s.trieDBs.perChain {
+			if _, err := os.Stat(s.trieDBPath(idStr)); err == nil {
+				return fmt.Errorf("the trie of chain %s is stored in its own file, %s must be set to %s",
+					idStr, envTrieStorage, trieStoragePerChain)
+			}
+			continue
+		}
+		if err := s.migrateTrie(idStr); err != nil {
+			return fmt.Errorf("couldn't move the trie of chain %s to its own file: %v", idStr, err)
+		}
+	}
+	return nil
+}
+
+// migrateTrie copies the trie of the chain idStr from the database of the
+// node to its own file, and then removes it from the database of the node. If
+// the copy is interrupted, it starts again on the next start of the node.
+func (s *Service) migrateTrie(idStr string) error {
+	shared, sharedName := s.GetAdditionalBucket([]byte(idStr))
+	var n int
+	err := shared.View(func(tx *bbolt.Tx) error {
+		n = tx.Bucket(sharedName).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Lvlf1("%s: moving the trie of chain %s with %d entries to %s", s.ServerIdentity(), idStr, n,
+			s.trieDBPath(idStr))
+		db, name, err := s.trieBucket(idStr)
+		if err != nil {
+			return err
+		}
+		err = shared.View(func(tx *bbolt.Tx) error {
+			c := tx.Bucket(sharedName).Cursor()
+			for k, v := c.First(); k != nil; {
+				err := db.Update(func(wtx *bbolt.Tx) error {
+					b := wtx.Bucket(name)
+					for i := 0; k != nil && i < trieMigrationBatch; i++ {
+						if err := b.Put(k, v); err != nil {
+							return err
+						}
+						k, v = c.Next()
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := db.Sync(); err != nil {
+			return err
+		}
+	}
+	return shared.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(sharedName); err != nil && err != bbolt.ErrBucketNotFound {
+			return errors.New("couldn't remove the trie from the database of the node: " + err.Error())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package byzcoin
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+)
+
+func TestTrieDB_ParseTrieStorage(t *testing.T) {
+	perChain, err := parseTrieStorage("")
+	require.NoError(t, err)
+	require.False(t, perChain)
+	perChain, err = parseTrieStorage(trieStorageShared)
+	require.NoError(t, err)
+	require.False(t, perChain)
+	perChain, err = parseTrieStorage(trieStoragePerChain)
+	require.NoError(t, err)
+	require.True(t, perChain)
+	_, err = parseTrieStorage("other")
+	require.Error(t, err)
+}
+
+// The tries are moved from the database of the node to their own files, and
+// the chain goes on with them.
+func TestTrieDB_PerChain(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	idStr := fmt.Sprintf("%x", s.genesis.SkipChainID())
+	for _, service := range s.services {
+		st, err := service.getStateTrie(s.genesis.SkipChainID())
+		require.NoError(t, err)
+		root := st.GetRoot()
+
+		// Restart the node with the tries in their own files, as the
+		// running chain keeps the trie it started with.
+		service.TestClose()
+		service.trieDBs.perChain = true
+		require.NoError(t, service.setupTrieStorage())
+		service.stateTriesLock.Lock()
+		delete(service.stateTries, idStr)
+		service.stateTriesLock.Unlock()
+		require.NoError(t, service.startAllChains())
+
+		_, err = os.Stat(service.trieDBPath(idStr))
+		require.NoError(t, err)
+		st, err = service.getStateTrie(s.genesis.SkipChainID())
+		require.NoError(t, err)
+		require.Equal(t, root, st.GetRoot())
+	}
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	pr := s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+	require.True(t, pr.InclusionProof.Match(tx.Instructions[0].Hash()))
+
+	// Removing the chain removes the file of its trie.
+	service := s.services[len(s.services)-1]
+	sig, err := schnorr.Sign(cothority.Suite, service.ServerIdentity().GetPrivate(), s.genesis.SkipChainID())
+	require.NoError(t, err)
+	_, err = service.DebugRemove(&DebugRemoveRequest{
+		ByzCoinID: s.genesis.SkipChainID(),
+		Signature: sig,
+	})
+	require.NoError(t, err)
+	_, err = os.Stat(service.trieDBPath(idStr))
+	require.True(t, os.IsNotExist(err))
+}
@@ -1,6 +1,7 @@
 package byzcoin
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -48,6 +49,8 @@ type txProcessorState struct {
 	scs     StateChanges
 	txs     TxResults
 	txsSize int
+	// cost is the total cost of the accepted transactions in txs.
+	cost uint64
 }
 
 func (s *txProcessorState) size() int {
@@ -66,6 +69,7 @@ func (s *txProcessorState) reset() {
 	s.scs = []StateChange{}
 	s.txs = []TxResult{}
 	s.txsSize = 0
+	s.cost = 0
 }
 
 // copy creates a shallow copy the state, we don't have the need for deep copy
@@ -76,13 +80,25 @@ func (s *txProcessorState) copy() *txProcessorState {
 		append([]StateChange{}, s.scs...),
 		append([]TxResult{}, s.txs...),
 		s.txsSize,
+		s.cost,
 	}
 }
 
+// errNotLeader is returned by ProposeBlock if the latest block demoted this
+// node while it was still polling.
+var errNotLeader = errors.New("this node is not the leader anymore")
+
 type defaultTxProcessor struct {
 	stopCollect chan bool
 	scID        skipchain.SkipBlockID
 	*Service
+	// lastBlock is the time of the last block created by this leader, or of
+	// the start of the polling. Only ProposeBlock accesses it, and the
+	// pipeline never calls it concurrently.
+	lastBlock time.Time
+	// stepDown is called when the leader didn't create a block for
+	// watchdogWindow block intervals.
+	stepDown func(skipchain.SkipBlockID)
 }
 
 func (s *defaultTxProcessor) CollectTx() ([]ClientTransaction, error) {
@@ -94,6 +110,12 @@ func (s *defaultTxProcessor) CollectTx() ([]ClientTransaction, error) {
 			"a problem with the database! "+err.Error())
 		return nil, err
 	}
+	// The pipeline of a demoted leader runs until the new block is applied
+	// by updateTrieCallback, so it must stop collecting on its own.
+	if !bcConfig.Roster.List[0].Equal(s.ServerIdentity()) {
+		logBlock.printf(2, "%s is not the leader of %x anymore, not collecting", s.ServerIdentity(), s.scID)
+		return nil, nil
+	}
 
 	sb, doCatchUp := s.skService().WaitBlock(s.scID, nil)
 	if sb == nil && !doCatchUp {
@@ -109,7 +131,7 @@ func (s *defaultTxProcessor) CollectTx() ([]ClientTransaction, error) {
 		return nil, err
 	}
 
-	log.Lvlf3("%s: Starting new block %d for chain %x", s.ServerIdentity(), latest.Index+1, s.scID)
+	logBlock.printf(3, "%s: Starting new block %d for chain %x", s.ServerIdentity(), latest.Index+1, s.scID)
 	tree := bcConfig.Roster.GenerateNaryTree(len(bcConfig.Roster.List))
 
 	proto, err := s.CreateProtocol(collectTxProtocol, tree)
@@ -148,18 +170,18 @@ collectTxLoop:
 					if txsz < bcConfig.MaxBlockSize {
 						txs = append(txs, ct)
 					} else {
-						log.Lvl2(s.ServerIdentity(), "dropping collected transaction with length", txsz)
+						s.dropTx(ct, txsz, bcConfig.MaxBlockSize)
 					}
 				}
 			} else {
 				break collectTxLoop
 			}
 		case <-protocolTimeout:
-			log.Lvl2(s.ServerIdentity(), "timeout while collecting transactions from other nodes")
+			logBlock.print(2, s.ServerIdentity(), "timeout while collecting transactions from other nodes")
 			close(root.Finish)
 			break collectTxLoop
 		case <-s.stopCollect:
-			log.Lvl2(s.ServerIdentity(), "abort collection of transactions")
+			logBlock.print(2, s.ServerIdentity(), "abort collection of transactions")
 			close(root.Finish)
 			return txs, nil
 		}
@@ -169,7 +191,13 @@ collectTxLoop:
 }
 
 func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcessorState) ([]*txProcessorState, error) {
-	scsOut, sstOut, err := s.processOneTx(inState.sst, tx)
+	// The maximum size may have changed since the transaction has been
+	// collected. Alone in a block, the transaction would be too big.
+	if txsz, maxsz := txSize(TxResult{ClientTransaction: tx}), s.GetBlockSize(); txsz > maxsz {
+		s.dropTx(tx, txsz, maxsz)
+		return []*txProcessorState{inState}, nil
+	}
+	scsOut, sstOut, cost, results, err := s.processOneTx(inState.sst, s.scID, tx)
 
 	// try to create a new state
 	newState := func() *txProcessorState {
@@ -177,38 +205,43 @@ func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcesso
 			return &txProcessorState{
 				inState.sst,
 				inState.scs,
-				append(inState.txs, TxResult{tx, false}),
+				append(inState.txs, TxResult{ClientTransaction: tx}),
 				0,
+				inState.cost,
 			}
 		}
 		return &txProcessorState{
 			sstOut,
 			append(inState.scs, scsOut...),
-			append(inState.txs, TxResult{tx, true}),
+			append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true, Results: results}),
 			0,
+			inState.cost + cost,
 		}
 	}()
 
-	// we're within the block size, so return one state
-	if s.GetBlockSize() > newState.size() {
+	// we're within the block size and the compute budget, so return one
+	// state
+	if s.GetBlockSize() > newState.size() && !overCostBudget(inState.cost, cost) {
 		return []*txProcessorState{newState}, nil
 	}
 
-	// if the new state is too big, we split it
+	// if the new state is too big or too expensive, we split it
 	newStates := []*txProcessorState{inState.copy()}
 	if err != nil {
 		newStates = append(newStates, &txProcessorState{
 			inState.sst,
 			inState.scs,
-			[]TxResult{{tx, false}},
+			[]TxResult{{ClientTransaction: tx}},
+			0,
 			0,
 		})
 	} else {
 		newStates = append(newStates, &txProcessorState{
 			sstOut,
 			scsOut,
-			[]TxResult{{tx, true}},
+			[]TxResult{{ClientTransaction: tx, Accepted: true, Results: results}},
 			0,
+			cost,
 		})
 	}
 	return newStates, nil
@@ -217,6 +250,23 @@ func (s *defaultTxProcessor) ProcessTx(tx ClientTransaction, inState *txProcesso
 // ProposeBlock basically calls s.createNewBlock which might block. There is
 // nothing we can do about it other than waiting for the timeout.
 func (s *defaultTxProcessor) ProposeBlock(state *txProcessorState) error {
+	err := s.proposeBlock(state)
+	if err != errNotLeader {
+		s.watchdog(err)
+	}
+	return err
+}
+
+func (s *defaultTxProcessor) proposeBlock(state *txProcessorState) error {
+	// The leadership is checked against the latest block, and not against
+	// the proposed state, which can already hold a new roster.
+	latest, err := s.LoadConfig(s.scID)
+	if err != nil {
+		return err
+	}
+	if !latest.Roster.List[0].Equal(s.ServerIdentity()) {
+		return errNotLeader
+	}
 	config, err := LoadConfigFromTrie(state.sst)
 	if err != nil {
 		return err
@@ -225,6 +275,40 @@ func (s *defaultTxProcessor) ProposeBlock(state *txProcessorState) error {
 	return err
 }
 
+// watchdog keeps track of the last successful block. If the leader fails to
+// create blocks for more than watchdogWindow block intervals, it reports
+// itself as stuck and steps down, instead of waiting for the followers to
+// miss its heartbeats.
+func (s *defaultTxProcessor) watchdog(err error) {
+	if err == nil {
+		s.lastBlock = time.Now()
+		return
+	}
+	window := s.GetInterval() * time.Duration(s.getWatchdogWindow())
+	if time.Since(s.lastBlock) <= window {
+		return
+	}
+	log.Errorf("%s couldn't create a block for %x since %v, stepping down: %v",
+		s.ServerIdentity(), s.scID, s.lastBlock, err)
+	// Avoid stepping down again before the next window.
+	s.lastBlock = time.Now()
+	if s.stepDown != nil {
+		// The step down stops this pipeline, so it cannot be done
+		// synchronously.
+		go s.stepDown(s.scID)
+	}
+}
+
+// getWatchdogWindow returns the watchdog window of the chain, or the one of
+// the node if the configuration cannot be loaded.
+func (s *defaultTxProcessor) getWatchdogWindow() int {
+	bcConfig, err := s.LoadConfig(s.scID)
+	if err != nil {
+		return watchdogWindow
+	}
+	return bcConfig.getWatchdogWindow()
+}
+
 func (s *defaultTxProcessor) GetInterval() time.Duration {
 	bcConfig, err := s.LoadConfig(s.scID)
 	if err != nil {
@@ -289,7 +373,7 @@ func (p *txPipeline) collectTx(stopChan chan bool) <-chan ClientTransaction {
 			interval := p.processor.GetInterval()
 			select {
 			case <-stopChan:
-				log.Lvl3("stopping tx collector")
+				logBlock.print(3, "stopping tx collector")
 				close(outChan)
 				return
 			case <-time.After(interval / 2):
@@ -317,9 +401,13 @@ func (p *txPipeline) processTxs(txChan <-chan ClientTransaction, initialState *t
 	// always use the latest one when adding new
 	currentState := []*txProcessorState{initialState}
 	proposalResult := make(chan error, 1)
+	var proposalStart time.Time
+	// backoff stretches the block interval while the proposals take longer
+	// than the interval, so that slow followers can keep up.
+	backoff := 1
 	getInterval := func() <-chan time.Time {
 		interval := p.processor.GetInterval()
-		return time.After(interval)
+		return time.After(interval * time.Duration(backoff))
 	}
 	go func() {
 		p.wg.Add(1)
@@ -329,7 +417,7 @@ func (p *txPipeline) processTxs(txChan <-chan ClientTransaction, initialState *t
 			select {
 			case tx, ok := <-txChan:
 				if !ok {
-					log.Lvl3("stopping txs processor")
+					logBlock.print(3, "stopping txs processor")
 					return
 				}
 				// when processing, we take the latest state
@@ -362,6 +450,7 @@ func (p *txPipeline) processTxs(txChan <-chan ClientTransaction, initialState *t
 					break
 				}
 				proposing = true
+				proposalStart = time.Now()
 
 				// find the right state and propose it in the block
 				var inState *txProcessorState
@@ -387,6 +476,7 @@ func (p *txPipeline) processTxs(txChan <-chan ClientTransaction, initialState *t
 			case err := <-proposalResult:
 				// only the ProposeBlock sends back results and it sends only one
 				proposing = false
+				backoff = adaptBackoff(backoff, err != nil, time.Since(proposalStart), p.processor.GetInterval())
 				if err != nil {
 					log.Error("reverting to last known state because proposal refused:", err)
 					currentState = []*txProcessorState{p.processor.GetLatestGoodState()}
@@ -396,6 +486,26 @@ func (p *txPipeline) processTxs(txChan <-chan ClientTransaction, initialState *t
 	}()
 }
 
+// adaptBackoff returns the new factor of the block interval after a
+// proposal. It doubles, up to maxBlockBackoff, if the proposal failed or took
+// longer than the interval, and halves if the proposal took less than half of
+// the interval.
+func adaptBackoff(backoff int, failed bool, took, interval time.Duration) int {
+	switch {
+	case (failed || took > interval) && backoff < maxBlockBackoff:
+		backoff *= 2
+		if backoff > maxBlockBackoff {
+			backoff = maxBlockBackoff
+		}
+		logBlock.printf(2, "block proposal took %v, using %d times the block interval", took, backoff)
+		return backoff
+	case took < interval/2 && backoff > 1:
+		logBlock.printf(2, "block proposal took %v, using %d times the block interval", took, backoff/2)
+		return backoff / 2
+	}
+	return backoff
+}
+
 // proposeInputState generates the next input state that is used in
 // ProposeBlock. It returns a new state for the pipeline and the state for
 // ProposeBlock.
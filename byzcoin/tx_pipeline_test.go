@@ -3,10 +3,12 @@ package byzcoin
 import (
 	"bytes"
 	"errors"
+	"strconv"
 	"sync"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
 
 	"testing"
 	"time"
@@ -61,7 +63,7 @@ func (p *defaultMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessor
 	return []*txProcessorState{{
 		sst: inState.sst,
 		scs: append(inState.scs, sc),
-		txs: append(inState.txs, TxResult{tx, true}),
+		txs: append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true}),
 	}}, nil
 }
 
@@ -216,6 +218,94 @@ func replayMockTxs(txs []ClientTransaction) ([]byte, error) {
 	return sst.GetRoot(), nil
 }
 
+// slowFollowerMockTxProc simulates followers that need longer than the block
+// interval to verify a block, and records when the blocks are proposed.
+type slowFollowerMockTxProc struct {
+	*defaultMockTxProc
+	proposals chan time.Time
+}
+
+func (p *slowFollowerMockTxProc) ProposeBlock(state *txProcessorState) error {
+	p.proposals <- time.Now()
+	return p.defaultMockTxProc.ProposeBlock(state)
+}
+
+// TestTxPipeline_Backoff checks that the leader stretches the block interval
+// while the followers are too slow, up to maxBlockBackoff times the interval.
+func TestTxPipeline_Backoff(t *testing.T) {
+	mbb := maxBlockBackoff
+	defer func() {
+		maxBlockBackoff = mbb
+	}()
+
+	interval := (&defaultMockTxProc{}).GetInterval()
+
+	// Without backoff, the next block is proposed at the first interval
+	// after the previous proposal.
+	maxBlockBackoff = 1
+	for _, gap := range proposalGaps(t, 5) {
+		require.True(t, gap < 3*interval, "unexpected gap %v", gap)
+	}
+
+	maxBlockBackoff = 4
+	gaps := proposalGaps(t, 5)
+	require.True(t, gaps[len(gaps)-1] > 3*interval, "interval didn't grow: %v", gaps)
+}
+
+// proposalGaps runs the pipeline with slow followers until n blocks have been
+// proposed, and returns the time between consecutive proposals.
+func proposalGaps(t *testing.T, n int) []time.Duration {
+	txs := make([]ClientTransaction, 10*n)
+	for i := range txs {
+		txs[i].Instructions = []Instruction{
+			{
+				InstanceID: NewInstanceID([]byte{byte(i)}),
+				Invoke: &Invoke{
+					ContractID: "",
+					Command:    strconv.Itoa(i),
+				},
+			},
+		}
+	}
+	processor := &slowFollowerMockTxProc{
+		defaultMockTxProc: newDefaultMockTxProc(t, 1, txs, len(txs)).(*defaultMockTxProc),
+		proposals:         make(chan time.Time, len(txs)),
+	}
+	interval := processor.GetInterval()
+	processor.proposeDelay = interval + interval/2
+
+	pipeline := txPipeline{
+		processor: processor,
+	}
+	sst, err := newMemStagingStateTrie([]byte(""))
+	require.NoError(t, err)
+	stopChan := make(chan bool)
+	pipelineDone := make(chan bool)
+	go func() {
+		pipeline.start(&txProcessorState{
+			sst: sst,
+		}, stopChan)
+		close(pipelineDone)
+	}()
+
+	var gaps []time.Duration
+	var last time.Time
+	for i := 0; i < n; i++ {
+		select {
+		case at := <-processor.proposals:
+			if i > 0 {
+				gaps = append(gaps, at.Sub(last))
+			}
+			last = at
+		case <-time.After(10 * interval):
+			require.Fail(t, "no block has been proposed")
+		}
+	}
+	close(stopChan)
+	<-pipelineDone
+	return gaps
+}
+
 func testTxPipeline(t *testing.T, n, batch, failAt int, mock newMockTxProcFunc) {
 	txs := make([]ClientTransaction, n)
 	for i := range txs {
@@ -302,7 +392,8 @@ func (p *bigMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessorStat
 			{
 				newState,
 				[]StateChange{sc},
-				[]TxResult{{tx, true}},
+				[]TxResult{{ClientTransaction: tx, Accepted: true}},
+				0,
 				0,
 			},
 		}, nil
@@ -310,7 +401,8 @@ func (p *bigMockTxProc) ProcessTx(tx ClientTransaction, inState *txProcessorStat
 	return []*txProcessorState{{
 		newState,
 		append(inState.scs, sc),
-		append(inState.txs, TxResult{tx, true}),
+		append(inState.txs, TxResult{ClientTransaction: tx, Accepted: true}),
+		0,
 		0,
 	}}, nil
 }
@@ -330,3 +422,45 @@ func TestTxPipeline_BigTx(t *testing.T) {
 	testTxPipeline(t, 4, 1, 4, newBigMockTxProc)
 	testTxPipeline(t, 8, 2, 8, newBigMockTxProc)
 }
+
+// TestTxPipeline_Watchdog simulates a leader that keeps failing to create
+// blocks and checks that it steps down once the watchdog window is over.
+func TestTxPipeline_Watchdog(t *testing.T) {
+	ww := watchdogWindow
+	defer func() {
+		watchdogWindow = ww
+	}()
+	watchdogWindow = 2
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	stepDown := make(chan skipchain.SkipBlockID, 1)
+	proc := &defaultTxProcessor{
+		scID:      s.genesis.SkipChainID(),
+		Service:   s.service(),
+		lastBlock: time.Now(),
+		stepDown: func(scID skipchain.SkipBlockID) {
+			stepDown <- scID
+		},
+	}
+
+	// A state without any configuration makes every block creation fail.
+	sst, err := newMemStagingStateTrie([]byte(""))
+	require.NoError(t, err)
+	state := &txProcessorState{sst: sst}
+
+	start := time.Now()
+	for {
+		require.Error(t, proc.ProposeBlock(state))
+		select {
+		case scID := <-stepDown:
+			require.True(t, scID.Equal(s.genesis.SkipChainID()))
+			require.True(t, time.Since(start) > testInterval*time.Duration(watchdogWindow))
+			return
+		case <-time.After(testInterval / 5):
+		}
+		require.True(t, time.Since(start) < 2*testInterval*time.Duration(watchdogWindow),
+			"leader didn't step down")
+	}
+}
@@ -0,0 +1,158 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+// The vectors pin the hash and the derived IDs of the instructions, which
+// are part of the ledger: any change to them breaks the existing chains. If
+// the change is on purpose, the file can be written again with
+//
+//	go test -run TestInstruction_Vectors -update-vectors
+const instructionVectorsFile = "testdata/instruction_vectors.json"
+
+var updateVectors = flag.Bool("update-vectors", false, "write the instruction test vectors")
+
+// instructionVector is an entry of the file of test vectors, with all the
+// values as hex strings.
+type instructionVector struct {
+	Name         string
+	Hash         string
+	DeriveID     string
+	DeriveIDCoin string
+}
+
+type instructionVectors struct {
+	Vectors []instructionVector
+	// InstructionsHash is the hash of all the instructions of the vectors,
+	// in order.
+	InstructionsHash string
+}
+
+// vectorInstructions returns the instructions of the test vectors. They only
+// use fixed values, so that they are the same on every run.
+func vectorInstructions() ([]string, Instructions) {
+	var id InstanceID
+	for i := range id {
+		id[i] = byte(i)
+	}
+	ed25519 := darc.NewIdentityEd25519(cothority.Suite.Point().Base())
+	darcID := darc.NewIdentityDarc(bytes.Repeat([]byte{0xaa}, 32))
+	x509ec := darc.NewIdentityX509EC(append([]byte{4}, bytes.Repeat([]byte{5}, 64)...))
+	spawn := func() *Spawn {
+		return &Spawn{
+			ContractID: "value",
+			Args:       Arguments{{Name: "value", Value: []byte("hello")}},
+		}
+	}
+	invoke := func() *Invoke {
+		return &Invoke{
+			ContractID: "value",
+			Command:    "update",
+			Args: Arguments{
+				{Name: "value", Value: []byte("world")},
+				{Name: "empty", Value: []byte{}},
+			},
+		}
+	}
+
+	names := []string{
+		"spawn",
+		"spawn_signed",
+		"spawn_precondition",
+		"invoke",
+		"invoke_signed",
+		"delete",
+		"delete_soft_signed",
+	}
+	instrs := Instructions{
+		{
+			InstanceID: id,
+			Spawn:      spawn(),
+		},
+		{
+			InstanceID:       id,
+			Spawn:            spawn(),
+			SignerIdentities: []darc.Identity{ed25519},
+			SignerCounter:    []uint64{1},
+			Signatures:       [][]byte{bytes.Repeat([]byte{1}, 64)},
+		},
+		{
+			InstanceID:       id,
+			Spawn:            spawn(),
+			SignerIdentities: []darc.Identity{ed25519},
+			SignerCounter:    []uint64{1},
+			Signatures:       [][]byte{bytes.Repeat([]byte{1}, 64)},
+			Preconditions: []Precondition{{
+				InstanceID: NewInstanceID(bytes.Repeat([]byte{0xbb}, 32)),
+				Version:    7,
+			}},
+		},
+		{
+			InstanceID: id,
+			Invoke:     invoke(),
+		},
+		{
+			InstanceID:       id,
+			Invoke:           invoke(),
+			SignerIdentities: []darc.Identity{ed25519, darcID},
+			SignerCounter:    []uint64{2, 3},
+			Signatures: [][]byte{
+				bytes.Repeat([]byte{2}, 64),
+				bytes.Repeat([]byte{3}, 64),
+			},
+		},
+		{
+			InstanceID: id,
+			Delete:     &Delete{ContractID: "value"},
+		},
+		{
+			InstanceID:       id,
+			Delete:           &Delete{ContractID: "value", Soft: true},
+			SignerIdentities: []darc.Identity{x509ec},
+			SignerCounter:    []uint64{4},
+			Signatures:       [][]byte{bytes.Repeat([]byte{4}, 72)},
+		},
+	}
+	return names, instrs
+}
+
+func TestInstruction_Vectors(t *testing.T) {
+	names, instrs := vectorInstructions()
+	var got instructionVectors
+	for i, instr := range instrs {
+		got.Vectors = append(got.Vectors, instructionVector{
+			Name:         names[i],
+			Hash:         hex.EncodeToString(instr.Hash()),
+			DeriveID:     hex.EncodeToString(instr.DeriveID("").Slice()),
+			DeriveIDCoin: hex.EncodeToString(instr.DeriveID("coin").Slice()),
+		})
+	}
+	got.InstructionsHash = hex.EncodeToString(instrs.Hash())
+
+	if *updateVectors {
+		buf, err := json.MarshalIndent(got, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(instructionVectorsFile, append(buf, '\n'), 0644))
+		return
+	}
+
+	buf, err := ioutil.ReadFile(instructionVectorsFile)
+	require.NoError(t, err)
+	var want instructionVectors
+	require.NoError(t, json.Unmarshal(buf, &want))
+	require.Equal(t, len(want.Vectors), len(got.Vectors))
+	for i := range want.Vectors {
+		require.Equal(t, want.Vectors[i], got.Vectors[i], "vector %s", want.Vectors[i].Name)
+	}
+	require.Equal(t, want.InstructionsHash, got.InstructionsHash)
+}
@@ -24,11 +24,14 @@ import (
 type viewChangeManager struct {
 	sync.Mutex
 	controllers map[string]*viewchange.Controller
+	// thresholds are the fault thresholds the controllers use.
+	thresholds map[string]int
 }
 
 func newViewChangeManager() viewChangeManager {
 	return viewChangeManager{
 		controllers: make(map[string]*viewchange.Controller),
+		thresholds:  make(map[string]int),
 	}
 }
 
@@ -52,9 +55,34 @@ func (m *viewChangeManager) start(myID network.ServerIdentityID, scID skipchain.
 	if !ok {
 		panic("never start without add first: " + log.Stack())
 	}
+	m.thresholds[k] = f
 	go c.Start(myID, scID, initialDuration, f)
 }
 
+// setFaultThreshold changes the fault threshold of the monitor of the chain,
+// if it is started and uses another threshold.
+func (m *viewChangeManager) setFaultThreshold(scID skipchain.SkipBlockID, f int) {
+	k := string(scID)
+	m.Lock()
+	defer m.Unlock()
+	c, ok := m.controllers[k]
+	if !ok || m.thresholds[k] == f {
+		return
+	}
+	logViewChange.printf(2, "fault threshold of %x changes from %d to %d", scID, m.thresholds[k], f)
+	c.SetFaultThreshold(f)
+	m.thresholds[k] = f
+}
+
+// faultThreshold returns the fault threshold of the monitor of the chain, if
+// it is started.
+func (m *viewChangeManager) faultThreshold(scID skipchain.SkipBlockID) (int, bool) {
+	m.Lock()
+	defer m.Unlock()
+	f, ok := m.thresholds[string(scID)]
+	return f, ok
+}
+
 // started returns true if the monitor is started. This supposes that `start`
 // has been called after `add`.
 func (m *viewChangeManager) started(scID skipchain.SkipBlockID) bool {
@@ -74,6 +102,7 @@ func (m *viewChangeManager) stop(scID skipchain.SkipBlockID) {
 	}
 	c.Stop()
 	delete(m.controllers, k)
+	delete(m.thresholds, k)
 }
 
 func (m *viewChangeManager) addReq(req viewchange.InitReq) {
@@ -93,7 +122,7 @@ func (m *viewChangeManager) done(view viewchange.View) {
 	if c != nil {
 		c.Done(view)
 	}
-	log.Lvl3("view-change done for " + view.String())
+	logViewChange.print(3, "view-change done for "+view.String())
 }
 
 func (m *viewChangeManager) waiting(k string) bool {
@@ -113,6 +142,7 @@ func (m *viewChangeManager) closeAll() {
 		c.Stop()
 	}
 	m.controllers = make(map[string]*viewchange.Controller)
+	m.thresholds = make(map[string]int)
 }
 
 // sendViewChangeReq is called when the node detects that a view change is
@@ -123,12 +153,12 @@ func (s *Service) sendViewChangeReq(view viewchange.View) error {
 		return errors.New("leader index must be positive")
 	}
 
-	log.Lvl2(s.ServerIdentity(), "sending view-change request for view:", view)
+	logViewChange.print(2, s.ServerIdentity(), "sending view-change request for view:", view)
 	latest, err := s.db().GetLatestByID(view.ID)
 	if err != nil {
 		return err
 	}
-	log.Lvlf2("%s: current leader: %s - asking to elect leader: %s", s.ServerIdentity(), latest.Roster.List[0],
+	logViewChange.printf(2, "%s: current leader: %s - asking to elect leader: %s", s.ServerIdentity(), latest.Roster.List[0],
 		latest.Roster.List[view.LeaderIndex%len(latest.Roster.List)])
 	req := viewchange.InitReq{
 		SignerID: s.ServerIdentity().ID,
@@ -158,7 +188,7 @@ func (s *Service) sendNewView(proof []viewchange.InitReq) {
 	if len(proof) == 0 {
 		log.Error(s.ServerIdentity(), "not enough proofs")
 	}
-	log.Lvl2(s.ServerIdentity(), "sending new-view request for view:", proof[0].View)
+	logViewChange.print(2, s.ServerIdentity(), "sending new-view request for view:", proof[0].View)
 
 	// Our own proof might not be signed, so sign it.
 	for i := range proof {
@@ -198,12 +228,22 @@ func (s *Service) computeInitialDuration(scID skipchain.SkipBlockID) (time.Durat
 	if err != nil {
 		return 0, err
 	}
-	return rotationWindow * interval, nil
+	return s.loadRotationWindow(scID) * interval, nil
+}
+
+// loadRotationWindow returns the rotation window of the chain, or the default
+// one if its configuration cannot be loaded.
+func (s *Service) loadRotationWindow(scID skipchain.SkipBlockID) time.Duration {
+	config, err := s.LoadConfig(scID)
+	if err != nil {
+		return rotationWindow
+	}
+	return config.getRotationWindow()
 }
 
-func (s *Service) getFaultThreshold(sbID skipchain.SkipBlockID) int {
-	sb := s.db().GetByID(sbID)
-	return len(sb.Roster.List) / 3
+// faultThreshold returns how many nodes of the roster can be faulty.
+func faultThreshold(roster *onet.Roster) int {
+	return len(roster.List) / 3
 }
 
 // handleViewChangeReq should be registered as a handler for viewchange.InitReq
@@ -251,7 +291,7 @@ func (s *Service) handleViewChangeReq(env *network.Envelope) error {
 }
 
 func (s *Service) startViewChangeCosi(req viewchange.NewViewReq) ([]byte, error) {
-	defer log.Lvl2(s.ServerIdentity(), "finished view-change blscosi")
+	defer logViewChange.print(2, s.ServerIdentity(), "finished view-change blscosi")
 	sb := s.db().GetByID(req.GetView().ID)
 	newRoster := rotateRoster(sb.Roster, req.GetView().LeaderIndex)
 	if !newRoster.List[0].Equal(s.ServerIdentity()) {
@@ -353,14 +393,14 @@ func (s *Service) verifyViewChange(msg []byte, data []byte) bool {
 			return false
 		}
 	}
-	log.Lvl2(s.ServerIdentity(), "view-change verification OK")
+	logViewChange.print(2, s.ServerIdentity(), "view-change verification OK")
 	return true
 }
 
 // createViewChangeBlock creates a new block to record the successful
 // view-change operation.
 func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []byte) error {
-	defer log.Lvl2(s.ServerIdentity(), "created view-change block")
+	defer logViewChange.print(2, s.ServerIdentity(), "created view-change block")
 	sb, err := s.db().GetLatestByID(req.GetGen())
 	if err != nil {
 		return err
@@ -384,6 +424,10 @@ func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []by
 	if err != nil {
 		return err
 	}
+	config, err := LoadConfigFromTrie(st)
+	if err != nil {
+		return err
+	}
 
 	ctx := ClientTransaction{
 		Instructions: []Instruction{{
@@ -406,11 +450,17 @@ func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []by
 			SignerCounter:    []uint64{ctr + 1},
 		}},
 	}
-	if err = ctx.Instructions[0].SignWith(ctx.Instructions.Hash(), signer); err != nil {
+	// The signature is only bound to the chain once all the nodes know
+	// about chain bound signatures.
+	digest := ctx.Instructions.Hash()
+	if config.ChainBoundSignatures {
+		digest = ctx.Instructions.HashWithChain(req.GetGen())
+	}
+	if err = ctx.Instructions[0].SignWith(digest, signer); err != nil {
 		return err
 	}
 
-	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{TxResult{ctx, false}})
+	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{{ClientTransaction: ctx}})
 	return err
 }
 
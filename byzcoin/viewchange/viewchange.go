@@ -84,6 +84,7 @@ type Controller struct {
 	doneChan         chan View
 	waiting          chan chan bool
 	closeMonitorChan chan bool
+	thresholdChan    chan int
 	sendInitReq      SendInitReqFunc
 	sendNewViewReq   SendNewViewReqFunc
 	isLeader         IsLeaderFunc
@@ -102,6 +103,7 @@ func NewController(sendInitReq SendInitReqFunc, sendNewView SendNewViewReqFunc,
 		doneChan:         make(chan View, 1),
 		waiting:          make(chan chan bool, 1),
 		closeMonitorChan: make(chan bool),
+		thresholdChan:    make(chan int),
 		sendInitReq:      sendInitReq,
 		sendNewViewReq:   sendNewView,
 		isLeader:         isLeader,
@@ -216,6 +218,10 @@ func (c *Controller) Start(myID network.ServerIdentityID, genesis skipchain.Skip
 			meta.add(req)
 			ctr = c.processAnomaly(req, &meta, ctr)
 			meta.clean(ctr)
+		case f = <-c.thresholdChan:
+			// Not a transition: the new threshold applies from
+			// the next request.
+			log.Lvl3("fault threshold changed to", f)
 		case ch := <-c.waiting:
 			if meta.stateOf(ctr) == startedTimerState {
 				ch <- true
@@ -263,6 +269,14 @@ func (c *Controller) AddReq(req InitReq) {
 	c.reqChan <- req
 }
 
+// SetFaultThreshold changes the number of faulty nodes the controller
+// tolerates, e.g. after a change of the roster. It blocks until the
+// controller uses the new threshold, so it must only be called while the
+// controller is started.
+func (c *Controller) SetFaultThreshold(f int) {
+	c.thresholdChan <- f
+}
+
 // Done should be called when a view-change is completed.
 func (c *Controller) Done(view View) {
 	c.doneChan <- view
@@ -32,6 +32,33 @@ func TestViewChange_AutoStart2(t *testing.T) {
 	testAutoStart(t, 2)
 }
 
+// A lower fault threshold needs less requests to start the timer.
+func TestViewChange_SetFaultThreshold(t *testing.T) {
+	dur := 100 * time.Millisecond
+	mySignerID := [16]byte{byte(255)}
+	view := View{
+		ID:          skipchain.SkipBlockID([]byte{42}),
+		LeaderIndex: 1,
+	}
+	vcl := NewController(func(View) error { return nil }, func([]InitReq) {},
+		func(View) bool { return false })
+	go vcl.Start(mySignerID, []byte{}, dur, 2)
+	defer vcl.Stop()
+
+	// 2f+1 requests with f=1, but not with f=2.
+	vcl.SetFaultThreshold(1)
+	vcl.AddReq(InitReq{SignerID: mySignerID, View: view})
+	for i := 0; i < 2; i++ {
+		vcl.AddReq(InitReq{SignerID: [16]byte{byte(i)}, View: view})
+	}
+	select {
+	case ctr := <-vcl.startTimerChan:
+		require.Equal(t, 1, ctr)
+	case <-time.After(dur):
+		require.Fail(t, "timer should have started")
+	}
+}
+
 // testSetupViewChangeF1 sets up the view-change log and sends f view-change
 // messages. If anomaly is set then it sends one more message to the anomaly
 // channel.
@@ -126,6 +126,35 @@ func testViewChange(t *testing.T, nHosts, nFailures int, interval time.Duration)
 	log.Lvl1("Sent two tx")
 }
 
+// TestViewChange_StepDown makes the leader step down, as it does when the
+// watchdog detects that it cannot create blocks anymore. The next node in the
+// roster must take over.
+func TestViewChange_StepDown(t *testing.T) {
+	rw := rotationWindow
+	defer func() {
+		rotationWindow = rw
+	}()
+	rotationWindow = 3
+	s := newSerN(t, 1, testInterval, 4, true)
+	defer s.local.CloseAll()
+
+	for i := range s.services {
+		s.waitProofWithIdx(t, InstanceID{}.Slice(), i)
+	}
+
+	s.services[0].stepDown(s.genesis.SkipChainID())
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(s.interval * rotationWindow)
+		leader, err := s.services[1].getLeader(s.genesis.SkipChainID())
+		require.NoError(t, err)
+		if leader != nil && leader.Equal(s.services[1].ServerIdentity()) {
+			return
+		}
+	}
+	t.Fatal("the leader didn't step down")
+}
+
 // Tests that a view change can happen when the leader index is out of bound
 func TestViewChange_LeaderIndex(t *testing.T) {
 	s := newSerN(t, 1, time.Second, 5, true)
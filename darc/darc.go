@@ -48,17 +48,23 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/kyber/v3/sign/eddsa"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/kyber/v3/util/encoding"
 	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/protobuf"
 )
 
 const evolve = "_evolve"
 const sign = "_sign"
 
+// blsSuite is the pairing suite of the BLS identities and signers.
+var blsSuite = pairing.NewSuiteBn256()
+
 // GetDarc is a callback function that we expect the user of this library to
 // supply in some of our methods. The user is free to choose how he/she wants
 // to store the darc. Hence, during verification, we need a way to retrieve an
@@ -339,6 +345,45 @@ func (d *Darc) EvolveFrom(prev *Darc) error {
 	return nil
 }
 
+// Diff compares the rules of d with the ones of other, typically its
+// evolution, by action. It returns the rules of other whose action is not in
+// d, the rules of d whose action is not in other, and the rules of other whose
+// expression differs from the one in d. Two expressions that only differ by
+// the order or the duplicates of their ids are the same.
+func (d *Darc) Diff(other *Darc) (added, removed, changed []Rule) {
+	for _, r := range other.Rules.List {
+		switch {
+		case !d.Rules.Contains(r.Action):
+			added = append(added, r)
+		case !sameExpr(d.Rules.Get(r.Action), r.Expr):
+			changed = append(changed, r)
+		}
+	}
+	for _, r := range d.Rules.List {
+		if !other.Rules.Contains(r.Action) {
+			removed = append(removed, r)
+		}
+	}
+	return
+}
+
+// sameExpr returns whether the expressions a and b are equal once normalized.
+// Expressions that cannot be parsed are compared as they are.
+func sameExpr(a, b expression.Expr) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	na, err := a.Normalize()
+	if err != nil {
+		return false
+	}
+	nb, err := b.Normalize()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(na, nb)
+}
+
 // MakeEvolveRequest creates a request and signs it such that it can be sent to
 // the darc service (for example) to execute the evolution. This function
 // assumes that the receiver has all the correct attributes to form a valid
@@ -697,6 +742,8 @@ func (s Signer) Type() int {
 		return 2
 	case s.Proxy != nil:
 		return 3
+	case s.BLS != nil:
+		return 4
 	default:
 		return -1
 	}
@@ -712,6 +759,8 @@ func (s Signer) Identity() Identity {
 		return NewIdentityX509EC(s.X509EC.Point)
 	case 3:
 		return NewIdentityProxy(s.Proxy)
+	case 4:
+		return Identity{BLS: &IdentityBLS{Public: copyBytes(s.BLS.Public)}}
 	default:
 		return Identity{}
 	}
@@ -731,6 +780,8 @@ func (s Signer) Sign(msg []byte) ([]byte, error) {
 		return s.X509EC.Sign(msg)
 	case 3:
 		return s.Proxy.Sign(msg)
+	case 4:
+		return s.BLS.Sign(msg)
 	default:
 		return nil, errors.New("unknown signer type")
 	}
@@ -741,6 +792,8 @@ func (s Signer) GetPrivate() (kyber.Scalar, error) {
 	switch s.Type() {
 	case 1:
 		return s.Ed25519.Secret, nil
+	case 4:
+		return s.BLS.private()
 	case 0, 2, 3:
 		return nil, errors.New("signer lacks a private key")
 	default:
@@ -763,6 +816,8 @@ func (id Identity) Equal(id2 *Identity) bool {
 		return id.X509EC.Equal(id2.X509EC)
 	case 3:
 		return id.Proxy.Equal(id2.Proxy)
+	case 4:
+		return id.BLS.Equal(id2.BLS)
 	}
 	return false
 }
@@ -779,6 +834,8 @@ func (id Identity) Type() int {
 		return 2
 	case id.Proxy != nil:
 		return 3
+	case id.BLS != nil:
+		return 4
 	}
 	return -1
 }
@@ -795,6 +852,8 @@ func (id Identity) PrimaryIdentity() bool {
 		return true
 	case id.Proxy != nil:
 		return true
+	case id.BLS != nil:
+		return true
 	}
 	return false
 }
@@ -810,6 +869,8 @@ func (id Identity) TypeString() string {
 		return "x509ec"
 	case 3:
 		return "proxy"
+	case 4:
+		return "bls"
 	default:
 		return "No identity"
 	}
@@ -826,6 +887,8 @@ func (id Identity) String() string {
 		return fmt.Sprintf("%s:%x", id.TypeString(), id.X509EC.Public)
 	case 3:
 		return fmt.Sprintf("%s:%v:%v", id.TypeString(), id.Proxy.Public, id.Proxy.Data)
+	case 4:
+		return fmt.Sprintf("%s:%x", id.TypeString(), id.BLS.Public)
 	default:
 		return "No identity"
 	}
@@ -843,6 +906,8 @@ func (id Identity) Verify(msg, sig []byte) error {
 		return id.X509EC.Verify(msg, sig)
 	case 3:
 		return id.Proxy.Verify(msg, sig)
+	case 4:
+		return id.BLS.Verify(msg, sig)
 	default:
 		return errors.New("unknown identity")
 	}
@@ -867,6 +932,8 @@ func (id Identity) GetPublicBytes() []byte {
 			return nil
 		}
 		return buf
+	case 4:
+		return id.BLS.Public
 	default:
 		return nil
 	}
@@ -935,6 +1002,44 @@ func (idp IdentityProxy) Equal(i2 *IdentityProxy) bool {
 	return idp.Data == i2.Data && idp.Public.Equal(i2.Public)
 }
 
+// NewIdentityBLS creates a new BLS identity struct given a point of the G2
+// group of the bn256 pairing curve.
+func NewIdentityBLS(point kyber.Point) Identity {
+	buf, err := point.MarshalBinary()
+	if err != nil {
+		return Identity{}
+	}
+	return Identity{
+		BLS: &IdentityBLS{
+			Public: buf,
+		},
+	}
+}
+
+// Equal returns true if both IdentityBLS point to the same data.
+func (idb IdentityBLS) Equal(idb2 *IdentityBLS) bool {
+	return bytes.Equal(idb.Public, idb2.Public)
+}
+
+// Point returns the public key of the identity.
+func (idb IdentityBLS) Point() (kyber.Point, error) {
+	p := blsSuite.G2().Point()
+	if err := p.UnmarshalBinary(idb.Public); err != nil {
+		return nil, fmt.Errorf("invalid bls public key: %v", err)
+	}
+	return p, nil
+}
+
+// Verify returns nil if the signature is correct, or an error if something
+// fails.
+func (idb IdentityBLS) Verify(msg, sig []byte) error {
+	p, err := idb.Point()
+	if err != nil {
+		return err
+	}
+	return bls.Verify(blsSuite, p, msg, sig)
+}
+
 type sigRS struct {
 	R *big.Int
 	S *big.Int
@@ -990,6 +1095,8 @@ func ParseIdentity(in string) (Identity, error) {
 		return parseIDX509ec(fields[1])
 	case "proxy":
 		return parseIDProxy(fields[1])
+	case "bls":
+		return parseIDBLS(fields[1])
 	default:
 		return Identity{}, fmt.Errorf("unknown identity type %v", fields[0])
 	}
@@ -1012,6 +1119,18 @@ func parseIDX509ec(in string) (Identity, error) {
 	return Identity{X509EC: &IdentityX509EC{Public: id}}, nil
 }
 
+func parseIDBLS(in string) (Identity, error) {
+	buf, err := hex.DecodeString(in)
+	if err != nil {
+		return Identity{}, err
+	}
+	id := Identity{BLS: &IdentityBLS{Public: buf}}
+	if _, err := id.BLS.Point(); err != nil {
+		return Identity{}, err
+	}
+	return id, nil
+}
+
 func parseIDDarc(in string) (Identity, error) {
 	id := make([]byte, hex.DecodedLen(len(in)))
 	_, err := hex.Decode(id, []byte(in))
@@ -1057,6 +1176,45 @@ func (eds SignerEd25519) Sign(msg []byte) ([]byte, error) {
 	return schnorr.Sign(cothority.Suite, eds.Secret, msg)
 }
 
+// NewSignerBLS initializes a new SignerBLS signer given public and private
+// keys on the bn256 pairing curve. If either of the given keys is nil, then a
+// new key pair is generated.
+func NewSignerBLS(public kyber.Point, private kyber.Scalar) Signer {
+	if public == nil || private == nil {
+		private, public = bls.NewKeyPair(blsSuite, random.New())
+	}
+	pub, err := public.MarshalBinary()
+	if err != nil {
+		return Signer{}
+	}
+	priv, err := private.MarshalBinary()
+	if err != nil {
+		return Signer{}
+	}
+	return Signer{BLS: &SignerBLS{
+		Public: pub,
+		Secret: priv,
+	}}
+}
+
+// Sign creates a BLS signature on the message. Signatures of different
+// signers on the same message can be aggregated with bls.AggregateSignatures.
+func (bs SignerBLS) Sign(msg []byte) ([]byte, error) {
+	priv, err := bs.private()
+	if err != nil {
+		return nil, err
+	}
+	return bls.Sign(blsSuite, priv, msg)
+}
+
+func (bs SignerBLS) private() (kyber.Scalar, error) {
+	priv := blsSuite.G2().Scalar()
+	if err := priv.UnmarshalBinary(bs.Secret); err != nil {
+		return nil, fmt.Errorf("invalid bls private key: %v", err)
+	}
+	return priv, nil
+}
+
 // Hash computes the digest of the request, the identities and signatures are
 // not included.
 func (r Request) Hash() []byte {
@@ -5,7 +5,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 func TestRules(t *testing.T) {
@@ -443,10 +447,82 @@ func TestDarc_DelegationDiamond(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// TestDarc_Threshold tests that a threshold needs the given number of its
+// identities, which can be darcs.
+func TestDarc_Threshold(t *testing.T) {
+	signers := []Signer{NewSignerEd25519(nil, nil), NewSignerEd25519(nil, nil),
+		NewSignerEd25519(nil, nil)}
+	ids := make([]string, len(signers))
+	for i, s := range signers {
+		ids[i] = s.Identity().String()
+	}
+	getDarc := DarcsToGetDarcs(nil)
+	expr := expression.InitThresholdExpr(2, ids...)
+	require.NoError(t, EvalExpr(expr, getDarc, ids[0], ids[2]))
+	require.NoError(t, EvalExprWithSigs(expr, getDarc,
+		Signature{Signer: signers[1].Identity()}, Signature{Signer: signers[2].Identity()}))
+	require.Error(t, EvalExpr(expr, getDarc, ids[1]))
+	require.Error(t, EvalExpr(expr, getDarc))
+
+	// The third identity delegates to a darc.
+	owner := []Identity{signers[2].Identity()}
+	d := NewDarc(InitRules(owner, owner), []byte("threshold"))
+	getDarc = DarcsToGetDarcs([]*Darc{d})
+	expr = expression.InitThresholdExpr(2, ids[0], ids[1], d.GetIdentityString())
+	require.NoError(t, EvalExpr(expr, getDarc, ids[0], ids[2]))
+	require.Error(t, EvalExpr(expr, getDarc, ids[2]))
+}
+
 func TestDarc_X509(t *testing.T) {
 	// TODO
 }
 
+func TestDarc_BLS(t *testing.T) {
+	signer := NewSignerBLS(nil, nil)
+	id := signer.Identity()
+	require.Equal(t, 4, id.Type())
+	require.True(t, id.PrimaryIdentity())
+
+	msg := []byte("message")
+	sig, err := signer.Sign(msg)
+	require.NoError(t, err)
+	require.NoError(t, id.Verify(msg, sig))
+	require.Error(t, id.Verify([]byte("another message"), sig))
+	require.Error(t, NewSignerBLS(nil, nil).Identity().Verify(msg, sig))
+
+	// A request signed by a BLS identity is accepted by a darc.
+	ids := []Identity{id}
+	d := NewDarc(InitRules(ids, ids), []byte("bls darc"))
+	req, err := InitAndSignRequest(d.GetBaseID(), sign, []byte("msg"), signer)
+	require.NoError(t, err)
+	require.NoError(t, req.Verify(d))
+
+	// The signer survives the encoding with the constructors of the
+	// Ed25519 suite, as used to store the keys.
+	buf, err := protobuf.Encode(&signer)
+	require.NoError(t, err)
+	var signer2 Signer
+	require.NoError(t, protobuf.DecodeWithConstructors(buf, &signer2,
+		network.DefaultConstructors(cothority.Suite)))
+	require.True(t, id.Equal(&Identity{BLS: &IdentityBLS{Public: signer2.BLS.Public}}))
+	sig, err = signer2.Sign(msg)
+	require.NoError(t, err)
+	require.NoError(t, id.Verify(msg, sig))
+
+	// Signatures on the same message can be aggregated.
+	signer3 := NewSignerBLS(nil, nil)
+	sig3, err := signer3.Sign(msg)
+	require.NoError(t, err)
+	agg, err := bls.AggregateSignatures(blsSuite, sig, sig3)
+	require.NoError(t, err)
+	p1, err := id.BLS.Point()
+	require.NoError(t, err)
+	p3, err := signer3.Identity().BLS.Point()
+	require.NoError(t, err)
+	aggPub := bls.AggregatePublicKeys(blsSuite, p1, p3)
+	require.NoError(t, NewIdentityBLS(aggPub).Verify(msg, agg))
+}
+
 func TestDarc_IsSubset(t *testing.T) {
 	expr := []byte(createIdentity().String())
 	supersetRules := NewRules()
@@ -473,6 +549,48 @@ func TestDarc_IsSubset(t *testing.T) {
 	require.False(t, wrongSubsetRules.IsSubset(supersetRules))
 }
 
+func TestDarc_Diff(t *testing.T) {
+	expr := []byte(createIdentity().String())
+	expr2 := []byte(createIdentity().String())
+	d := NewDarc(InitRules([]Identity{createIdentity()}, nil), []byte("diff"))
+	require.NoError(t, d.Rules.AddRule("rule1", expr))
+	require.NoError(t, d.Rules.AddRule("rule2", expr))
+	require.NoError(t, d.Rules.AddRule("rule3", expr))
+
+	d2 := d.Copy()
+	require.NoError(t, d2.EvolveFrom(d))
+	added, removed, changed := d.Diff(d2)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+	require.Empty(t, changed)
+
+	require.NoError(t, d2.Rules.DeleteRules("rule1"))
+	require.NoError(t, d2.Rules.UpdateRule("rule2", expr2))
+	require.NoError(t, d2.Rules.AddRule("rule4", expr))
+	require.NoError(t, d2.Rules.AddRule("rule5", expr2))
+	added, removed, changed = d.Diff(d2)
+	require.Equal(t, []Rule{{"rule4", expr}, {"rule5", expr2}}, added)
+	require.Equal(t, []Rule{{"rule1", expr}}, removed)
+	require.Equal(t, []Rule{{"rule2", expr2}}, changed)
+
+	// The other way around, the changes are reversed.
+	added, removed, changed = d2.Diff(d)
+	require.Equal(t, []Rule{{"rule1", expr}}, added)
+	require.Equal(t, []Rule{{"rule4", expr}, {"rule5", expr2}}, removed)
+	require.Equal(t, []Rule{{"rule2", expr}}, changed)
+
+	// Reordering the ids of an expression doesn't change it.
+	d3 := d2.Copy()
+	require.NoError(t, d3.EvolveFrom(d2))
+	require.NoError(t, d2.Rules.UpdateRule("rule3", []byte(string(expr)+" | "+string(expr2))))
+	require.NoError(t, d3.Rules.UpdateRule("rule3", []byte(string(expr2)+" | "+string(expr))))
+	_, _, changed = d2.Diff(d3)
+	require.Empty(t, changed)
+	require.NoError(t, d3.Rules.UpdateRule("rule3", []byte(string(expr2)+" & "+string(expr))))
+	_, _, changed = d2.Diff(d3)
+	require.Equal(t, 1, len(changed))
+}
+
 type testDarc struct {
 	darc   *Darc
 	owners []Signer
@@ -577,4 +695,14 @@ func TestParseIdentity(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, i.Proxy)
 	require.Equal(t, in, i.String())
+
+	in = "bls:010203"
+	i, err = ParseIdentity(in)
+	require.Error(t, err)
+
+	in = NewSignerBLS(nil, nil).Identity().String()
+	i, err = ParseIdentity(in)
+	require.NoError(t, err)
+	require.NotNil(t, i.BLS)
+	require.Equal(t, in, i.String())
 }
@@ -5,8 +5,9 @@ the syntax we use is from: https://en.wikipedia.org/wiki/Extended_Backus%E2%80%9
 
 	expr = term, [ '&', term ]*
 	term = factor, [ '|', factor ]*
-	factor = '(', expr, ')' | id | openid
-	typeHex = (darc|ed25519|x509ec):[0-9a-fA-F]
+	factor = '(', expr, ')' | id | openid | threshold
+	threshold = 'threshold(', digit+, [ ',', factor ]+, ')'
+	typeHex = (darc|ed25519|x509ec|bls):[0-9a-fA-F]
     proxy = proxy:ed25519-pubkey:associated_data
 
 Examples:
@@ -21,15 +22,18 @@ true.  If the set of valid ids is [a:a, c:c], then the expression will evaluate
 to false. However, the user is able to provide a ValueCheckFn to customise how
 the expressions are evaluated.
 
-EXTENSION - NOT YET IMPLEMENTED:
-To support threshold signatures, we extend the syntax to include the following.
-	thexpr = '[', id, [ ',', id ]*, ']', '/', digit
+A threshold evaluates to true if at least the given number of its factors
+evaluate to true, so threshold(2, a:a, b:b, c:c) needs any 2 of the 3 ids. The
+number must be between 1 and the number of factors, and a factor must not be
+given twice, as it would count twice.
 */
 package expression
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	parsec "github.com/prataprc/goparsec"
@@ -47,8 +51,19 @@ type ValueCheckFn func(string) bool
 // Expr represents the unprocess expression of our DSL.
 type Expr []byte
 
+// HasThreshold returns true if the expression contains a threshold.
+func (e Expr) HasThreshold() bool {
+	return strings.Contains(string(e), "threshold(")
+}
+
 // InitParser creates the root parser
 func InitParser(fn ValueCheckFn) parsec.Parser {
+	return initParser(exprValueNode(fn), sumNode(fn), thresholdNode)
+}
+
+// initParser creates the root parser, with the given functions creating the
+// nodes of the ids, of the sums and of the thresholds.
+func initParser(valueNode, sumNode, thresholdNode parsec.Nodify) parsec.Parser {
 	// Y is root Parser, usually called as `s` in CFG theory.
 	var Y parsec.Parser
 	var sum, value parsec.Parser // circular rats
@@ -58,6 +73,9 @@ func InitParser(fn ValueCheckFn) parsec.Parser {
 	var closeparan = parsec.Token(`\)`, "CLOSEPARAN")
 	var andop = parsec.Token(`&`, "AND")
 	var orop = parsec.Token(`\|`, "OR")
+	var thresholdop = parsec.Token(`threshold\(`, "THRESHOLD")
+	var comma = parsec.Token(`,`, "COMMA")
+	var number = parsec.Token(`[0-9]+`, "NUMBER")
 
 	// NonTerminal rats
 	// andop -> "&" |  "|"
@@ -69,11 +87,15 @@ func InitParser(fn ValueCheckFn) parsec.Parser {
 	// (andop prod)*
 	var prodK = parsec.Kleene(nil, parsec.And(many2many, sumOp, &value), nil)
 
+	// value -> "threshold(" number ("," value)* ")"
+	var thresholdK = parsec.Kleene(nil, parsec.And(many2many, comma, &value), nil)
+	var thresholdExpr = parsec.And(thresholdNode, thresholdop, number, thresholdK, closeparan)
+
 	// Circular rats come to life
 	// sum -> prod (andop prod)*
-	sum = parsec.And(sumNode(fn), &value, prodK)
-	// value -> id | "(" expr ")"
-	value = parsec.OrdChoice(exprValueNode(fn), typeHex(), proxy(), groupExpr)
+	sum = parsec.And(sumNode, &value, prodK)
+	// value -> id | "(" expr ")" | threshold
+	value = parsec.OrdChoice(valueNode, typeHex(), proxy(), groupExpr, thresholdExpr)
 	// expr  -> sum
 	Y = parsec.OrdChoice(one2one, sum)
 	return Y
@@ -83,6 +105,15 @@ func InitParser(fn ValueCheckFn) parsec.Parser {
 // the result of the evaluate (a boolean), but the result is only valid if
 // there are no errors.
 func Evaluate(parser parsec.Parser, expr Expr) (bool, error) {
+	if bytes.Contains(expr, []byte(opThreshold+"(")) {
+		tree, err := parseTree(expr)
+		if err != nil {
+			return false, err
+		}
+		if err := tree.checkThresholds(); err != nil {
+			return false, err
+		}
+	}
 	v, s := parser(parsec.NewScanner(expr))
 	_, s = s.SkipWS()
 	if !s.Endof() {
@@ -121,11 +152,17 @@ func InitOrExpr(ids ...string) Expr {
 	return Expr(strings.Join(ids, " | "))
 }
 
+// InitThresholdExpr creates an expression that is true if at least n of the
+// IDs are valid.
+func InitThresholdExpr(n int, ids ...string) Expr {
+	return Expr(fmt.Sprintf("threshold(%d, %s)", n, strings.Join(ids, ", ")))
+}
+
 // Accepts tokens of the form "type:HEX"
 func typeHex() parsec.Parser {
 	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
 		_, s = s.SkipAny(`^[ \n\t]+`)
-		p := parsec.Token(`(darc|ed25519|x509ec):[0-9a-fA-F]+`, "HEX")
+		p := parsec.Token(`(darc|ed25519|x509ec|bls):[0-9a-fA-F]+`, "HEX")
 		return p(s)
 	}
 }
@@ -170,6 +207,30 @@ func exprValueNode(fn ValueCheckFn) func(ns []parsec.ParsecNode) parsec.ParsecNo
 	}
 }
 
+// thresholdNode counts the factors that are true. Like for the other nodes,
+// all the factors are evaluated. A threshold out of range makes the parsing
+// fail. The duplicate factors are refused by Evaluate.
+func thresholdNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) == 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(ns[1].(*parsec.Terminal).Value)
+	if err != nil {
+		return nil
+	}
+	factors := ns[2].([]parsec.ParsecNode)
+	if n < 1 || n > len(factors) {
+		return nil
+	}
+	count := 0
+	for _, x := range factors {
+		if x.([]parsec.ParsecNode)[1].(bool) {
+			count++
+		}
+	}
+	return count >= n
+}
+
 func exprNode(ns []parsec.ParsecNode) parsec.ParsecNode {
 	if len(ns) == 0 {
 		return nil
@@ -218,3 +218,135 @@ func TestEval_DefaultParser(t *testing.T) {
 		t.Fatal("evaluation should return false")
 	}
 }
+
+func TestParsing_Threshold(t *testing.T) {
+	keys := []string{"ed25519:a", "ed25519:b", "ed25519:c"}
+	expr := InitThresholdExpr(2, keys...)
+	if string(expr) != "threshold(2, ed25519:a, ed25519:b, ed25519:c)" {
+		t.Fatalf("wrong expression %s", expr)
+	}
+	if !expr.HasThreshold() || Expr("ed25519:a | ed25519:b").HasThreshold() {
+		t.Fatal("wrong detection of the threshold")
+	}
+	for _, tc := range []struct {
+		ids []string
+		ok  bool
+	}{
+		{keys[:2], true},
+		{keys[1:], true},
+		{keys, true},
+		{keys[:1], false},
+		{nil, false},
+	} {
+		ok, err := DefaultParser(expr, tc.ids...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.ok {
+			t.Fatalf("%v should evaluate to %v", tc.ids, tc.ok)
+		}
+	}
+
+	// A threshold is a factor like the others.
+	expr = []byte("ed25519:d & threshold(1, ed25519:a, (ed25519:b & ed25519:c))")
+	ok, err := DefaultParser(expr, "ed25519:d", "ed25519:b", "ed25519:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("evaluation should return true")
+	}
+	ok, err = DefaultParser(expr, "ed25519:d", "ed25519:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("evaluation should return false")
+	}
+
+	for _, expr := range []string{
+		"threshold(0, ed25519:a)",
+		"threshold(2, ed25519:a)",
+		"threshold(1)",
+		"threshold(ed25519:a)",
+		"threshold(1, ed25519:a",
+		"threshold(1 ed25519:a)",
+		// A factor given twice would count twice.
+		"threshold(2, ed25519:a, ed25519:a, ed25519:b)",
+		"threshold(2, (ed25519:a | ed25519:b), (ed25519:b | ed25519:a))",
+		"ed25519:c | threshold(1, ed25519:a, threshold(1, ed25519:b, ed25519:b))",
+	} {
+		_, err := Evaluate(InitParser(trueFn), Expr(expr))
+		if err == nil {
+			t.Fatalf("%s should fail", expr)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	ids := []string{"ed25519:a", "ed25519:b", "ed25519:c"}
+	for _, tc := range []struct {
+		expr       string
+		normalized string
+	}{
+		{"ed25519:a", "ed25519:a"},
+		{"ed25519:b | ed25519:a", "ed25519:a | ed25519:b"},
+		{"ed25519:b & ed25519:a", "ed25519:a & ed25519:b"},
+		{"(ed25519:a | ed25519:a)", "ed25519:a"},
+		{"ed25519:b & (ed25519:a & ed25519:b)", "ed25519:a & ed25519:b"},
+		{"(ed25519:c | ed25519:a) | (ed25519:b | ed25519:a)", "ed25519:a | ed25519:b | ed25519:c"},
+		// The operators are applied from left to right.
+		{"ed25519:c | ed25519:b & ed25519:a", "(ed25519:b | ed25519:c) & ed25519:a"},
+		{"ed25519:c & (ed25519:b | ed25519:a)", "(ed25519:a | ed25519:b) & ed25519:c"},
+		{"threshold(1, ed25519:c, (ed25519:b | ed25519:a))", "threshold(1, (ed25519:a | ed25519:b), ed25519:c)"},
+	} {
+		normalized, err := Expr(tc.expr).Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(normalized) != tc.normalized {
+			t.Fatalf("%s should be normalized to %s, got %s", tc.expr, tc.normalized, normalized)
+		}
+		again, err := normalized.Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != tc.normalized {
+			t.Fatalf("%s is not normalized again to itself, got %s", normalized, again)
+		}
+
+		// The normalized expression evaluates like the original one for
+		// every set of valid ids.
+		for i := 0; i < 1<<uint(len(ids)); i++ {
+			var valid []string
+			for j, id := range ids {
+				if i&(1<<uint(j)) != 0 {
+					valid = append(valid, id)
+				}
+			}
+			want, err := DefaultParser(Expr(tc.expr), valid...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DefaultParser(normalized, valid...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("%s and %s evaluate differently for %v", tc.expr, normalized, valid)
+			}
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"ed25519:a &",
+		"ed25519:a ed25519:b",
+		"threshold(2, ed25519:a)",
+		"threshold(2, ed25519:b, ed25519:a, ed25519:a)",
+	} {
+		if _, err := Expr(expr).Normalize(); err == nil {
+			t.Fatalf("%s should fail", expr)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	parsec "github.com/prataprc/goparsec"
+)
+
+// node is an expression parsed into a tree, so that it can be rewritten. An
+// id has no operator.
+type node struct {
+	op       string
+	id       string
+	n        int
+	children []*node
+}
+
+const (
+	opAnd       = "&"
+	opOr        = "|"
+	opThreshold = "threshold"
+)
+
+// Normalize returns an expression that evaluates like e, but is easier to
+// read and to compare:
+//   - nested ANDs and ORs are flattened, with explicit parentheses where they
+//     are mixed, as the operators are applied from left to right
+//   - an id given twice in the same AND or OR is only kept once
+//   - the factors of the ANDs, ORs and thresholds are sorted
+//
+// So two expressions that only differ by the order of their ids, or by
+// duplicates, have the same normalized form. A threshold with the same factor
+// twice is refused, like by Evaluate.
+func (e Expr) Normalize() (Expr, error) {
+	tree, err := parseTree(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.checkThresholds(); err != nil {
+		return nil, err
+	}
+	return Expr(normalize(tree).String()), nil
+}
+
+// parseTree parses e with the grammar of InitParser, but returns the tree of
+// the expression instead of evaluating it.
+func parseTree(e Expr) (*node, error) {
+	parser := initParser(treeValueNode, treeSumNode, treeThresholdNode)
+	v, s := parser(parsec.NewScanner(e))
+	_, s = s.SkipWS()
+	if !s.Endof() {
+		rest, _ := s.Match(".*")
+		return nil, fmt.Errorf("%v: (rest = %v)", errScannerNotEmpty, string(rest))
+	}
+	tree, ok := v.(*node)
+	if !ok || tree == nil {
+		return nil, errors.New("parsing failed - not a valid expression")
+	}
+	return tree, nil
+}
+
+func treeValueNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) == 0 {
+		return nil
+	} else if term, ok := ns[0].(*parsec.Terminal); ok {
+		return &node{id: term.Value}
+	}
+	return ns[0]
+}
+
+// treeSumNode applies the operators from left to right, like sumNode.
+func treeSumNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) == 0 {
+		return nil
+	}
+	val, ok := ns[0].(*node)
+	if !ok {
+		return nil
+	}
+	for _, x := range ns[1].([]parsec.ParsecNode) {
+		y := x.([]parsec.ParsecNode)
+		n, ok := y[1].(*node)
+		if !ok {
+			return nil
+		}
+		switch y[0].(*parsec.Terminal).Name {
+		case "AND":
+			val = &node{op: opAnd, children: []*node{val, n}}
+		case "OR":
+			val = &node{op: opOr, children: []*node{val, n}}
+		}
+	}
+	return val
+}
+
+func treeThresholdNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) == 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(ns[1].(*parsec.Terminal).Value)
+	if err != nil {
+		return nil
+	}
+	factors := ns[2].([]parsec.ParsecNode)
+	if n < 1 || n > len(factors) {
+		return nil
+	}
+	t := &node{op: opThreshold, n: n}
+	for _, x := range factors {
+		f, ok := x.([]parsec.ParsecNode)[1].(*node)
+		if !ok {
+			return nil
+		}
+		t.children = append(t.children, f)
+	}
+	return t
+}
+
+// checkThresholds returns an error if a threshold of the tree has the same
+// factor twice, once normalized. Otherwise, threshold(2, a, a, b) would be met
+// by a alone.
+func (nd *node) checkThresholds() error {
+	for _, c := range nd.children {
+		if err := c.checkThresholds(); err != nil {
+			return err
+		}
+	}
+	if nd.op != opThreshold {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, c := range nd.children {
+		f := normalize(c).factor()
+		if seen[f] {
+			return fmt.Errorf("the factor %s is given twice in a threshold", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
+
+// normalize returns the normalized tree of nd. The trees with a duplicate in
+// a threshold are refused by checkThresholds before.
+func normalize(nd *node) *node {
+	if nd.op == "" {
+		return nd
+	}
+	var children []*node
+	for _, c := range nd.children {
+		c = normalize(c)
+		if nd.op != opThreshold && c.op == nd.op {
+			children = append(children, c.children...)
+		} else {
+			children = append(children, c)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].factor() < children[j].factor()
+	})
+	if nd.op == opThreshold {
+		return &node{op: opThreshold, n: nd.n, children: children}
+	}
+
+	unique := children[:1]
+	for _, c := range children[1:] {
+		if c.factor() != unique[len(unique)-1].factor() {
+			unique = append(unique, c)
+		}
+	}
+	if len(unique) == 1 {
+		return unique[0]
+	}
+	return &node{op: nd.op, children: unique}
+}
+
+// String returns the expression of the tree.
+func (nd *node) String() string {
+	if nd.op == "" {
+		return nd.id
+	}
+	factors := make([]string, len(nd.children))
+	for i, c := range nd.children {
+		factors[i] = c.factor()
+	}
+	if nd.op == opThreshold {
+		return fmt.Sprintf("threshold(%d, %s)", nd.n, strings.Join(factors, ", "))
+	}
+	return strings.Join(factors, " "+nd.op+" ")
+}
+
+// factor returns the expression of the tree, with parentheses if it is an AND
+// or an OR.
+func (nd *node) factor() string {
+	if nd.op == opAnd || nd.op == opOr {
+		return "(" + nd.String() + ")"
+	}
+	return nd.String()
+}
@@ -53,8 +53,8 @@ type Darc struct {
 	VerificationDarcs []*Darc
 }
 
-// Identity is a generic structure can be either an Ed25519 public key, a Darc
-// or a X509 Identity.
+// Identity is a generic structure can be either an Ed25519 public key, a Darc,
+// a X509 Identity or a BLS public key.
 type Identity struct {
 	// Darc identity
 	Darc *IdentityDarc
@@ -64,6 +64,8 @@ type Identity struct {
 	X509EC *IdentityX509EC
 	// A claim which has been signed by a proxy or proxies.
 	Proxy *IdentityProxy
+	// Public-key identity on the bn256 pairing curve.
+	BLS *IdentityBLS
 }
 
 // IdentityEd25519 holds a Ed25519 public key (Point)
@@ -83,6 +85,13 @@ type IdentityProxy struct {
 	Public kyber.Point
 }
 
+// IdentityBLS holds a BLS public key, which is a point of the G2 group of the
+// bn256 pairing curve. It is stored marshalled, as the points of the
+// identities are decoded on the Ed25519 curve.
+type IdentityBLS struct {
+	Public []byte
+}
+
 // IdentityDarc is a structure that points to a Darc with a given ID on a
 // skipchain. The signer should belong to the Darc.
 type IdentityDarc struct {
@@ -104,6 +113,7 @@ type Signer struct {
 	Ed25519 *SignerEd25519
 	X509EC  *SignerX509EC
 	Proxy   *SignerProxy
+	BLS     *SignerBLS
 }
 
 // SignerEd25519 holds a public and private keys necessary to sign Darcs
@@ -112,6 +122,13 @@ type SignerEd25519 struct {
 	Secret kyber.Scalar
 }
 
+// SignerBLS holds the public and private keys of a BLS signer, marshalled
+// like the public key of IdentityBLS.
+type SignerBLS struct {
+	Public []byte
+	Secret []byte
+}
+
 // SignerX509EC holds a public and private keys necessary to sign Darcs,
 // but the private key will not be given out.
 type SignerX509EC struct {
@@ -20,6 +20,7 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/authprox"
 	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/eventlog"
 	"go.dedis.ch/cothority/v3/skipchain"
@@ -233,7 +234,7 @@ func getClient(c *cli.Context, priv bool) (*eventlog.Client, error) {
 		return nil, errors.New("--bc flag is required")
 	}
 
-	cfgBuf, err := ioutil.ReadFile(bc)
+	cfgBuf, err := lib.ReadCheckedFile(bc)
 	if err != nil {
 		return nil, err
 	}
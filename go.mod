@@ -14,6 +14,7 @@ require (
 	go.dedis.ch/onet/v3 v3.0.9
 	go.dedis.ch/protobuf v1.0.6
 	go.etcd.io/bbolt v1.3.2
+	golang.org/x/crypto v0.0.0-20190123085648-057139ce5d2b
 	golang.org/x/oauth2 v0.0.0-20190115181402-5dab4167f31c
 	golang.org/x/sys v0.0.0-20190124100055-b90733256f2e
 	golang.org/x/text v0.3.1-0.20180807135948-17ff2d5776d2 // indirect
@@ -335,6 +335,18 @@ func (c *Client) GetUpdateChainLevel(roster *onet.Roster, latest SkipBlockID,
 	}
 }
 
+// FollowRoster moves the follower to the latest block of the chain. It asks
+// the nodes of the roster of the follower for the update chain, and verifies
+// it with RosterFollower.Follow, so that the new rosters have to be signed
+// by the previous ones.
+func (c *Client) FollowRoster(rf *RosterFollower) error {
+	reply, err := c.GetUpdateChain(rf.Roster, rf.Latest)
+	if err != nil {
+		return err
+	}
+	return rf.Follow(reply.Update)
+}
+
 // GetAllSkipchains is deprecated and should no longer be used. See GetAllSkipChainIDs.
 func (c *Client) GetAllSkipchains(si *network.ServerIdentity) (reply *GetAllSkipchainsReply,
 	err error) {
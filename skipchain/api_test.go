@@ -156,6 +156,75 @@ func TestClient_GetUpdateChain(t *testing.T) {
 	}
 }
 
+// A follower goes over several roster changes, verifying every forward link
+// with the roster it already trusts.
+func TestClient_FollowRoster(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+
+	conodes := 5
+	sbCount := conodes - 1
+	servers, roster, gs := local.MakeSRS(cothority.Suite, conodes, skipchainSID)
+	s := gs.(*Service)
+	c := newTestClient(local)
+
+	sbs := make([]*SkipBlock, sbCount)
+	var err error
+	sbs[0], err = makeGenesisRosterArgs(s, onet.NewRoster(roster.List[0:2]),
+		nil, VerificationNone, 2, 3)
+	require.NoError(t, err)
+	for i := 1; i < sbCount; i++ {
+		newSB := NewSkipBlock()
+		newSB.Roster = onet.NewRoster(roster.List[i : i+2])
+		service := local.Services[servers[i].ServerIdentity.ID][skipchainSID].(*Service)
+		reply, err := service.StoreSkipBlock(&StoreSkipBlock{TargetSkipChainID: sbs[i-1].Hash, NewBlock: newSB})
+		require.NoError(t, err)
+		sbs[i] = reply.Latest
+	}
+	last := sbs[sbCount-1]
+
+	rf, err := NewRosterFollower(sbs[0])
+	require.NoError(t, err)
+	require.NoError(t, c.FollowRoster(rf))
+	require.True(t, rf.Latest.Equal(last.Hash))
+	require.Equal(t, last.Index, rf.Index)
+	require.True(t, rf.Roster.ID.Equal(last.Roster.ID))
+	// Nothing new.
+	require.NoError(t, c.FollowRoster(rf))
+	require.True(t, rf.Latest.Equal(last.Hash))
+
+	// A new roster that doesn't match the signed ID is refused, and the
+	// follower stays at the trusted block.
+	rf, err = NewRosterFollower(sbs[0])
+	require.NoError(t, err)
+	reply, err := c.GetUpdateChain(rf.Roster, rf.Latest)
+	require.NoError(t, err)
+	require.True(t, len(reply.Update) > 1)
+	for _, fl := range reply.Update[0].ForwardLink {
+		if fl.NewRoster != nil {
+			fl.NewRoster = &onet.Roster{ID: fl.NewRoster.ID, List: roster.List[3:5]}
+		}
+	}
+	err = rf.Follow(reply.Update)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't match its ID")
+	require.True(t, rf.Latest.Equal(sbs[0].Hash))
+
+	// A follower with another roster refuses the forward links.
+	rf, err = NewRosterFollower(sbs[0])
+	require.NoError(t, err)
+	reply, err = c.GetUpdateChain(rf.Roster, rf.Latest)
+	require.NoError(t, err)
+	rf.Roster = onet.NewRoster(roster.List[3:5])
+	err = rf.Follow(reply.Update)
+	require.Error(t, err)
+	require.True(t, rf.Latest.Equal(sbs[0].Hash))
+
+	_, err = NewRosterFollower(&SkipBlock{SkipBlockFix: &SkipBlockFix{}})
+	require.Error(t, err)
+}
+
 func TestClient_StoreSkipBlock(t *testing.T) {
 	nbrHosts := 3
 	l := onet.NewTCPTest(cothority.Suite)
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/kyber/v3/util/key"
@@ -303,6 +304,42 @@ func TestService_SignBlock(t *testing.T) {
 	log.ErrFatal(sbSecond.VerifyForwardSignatures())
 }
 
+func TestService_ForwardLinkSigners(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeSRS(cothority.Suite, 4, skipchainSID)
+	service := genService.(*Service)
+
+	sbRoot, err := makeGenesisRosterArgs(service, el, nil, VerificationNone, 1, 1)
+	require.NoError(t, err)
+	_, err = sbRoot.ForwardLinkSigners(0)
+	require.Error(t, err)
+
+	sb := NewSkipBlock()
+	sb.Roster = el
+	reply, err := service.StoreSkipBlock(&StoreSkipBlock{TargetSkipChainID: sbRoot.Hash, NewBlock: sb})
+	require.NoError(t, err)
+	sbRoot = reply.Previous
+
+	signers, err := sbRoot.ForwardLinkSigners(0)
+	require.NoError(t, err)
+	require.True(t, len(signers) >= protocol.DefaultThreshold(len(el.List)))
+	for _, si := range signers {
+		idx, _ := el.Search(si.ID)
+		require.True(t, idx >= 0)
+	}
+
+	_, err = sbRoot.ForwardLinkSigners(1)
+	require.Error(t, err)
+
+	// A corrupted signature is refused.
+	sig := sbRoot.ForwardLink[0].Signature.Sig
+	sbRoot.ForwardLink[0].Signature.Sig = sig[:len(sig)-1]
+	_, err = sbRoot.ForwardLinkSigners(0)
+	require.Error(t, err)
+}
+
 func TestService_ProtocolVerification(t *testing.T) {
 	// Testing whether we sign correctly the SkipBlocks
 	local := onet.NewLocalTest(cothority.Suite)
@@ -388,6 +388,45 @@ func (sb *SkipBlock) VerifyForwardSignatures() error {
 	return nil
 }
 
+// ForwardLinkSigners returns the conodes of the roster of the block that
+// co-signed the forward link at the given level. The signature of the
+// forward link is verified first, so the returned list always fulfills the
+// threshold. This is useful to audit how many conodes took part in the
+// consensus of a block.
+func (sb *SkipBlock) ForwardLinkSigners(level int) ([]*network.ServerIdentity, error) {
+	if level < 0 || level >= len(sb.ForwardLink) {
+		return nil, fmt.Errorf("no forward link at level %d", level)
+	}
+	fl := sb.ForwardLink[level]
+	if fl.IsEmpty() {
+		return nil, fmt.Errorf("forward link at level %d is empty", level)
+	}
+	if !sb.Hash.Equal(sb.CalculateHash()) {
+		return nil, errors.New("Calculated hash does not match")
+	}
+	if sb.Roster == nil {
+		return nil, errors.New("Missing roster in the block")
+	}
+
+	publics := sb.Roster.ServicePublics(ServiceName)
+	if err := fl.Verify(suite, publics); err != nil {
+		return nil, errors.New("Wrong signature in forward-link: " + err.Error())
+	}
+	mask, err := protocol.BlsSignature(fl.Signature.Sig).GetMask(suite, publics)
+	if err != nil {
+		return nil, err
+	}
+
+	bits := mask.Mask()
+	var signers []*network.ServerIdentity
+	for i, si := range sb.Roster.List {
+		if i/8 < len(bits) && bits[i/8]&(byte(1)<<uint(i&7)) != 0 {
+			signers = append(signers, si)
+		}
+	}
+	return signers, nil
+}
+
 // Equal returns bool if both hashes are equal
 func (sb *SkipBlock) Equal(other *SkipBlock) bool {
 	return bytes.Equal(sb.Hash, other.Hash)
@@ -631,6 +670,91 @@ func (fl *ForwardLink) Verify(suite *pairing.SuiteBn256, pubs []kyber.Point) err
 	return protocol.BlsSignature(fl.Signature.Sig).Verify(suite, fl.Signature.Msg, pubs)
 }
 
+// VerifyFrom verifies the forward link with the roster of the block it comes
+// from, and returns the roster of the block it points to. As only the ID of a
+// new roster is signed, the list of the new roster has to match its ID. The
+// ID only covers the ed25519 keys of the nodes, so the service keys of the
+// new roster, which verify the next links, are not checked.
+func (fl *ForwardLink) VerifyFrom(from *onet.Roster) (*onet.Roster, error) {
+	if from == nil {
+		return nil, errors.New("missing roster of the source block")
+	}
+	if err := fl.Verify(suite, from.ServicePublics(ServiceName)); err != nil {
+		return nil, err
+	}
+	if fl.NewRoster == nil {
+		return from, nil
+	}
+	if len(fl.NewRoster.List) == 0 || !onet.NewRoster(fl.NewRoster.List).ID.Equal(fl.NewRoster.ID) {
+		return nil, errors.New("the new roster of the forward link doesn't match its ID")
+	}
+	return fl.NewRoster, nil
+}
+
+// RosterFollower is the state of a light client that follows the roster
+// changes of a skipchain from a trusted block, without storing the blocks.
+// Every forward link is verified with the roster of the block it comes from.
+// As VerifyFrom doesn't check the service keys of a new roster, the client
+// still has to trust the nodes giving it the update chain.
+type RosterFollower struct {
+	// Latest is the ID of the latest verified block.
+	Latest SkipBlockID
+	// Index is the index of the latest verified block.
+	Index int
+	// Roster is the roster of the latest verified block, which signs its
+	// forward links.
+	Roster *onet.Roster
+}
+
+// NewRosterFollower returns a follower starting at the trusted block sb.
+func NewRosterFollower(sb *SkipBlock) (*RosterFollower, error) {
+	if sb == nil || sb.Roster == nil {
+		return nil, errors.New("the trusted block needs a roster")
+	}
+	if !sb.CalculateHash().Equal(sb.Hash) {
+		return nil, errors.New("wrong hash of the trusted block")
+	}
+	return &RosterFollower{Latest: sb.Hash, Index: sb.Index, Roster: sb.Roster}, nil
+}
+
+// Follow verifies the blocks of an update chain, as returned by
+// GetUpdateChain, which has to start with the latest block of the follower.
+// Every block is only accepted if the forward link pointing to it is signed
+// by the roster of the previous block, as the follower knows it, and if it
+// has the roster of the link. The follower then moves to the last block, or
+// stays where it was if one of the blocks is wrong.
+func (rf *RosterFollower) Follow(update []*SkipBlock) error {
+	if len(update) == 0 || !update[0].Hash.Equal(rf.Latest) {
+		return errors.New("the update doesn't start with the latest block of the follower")
+	}
+	latest, index, roster := rf.Latest, rf.Index, rf.Roster
+	for i, sb := range update[1:] {
+		if !sb.CalculateHash().Equal(sb.Hash) {
+			return fmt.Errorf("wrong hash of block %d", sb.Index)
+		}
+		var link *ForwardLink
+		for _, fl := range update[i].ForwardLink {
+			if fl != nil && fl.From.Equal(latest) && fl.To.Equal(sb.Hash) {
+				link = fl
+			}
+		}
+		if link == nil {
+			return fmt.Errorf("no forward link from block %d to block %d", index, sb.Index)
+		}
+		var err error
+		roster, err = link.VerifyFrom(roster)
+		if err != nil {
+			return fmt.Errorf("forward link from block %d to block %d: %v", index, sb.Index, err)
+		}
+		if sb.Roster == nil || !sb.Roster.ID.Equal(roster.ID) {
+			return fmt.Errorf("block %d doesn't have the roster of its forward link", sb.Index)
+		}
+		latest, index = sb.Hash, sb.Index
+	}
+	rf.Latest, rf.Index, rf.Roster = latest, index, roster
+	return nil
+}
+
 // IsEmpty indicates whether this forwardlink is merely a placeholder for
 // higher-order forwardlinks to be in the correct place.
 func (fl *ForwardLink) IsEmpty() bool {
@@ -1006,6 +1130,13 @@ func (db *SkipBlockDB) GetSkipchains() (map[string]*SkipBlock, error) {
 	return db.getAll()
 }
 
+// GetLatestSkipchains returns the latest block of each of the skipchains in
+// the database, indexed by the ID of the skipchain. A skipchain whose genesis
+// block is missing is also returned.
+func (db *SkipBlockDB) GetLatestSkipchains() (map[string]*SkipBlock, error) {
+	return db.getAllSkipchains()
+}
+
 // RemoveSkipchain removes all block from a given skipchain from the database.
 // If the skipchain is only partial, it can skip missing blocks, as long as the
 // forwardlinks are present.